@@ -0,0 +1,9 @@
+// Package configs embeds the default configuration files, so that bkpsrv can
+// serve the report templates and the category map to the web dashboard.
+package configs
+
+import "embed"
+
+// tpl/*.json and category_map.json, by their paths in this directory
+//go:embed tpl/*.json category_map.json
+var Files embed.FS
@@ -17,11 +17,16 @@ import (
 	"time"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
 )
 
 type QueryData struct {
-	Clause string
-	Values []interface{}
+	Clause  string
+	Values  []interface{}
+	OrderBy string // empty if the query does not specify an order
+	Limit   int    // 0 if the query does not specify a limit
+	Offset  int
 }
 
 func toIfaceSlice(v interface{}) []interface{} {
@@ -76,23 +81,56 @@ var g = &grammar{
 	rules: []*rule{
 		{
 			name: "Input",
-			pos:  position{line: 58, col: 1, offset: 1428},
+			pos:  position{line: 63, col: 1, offset: 1642},
 			expr: &actionExpr{
-				pos: position{line: 58, col: 10, offset: 1437},
+				pos: position{line: 63, col: 10, offset: 1651},
 				run: (*parser).callonInput1,
 				expr: &seqExpr{
-					pos: position{line: 58, col: 10, offset: 1437},
+					pos: position{line: 63, col: 10, offset: 1651},
 					exprs: []interface{}{
 						&labeledExpr{
-							pos:   position{line: 58, col: 10, offset: 1437},
+							pos:   position{line: 63, col: 10, offset: 1651},
 							label: "expr",
 							expr: &ruleRefExpr{
-								pos:  position{line: 58, col: 15, offset: 1442},
+								pos:  position{line: 63, col: 15, offset: 1656},
 								name: "Expr",
 							},
 						},
+						&labeledExpr{
+							pos:   position{line: 63, col: 20, offset: 1661},
+							label: "ord",
+							expr: &zeroOrOneExpr{
+								pos: position{line: 63, col: 24, offset: 1665},
+								expr: &ruleRefExpr{
+									pos:  position{line: 63, col: 24, offset: 1665},
+									name: "OrderByClause",
+								},
+							},
+						},
+						&labeledExpr{
+							pos:   position{line: 63, col: 39, offset: 1680},
+							label: "lim",
+							expr: &zeroOrOneExpr{
+								pos: position{line: 63, col: 43, offset: 1684},
+								expr: &ruleRefExpr{
+									pos:  position{line: 63, col: 43, offset: 1684},
+									name: "LimitClause",
+								},
+							},
+						},
+						&labeledExpr{
+							pos:   position{line: 63, col: 56, offset: 1697},
+							label: "off",
+							expr: &zeroOrOneExpr{
+								pos: position{line: 63, col: 60, offset: 1701},
+								expr: &ruleRefExpr{
+									pos:  position{line: 63, col: 60, offset: 1701},
+									name: "OffsetClause",
+								},
+							},
+						},
 						&ruleRefExpr{
-							pos:  position{line: 58, col: 20, offset: 1447},
+							pos:  position{line: 63, col: 74, offset: 1715},
 							name: "EOF",
 						},
 					},
@@ -101,47 +139,47 @@ var g = &grammar{
 		},
 		{
 			name: "Expr",
-			pos:  position{line: 62, col: 1, offset: 1477},
+			pos:  position{line: 77, col: 1, offset: 1941},
 			expr: &actionExpr{
-				pos: position{line: 62, col: 9, offset: 1485},
+				pos: position{line: 77, col: 9, offset: 1949},
 				run: (*parser).callonExpr1,
 				expr: &seqExpr{
-					pos: position{line: 62, col: 9, offset: 1485},
+					pos: position{line: 77, col: 9, offset: 1949},
 					exprs: []interface{}{
 						&ruleRefExpr{
-							pos:  position{line: 62, col: 9, offset: 1485},
+							pos:  position{line: 77, col: 9, offset: 1949},
 							name: "_",
 						},
 						&labeledExpr{
-							pos:   position{line: 62, col: 11, offset: 1487},
+							pos:   position{line: 77, col: 11, offset: 1951},
 							label: "first",
 							expr: &ruleRefExpr{
-								pos:  position{line: 62, col: 17, offset: 1493},
+								pos:  position{line: 77, col: 17, offset: 1957},
 								name: "Term",
 							},
 						},
 						&labeledExpr{
-							pos:   position{line: 62, col: 22, offset: 1498},
+							pos:   position{line: 77, col: 22, offset: 1962},
 							label: "rest",
 							expr: &zeroOrMoreExpr{
-								pos: position{line: 62, col: 27, offset: 1503},
+								pos: position{line: 77, col: 27, offset: 1967},
 								expr: &seqExpr{
-									pos: position{line: 62, col: 29, offset: 1505},
+									pos: position{line: 77, col: 29, offset: 1969},
 									exprs: []interface{}{
 										&ruleRefExpr{
-											pos:  position{line: 62, col: 29, offset: 1505},
+											pos:  position{line: 77, col: 29, offset: 1969},
 											name: "_",
 										},
 										&ruleRefExpr{
-											pos:  position{line: 62, col: 31, offset: 1507},
+											pos:  position{line: 77, col: 31, offset: 1971},
 											name: "LogicOrOp",
 										},
 										&ruleRefExpr{
-											pos:  position{line: 62, col: 41, offset: 1517},
+											pos:  position{line: 77, col: 41, offset: 1981},
 											name: "_",
 										},
 										&ruleRefExpr{
-											pos:  position{line: 62, col: 43, offset: 1519},
+											pos:  position{line: 77, col: 43, offset: 1983},
 											name: "Term",
 										},
 									},
@@ -149,7 +187,7 @@ var g = &grammar{
 							},
 						},
 						&ruleRefExpr{
-							pos:  position{line: 62, col: 50, offset: 1526},
+							pos:  position{line: 77, col: 50, offset: 1990},
 							name: "_",
 						},
 					},
@@ -158,43 +196,43 @@ var g = &grammar{
 		},
 		{
 			name: "Term",
-			pos:  position{line: 66, col: 1, offset: 1567},
+			pos:  position{line: 81, col: 1, offset: 2031},
 			expr: &actionExpr{
-				pos: position{line: 66, col: 9, offset: 1575},
+				pos: position{line: 81, col: 9, offset: 2039},
 				run: (*parser).callonTerm1,
 				expr: &seqExpr{
-					pos: position{line: 66, col: 9, offset: 1575},
+					pos: position{line: 81, col: 9, offset: 2039},
 					exprs: []interface{}{
 						&labeledExpr{
-							pos:   position{line: 66, col: 9, offset: 1575},
+							pos:   position{line: 81, col: 9, offset: 2039},
 							label: "first",
 							expr: &ruleRefExpr{
-								pos:  position{line: 66, col: 15, offset: 1581},
+								pos:  position{line: 81, col: 15, offset: 2045},
 								name: "Factor",
 							},
 						},
 						&labeledExpr{
-							pos:   position{line: 66, col: 22, offset: 1588},
+							pos:   position{line: 81, col: 22, offset: 2052},
 							label: "rest",
 							expr: &zeroOrMoreExpr{
-								pos: position{line: 66, col: 27, offset: 1593},
+								pos: position{line: 81, col: 27, offset: 2057},
 								expr: &seqExpr{
-									pos: position{line: 66, col: 29, offset: 1595},
+									pos: position{line: 81, col: 29, offset: 2059},
 									exprs: []interface{}{
 										&ruleRefExpr{
-											pos:  position{line: 66, col: 29, offset: 1595},
+											pos:  position{line: 81, col: 29, offset: 2059},
 											name: "_",
 										},
 										&ruleRefExpr{
-											pos:  position{line: 66, col: 31, offset: 1597},
+											pos:  position{line: 81, col: 31, offset: 2061},
 											name: "LogicAndOp",
 										},
 										&ruleRefExpr{
-											pos:  position{line: 66, col: 42, offset: 1608},
+											pos:  position{line: 81, col: 42, offset: 2072},
 											name: "_",
 										},
 										&ruleRefExpr{
-											pos:  position{line: 66, col: 44, offset: 1610},
+											pos:  position{line: 81, col: 44, offset: 2074},
 											name: "Factor",
 										},
 									},
@@ -207,12 +245,12 @@ var g = &grammar{
 		},
 		{
 			name: "LogicOrOp",
-			pos:  position{line: 70, col: 1, offset: 1658},
+			pos:  position{line: 85, col: 1, offset: 2122},
 			expr: &actionExpr{
-				pos: position{line: 70, col: 14, offset: 1671},
+				pos: position{line: 85, col: 14, offset: 2135},
 				run: (*parser).callonLogicOrOp1,
 				expr: &litMatcher{
-					pos:        position{line: 70, col: 14, offset: 1671},
+					pos:        position{line: 85, col: 14, offset: 2135},
 					val:        "or",
 					ignoreCase: true,
 					want:       "\"OR\"i",
@@ -221,12 +259,12 @@ var g = &grammar{
 		},
 		{
 			name: "LogicAndOp",
-			pos:  position{line: 74, col: 1, offset: 1713},
+			pos:  position{line: 89, col: 1, offset: 2177},
 			expr: &actionExpr{
-				pos: position{line: 74, col: 15, offset: 1727},
+				pos: position{line: 89, col: 15, offset: 2191},
 				run: (*parser).callonLogicAndOp1,
 				expr: &litMatcher{
-					pos:        position{line: 74, col: 15, offset: 1727},
+					pos:        position{line: 89, col: 15, offset: 2191},
 					val:        "and",
 					ignoreCase: true,
 					want:       "\"AND\"i",
@@ -235,32 +273,32 @@ var g = &grammar{
 		},
 		{
 			name: "Factor",
-			pos:  position{line: 78, col: 1, offset: 1770},
+			pos:  position{line: 93, col: 1, offset: 2234},
 			expr: &choiceExpr{
-				pos: position{line: 78, col: 11, offset: 1780},
+				pos: position{line: 93, col: 11, offset: 2244},
 				alternatives: []interface{}{
 					&actionExpr{
-						pos: position{line: 78, col: 11, offset: 1780},
+						pos: position{line: 93, col: 11, offset: 2244},
 						run: (*parser).callonFactor2,
 						expr: &seqExpr{
-							pos: position{line: 78, col: 11, offset: 1780},
+							pos: position{line: 93, col: 11, offset: 2244},
 							exprs: []interface{}{
 								&litMatcher{
-									pos:        position{line: 78, col: 11, offset: 1780},
+									pos:        position{line: 93, col: 11, offset: 2244},
 									val:        "(",
 									ignoreCase: false,
 									want:       "\"(\"",
 								},
 								&labeledExpr{
-									pos:   position{line: 78, col: 15, offset: 1784},
+									pos:   position{line: 93, col: 15, offset: 2248},
 									label: "expr",
 									expr: &ruleRefExpr{
-										pos:  position{line: 78, col: 20, offset: 1789},
+										pos:  position{line: 93, col: 20, offset: 2253},
 										name: "Expr",
 									},
 								},
 								&litMatcher{
-									pos:        position{line: 78, col: 25, offset: 1794},
+									pos:        position{line: 93, col: 25, offset: 2258},
 									val:        ")",
 									ignoreCase: false,
 									want:       "\")\"",
@@ -269,13 +307,13 @@ var g = &grammar{
 						},
 					},
 					&actionExpr{
-						pos: position{line: 80, col: 5, offset: 1825},
+						pos: position{line: 95, col: 5, offset: 2289},
 						run: (*parser).callonFactor8,
 						expr: &labeledExpr{
-							pos:   position{line: 80, col: 5, offset: 1825},
+							pos:   position{line: 95, col: 5, offset: 2289},
 							label: "cond",
 							expr: &ruleRefExpr{
-								pos:  position{line: 80, col: 10, offset: 1830},
+								pos:  position{line: 95, col: 10, offset: 2294},
 								name: "Condition",
 							},
 						},
@@ -285,47 +323,47 @@ var g = &grammar{
 		},
 		{
 			name: "Condition",
-			pos:  position{line: 84, col: 1, offset: 1866},
+			pos:  position{line: 99, col: 1, offset: 2330},
 			expr: &choiceExpr{
-				pos: position{line: 84, col: 14, offset: 1879},
+				pos: position{line: 99, col: 14, offset: 2343},
 				alternatives: []interface{}{
 					&actionExpr{
-						pos: position{line: 84, col: 14, offset: 1879},
+						pos: position{line: 99, col: 14, offset: 2343},
 						run: (*parser).callonCondition2,
 						expr: &seqExpr{
-							pos: position{line: 84, col: 14, offset: 1879},
+							pos: position{line: 99, col: 14, offset: 2343},
 							exprs: []interface{}{
 								&labeledExpr{
-									pos:   position{line: 84, col: 14, offset: 1879},
+									pos:   position{line: 99, col: 14, offset: 2343},
 									label: "f",
 									expr: &litMatcher{
-										pos:        position{line: 84, col: 16, offset: 1881},
+										pos:        position{line: 99, col: 16, offset: 2345},
 										val:        "date",
 										ignoreCase: false,
 										want:       "\"date\"",
 									},
 								},
 								&ruleRefExpr{
-									pos:  position{line: 84, col: 23, offset: 1888},
+									pos:  position{line: 99, col: 23, offset: 2352},
 									name: "_",
 								},
 								&labeledExpr{
-									pos:   position{line: 84, col: 25, offset: 1890},
+									pos:   position{line: 99, col: 25, offset: 2354},
 									label: "o",
 									expr: &ruleRefExpr{
-										pos:  position{line: 84, col: 27, offset: 1892},
+										pos:  position{line: 99, col: 27, offset: 2356},
 										name: "Op",
 									},
 								},
 								&ruleRefExpr{
-									pos:  position{line: 84, col: 30, offset: 1895},
+									pos:  position{line: 99, col: 30, offset: 2359},
 									name: "_",
 								},
 								&labeledExpr{
-									pos:   position{line: 84, col: 32, offset: 1897},
+									pos:   position{line: 99, col: 32, offset: 2361},
 									label: "d",
 									expr: &ruleRefExpr{
-										pos:  position{line: 84, col: 34, offset: 1899},
+										pos:  position{line: 99, col: 34, offset: 2363},
 										name: "DateLiteral",
 									},
 								},
@@ -333,44 +371,44 @@ var g = &grammar{
 						},
 					},
 					&actionExpr{
-						pos: position{line: 99, col: 5, offset: 2313},
+						pos: position{line: 114, col: 5, offset: 2777},
 						run: (*parser).callonCondition12,
 						expr: &seqExpr{
-							pos: position{line: 99, col: 5, offset: 2313},
+							pos: position{line: 114, col: 5, offset: 2777},
 							exprs: []interface{}{
 								&labeledExpr{
-									pos:   position{line: 99, col: 5, offset: 2313},
+									pos:   position{line: 114, col: 5, offset: 2777},
 									label: "f",
 									expr: &litMatcher{
-										pos:        position{line: 99, col: 7, offset: 2315},
+										pos:        position{line: 114, col: 7, offset: 2779},
 										val:        "type",
 										ignoreCase: false,
 										want:       "\"type\"",
 									},
 								},
 								&ruleRefExpr{
-									pos:  position{line: 99, col: 14, offset: 2322},
+									pos:  position{line: 114, col: 14, offset: 2786},
 									name: "_",
 								},
 								&labeledExpr{
-									pos:   position{line: 99, col: 16, offset: 2324},
+									pos:   position{line: 114, col: 16, offset: 2788},
 									label: "o",
 									expr: &litMatcher{
-										pos:        position{line: 99, col: 18, offset: 2326},
+										pos:        position{line: 114, col: 18, offset: 2790},
 										val:        "=",
 										ignoreCase: false,
 										want:       "\"=\"",
 									},
 								},
 								&ruleRefExpr{
-									pos:  position{line: 99, col: 22, offset: 2330},
+									pos:  position{line: 114, col: 22, offset: 2794},
 									name: "_",
 								},
 								&labeledExpr{
-									pos:   position{line: 99, col: 24, offset: 2332},
+									pos:   position{line: 114, col: 24, offset: 2796},
 									label: "v",
 									expr: &ruleRefExpr{
-										pos:  position{line: 99, col: 26, offset: 2334},
+										pos:  position{line: 114, col: 26, offset: 2798},
 										name: "StringLiteral",
 									},
 								},
@@ -378,37 +416,37 @@ var g = &grammar{
 						},
 					},
 					&actionExpr{
-						pos: position{line: 101, col: 5, offset: 2392},
+						pos: position{line: 116, col: 5, offset: 2856},
 						run: (*parser).callonCondition22,
 						expr: &seqExpr{
-							pos: position{line: 101, col: 5, offset: 2392},
+							pos: position{line: 116, col: 5, offset: 2856},
 							exprs: []interface{}{
 								&labeledExpr{
-									pos:   position{line: 101, col: 5, offset: 2392},
+									pos:   position{line: 116, col: 5, offset: 2856},
 									label: "f",
 									expr: &choiceExpr{
-										pos: position{line: 101, col: 8, offset: 2395},
+										pos: position{line: 116, col: 8, offset: 2859},
 										alternatives: []interface{}{
 											&litMatcher{
-												pos:        position{line: 101, col: 8, offset: 2395},
+												pos:        position{line: 116, col: 8, offset: 2859},
 												val:        "category",
 												ignoreCase: false,
 												want:       "\"category\"",
 											},
 											&litMatcher{
-												pos:        position{line: 101, col: 21, offset: 2408},
+												pos:        position{line: 116, col: 21, offset: 2872},
 												val:        "sub_category",
 												ignoreCase: false,
 												want:       "\"sub_category\"",
 											},
 											&litMatcher{
-												pos:        position{line: 101, col: 38, offset: 2425},
+												pos:        position{line: 116, col: 38, offset: 2889},
 												val:        "a.name",
 												ignoreCase: false,
 												want:       "\"a.name\"",
 											},
 											&litMatcher{
-												pos:        position{line: 101, col: 49, offset: 2436},
+												pos:        position{line: 116, col: 49, offset: 2900},
 												val:        "notes",
 												ignoreCase: false,
 												want:       "\"notes\"",
@@ -417,23 +455,23 @@ var g = &grammar{
 									},
 								},
 								&ruleRefExpr{
-									pos:  position{line: 101, col: 58, offset: 2445},
+									pos:  position{line: 116, col: 58, offset: 2909},
 									name: "_",
 								},
 								&labeledExpr{
-									pos:   position{line: 101, col: 60, offset: 2447},
+									pos:   position{line: 116, col: 60, offset: 2911},
 									label: "o",
 									expr: &choiceExpr{
-										pos: position{line: 101, col: 63, offset: 2450},
+										pos: position{line: 116, col: 63, offset: 2914},
 										alternatives: []interface{}{
 											&litMatcher{
-												pos:        position{line: 101, col: 63, offset: 2450},
+												pos:        position{line: 116, col: 63, offset: 2914},
 												val:        "=",
 												ignoreCase: false,
 												want:       "\"=\"",
 											},
 											&litMatcher{
-												pos:        position{line: 101, col: 69, offset: 2456},
+												pos:        position{line: 116, col: 69, offset: 2920},
 												val:        "~",
 												ignoreCase: false,
 												want:       "\"~\"",
@@ -442,14 +480,14 @@ var g = &grammar{
 									},
 								},
 								&ruleRefExpr{
-									pos:  position{line: 101, col: 74, offset: 2461},
+									pos:  position{line: 116, col: 74, offset: 2925},
 									name: "_",
 								},
 								&labeledExpr{
-									pos:   position{line: 101, col: 76, offset: 2463},
+									pos:   position{line: 116, col: 76, offset: 2927},
 									label: "v",
 									expr: &ruleRefExpr{
-										pos:  position{line: 101, col: 78, offset: 2465},
+										pos:  position{line: 116, col: 78, offset: 2929},
 										name: "StringLiteral",
 									},
 								},
@@ -457,42 +495,69 @@ var g = &grammar{
 						},
 					},
 					&actionExpr{
-						pos: position{line: 103, col: 5, offset: 2523},
+						pos: position{line: 118, col: 5, offset: 2987},
 						run: (*parser).callonCondition38,
 						expr: &seqExpr{
-							pos: position{line: 103, col: 5, offset: 2523},
+							pos: position{line: 118, col: 5, offset: 2987},
+							exprs: []interface{}{
+								&litMatcher{
+									pos:        position{line: 118, col: 5, offset: 2987},
+									val:        "search",
+									ignoreCase: false,
+									want:       "\"search\"",
+								},
+								&ruleRefExpr{
+									pos:  position{line: 118, col: 14, offset: 2996},
+									name: "_",
+								},
+								&labeledExpr{
+									pos:   position{line: 118, col: 16, offset: 2998},
+									label: "v",
+									expr: &ruleRefExpr{
+										pos:  position{line: 118, col: 18, offset: 3000},
+										name: "StringLiteral",
+									},
+								},
+							},
+						},
+					},
+					&actionExpr{
+						pos: position{line: 121, col: 5, offset: 3139},
+						run: (*parser).callonCondition44,
+						expr: &seqExpr{
+							pos: position{line: 121, col: 5, offset: 3139},
 							exprs: []interface{}{
 								&labeledExpr{
-									pos:   position{line: 103, col: 5, offset: 2523},
+									pos:   position{line: 121, col: 5, offset: 3139},
 									label: "f",
 									expr: &litMatcher{
-										pos:        position{line: 103, col: 7, offset: 2525},
+										pos:        position{line: 121, col: 7, offset: 3141},
 										val:        "amount",
 										ignoreCase: false,
 										want:       "\"amount\"",
 									},
 								},
 								&ruleRefExpr{
-									pos:  position{line: 103, col: 16, offset: 2534},
+									pos:  position{line: 121, col: 16, offset: 3150},
 									name: "_",
 								},
 								&labeledExpr{
-									pos:   position{line: 103, col: 18, offset: 2536},
+									pos:   position{line: 121, col: 18, offset: 3152},
 									label: "o",
 									expr: &ruleRefExpr{
-										pos:  position{line: 103, col: 20, offset: 2538},
+										pos:  position{line: 121, col: 20, offset: 3154},
 										name: "Op",
 									},
 								},
 								&ruleRefExpr{
-									pos:  position{line: 103, col: 23, offset: 2541},
+									pos:  position{line: 121, col: 23, offset: 3157},
 									name: "_",
 								},
 								&labeledExpr{
-									pos:   position{line: 103, col: 25, offset: 2543},
+									pos:   position{line: 121, col: 25, offset: 3159},
 									label: "v",
 									expr: &ruleRefExpr{
-										pos:  position{line: 103, col: 27, offset: 2545},
+										pos:  position{line: 121, col: 27, offset: 3161},
 										name: "Integer",
 									},
 								},
@@ -502,78 +567,350 @@ var g = &grammar{
 				},
 			},
 		},
+		{
+			name: "OrderByClause",
+			pos:  position{line: 131, col: 1, offset: 3375},
+			expr: &actionExpr{
+				pos: position{line: 131, col: 18, offset: 3392},
+				run: (*parser).callonOrderByClause1,
+				expr: &seqExpr{
+					pos: position{line: 131, col: 18, offset: 3392},
+					exprs: []interface{}{
+						&litMatcher{
+							pos:        position{line: 131, col: 18, offset: 3392},
+							val:        "order",
+							ignoreCase: true,
+							want:       "\"ORDER\"i",
+						},
+						&ruleRefExpr{
+							pos:  position{line: 131, col: 27, offset: 3401},
+							name: "_",
+						},
+						&litMatcher{
+							pos:        position{line: 131, col: 29, offset: 3403},
+							val:        "by",
+							ignoreCase: true,
+							want:       "\"BY\"i",
+						},
+						&ruleRefExpr{
+							pos:  position{line: 131, col: 35, offset: 3409},
+							name: "_",
+						},
+						&labeledExpr{
+							pos:   position{line: 131, col: 37, offset: 3411},
+							label: "first",
+							expr: &ruleRefExpr{
+								pos:  position{line: 131, col: 43, offset: 3417},
+								name: "SortTerm",
+							},
+						},
+						&labeledExpr{
+							pos:   position{line: 131, col: 52, offset: 3426},
+							label: "rest",
+							expr: &zeroOrMoreExpr{
+								pos: position{line: 131, col: 57, offset: 3431},
+								expr: &seqExpr{
+									pos: position{line: 131, col: 59, offset: 3433},
+									exprs: []interface{}{
+										&ruleRefExpr{
+											pos:  position{line: 131, col: 59, offset: 3433},
+											name: "_",
+										},
+										&litMatcher{
+											pos:        position{line: 131, col: 61, offset: 3435},
+											val:        ",",
+											ignoreCase: false,
+											want:       "\",\"",
+										},
+										&ruleRefExpr{
+											pos:  position{line: 131, col: 65, offset: 3439},
+											name: "_",
+										},
+										&ruleRefExpr{
+											pos:  position{line: 131, col: 67, offset: 3441},
+											name: "SortTerm",
+										},
+									},
+								},
+							},
+						},
+						&ruleRefExpr{
+							pos:  position{line: 131, col: 78, offset: 3452},
+							name: "_",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "SortTerm",
+			pos:  position{line: 139, col: 1, offset: 3647},
+			expr: &actionExpr{
+				pos: position{line: 139, col: 13, offset: 3659},
+				run: (*parser).callonSortTerm1,
+				expr: &seqExpr{
+					pos: position{line: 139, col: 13, offset: 3659},
+					exprs: []interface{}{
+						&labeledExpr{
+							pos:   position{line: 139, col: 13, offset: 3659},
+							label: "f",
+							expr: &ruleRefExpr{
+								pos:  position{line: 139, col: 15, offset: 3661},
+								name: "SortField",
+							},
+						},
+						&labeledExpr{
+							pos:   position{line: 139, col: 25, offset: 3671},
+							label: "d",
+							expr: &zeroOrOneExpr{
+								pos: position{line: 139, col: 27, offset: 3673},
+								expr: &seqExpr{
+									pos: position{line: 139, col: 29, offset: 3675},
+									exprs: []interface{}{
+										&ruleRefExpr{
+											pos:  position{line: 139, col: 29, offset: 3675},
+											name: "_",
+										},
+										&ruleRefExpr{
+											pos:  position{line: 139, col: 31, offset: 3677},
+											name: "SortDir",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "SortField",
+			pos:  position{line: 147, col: 1, offset: 3829},
+			expr: &actionExpr{
+				pos: position{line: 147, col: 14, offset: 3842},
+				run: (*parser).callonSortField1,
+				expr: &choiceExpr{
+					pos: position{line: 147, col: 15, offset: 3843},
+					alternatives: []interface{}{
+						&litMatcher{
+							pos:        position{line: 147, col: 15, offset: 3843},
+							val:        "date",
+							ignoreCase: false,
+							want:       "\"date\"",
+						},
+						&litMatcher{
+							pos:        position{line: 147, col: 24, offset: 3852},
+							val:        "amount",
+							ignoreCase: false,
+							want:       "\"amount\"",
+						},
+						&litMatcher{
+							pos:        position{line: 147, col: 35, offset: 3863},
+							val:        "type",
+							ignoreCase: false,
+							want:       "\"type\"",
+						},
+						&litMatcher{
+							pos:        position{line: 147, col: 44, offset: 3872},
+							val:        "category",
+							ignoreCase: false,
+							want:       "\"category\"",
+						},
+						&litMatcher{
+							pos:        position{line: 147, col: 57, offset: 3885},
+							val:        "sub_category",
+							ignoreCase: false,
+							want:       "\"sub_category\"",
+						},
+						&litMatcher{
+							pos:        position{line: 147, col: 74, offset: 3902},
+							val:        "a.name",
+							ignoreCase: false,
+							want:       "\"a.name\"",
+						},
+						&litMatcher{
+							pos:        position{line: 147, col: 85, offset: 3913},
+							val:        "notes",
+							ignoreCase: false,
+							want:       "\"notes\"",
+						},
+						&litMatcher{
+							pos:        position{line: 147, col: 95, offset: 3923},
+							val:        "id",
+							ignoreCase: false,
+							want:       "\"id\"",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "SortDir",
+			pos:  position{line: 156, col: 1, offset: 4085},
+			expr: &actionExpr{
+				pos: position{line: 156, col: 12, offset: 4096},
+				run: (*parser).callonSortDir1,
+				expr: &choiceExpr{
+					pos: position{line: 156, col: 13, offset: 4097},
+					alternatives: []interface{}{
+						&litMatcher{
+							pos:        position{line: 156, col: 13, offset: 4097},
+							val:        "asc",
+							ignoreCase: true,
+							want:       "\"ASC\"i",
+						},
+						&litMatcher{
+							pos:        position{line: 156, col: 22, offset: 4106},
+							val:        "desc",
+							ignoreCase: true,
+							want:       "\"DESC\"i",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "LimitClause",
+			pos:  position{line: 160, col: 1, offset: 4168},
+			expr: &actionExpr{
+				pos: position{line: 160, col: 16, offset: 4183},
+				run: (*parser).callonLimitClause1,
+				expr: &seqExpr{
+					pos: position{line: 160, col: 16, offset: 4183},
+					exprs: []interface{}{
+						&litMatcher{
+							pos:        position{line: 160, col: 16, offset: 4183},
+							val:        "limit",
+							ignoreCase: true,
+							want:       "\"LIMIT\"i",
+						},
+						&ruleRefExpr{
+							pos:  position{line: 160, col: 25, offset: 4192},
+							name: "_",
+						},
+						&labeledExpr{
+							pos:   position{line: 160, col: 27, offset: 4194},
+							label: "v",
+							expr: &ruleRefExpr{
+								pos:  position{line: 160, col: 29, offset: 4196},
+								name: "Natural",
+							},
+						},
+						&ruleRefExpr{
+							pos:  position{line: 160, col: 37, offset: 4204},
+							name: "_",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "OffsetClause",
+			pos:  position{line: 164, col: 1, offset: 4229},
+			expr: &actionExpr{
+				pos: position{line: 164, col: 17, offset: 4245},
+				run: (*parser).callonOffsetClause1,
+				expr: &seqExpr{
+					pos: position{line: 164, col: 17, offset: 4245},
+					exprs: []interface{}{
+						&litMatcher{
+							pos:        position{line: 164, col: 17, offset: 4245},
+							val:        "offset",
+							ignoreCase: true,
+							want:       "\"OFFSET\"i",
+						},
+						&ruleRefExpr{
+							pos:  position{line: 164, col: 27, offset: 4255},
+							name: "_",
+						},
+						&labeledExpr{
+							pos:   position{line: 164, col: 29, offset: 4257},
+							label: "v",
+							expr: &ruleRefExpr{
+								pos:  position{line: 164, col: 31, offset: 4259},
+								name: "Natural",
+							},
+						},
+						&ruleRefExpr{
+							pos:  position{line: 164, col: 39, offset: 4267},
+							name: "_",
+						},
+					},
+				},
+			},
+		},
 		{
 			name: "DateLiteral",
-			pos:  position{line: 113, col: 1, offset: 2759},
+			pos:  position{line: 168, col: 1, offset: 4292},
 			expr: &actionExpr{
-				pos: position{line: 113, col: 16, offset: 2774},
+				pos: position{line: 168, col: 16, offset: 4307},
 				run: (*parser).callonDateLiteral1,
 				expr: &seqExpr{
-					pos: position{line: 113, col: 16, offset: 2774},
+					pos: position{line: 168, col: 16, offset: 4307},
 					exprs: []interface{}{
 						&charClassMatcher{
-							pos:        position{line: 113, col: 16, offset: 2774},
+							pos:        position{line: 168, col: 16, offset: 4307},
 							val:        "[0-9]",
 							ranges:     []rune{'0', '9'},
 							ignoreCase: false,
 							inverted:   false,
 						},
 						&charClassMatcher{
-							pos:        position{line: 113, col: 21, offset: 2779},
+							pos:        position{line: 168, col: 21, offset: 4312},
 							val:        "[0-9]",
 							ranges:     []rune{'0', '9'},
 							ignoreCase: false,
 							inverted:   false,
 						},
 						&charClassMatcher{
-							pos:        position{line: 113, col: 26, offset: 2784},
+							pos:        position{line: 168, col: 26, offset: 4317},
 							val:        "[0-9]",
 							ranges:     []rune{'0', '9'},
 							ignoreCase: false,
 							inverted:   false,
 						},
 						&charClassMatcher{
-							pos:        position{line: 113, col: 31, offset: 2789},
+							pos:        position{line: 168, col: 31, offset: 4322},
 							val:        "[0-9]",
 							ranges:     []rune{'0', '9'},
 							ignoreCase: false,
 							inverted:   false,
 						},
 						&litMatcher{
-							pos:        position{line: 113, col: 37, offset: 2795},
+							pos:        position{line: 168, col: 37, offset: 4328},
 							val:        "/",
 							ignoreCase: false,
 							want:       "\"/\"",
 						},
 						&charClassMatcher{
-							pos:        position{line: 113, col: 41, offset: 2799},
+							pos:        position{line: 168, col: 41, offset: 4332},
 							val:        "[0-9]",
 							ranges:     []rune{'0', '9'},
 							ignoreCase: false,
 							inverted:   false,
 						},
 						&charClassMatcher{
-							pos:        position{line: 113, col: 46, offset: 2804},
+							pos:        position{line: 168, col: 46, offset: 4337},
 							val:        "[0-9]",
 							ranges:     []rune{'0', '9'},
 							ignoreCase: false,
 							inverted:   false,
 						},
 						&litMatcher{
-							pos:        position{line: 113, col: 52, offset: 2810},
+							pos:        position{line: 168, col: 52, offset: 4343},
 							val:        "/",
 							ignoreCase: false,
 							want:       "\"/\"",
 						},
 						&charClassMatcher{
-							pos:        position{line: 113, col: 56, offset: 2814},
+							pos:        position{line: 168, col: 56, offset: 4347},
 							val:        "[0-9]",
 							ranges:     []rune{'0', '9'},
 							ignoreCase: false,
 							inverted:   false,
 						},
 						&charClassMatcher{
-							pos:        position{line: 113, col: 61, offset: 2819},
+							pos:        position{line: 168, col: 61, offset: 4352},
 							val:        "[0-9]",
 							ranges:     []rune{'0', '9'},
 							ignoreCase: false,
@@ -585,50 +922,50 @@ var g = &grammar{
 		},
 		{
 			name: "StringLiteral",
-			pos:  position{line: 117, col: 1, offset: 2861},
+			pos:  position{line: 172, col: 1, offset: 4394},
 			expr: &actionExpr{
-				pos: position{line: 117, col: 18, offset: 2878},
+				pos: position{line: 172, col: 18, offset: 4411},
 				run: (*parser).callonStringLiteral1,
 				expr: &seqExpr{
-					pos: position{line: 117, col: 18, offset: 2878},
+					pos: position{line: 172, col: 18, offset: 4411},
 					exprs: []interface{}{
 						&litMatcher{
-							pos:        position{line: 117, col: 18, offset: 2878},
+							pos:        position{line: 172, col: 18, offset: 4411},
 							val:        "\"",
 							ignoreCase: false,
 							want:       "\"\\\"\"",
 						},
 						&zeroOrMoreExpr{
-							pos: position{line: 117, col: 22, offset: 2882},
+							pos: position{line: 172, col: 22, offset: 4415},
 							expr: &choiceExpr{
-								pos: position{line: 117, col: 24, offset: 2884},
+								pos: position{line: 172, col: 24, offset: 4417},
 								alternatives: []interface{}{
 									&seqExpr{
-										pos: position{line: 117, col: 24, offset: 2884},
+										pos: position{line: 172, col: 24, offset: 4417},
 										exprs: []interface{}{
 											&notExpr{
-												pos: position{line: 117, col: 24, offset: 2884},
+												pos: position{line: 172, col: 24, offset: 4417},
 												expr: &ruleRefExpr{
-													pos:  position{line: 117, col: 25, offset: 2885},
+													pos:  position{line: 172, col: 25, offset: 4418},
 													name: "EscapedChar",
 												},
 											},
 											&anyMatcher{
-												line: 117, col: 37, offset: 2897,
+												line: 172, col: 37, offset: 4430,
 											},
 										},
 									},
 									&seqExpr{
-										pos: position{line: 117, col: 41, offset: 2901},
+										pos: position{line: 172, col: 41, offset: 4434},
 										exprs: []interface{}{
 											&litMatcher{
-												pos:        position{line: 117, col: 41, offset: 2901},
+												pos:        position{line: 172, col: 41, offset: 4434},
 												val:        "\\",
 												ignoreCase: false,
 												want:       "\"\\\\\"",
 											},
 											&ruleRefExpr{
-												pos:  position{line: 117, col: 46, offset: 2906},
+												pos:  position{line: 172, col: 46, offset: 4439},
 												name: "EscapeSequence",
 											},
 										},
@@ -637,7 +974,7 @@ var g = &grammar{
 							},
 						},
 						&litMatcher{
-							pos:        position{line: 117, col: 64, offset: 2924},
+							pos:        position{line: 172, col: 64, offset: 4457},
 							val:        "\"",
 							ignoreCase: false,
 							want:       "\"\\\"\"",
@@ -648,9 +985,9 @@ var g = &grammar{
 		},
 		{
 			name: "EscapedChar",
-			pos:  position{line: 122, col: 1, offset: 3042},
+			pos:  position{line: 177, col: 1, offset: 4575},
 			expr: &charClassMatcher{
-				pos:        position{line: 122, col: 16, offset: 3057},
+				pos:        position{line: 177, col: 16, offset: 4590},
 				val:        "[\\x00-\\x1f\"\\\\]",
 				chars:      []rune{'"', '\\'},
 				ranges:     []rune{'\x00', '\x1f'},
@@ -660,16 +997,16 @@ var g = &grammar{
 		},
 		{
 			name: "EscapeSequence",
-			pos:  position{line: 124, col: 1, offset: 3073},
+			pos:  position{line: 179, col: 1, offset: 4606},
 			expr: &choiceExpr{
-				pos: position{line: 124, col: 19, offset: 3091},
+				pos: position{line: 179, col: 19, offset: 4624},
 				alternatives: []interface{}{
 					&ruleRefExpr{
-						pos:  position{line: 124, col: 19, offset: 3091},
+						pos:  position{line: 179, col: 19, offset: 4624},
 						name: "SingleCharEscape",
 					},
 					&ruleRefExpr{
-						pos:  position{line: 124, col: 38, offset: 3110},
+						pos:  position{line: 179, col: 38, offset: 4643},
 						name: "UnicodeEscape",
 					},
 				},
@@ -677,9 +1014,9 @@ var g = &grammar{
 		},
 		{
 			name: "SingleCharEscape",
-			pos:  position{line: 126, col: 1, offset: 3125},
+			pos:  position{line: 181, col: 1, offset: 4658},
 			expr: &charClassMatcher{
-				pos:        position{line: 126, col: 21, offset: 3145},
+				pos:        position{line: 181, col: 21, offset: 4678},
 				val:        "[\"\\\\/bfnrt]",
 				chars:      []rune{'"', '\\', '/', 'b', 'f', 'n', 'r', 't'},
 				ignoreCase: false,
@@ -688,30 +1025,30 @@ var g = &grammar{
 		},
 		{
 			name: "UnicodeEscape",
-			pos:  position{line: 128, col: 1, offset: 3158},
+			pos:  position{line: 183, col: 1, offset: 4691},
 			expr: &seqExpr{
-				pos: position{line: 128, col: 18, offset: 3175},
+				pos: position{line: 183, col: 18, offset: 4708},
 				exprs: []interface{}{
 					&litMatcher{
-						pos:        position{line: 128, col: 18, offset: 3175},
+						pos:        position{line: 183, col: 18, offset: 4708},
 						val:        "u",
 						ignoreCase: false,
 						want:       "\"u\"",
 					},
 					&ruleRefExpr{
-						pos:  position{line: 128, col: 22, offset: 3179},
+						pos:  position{line: 183, col: 22, offset: 4712},
 						name: "HexDigit",
 					},
 					&ruleRefExpr{
-						pos:  position{line: 128, col: 31, offset: 3188},
+						pos:  position{line: 183, col: 31, offset: 4721},
 						name: "HexDigit",
 					},
 					&ruleRefExpr{
-						pos:  position{line: 128, col: 40, offset: 3197},
+						pos:  position{line: 183, col: 40, offset: 4730},
 						name: "HexDigit",
 					},
 					&ruleRefExpr{
-						pos:  position{line: 128, col: 49, offset: 3206},
+						pos:  position{line: 183, col: 49, offset: 4739},
 						name: "HexDigit",
 					},
 				},
@@ -719,9 +1056,9 @@ var g = &grammar{
 		},
 		{
 			name: "HexDigit",
-			pos:  position{line: 130, col: 1, offset: 3216},
+			pos:  position{line: 185, col: 1, offset: 4749},
 			expr: &charClassMatcher{
-				pos:        position{line: 130, col: 13, offset: 3228},
+				pos:        position{line: 185, col: 13, offset: 4761},
 				val:        "[0-9a-f]i",
 				ranges:     []rune{'0', '9', 'a', 'f'},
 				ignoreCase: true,
@@ -730,26 +1067,26 @@ var g = &grammar{
 		},
 		{
 			name: "Integer",
-			pos:  position{line: 132, col: 1, offset: 3239},
+			pos:  position{line: 187, col: 1, offset: 4772},
 			expr: &actionExpr{
-				pos: position{line: 132, col: 12, offset: 3250},
+				pos: position{line: 187, col: 12, offset: 4783},
 				run: (*parser).callonInteger1,
 				expr: &seqExpr{
-					pos: position{line: 132, col: 12, offset: 3250},
+					pos: position{line: 187, col: 12, offset: 4783},
 					exprs: []interface{}{
 						&zeroOrOneExpr{
-							pos: position{line: 132, col: 12, offset: 3250},
+							pos: position{line: 187, col: 12, offset: 4783},
 							expr: &litMatcher{
-								pos:        position{line: 132, col: 12, offset: 3250},
+								pos:        position{line: 187, col: 12, offset: 4783},
 								val:        "-",
 								ignoreCase: false,
 								want:       "\"-\"",
 							},
 						},
 						&oneOrMoreExpr{
-							pos: position{line: 132, col: 17, offset: 3255},
+							pos: position{line: 187, col: 17, offset: 4788},
 							expr: &charClassMatcher{
-								pos:        position{line: 132, col: 17, offset: 3255},
+								pos:        position{line: 187, col: 17, offset: 4788},
 								val:        "[0-9]",
 								ranges:     []rune{'0', '9'},
 								ignoreCase: false,
@@ -760,41 +1097,59 @@ var g = &grammar{
 				},
 			},
 		},
+		{
+			name: "Natural",
+			pos:  position{line: 191, col: 1, offset: 4852},
+			expr: &actionExpr{
+				pos: position{line: 191, col: 12, offset: 4863},
+				run: (*parser).callonNatural1,
+				expr: &oneOrMoreExpr{
+					pos: position{line: 191, col: 12, offset: 4863},
+					expr: &charClassMatcher{
+						pos:        position{line: 191, col: 12, offset: 4863},
+						val:        "[0-9]",
+						ranges:     []rune{'0', '9'},
+						ignoreCase: false,
+						inverted:   false,
+					},
+				},
+			},
+		},
 		{
 			name: "Op",
-			pos:  position{line: 136, col: 1, offset: 3319},
+			pos:  position{line: 195, col: 1, offset: 4915},
 			expr: &actionExpr{
-				pos: position{line: 136, col: 7, offset: 3325},
+				pos: position{line: 195, col: 7, offset: 4921},
 				run: (*parser).callonOp1,
 				expr: &choiceExpr{
-					pos: position{line: 136, col: 8, offset: 3326},
+					pos: position{line: 195, col: 8, offset: 4922},
 					alternatives: []interface{}{
 						&litMatcher{
-							pos:        position{line: 136, col: 8, offset: 3326},
+							pos:        position{line: 195, col: 8, offset: 4922},
 							val:        "<=",
 							ignoreCase: false,
 							want:       "\"<=\"",
 						},
 						&litMatcher{
-							pos:        position{line: 136, col: 15, offset: 3333},
+							pos:        position{line: 195, col: 15, offset: 4929},
 							val:        ">=",
 							ignoreCase: false,
 							want:       "\">=\"",
 						},
 						&litMatcher{
-							pos:        position{line: 136, col: 22, offset: 3340},
+							pos:        position{line: 195, col: 22, offset: 4936},
 							val:        "=",
 							ignoreCase: false,
 							want:       "\"=\"",
 						},
 						&litMatcher{
-							pos:        position{line: 136, col: 28, offset: 3346},
+							pos:        position{line: 195, col: 28, offset: 4942},
 							val:        "<",
 							ignoreCase: false,
 							want:       "\"<\"",
 						},
 						&litMatcher{
-							pos:        position{line: 136, col: 34, offset: 3352},
+							pos:        position{line: 195, col: 34, offset: 4948},
 							val:        ">",
 							ignoreCase: false,
 							want:       "\">\"",
@@ -806,11 +1161,11 @@ var g = &grammar{
 		{
 			name:        "_",
 			displayName: "\"whitespace\"",
-			pos:         position{line: 140, col: 1, offset: 3393},
+			pos:         position{line: 199, col: 1, offset: 4989},
 			expr: &zeroOrMoreExpr{
-				pos: position{line: 140, col: 19, offset: 3411},
+				pos: position{line: 199, col: 19, offset: 5007},
 				expr: &charClassMatcher{
-					pos:        position{line: 140, col: 19, offset: 3411},
+					pos:        position{line: 199, col: 19, offset: 5007},
 					val:        "[ \\n\\t\\r]",
 					chars:      []rune{' ', '\n', '\t', '\r'},
 					ignoreCase: false,
@@ -820,25 +1175,35 @@ var g = &grammar{
 		},
 		{
 			name: "EOF",
-			pos:  position{line: 142, col: 1, offset: 3423},
+			pos:  position{line: 201, col: 1, offset: 5019},
 			expr: &notExpr{
-				pos: position{line: 142, col: 8, offset: 3430},
+				pos: position{line: 201, col: 8, offset: 5026},
 				expr: &anyMatcher{
-					line: 142, col: 9, offset: 3431,
+					line: 201, col: 9, offset: 5027,
 				},
 			},
 		},
 	},
 }
 
-func (c *current) onInput1(expr interface{}) (interface{}, error) {
-	return expr, nil
+func (c *current) onInput1(expr, ord, lim, off interface{}) (interface{}, error) {
+	qd := expr.(QueryData)
+	if ord != nil {
+		qd.OrderBy = ord.(string)
+	}
+	if lim != nil {
+		qd.Limit = lim.(int)
+	}
+	if off != nil {
+		qd.Offset = off.(int)
+	}
+	return qd, nil
 }
 
 func (p *parser) callonInput1() (interface{}, error) {
 	stack := p.vstack[len(p.vstack)-1]
 	_ = stack
-	return p.cur.onInput1(stack["expr"])
+	return p.cur.onInput1(stack["expr"], stack["ord"], stack["lim"], stack["off"])
 }
 
 func (c *current) onExpr1(first, rest interface{}) (interface{}, error) {
@@ -944,7 +1309,18 @@ func (p *parser) callonCondition22() (interface{}, error) {
 	return p.cur.onCondition22(stack["f"], stack["o"], stack["v"])
 }
 
-func (c *current) onCondition38(f, o, v interface{}) (interface{}, error) {
+func (c *current) onCondition38(v interface{}) (interface{}, error) {
+	clause, values := bookkeeper.SearchCondition(v.(string))
+	return QueryData{Clause: clause, Values: values}, nil
+}
+
+func (p *parser) callonCondition38() (interface{}, error) {
+	stack := p.vstack[len(p.vstack)-1]
+	_ = stack
+	return p.cur.onCondition38(stack["v"])
+}
+
+func (c *current) onCondition44(f, o, v interface{}) (interface{}, error) {
 	fStr := string(f.([]byte))
 	oStr := o.(string)
 	vInt := v.(int64)
@@ -954,10 +1330,83 @@ func (c *current) onCondition38(f, o, v interface{}) (interface{}, error) {
 	}, nil
 }
 
-func (p *parser) callonCondition38() (interface{}, error) {
+func (p *parser) callonCondition44() (interface{}, error) {
 	stack := p.vstack[len(p.vstack)-1]
 	_ = stack
-	return p.cur.onCondition38(stack["f"], stack["o"], stack["v"])
+	return p.cur.onCondition44(stack["f"], stack["o"], stack["v"])
+}
+
+func (c *current) onOrderByClause1(first, rest interface{}) (interface{}, error) {
+	terms := []string{first.(string)}
+	for _, v := range toIfaceSlice(rest) {
+		terms = append(terms, toIfaceSlice(v)[3].(string))
+	}
+	return strings.Join(terms, ", "), nil
+}
+
+func (p *parser) callonOrderByClause1() (interface{}, error) {
+	stack := p.vstack[len(p.vstack)-1]
+	_ = stack
+	return p.cur.onOrderByClause1(stack["first"], stack["rest"])
+}
+
+func (c *current) onSortTerm1(f, d interface{}) (interface{}, error) {
+	dir := "ASC"
+	if d != nil {
+		dir = toIfaceSlice(d)[1].(string)
+	}
+	return fmt.Sprintf("%s %s", f.(string), dir), nil
+}
+
+func (p *parser) callonSortTerm1() (interface{}, error) {
+	stack := p.vstack[len(p.vstack)-1]
+	_ = stack
+	return p.cur.onSortTerm1(stack["f"], stack["d"])
+}
+
+func (c *current) onSortField1() (interface{}, error) {
+	f := string(c.text)
+	if f == "id" {
+		// disambiguate from the id column of the accounts table
+		f = "t.id"
+	}
+	return f, nil
+}
+
+func (p *parser) callonSortField1() (interface{}, error) {
+	stack := p.vstack[len(p.vstack)-1]
+	_ = stack
+	return p.cur.onSortField1()
+}
+
+func (c *current) onSortDir1() (interface{}, error) {
+	return strings.ToUpper(string(c.text)), nil
+}
+
+func (p *parser) callonSortDir1() (interface{}, error) {
+	stack := p.vstack[len(p.vstack)-1]
+	_ = stack
+	return p.cur.onSortDir1()
+}
+
+func (c *current) onLimitClause1(v interface{}) (interface{}, error) {
+	return v, nil
+}
+
+func (p *parser) callonLimitClause1() (interface{}, error) {
+	stack := p.vstack[len(p.vstack)-1]
+	_ = stack
+	return p.cur.onLimitClause1(stack["v"])
+}
+
+func (c *current) onOffsetClause1(v interface{}) (interface{}, error) {
+	return v, nil
+}
+
+func (p *parser) callonOffsetClause1() (interface{}, error) {
+	stack := p.vstack[len(p.vstack)-1]
+	_ = stack
+	return p.cur.onOffsetClause1(stack["v"])
 }
 
 func (c *current) onDateLiteral1() (interface{}, error) {
@@ -991,6 +1440,16 @@ func (p *parser) callonInteger1() (interface{}, error) {
 	return p.cur.onInteger1()
 }
 
+func (c *current) onNatural1() (interface{}, error) {
+	return strconv.Atoi(string(c.text))
+}
+
+func (p *parser) callonNatural1() (interface{}, error) {
+	stack := p.vstack[len(p.vstack)-1]
+	_ = stack
+	return p.cur.onNatural1()
+}
+
 func (c *current) onOp1() (interface{}, error) {
 	return string(c.text), nil
 }
@@ -2,140 +2,235 @@ package api
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"strconv"
-	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
 	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
-	"go.uber.org/zap"
 )
 
-var MAX_NUM_RECORDS int = 1000
-
-func returnAllAccounts(w http.ResponseWriter, r *http.Request) {
-	accounts, err := bookkeeper.GetAllAccounts(dbpool, MAX_NUM_RECORDS, 0)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+func (s *Server) returnAllAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := bookkeeper.GetAllAccounts(r.Context(), s.db, s.config.MaxNumRecords, 0)
+	if !checkErr(err, w, 500, "Failed to get accounts") {
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(accounts)
 }
 
-func returnSingleAccount(w http.ResponseWriter, r *http.Request) {
-	sugar := zap.L().Sugar()
-	defer sugar.Sync()
-
+func (s *Server) returnSingleAccount(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["id"]
 	id, err := strconv.Atoi(key)
 	if err != nil {
-		http.Error(w, "Invalid id in query", 400)
+		writeError(w, "Invalid id in query", 400)
 		return
 	}
-	account, err := bookkeeper.GetSingleAccount(dbpool, id)
-	if err != nil {
-		if strings.Contains(err.Error(), "no rows in result set") {
-			http.Error(w, "Account not found", 404)
-		} else {
-			http.Error(w, "Internal server error", 500)
-			sugar.Errorw("failed to get account", "account_id", id, "error", err)
-		}
+	account, err := bookkeeper.GetSingleAccount(r.Context(), s.db, id)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, "Account not found", 404)
+		return
+	}
+	if !checkErr(err, w, 500, "failed to get account", "account_id", id) {
 		return
 	}
+	w.Header().Set("ETag", etagFor(account.Version))
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(account)
 }
 
-func returnAccountByName(w http.ResponseWriter, r *http.Request) {
-	sugar := zap.L().Sugar()
+func (s *Server) returnAccountByName(w http.ResponseWriter, r *http.Request) {
+	sugar := requestLogger(r)
 	defer sugar.Sync()
 
 	accountName := r.FormValue("accountName")
 	sugar.Infow("got a query on account", "accountName", accountName)
-	account, err := bookkeeper.GetSingleAccountByName(dbpool, accountName)
-	if err != nil {
-		if strings.Contains(err.Error(), "no rows in result set") {
-			http.Error(w, "Account not found", 404)
-		} else {
-			http.Error(w, "Internal server error", 500)
-			sugar.Errorw("failed to get account", "accountName", accountName,
-				"error", err)
-		}
+	account, err := bookkeeper.GetSingleAccountByName(r.Context(), s.db, accountName)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, "Account not found", 404)
 		return
 	}
+	if !checkErr(err, w, 500, "failed to get account", "accountName", accountName) {
+		return
+	}
+	w.Header().Set("ETag", etagFor(account.Version))
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(account)
 }
 
-func postAccount(w http.ResponseWriter, r *http.Request) {
-	postOrPatchAccount(w, r, -1)
+func (s *Server) postAccount(w http.ResponseWriter, r *http.Request) {
+	s.postOrPatchAccount(w, r, -1, 0)
 }
 
-func patchAccount(w http.ResponseWriter, r *http.Request) {
+func (s *Server) patchAccount(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if !checkErr(err, w, 400, "Invalid account id provided") {
 		return
 	}
-	postOrPatchAccount(w, r, id)
+	version, ok := parseIfMatchAndFail(w, r)
+	if !ok {
+		return
+	}
+	s.postOrPatchAccount(w, r, id, version)
 }
 
-func postOrPatchAccount(w http.ResponseWriter, r *http.Request, accountId int) {
+func (s *Server) postOrPatchAccount(
+	w http.ResponseWriter, r *http.Request, accountId int, version int,
+) {
 	var account bookkeeper.Account
 
 	body, err := ioutil.ReadAll(r.Body)
 	if !checkErr(err, w, 400, "Failed to read the request body") {
 		return
 	}
-	err = json.Unmarshal(body, &account)
-	if !checkErr(err, w, 400, "Failed to parse the request body as a JSON string") {
-		return
+	if accountId < 0 {
+		err = json.Unmarshal(body, &account)
+		if !checkErr(err, w, 400, "Failed to parse the request body as a JSON string") {
+			return
+		}
+	} else {
+		// only the fields in the payload change
+		current, err := bookkeeper.GetSingleAccount(r.Context(), s.db, accountId)
+		if errors.Is(err, bookkeeper.ErrNotFound) {
+			writeError(w, "Cannot find account with the specified id", 404)
+			return
+		}
+		if !checkErr(err, w, 500, "Failed to get account", "account_id", accountId) {
+			return
+		}
+		// so that no one can take over an account by patching its owners
+		if !s.checkCanPostAndFail(w, r, accountId) {
+			return
+		}
+		err = applyMergePatch(current, body, &account)
+		if !checkErr(err, w, 400, "Failed to apply the request body as a JSON merge patch") {
+			return
+		}
+		// overwrite the id and the version in the payload
+		account.Id, account.Version = accountId, version
 	}
-	if !account.Validate() {
-		checkErr(
-			fmt.Errorf("validation of account failed"), w, 400,
-			"Invalid account payload", "account", account,
-		)
+	if !checkErr(account.Validate(), w, 400, "Invalid account payload",
+		"account", account) {
 		return
 	}
 
 	if accountId < 0 {
-		err := bookkeeper.InsertAccount(dbpool, &account)
+		err := s.changeWithEvents(r.Context(), func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+			err := bookkeeper.InsertAccount(r.Context(), tx, &account)
+			return []bookkeeper.Event{
+				newEvent(bookkeeper.EventAccountCreated, account.Id, account),
+			}, err
+		})
 		if !checkErr(err, w, 500, "Failed to insert account") {
 			return
 		}
+		w.Header().Set("ETag", etagFor(account.Version))
 		json.NewEncoder(w).Encode(account)
 	} else {
-		// overwrite the id in the payload
-		account.Id = accountId
-		err := bookkeeper.UpdateAccount(dbpool, &account)
-		if err != nil && strings.Contains(err.Error(), "no rows in result set") {
-			http.Error(w, "Cannot find account with the specified id", 404)
+		err := s.changeWithEvents(r.Context(), func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+			err := bookkeeper.UpdateAccount(r.Context(), tx, &account)
+			return []bookkeeper.Event{
+				newEvent(bookkeeper.EventAccountUpdated, account.Id, account),
+			}, err
+		})
+		if errors.Is(err, bookkeeper.ErrNotFound) {
+			writeError(w, "Cannot find account with the specified id", 404)
+			return
+		}
+		if errors.Is(err, bookkeeper.ErrVersionMismatch) {
+			writeError(w, "Account has been changed since it was fetched", 412)
 			return
 		}
 		if !checkErr(err, w, 500, "Failed to update account", "accout_id", accountId) {
 			return
 		}
+		w.Header().Set("ETag", etagFor(account.Version))
 		json.NewEncoder(w).Encode(account)
 	}
 }
 
-func deleteAccount(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if !checkErr(err, w, 400, "Invalid account id provided") {
+		return
+	}
+	version, ok := parseIfMatchAndFail(w, r)
+	if !ok {
+		return
+	}
+	if !s.checkCanPostAndFail(w, r, id) {
+		return
+	}
+	err = s.changeWithEvents(r.Context(), func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		err := bookkeeper.DeleteAccount(r.Context(), tx, id, version)
+		return []bookkeeper.Event{newEvent(bookkeeper.EventAccountDeleted, id, nil)}, err
+	})
+	if errors.Is(err, bookkeeper.ErrConflict) {
+		writeError(w, "Failed to delete account. Account may be referenced by a transaction; merge it into another account instead.", 409)
+		return
+	}
+	if errors.Is(err, bookkeeper.ErrVersionMismatch) {
+		writeError(w, "Account has been changed since it was fetched", 412)
+		return
+	}
+	if !checkErr(err, w, 500, "Failed to delete account", "accout_id", id) {
+		return
+	}
+}
+
+// mergeAccount moves every transaction of the account onto the account given
+// by into, and deletes it. With dryRun, it only tells what the merge would do.
+func (s *Server) mergeAccount(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if !checkErr(err, w, 400, "Invalid account id provided") {
 		return
 	}
-	err = bookkeeper.DeleteAccount(dbpool, id)
-	if err != nil && strings.Contains(err.Error(), "violates foreign key constraint") {
-		http.Error(w, "Failed to update account. Account may be referenced by a transaction.", 500)
+	intoId, err := strconv.Atoi(r.FormValue("into"))
+	if !checkErr(err, w, 400, "Invalid id of the account to merge into") {
+		return
+	}
+	dryRun := false
+	if dryRunStr := r.FormValue("dryRun"); dryRunStr != "" {
+		dryRun, err = strconv.ParseBool(dryRunStr)
+		if !checkErr(err, w, 400, "Invalid dryRun provided") {
+			return
+		}
+	}
+	// a dry run changes nothing, so it needs no version
+	version := 0
+	if !dryRun {
+		var ok bool
+		if version, ok = parseIfMatchAndFail(w, r); !ok {
+			return
+		}
+	}
+	var merge bookkeeper.AccountMerge
+	err = s.changeWithEvents(r.Context(), func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		var err error
+		merge, err = bookkeeper.MergeAccounts(
+			r.Context(), tx, id, intoId, version, dryRun, canPostCheck(r.Context()))
+		if err != nil || dryRun {
+			return nil, err
+		}
+		var evs []bookkeeper.Event
+		for _, transId := range merge.TransactionIds {
+			evs = append(evs, newEvent(bookkeeper.EventTransactionUpdated, transId, nil))
+		}
+		return append(evs, newEvent(bookkeeper.EventAccountDeleted, id, merge.From)), nil
+	})
+	if errors.Is(err, bookkeeper.ErrVersionMismatch) {
+		writeError(w, "Account has been changed since it was fetched", 412)
 		return
 	}
-	if !checkErr(err, w, 500, "Failed to update account", "accout_id", id) {
+	if !checkErr(err, w, 500, "Failed to merge accounts",
+		"account_id", id, "into", intoId) {
 		return
 	}
+	json.NewEncoder(w).Encode(merge)
 }
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	tokenContextKey
+	loggerContextKey
+	graphqlLoaderContextKey
+)
+
+// routes that can be reached without a token
+var publicPaths = []string{
+	"/", "/openapi.json", "/auth/login", "/healthz", "/readyz", "/dashboard",
+}
+
+// the files of the dashboard are public too; it logs in by itself
+func isPublicPath(path string) bool {
+	for _, p := range publicPaths {
+		if path == p {
+			return true
+		}
+	}
+	return strings.HasPrefix(path, "/dashboard/")
+}
+
+func isReadOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead ||
+		method == http.MethodOptions
+}
+
+// isReadOnlyRequest tells if the request cannot change data. GraphQL queries
+// are POSTed, but the schema has no mutations.
+func isReadOnlyRequest(r *http.Request) bool {
+	return isReadOnlyMethod(r.Method) || r.URL.Path == "/graphql"
+}
+
+// authMiddleware requires a valid bearer token on every request to a
+// non-public route, and a read-write token on every request that may change
+// data. The user and the token are attached to the request context.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret := strings.TrimSpace(strings.TrimPrefix(
+			r.Header.Get("Authorization"), "Bearer "))
+		if secret == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bkpsrv"`)
+			writeError(w, "Missing bearer token", 401)
+			return
+		}
+		token, err := bookkeeper.GetActiveApiTokenByHash(
+			r.Context(), s.db, bookkeeper.HashApiToken(secret))
+		if errors.Is(err, bookkeeper.ErrNotFound) {
+			w.Header().Set("WWW-Authenticate",
+				`Bearer realm="bkpsrv", error="invalid_token"`)
+			writeError(w, "Invalid or revoked token", 401)
+			return
+		}
+		if !checkErr(err, w, 500, "Failed to look up token") {
+			return
+		}
+		if !isReadOnlyRequest(r) && !token.AllowsWrite() {
+			w.Header().Set("WWW-Authenticate",
+				`Bearer realm="bkpsrv", error="insufficient_scope"`)
+			writeError(w, "The token is read-only", 403)
+			return
+		}
+		user, err := bookkeeper.GetUserById(r.Context(), s.db, token.UserId)
+		if !checkErr(err, w, 500, "Failed to look up user",
+			"user_id", token.UserId) {
+			return
+		}
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, tokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// the authenticated user of the request; ok is false if auth is disabled
+func userFromRequest(r *http.Request) (user bookkeeper.User, ok bool) {
+	return userFromContext(r.Context())
+}
+
+// the authenticated user of a REST request or a gRPC call
+func userFromContext(ctx context.Context) (user bookkeeper.User, ok bool) {
+	user, ok = ctx.Value(userContextKey).(bookkeeper.User)
+	return
+}
+
+// postingDeniedReason tells why the authenticated member may not post to the
+// account, or returns "" if they own it or it belongs to the whole household.
+// Everyone may post to any account when auth is disabled.
+func (s *Server) postingDeniedReason(ctx context.Context, accountId int) (string, error) {
+	user, ok := userFromContext(ctx)
+	if !ok {
+		return "", nil
+	}
+	account, err := bookkeeper.GetSingleAccount(ctx, s.db, accountId)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		// let the insert or update report the missing account
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return postingDeniedReasonFor(user, account), nil
+}
+
+func postingDeniedReasonFor(user bookkeeper.User, account bookkeeper.Account) string {
+	if account.AllowsPostingBy(user.Id) {
+		return ""
+	}
+	return fmt.Sprintf("%s cannot post to account %s, which they do not own",
+		user.Name, account.Name)
+}
+
+// canPostCheck returns the check, for the functions of the bookkeeper package
+// that lock the accounts before they change them, that fails with a
+// PermissionError unless the authenticated member may post to the account
+func canPostCheck(ctx context.Context) func(bookkeeper.Account) error {
+	user, ok := userFromContext(ctx)
+	return func(account bookkeeper.Account) error {
+		if !ok {
+			return nil
+		}
+		if reason := postingDeniedReasonFor(user, account); reason != "" {
+			return &bookkeeper.PermissionError{Reason: reason}
+		}
+		return nil
+	}
+}
+
+// checkCanPostAndFail fails the request unless the authenticated member may
+// post to all of the accounts
+func (s *Server) checkCanPostAndFail(
+	w http.ResponseWriter, r *http.Request, accountIds ...int,
+) bool {
+	for _, id := range accountIds {
+		reason, err := s.postingDeniedReason(r.Context(), id)
+		if !checkErr(err, w, 500, "Failed to get account", "account_id", id) {
+			return false
+		}
+		if reason != "" {
+			writeError(w, reason, 403)
+			return false
+		}
+	}
+	return true
+}
+
+func returnCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFromRequest(r)
+	if !ok {
+		writeError(w, "There is no current user when auth is disabled", 404)
+		return
+	}
+	json.NewEncoder(w).Encode(user)
+}
+
+// checked against the password of a user that does not exist, so that
+// logging in takes as long as for one that does, and the response time does
+// not tell which user names exist. It is the bcrypt hash of a password nobody
+// logs in with, at the default cost.
+var unknownUser = bookkeeper.User{
+	PasswordHash: "$2a$10$eBnfr4q0.9Kv8Cp4srjnfutQtNmacWBYZ/6zYFm0y/78OQzztbNdS",
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var req bookkeeper.LoginRequest
+
+	body, err := ioutil.ReadAll(r.Body)
+	if !checkErr(err, w, 400, "Failed to read the request body") {
+		return
+	}
+	err = json.Unmarshal(body, &req)
+	if !checkErr(err, w, 400, "Failed to parse the request body as a JSON string") {
+		return
+	}
+	user, err := bookkeeper.GetUserByName(r.Context(), s.db, req.Name)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		unknownUser.CheckPassword(req.Password)
+		writeError(w, "Invalid user name or password", 401)
+		return
+	}
+	if err == nil && !user.CheckPassword(req.Password) {
+		writeError(w, "Invalid user name or password", 401)
+		return
+	}
+	if !checkErr(err, w, 500, "Failed to look up user", "name", req.Name) {
+		return
+	}
+	token := bookkeeper.ApiToken{
+		UserId: user.Id, Name: req.TokenName, Scope: req.Scope,
+	}
+	s.createApiToken(w, r, token)
+}
+
+func (s *Server) postApiToken(w http.ResponseWriter, r *http.Request) {
+	var token bookkeeper.ApiToken
+
+	user, ok := userFromRequest(r)
+	if !ok {
+		writeError(w, "Tokens are not available when auth is disabled", 400)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if !checkErr(err, w, 400, "Failed to read the request body") {
+		return
+	}
+	err = json.Unmarshal(body, &token)
+	if !checkErr(err, w, 400, "Failed to parse the request body as a JSON string") {
+		return
+	}
+	token.UserId = user.Id
+	s.createApiToken(w, r, token)
+}
+
+func (s *Server) createApiToken(
+	w http.ResponseWriter, r *http.Request, token bookkeeper.ApiToken,
+) {
+	if !checkErr(token.Validate(), w, 400, "Invalid token payload") {
+		return
+	}
+	secret, err := token.NewSecret()
+	if !checkErr(err, w, 500, "Failed to generate token") {
+		return
+	}
+	err = bookkeeper.InsertApiToken(r.Context(), s.db, &token)
+	if !checkErr(err, w, 500, "Failed to insert token", "user_id", token.UserId) {
+		return
+	}
+	json.NewEncoder(w).Encode(bookkeeper.NewApiTokenResponse{
+		Token: secret, ApiToken: token,
+	})
+}
+
+func (s *Server) returnApiTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFromRequest(r)
+	if !ok {
+		writeError(w, "Tokens are not available when auth is disabled", 400)
+		return
+	}
+	tokens, err := bookkeeper.GetApiTokensByUser(r.Context(), s.db, user.Id)
+	if !checkErr(err, w, 500, "Failed to get tokens", "user_id", user.Id) {
+		return
+	}
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (s *Server) revokeApiToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := userFromRequest(r)
+	if !ok {
+		writeError(w, "Tokens are not available when auth is disabled", 400)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if !checkErr(err, w, 400, "Invalid token id provided") {
+		return
+	}
+	err = bookkeeper.RevokeApiToken(r.Context(), s.db, user.Id, id)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, "Token not found", 404)
+		return
+	}
+	if !checkErr(err, w, 500, "Failed to revoke token", "token_id", id) {
+		return
+	}
+}
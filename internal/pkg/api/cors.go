@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The headers that a page of another origin may send and read
+const (
+	corsAllowMethods  = "GET, POST, PUT, PATCH, DELETE"
+	corsAllowHeaders  = "Authorization, Content-Type, If-Match, Idempotency-Key, Last-Event-ID, X-Request-Id"
+	corsExposeHeaders = "ETag, Link, X-Total-Count, X-Request-Id, Content-Disposition"
+	corsMaxAge        = "600"
+)
+
+// corsMiddleware lets pages of the allowed origins, e.g. a dashboard hosted
+// elsewhere, call the API; "*" allows any origin. The dashboard served by the
+// server itself is of the same origin and does not need it. It wraps the
+// router, so that preflight requests are answered before routing.
+func corsMiddleware(allowedOrigins []string, next http.Handler) http.Handler {
+	allowAll := false
+	allowed := make(map[string]bool)
+	for _, origin := range allowedOrigins {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "*" {
+			allowAll = true
+		}
+		allowed[origin] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !allowAll && !allowed[origin] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// tokens are sent in headers rather than cookies, so credentials are
+		// never allowed
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions &&
+			r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lirenzhucn/bookkeeper/configs"
+)
+
+// The web dashboard, a single page that uses the API with a token of its own
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+type dashboardAsset struct {
+	content []byte
+	etag    string
+}
+
+// the files of the dashboard, and under configs/ the configuration files that
+// it reads, by their paths under /dashboard/
+var dashboardAssets = loadDashboardAssets()
+
+func loadDashboardAssets() map[string]dashboardAsset {
+	assets := make(map[string]dashboardAsset)
+	add := func(fsys fs.FS, root string, prefix string) {
+		err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			content, err := fs.ReadFile(fsys, p)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(content)
+			assets[prefix+strings.TrimPrefix(p, root+"/")] = dashboardAsset{
+				content: content,
+				etag:    `"` + hex.EncodeToString(sum[:8]) + `"`,
+			}
+			return nil
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	add(dashboardFiles, "dashboard", "")
+	add(configs.Files, ".", "configs/")
+	return assets
+}
+
+// serveDashboard serves the files of the dashboard. They are not versioned by
+// name, so browsers must revalidate them by their ETags before every use.
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/dashboard" {
+		http.Redirect(w, r, "/dashboard/", http.StatusMovedPermanently)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/dashboard")
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		name = "index.html"
+	}
+	asset, ok := dashboardAssets[name]
+	if !ok {
+		writeError(w, "Not found", 404)
+		return
+	}
+	w.Header().Set("ETag", asset.etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if name == "index.html" {
+		w.Header().Set("Content-Security-Policy",
+			"default-src 'self'; frame-ancestors 'none'")
+	}
+	// answers If-None-Match with 304, and sets the type by the extension
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(asset.content))
+}
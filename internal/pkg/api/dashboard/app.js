@@ -0,0 +1,452 @@
+// The bookkeeper dashboard. It talks to the API of the server that serves it,
+// with a token that it keeps in the local storage of the browser.
+"use strict";
+
+const TOKEN_KEY = "bookkeeper.token";
+
+// the tags that the reports collect, the same as the defaults of bkpctl
+const ASSET_TAGS = ["cash", "taxable+liquid", "retirement", "education",
+  "nonliquid", "real estate"];
+const LIABILITY_TAGS = ["credit card", "loan"];
+const REVENUE_TAGS = ["Professional Income/Salary", "Professional Income/RSU",
+  "Professional Income/Employer Match", "Other Income/"];
+const TAXES_TAGS = ["Taxes/"];
+const EXPENSES_TAGS = ["Home/Mortgage Interest", "Home/Loan Fees", "Home/HOA",
+  "Food & Dining/", "Kids/", "Bills & Utilities/", "Transportation/",
+  "Entertainment/", "Shopping/", "Communications/", "Medical Exp/",
+  "Other Exp/"];
+const INVESTMENTS_TAGS = ["Investment/Taxable Investment",
+  "Investment/Retirement Investment", "Investment/Education Investment"];
+
+const NET_WORTH_MONTHS = 12;
+const RECENT_DAYS = 30;
+const PAGE_SIZE = 50;
+
+const $ = (id) => document.getElementById(id);
+
+class HttpError extends Error {
+  constructor(status, message) {
+    super(message);
+    this.status = status;
+  }
+}
+
+// apiResponse calls the API and returns the decoded JSON body and the headers
+// of the response, or throws an HttpError with the message of the server
+async function apiResponse(path, options = {}) {
+  const headers = Object.assign({}, options.headers);
+  const token = localStorage.getItem(TOKEN_KEY);
+  if (token) {
+    headers["Authorization"] = "Bearer " + token;
+  }
+  if (options.body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  const resp = await fetch("/" + path, {
+    method: options.method || "GET",
+    headers: headers,
+    body: options.body === undefined ? undefined : JSON.stringify(options.body),
+  });
+  const text = await resp.text();
+  let body = null;
+  try {
+    body = text ? JSON.parse(text) : null;
+  } catch (e) {
+    body = text;
+  }
+  if (!resp.ok) {
+    throw new HttpError(resp.status, errorMessage(body) || resp.statusText);
+  }
+  return { body: body, headers: resp.headers };
+}
+
+// errorMessage reads the message of an error response of the API, followed by
+// the reasons of the fields that failed, if any
+function errorMessage(body) {
+  const error = body && body.error;
+  if (!error || !error.message) {
+    return "";
+  }
+  const fields = (error.fields || []).map((f) => f.field + " " + f.reason);
+  return fields.length ? error.message + ": " + fields.join("; ") : error.message;
+}
+
+async function api(path, options = {}) {
+  return (await apiResponse(path, options)).body;
+}
+
+// query builds the query string of a URL from an object
+function query(params) {
+  return Object.entries(params)
+    .map(([k, v]) => encodeURIComponent(k) + "=" + encodeURIComponent(v))
+    .join("&");
+}
+
+// the configuration files of the server, e.g. "tpl/balance_sheet_tpl.json"
+async function config(path) {
+  const resp = await fetch("configs/" + path);
+  if (!resp.ok) {
+    throw new HttpError(resp.status, "Failed to load " + path);
+  }
+  return resp.json();
+}
+
+// dates are written like 2021/12/31 in queries and reports
+function formatDate(d) {
+  const pad = (n) => String(n).padStart(2, "0");
+  return d.getFullYear() + "/" + pad(d.getMonth() + 1) + "/" + pad(d.getDate());
+}
+
+function formatAmount(cents) {
+  return (cents / 100).toLocaleString(undefined, {
+    minimumFractionDigits: 2, maximumFractionDigits: 2,
+  });
+}
+
+function el(tag, attrs = {}, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs)) {
+    if (k === "class") {
+      e.className = v;
+    } else {
+      e.setAttribute(k, v);
+    }
+  }
+  for (const c of children) {
+    e.append(c);
+  }
+  return e;
+}
+
+function svg(tag, attrs = {}, text) {
+  const e = document.createElementNS("http://www.w3.org/2000/svg", tag);
+  for (const [k, v] of Object.entries(attrs)) {
+    e.setAttribute(k, v);
+  }
+  if (text !== undefined) {
+    e.textContent = text;
+  }
+  return e;
+}
+
+// show runs a loader and shows its error, if any, in the element
+async function show(errorId, loader) {
+  $(errorId).textContent = "";
+  try {
+    await loader();
+  } catch (e) {
+    if (e instanceof HttpError && e.status === 401) {
+      logOut();
+      return;
+    }
+    $(errorId).textContent = e.message;
+  }
+}
+
+// Reports
+
+// the statement field of a report schema tag, e.g. "RevenueNetTaxes", is
+// the JSON key "revenue_net_taxes"
+function fieldKey(field) {
+  return field.replace(/([a-z])([A-Z])/g, "$1_$2").toLowerCase();
+}
+
+// itemValue adds up the figures of the statement that the schema maps to the
+// item, like ReportSchema does on the server
+function itemValue(schema, statement, itemName) {
+  const tags = schema.mapping[itemName];
+  if (!tags) {
+    throw new Error("missing item (" + itemName + ") in mapping");
+  }
+  let value = 0;
+  for (const tag of tags) {
+    const i = tag.indexOf("/");
+    if (i < 0) {
+      throw new Error("invalid tag (" + tag + ") in schema");
+    }
+    const field = statement[fieldKey(tag.slice(0, i))];
+    const name = tag.slice(i + 1);
+    if (typeof field === "number") {
+      value += name === "TOTAL" ? field : 0;
+    } else if (field) {
+      value += name === "TOTAL" ? field.total : (field.groups[name] || 0);
+    } else {
+      throw new Error("invalid tag (" + tag + ") in schema");
+    }
+  }
+  return value;
+}
+
+function renderReport(table, schema, statement) {
+  table.replaceChildren();
+  for (const itemName of schema.order) {
+    if (itemName === "-") {
+      table.append(el("tr", { class: "blank" }, el("td", { colspan: 2 })));
+      continue;
+    }
+    const value = itemValue(schema, statement, itemName);
+    const formatters = (schema.formatters && schema.formatters[itemName]) || [];
+    table.append(el("tr", { class: formatters.join(" ") },
+      el("td", {}, itemName),
+      el("td", { class: "amount" + (value < 0 ? " negative" : "") },
+        formatAmount(value))));
+  }
+}
+
+async function loadBalanceSheet() {
+  const date = formatDate(new Date());
+  const [schema, sheets] = await Promise.all([
+    config("tpl/balance_sheet_tpl.json"),
+    api("reporting/balance_sheet?" + query({
+      date: date,
+      assetTags: ASSET_TAGS.join(","),
+      liabilityTags: LIABILITY_TAGS.join(","),
+    })),
+  ]);
+  $("balance-sheet-date").textContent = date;
+  renderReport($("balance-sheet"), schema, sheets[0]);
+}
+
+async function loadIncomeStatement() {
+  const today = new Date();
+  const range = formatDate(new Date(today.getFullYear(), 0, 1)) + "-" +
+    formatDate(today);
+  const [schema, statements] = await Promise.all([
+    config("tpl/income_statement_tpl.json"),
+    api("reporting/income_statement?" + query({
+      dateRange: range,
+      revenueTags: REVENUE_TAGS.join(","),
+      taxesTags: TAXES_TAGS.join(","),
+      expensesTags: EXPENSES_TAGS.join(","),
+      investmentsTags: INVESTMENTS_TAGS.join(","),
+    })),
+  ]);
+  $("income-statement-range").textContent = range;
+  renderReport($("income-statement"), schema, statements[0]);
+}
+
+// Net worth
+
+// the equities at the end of each of the last months, and today
+async function loadNetWorth() {
+  const today = new Date();
+  const dates = [];
+  for (let i = NET_WORTH_MONTHS; i > 0; i--) {
+    dates.push(new Date(today.getFullYear(), today.getMonth() - i + 1, 0));
+  }
+  dates.push(today);
+  const sheets = await api("reporting/balance_sheet?" + query({
+    date: dates.map(formatDate).join(","),
+    assetTags: ASSET_TAGS.join(","),
+    liabilityTags: LIABILITY_TAGS.join(","),
+  }));
+  drawNetWorth(dates, sheets.map((bs) => bs.equities));
+}
+
+function drawNetWorth(dates, values) {
+  const chart = $("net-worth-chart");
+  const [width, height] = [800, 240];
+  const [left, right, top, bottom] = [90, 10, 10, 25];
+  chart.replaceChildren();
+  let min = Math.min(0, ...values);
+  let max = Math.max(...values);
+  if (max === min) {
+    max = min + 100;
+  }
+  const x = (i) => left + i * (width - left - right) / (values.length - 1);
+  const y = (v) => top + (max - v) * (height - top - bottom) / (max - min);
+  for (const v of [min, (min + max) / 2, max]) {
+    chart.append(svg("line", {
+      class: "grid", x1: left, x2: width - right, y1: y(v), y2: y(v),
+    }));
+    chart.append(svg("text", { x: 4, y: y(v) + 4 }, formatAmount(v)));
+  }
+  dates.forEach((d, i) => {
+    if (i % 3 === 0 || i === dates.length - 1) {
+      chart.append(svg("text", {
+        x: x(i) - 30, y: height - 6,
+      }, formatDate(d)));
+    }
+  });
+  chart.append(svg("polyline", {
+    class: "line",
+    points: values.map((v, i) => x(i) + "," + y(v)).join(" "),
+  }));
+}
+
+// Transactions
+
+async function loadTransactions() {
+  const { body, headers } = await apiResponse("transactions?" + query({
+    queryString: $("query").value,
+    limit: PAGE_SIZE,
+  }));
+  const rows = $("transactions-table").tBodies[0];
+  rows.replaceChildren();
+  for (const t of body) {
+    rows.append(el("tr", {},
+      el("td", {}, formatDate(new Date(t.date.slice(0, 10) + "T00:00:00"))),
+      el("td", {}, t.type),
+      el("td", {}, t.category),
+      el("td", {}, t.sub_category),
+      el("td", {}, t.account_name),
+      el("td", { class: "amount" + (t.amount < 0 ? " negative" : "") },
+        formatAmount(t.amount)),
+      el("td", {}, t.notes)));
+  }
+  const total = headers.get("X-Total-Count");
+  $("transactions-count").textContent = total && Number(total) > body.length
+    ? "Showing " + body.length + " of " + total + " transactions"
+    : body.length + " transactions";
+}
+
+// Expenses
+
+async function loadExpenseForm() {
+  const form = $("expense-form");
+  form.elements.date.value = formatDate(new Date()).replace(/\//g, "-");
+  const [accounts, categories] = await Promise.all([
+    api("accounts"), config("category_map.json"),
+  ]);
+  form.elements.account.replaceChildren(...accounts.map(
+    (a) => el("option", { value: a.id }, a.name)));
+  form.elements.category.replaceChildren(...categories.map(
+    (c) => el("option", {}, c.category)));
+  const updateSubCategories = () => {
+    const c = categories.find((c) => c.category === form.elements.category.value);
+    form.elements.sub_category.replaceChildren(...(c ? c.sub_categories : []).map(
+      (s) => el("option", {}, s)));
+  };
+  form.elements.category.onchange = updateSubCategories;
+  updateSubCategories();
+}
+
+// newIdempotencyKey returns a random key. crypto.randomUUID only exists in
+// secure contexts, and bkpsrv serves plain HTTP.
+function newIdempotencyKey() {
+  const bytes = new Uint8Array(16);
+  if (window.crypto && crypto.getRandomValues) {
+    crypto.getRandomValues(bytes);
+  } else {
+    bytes.forEach((_, i) => { bytes[i] = Math.floor(Math.random() * 256); });
+  }
+  return Array.from(bytes, (b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+async function addExpense(form) {
+  const amount = Math.round(Number(form.elements.amount.value) * 100);
+  // one key per filled in form, so that resubmitting it after an error
+  // cannot add the expense twice; editing the form starts a new one
+  if (!form.dataset.idempotencyKey) {
+    form.dataset.idempotencyKey = newIdempotencyKey();
+  }
+  const transaction = await api("transactions", {
+    method: "POST",
+    headers: { "Idempotency-Key": form.dataset.idempotencyKey },
+    body: {
+      type: "Out",
+      date: form.elements.date.value + "T00:00:00Z",
+      category: form.elements.category.value,
+      sub_category: form.elements.sub_category.value,
+      account_id: Number(form.elements.account.value),
+      amount: -amount,
+      notes: form.elements.notes.value,
+    },
+  });
+  delete form.dataset.idempotencyKey;
+  form.elements.amount.value = "";
+  form.elements.notes.value = "";
+  $("expense-done").textContent = "Added transaction " + transaction.id;
+  await Promise.all([
+    show("transactions-error", loadTransactions),
+    show("balance-sheet-error", loadBalanceSheet),
+    show("income-statement-error", loadIncomeStatement),
+  ]);
+}
+
+// Login
+
+function logOut() {
+  localStorage.removeItem(TOKEN_KEY);
+  $("main").hidden = true;
+  $("logout").hidden = true;
+  $("user").textContent = "";
+  $("login").hidden = false;
+}
+
+async function logIn(form) {
+  const resp = await api("auth/login", {
+    method: "POST",
+    body: {
+      name: form.elements.name.value,
+      password: form.elements.password.value,
+      token_name: "dashboard " + new Date().toISOString(),
+      scope: "read-write",
+    },
+  });
+  localStorage.setItem(TOKEN_KEY, resp.token);
+  form.reset();
+  await start();
+}
+
+// start shows the dashboard if there is a valid token, or if the server does
+// not require one, and the login form otherwise
+async function start() {
+  try {
+    const user = await api("auth/me");
+    $("user").textContent = user.name;
+    $("logout").hidden = false;
+  } catch (e) {
+    // there is no current user when auth is disabled
+    if (!(e instanceof HttpError && e.status === 404)) {
+      logOut();
+      return;
+    }
+  }
+  $("login").hidden = true;
+  $("main").hidden = false;
+  const since = new Date();
+  since.setDate(since.getDate() - RECENT_DAYS);
+  if (!$("query").value) {
+    $("query").value = "date >= " + formatDate(since) + " ORDER BY date DESC";
+  }
+  await Promise.all([
+    show("net-worth-error", loadNetWorth),
+    show("balance-sheet-error", loadBalanceSheet),
+    show("income-statement-error", loadIncomeStatement),
+    show("transactions-error", loadTransactions),
+    show("expense-error", loadExpenseForm),
+  ]);
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("login-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    show("login-error", () => logIn(e.target));
+  });
+  $("logout").addEventListener("click", logOut);
+  $("query-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    show("transactions-error", loadTransactions);
+  });
+  $("expense-form").addEventListener("input", (e) => {
+    delete e.currentTarget.dataset.idempotencyKey;
+  });
+  $("expense-form").addEventListener("submit", async (e) => {
+    e.preventDefault();
+    const form = e.target;
+    const button = form.querySelector("button[type=submit]");
+    // one request at a time, so that a double click adds the expense once
+    if (button.disabled) {
+      return;
+    }
+    button.disabled = true;
+    $("expense-done").textContent = "";
+    try {
+      await show("expense-error", () => addExpense(form));
+    } finally {
+      button.disabled = false;
+    }
+  });
+  start();
+});
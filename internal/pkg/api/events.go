@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+)
+
+var (
+	// how often a stream checks for events written by other processes, e.g.
+	// an import run directly against the database; it also keeps the
+	// connection alive
+	EVENT_POLL_INTERVAL = 5 * time.Second
+	// how long a stream lasts before the client has to reconnect; it must be
+	// shorter than REQUEST_TIMEOUT
+	EVENT_STREAM_DURATION = 30 * time.Second
+	// how long a client waits before reconnecting
+	EVENT_RETRY_INTERVAL = time.Second
+)
+
+// the number of events read from the database at a time
+const eventPageSize = 500
+
+// eventHub wakes up the streams whenever this server records an event
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[chan struct{}]bool)}
+}
+
+func (h *eventHub) subscribe() chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	h.subscribers[ch] = true
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, ch)
+}
+
+func (h *eventHub) notify() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		// a pending wake-up is as good as a new one
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// newEvent describes a change to an entity; data is the entity after the
+// change (before it, for a deletion), or nil
+func newEvent(eventType string, entityId int, data interface{}) bookkeeper.Event {
+	event := bookkeeper.Event{Type: eventType, EntityId: entityId}
+	if data != nil {
+		event.Data, _ = json.Marshal(data)
+	}
+	return event
+}
+
+// changeWithEvents makes a change in a database transaction, and records the
+// events that change returns in the same one, so that the streams and the
+// webhooks see every change that commits and no other. change passes the
+// transaction on to the bookkeeper functions that make the change.
+func (s *Server) changeWithEvents(
+	ctx context.Context, change func(tx pgx.Tx) ([]bookkeeper.Event, error),
+) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	evs, err := change(tx)
+	if err != nil {
+		return err
+	}
+	if len(evs) > 0 {
+		if err = bookkeeper.InsertEvents(ctx, tx, evs); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	if len(evs) > 0 {
+		s.events.notify()
+	}
+	return nil
+}
+
+// parse the comma separated event types to stream; all types if empty
+func parseEventTypesInQueryAndFail(
+	w http.ResponseWriter, r *http.Request,
+) (types map[string]bool, ok bool) {
+	typesStr := r.FormValue("types")
+	if typesStr == "" {
+		return nil, true
+	}
+	types = make(map[string]bool)
+	for _, t := range strings.Split(typesStr, ",") {
+		t = strings.TrimSpace(t)
+		if !bookkeeper.IsValidEventType(t) {
+			writeError(w, fmt.Sprintf("Unknown event type %s", t), 400)
+			return nil, false
+		}
+		types[t] = true
+	}
+	return types, true
+}
+
+// the id of the last event the client has seen, from the Last-Event-ID header
+// of a reconnecting client, or else from the lastEventId query term. Without
+// either, the stream starts with the next event.
+func (s *Server) parseLastEventIdAndFail(
+	w http.ResponseWriter, r *http.Request,
+) (lastId int64, ok bool) {
+	lastIdStr := r.Header.Get("Last-Event-ID")
+	if lastIdStr == "" {
+		lastIdStr = r.FormValue("lastEventId")
+	}
+	if lastIdStr == "" {
+		lastId, err := bookkeeper.GetLatestEventId(r.Context(), s.db)
+		return lastId, checkErr(err, w, 500, "Failed to get the latest event")
+	}
+	lastId, err := strconv.ParseInt(lastIdStr, 10, 64)
+	if err != nil || lastId < 0 {
+		writeError(w, "Invalid last event id", 400)
+		return 0, false
+	}
+	return lastId, true
+}
+
+// streamEvents serves the change feed as Server-Sent Events. A stream ends
+// after EVENT_STREAM_DURATION, and the client resumes it by reconnecting with
+// the id of the last event it has seen.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	sugar := requestLogger(r)
+	defer sugar.Sync()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, "Streaming is not supported", 500)
+		return
+	}
+	types, ok := parseEventTypesInQueryAndFail(w, r)
+	if !ok {
+		return
+	}
+	lastId, ok := s.parseLastEventIdAndFail(w, r)
+	if !ok {
+		return
+	}
+	// subscribe before reading, so that no event slips in between
+	wakeUp := s.events.subscribe()
+	defer s.events.unsubscribe(wakeUp)
+
+	ctx, cancel := context.WithTimeout(r.Context(), EVENT_STREAM_DURATION)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", EVENT_RETRY_INTERVAL.Milliseconds())
+	flusher.Flush()
+
+	ticker := time.NewTicker(EVENT_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		evs, err := bookkeeper.GetEventsAfter(ctx, s.db, lastId, eventPageSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				sugar.Errorw("failed to read events", "after", lastId, "error", err)
+			}
+			return
+		}
+		for _, e := range evs {
+			lastId = e.Id
+			if types != nil && !types[e.Type] {
+				continue
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Type, data)
+		}
+		flusher.Flush()
+		if len(evs) == eventPageSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wakeUp:
+		case <-ticker.C:
+			// a comment, which clients ignore
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+	}
+}
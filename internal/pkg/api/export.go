@@ -0,0 +1,121 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+)
+
+// The formats that listings and reports may be exported in, besides JSON
+const (
+	formatJson = "json"
+	formatCsv  = "csv"
+	formatXlsx = "xlsx"
+)
+
+var exportContentTypes = map[string]string{
+	formatCsv:  "text/csv",
+	formatXlsx: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// parseFormatInQueryAndFail picks the format of the response from the format
+// query term or, failing that, from the Accept header; JSON by default
+func parseFormatInQueryAndFail(
+	w http.ResponseWriter, r *http.Request,
+) (format string, ok bool) {
+	if format = r.FormValue("format"); format != "" {
+		if _, known := exportContentTypes[format]; !known && format != formatJson {
+			writeError(w, fmt.Sprintf("Invalid format %s", format), 400)
+			return format, false
+		}
+		return format, true
+	}
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		for f, contentType := range exportContentTypes {
+			if mediaType == contentType {
+				return f, true
+			}
+		}
+	}
+	return formatJson, true
+}
+
+// writeTable writes the table as an attachment in the format, named after
+// the table
+func writeTable(
+	w http.ResponseWriter, format string, table bookkeeper.Table, filename string,
+) {
+	var (
+		buf   bytes.Buffer
+		write func(io.Writer) error
+	)
+	switch format {
+	case formatCsv:
+		write = table.WriteCsv
+	case formatXlsx:
+		write = table.WriteXlsx
+	}
+	// write to a buffer first, so that a failure can still be reported
+	if !checkErr(write(&buf), w, 500, "Failed to write table", "format", format) {
+		return
+	}
+	contentType := exportContentTypes[format]
+	if format == formatCsv {
+		contentType += "; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="%s.%s"`, filename, format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// writeTransactions writes a listing of transactions in the format
+func writeTransactions(
+	w http.ResponseWriter, format string, transactions []bookkeeper.Transaction_,
+) {
+	if format != formatJson {
+		writeTable(w, format, bookkeeper.TransactionsTable(transactions), "transactions")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(transactions)
+}
+
+// parseReportSchemaInQueryAndFail reads the report schema, given as JSON in
+// the reportSchema query term, or returns the default if there is none
+func parseReportSchemaInQueryAndFail(
+	w http.ResponseWriter, r *http.Request, defaultSchema bookkeeper.ReportSchema,
+) (schema bookkeeper.ReportSchema, ok bool) {
+	schemaStr := r.FormValue("reportSchema")
+	if schemaStr == "" {
+		return defaultSchema, true
+	}
+	err := json.Unmarshal([]byte(schemaStr), &schema)
+	return schema, checkErr(err, w, 400, "Failed to parse the report schema")
+}
+
+// writeStatements lays out the statements by the schema, one column per
+// header, and writes them in the format
+func writeStatements(
+	w http.ResponseWriter, format string, schema bookkeeper.ReportSchema,
+	title string, statements []bookkeeper.StatementWithFields, headers []string,
+	filename string,
+) {
+	table, err := schema.Table(title, statements, headers)
+	if err != nil {
+		writeError(w, "Invalid report schema: "+err.Error(), 400)
+		return
+	}
+	writeTable(w, format, table, filename)
+}
@@ -0,0 +1,723 @@
+package api
+
+import (
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/api/_peg"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+)
+
+// The GraphQL schema served at /graphql. It only has queries, so that
+// read-only tokens may use it.
+//go:embed schema.graphql
+var graphqlSchemaString string
+
+// how deeply queries may nest, e.g. account > transactions > account > ...
+const graphqlMaxDepth = 8
+
+// the number of transactions in a connection when first is not given
+const defaultConnectionSize = 50
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// serveGraphql runs a GraphQL query sent as a JSON body, or in the query
+// terms of a GET request. Errors of the query are reported in the response
+// body, with status 200, as GraphQL clients expect.
+func (s *Server) serveGraphql(w http.ResponseWriter, r *http.Request) {
+	sugar := requestLogger(r)
+	defer sugar.Sync()
+
+	var req graphqlRequest
+	if r.Method == http.MethodGet {
+		req.Query = r.FormValue("query")
+		req.OperationName = r.FormValue("operationName")
+		if variables := r.FormValue("variables"); variables != "" {
+			err := json.Unmarshal([]byte(variables), &req.Variables)
+			if !checkErr(err, w, 400, "Failed to parse the variables as a JSON object") {
+				return
+			}
+		}
+	} else {
+		body, err := ioutil.ReadAll(r.Body)
+		if !checkErr(err, w, 400, "Failed to read the request body") {
+			return
+		}
+		err = json.Unmarshal(body, &req)
+		if !checkErr(err, w, 400, "Failed to parse the request body as a JSON string") {
+			return
+		}
+	}
+	if req.Query == "" {
+		writeError(w, "The query is required", 400)
+		return
+	}
+	ctx := context.WithValue(r.Context(), graphqlLoaderContextKey, &graphqlLoader{s: s})
+	resp := s.graphqlSchema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	for _, e := range resp.Errors {
+		if e.ResolverError == nil {
+			continue
+		}
+		statusCode, details := describeError(e.ResolverError, 500, e.Message)
+		if statusCode == 500 {
+			sugar.Errorw("failed to resolve a GraphQL field", "path", e.Path,
+				"error", e.ResolverError)
+		}
+		e.Message = details.Message
+		e.Extensions = map[string]interface{}{"code": details.Code}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// graphqlLoader caches the accounts and the balances looked up for a query,
+// so that e.g. the account of every transaction is only fetched once
+type graphqlLoader struct {
+	s        *Server
+	mu       sync.Mutex
+	accounts map[int]bookkeeper.Account
+	balances map[accountOnDate]int64
+}
+
+type accountOnDate struct {
+	accountId int
+	date      time.Time
+}
+
+func loaderOf(ctx context.Context) *graphqlLoader {
+	return ctx.Value(graphqlLoaderContextKey).(*graphqlLoader)
+}
+
+// all accounts, fetched once per query
+func (l *graphqlLoader) allAccounts(ctx context.Context) (map[int]bookkeeper.Account, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.accounts != nil {
+		return l.accounts, nil
+	}
+	accounts, err := bookkeeper.GetAllAccounts(ctx, l.s.db, l.s.config.MaxNumRecords, 0)
+	if err != nil {
+		return nil, err
+	}
+	l.accounts = make(map[int]bookkeeper.Account)
+	for _, a := range accounts {
+		l.accounts[a.Id] = a
+	}
+	return l.accounts, nil
+}
+
+func (l *graphqlLoader) account(ctx context.Context, id int) (bookkeeper.Account, error) {
+	accounts, err := l.allAccounts(ctx)
+	if err != nil {
+		return bookkeeper.Account{}, err
+	}
+	if account, ok := accounts[id]; ok {
+		return account, nil
+	}
+	return bookkeeper.GetSingleAccount(ctx, l.s.db, id)
+}
+
+func (l *graphqlLoader) balance(ctx context.Context, id int, date time.Time) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := accountOnDate{accountId: id, date: date}
+	if balance, ok := l.balances[key]; ok {
+		return balance, nil
+	}
+	_, balance, err := bookkeeper.ComputeAccountBalanceById(ctx, l.s.db, id, date)
+	if err != nil {
+		return 0, err
+	}
+	if l.balances == nil {
+		l.balances = make(map[accountOnDate]int64)
+	}
+	l.balances[key] = balance
+	return balance, nil
+}
+
+// an error about an argument of a field, reported like a failed validation
+func invalidArgument(name string, reason string) error {
+	var verr bookkeeper.ValidationError
+	verr.Add(name, reason)
+	return &verr
+}
+
+func parseIdArgument(name string, id graphql.ID) (int, error) {
+	i, err := strconv.Atoi(string(id))
+	if err != nil {
+		return 0, invalidArgument(name, "must be an integer id")
+	}
+	return i, nil
+}
+
+// parse a date argument as the end of that day, or today if it is absent
+func parseDateArgument(name string, date *string) (time.Time, error) {
+	if date == nil {
+		return parseEndOfDay(time.Now().Format("2006/01/02"))
+	}
+	d, err := parseEndOfDay(*date)
+	if err != nil {
+		return d, invalidArgument(name, "must be a date like 2021/12/31")
+	}
+	return d, nil
+}
+
+type queryResolver struct {
+	s *Server
+}
+
+func (*queryResolver) Accounts(
+	ctx context.Context, args struct{ Tag *string },
+) ([]*accountResolver, error) {
+	accounts, err := loaderOf(ctx).allAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := []*accountResolver{}
+	for _, a := range accounts {
+		if args.Tag == nil || hasTag(a, *args.Tag) {
+			resolvers = append(resolvers, &accountResolver{a})
+		}
+	}
+	sort.Slice(resolvers, func(i, j int) bool {
+		return resolvers[i].account.Id < resolvers[j].account.Id
+	})
+	return resolvers, nil
+}
+
+func hasTag(account bookkeeper.Account, tag string) bool {
+	for _, t := range account.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *queryResolver) Account(
+	ctx context.Context, args struct {
+		Id   *graphql.ID
+		Name *string
+	},
+) (*accountResolver, error) {
+	var (
+		account bookkeeper.Account
+		err     error
+	)
+	switch {
+	case args.Id != nil:
+		var id int
+		if id, err = parseIdArgument("id", *args.Id); err != nil {
+			return nil, err
+		}
+		account, err = loaderOf(ctx).account(ctx, id)
+	case args.Name != nil:
+		account, err = bookkeeper.GetSingleAccountByName(ctx, q.s.db, *args.Name)
+	default:
+		return nil, invalidArgument("id", "id or name is required")
+	}
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &accountResolver{account}, nil
+}
+
+func (q *queryResolver) Transaction(
+	ctx context.Context, args struct{ Id graphql.ID },
+) (*transactionResolver, error) {
+	id, err := parseIdArgument("id", args.Id)
+	if err != nil {
+		return nil, err
+	}
+	trans, err := bookkeeper.GetSingleTransaction(ctx, q.s.db, id)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &transactionResolver{trans}, nil
+}
+
+// the arguments of a field that returns a connection
+type connectionArgs struct {
+	First *int32
+	After *string
+}
+
+func (q *queryResolver) Transactions(
+	ctx context.Context, args struct {
+		Query *string
+		First *int32
+		After *string
+	},
+) (*transactionConnectionResolver, error) {
+	filter := transactionFilter{clause: "true"}
+	if args.Query != nil && strings.TrimSpace(*args.Query) != "" {
+		queryData, err := _peg.ParseString(strings.Trim(*args.Query, "'"))
+		if err != nil {
+			return nil, invalidArgument("query", err.Error())
+		}
+		prepQueryData(&queryData)
+		filter = transactionFilter{
+			clause: queryData.Clause, values: queryData.Values,
+			orderBy: queryData.OrderBy,
+		}
+	}
+	return q.s.newTransactionConnection(ctx, filter,
+		connectionArgs{First: args.First, After: args.After})
+}
+
+func (q *queryResolver) BalanceSheets(
+	ctx context.Context, args struct {
+		Dates         []string
+		AssetTags     []string
+		LiabilityTags []string
+		Owner         *string
+	},
+) ([]*balanceSheetResolver, error) {
+	owner, err := q.s.ownerArgument(ctx, args.Owner)
+	if err != nil {
+		return nil, err
+	}
+	householdSize := 0
+	if owner != "" {
+		if householdSize, err = bookkeeper.CountUsers(ctx, q.s.db); err != nil {
+			return nil, err
+		}
+	}
+	resolvers := []*balanceSheetResolver{}
+	for i, dateStr := range args.Dates {
+		date, err := parseDateArgument(fmt.Sprintf("dates[%d]", i), &dateStr)
+		if err != nil {
+			return nil, err
+		}
+		accounts, err := bookkeeper.GetAllAccountsBalanceOnDate(ctx, q.s.db, date)
+		if err != nil {
+			return nil, err
+		}
+		if owner != "" {
+			accounts = bookkeeper.ApportionBalances(accounts, owner, householdSize)
+		}
+		resolvers = append(resolvers, &balanceSheetResolver{
+			date: dateStr,
+			bs:   bookkeeper.ComputeBalanceSheet(accounts, args.AssetTags, args.LiabilityTags),
+		})
+	}
+	return resolvers, nil
+}
+
+func (q *queryResolver) IncomeStatements(
+	ctx context.Context, args struct {
+		DateRanges      []string
+		RevenueTags     []string
+		TaxesTags       []string
+		ExpensesTags    []string
+		InvestmentsTags []string
+		Owner           *string
+	},
+) ([]*incomeStatementResolver, error) {
+	owner, err := q.s.ownerArgument(ctx, args.Owner)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := []*incomeStatementResolver{}
+	for i, s := range args.DateRanges {
+		dr, err := parseDateRange(s)
+		if err != nil {
+			return nil, invalidArgument(fmt.Sprintf("dateRanges[%d]", i),
+				"must be a date range like 2021/01/01-2021/03/31, 2021Q1 or 2021H2")
+		}
+		is, err := bookkeeper.ComputeIncomeStatement(
+			ctx, q.s.db, dr.startDate, dr.endDate, args.RevenueTags,
+			args.TaxesTags, args.ExpensesTags, args.InvestmentsTags, owner,
+		)
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, &incomeStatementResolver{dr: dr, is: is})
+	}
+	return resolvers, nil
+}
+
+// the owner to report on, which must name a household member; "" for the
+// whole household
+func (s *Server) ownerArgument(ctx context.Context, owner *string) (string, error) {
+	if owner == nil || *owner == "" {
+		return "", nil
+	}
+	_, err := bookkeeper.GetUserByName(ctx, s.db, *owner)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		return "", invalidArgument("owner", fmt.Sprintf("unknown owner %s", *owner))
+	}
+	return *owner, err
+}
+
+type accountResolver struct {
+	account bookkeeper.Account
+}
+
+func (r *accountResolver) Id() graphql.ID {
+	return graphql.ID(strconv.Itoa(r.account.Id))
+}
+
+func (r *accountResolver) Name() string {
+	return r.account.Name
+}
+
+func (r *accountResolver) Desc() string {
+	return r.account.Desc
+}
+
+func (r *accountResolver) Tags() []string {
+	if r.account.Tags == nil {
+		return []string{}
+	}
+	return r.account.Tags
+}
+
+func (r *accountResolver) Owners() []*accountOwnerResolver {
+	owners := []*accountOwnerResolver{}
+	for _, o := range r.account.Owners {
+		owners = append(owners, &accountOwnerResolver{o})
+	}
+	return owners
+}
+
+func (r *accountResolver) Version() int32 {
+	return int32(r.account.Version)
+}
+
+func (r *accountResolver) Balance(
+	ctx context.Context, args struct{ Date *string },
+) (float64, error) {
+	date, err := parseDateArgument("date", args.Date)
+	if err != nil {
+		return 0, err
+	}
+	balance, err := loaderOf(ctx).balance(ctx, r.account.Id, date)
+	return float64(balance), err
+}
+
+func (r *accountResolver) Transactions(
+	ctx context.Context, args struct {
+		StartDate *string
+		EndDate   *string
+		First     *int32
+		After     *string
+	},
+) (*transactionConnectionResolver, error) {
+	filter := transactionFilter{
+		clause: "t.account_id = $1", values: []interface{}{r.account.Id},
+	}
+	if args.StartDate != nil {
+		startDate, err := time.Parse("2006/01/02", *args.StartDate)
+		if err != nil {
+			return nil, invalidArgument("startDate", "must be a date like 2021/12/31")
+		}
+		filter.values = append(filter.values, startDate)
+		filter.clause += fmt.Sprintf(" AND date >= $%d", len(filter.values))
+	}
+	if args.EndDate != nil {
+		endDate, err := parseDateArgument("endDate", args.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		filter.values = append(filter.values, endDate)
+		filter.clause += fmt.Sprintf(" AND date <= $%d", len(filter.values))
+	}
+	return loaderOf(ctx).s.newTransactionConnection(ctx, filter,
+		connectionArgs{First: args.First, After: args.After})
+}
+
+type accountOwnerResolver struct {
+	owner bookkeeper.AccountOwner
+}
+
+func (r *accountOwnerResolver) UserId() int32 {
+	return int32(r.owner.UserId)
+}
+
+func (r *accountOwnerResolver) UserName() string {
+	return r.owner.UserName
+}
+
+func (r *accountOwnerResolver) Share() float64 {
+	return r.owner.Share
+}
+
+type transactionResolver struct {
+	trans bookkeeper.Transaction_
+}
+
+func (r *transactionResolver) Id() graphql.ID {
+	return graphql.ID(strconv.Itoa(r.trans.Id))
+}
+
+func (r *transactionResolver) Type() string {
+	return r.trans.Type
+}
+
+func (r *transactionResolver) Date() string {
+	return r.trans.Date.Format("2006/01/02")
+}
+
+func (r *transactionResolver) Category() string {
+	return r.trans.Category
+}
+
+func (r *transactionResolver) SubCategory() string {
+	return r.trans.SubCategory
+}
+
+func (r *transactionResolver) Account(ctx context.Context) (*accountResolver, error) {
+	account, err := loaderOf(ctx).account(ctx, r.trans.AccountId)
+	if err != nil {
+		return nil, err
+	}
+	return &accountResolver{account}, nil
+}
+
+func (r *transactionResolver) Amount() float64 {
+	return float64(r.trans.Amount)
+}
+
+func (r *transactionResolver) Notes() string {
+	return r.trans.Notes
+}
+
+func (r *transactionResolver) AssociationId() string {
+	return r.trans.AssociationId
+}
+
+func (r *transactionResolver) Version() int32 {
+	return int32(r.trans.Version)
+}
+
+// transactionFilter is a where clause over transactions t joined with
+// accounts a, like those produced by the query parser
+type transactionFilter struct {
+	clause  string
+	values  []interface{}
+	orderBy string
+}
+
+// a cursor is the opaque offset of the edge after which a page starts
+func encodeCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(b), "offset:") {
+		return 0, invalidArgument("after", "is not a valid cursor")
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(b), "offset:"))
+	if err != nil || offset < 0 {
+		return 0, invalidArgument("after", "is not a valid cursor")
+	}
+	return offset, nil
+}
+
+type transactionConnectionResolver struct {
+	total  int
+	offset int
+	trans  []bookkeeper.Transaction_
+}
+
+func (s *Server) newTransactionConnection(
+	ctx context.Context, filter transactionFilter, args connectionArgs,
+) (*transactionConnectionResolver, error) {
+	limit := defaultConnectionSize
+	if args.First != nil {
+		if *args.First < 0 {
+			return nil, invalidArgument("first", "must not be negative")
+		}
+		limit = int(*args.First)
+	}
+	if limit > s.config.MaxNumRecords {
+		limit = s.config.MaxNumRecords
+	}
+	offset := 0
+	if args.After != nil {
+		after, err := decodeCursor(*args.After)
+		if err != nil {
+			return nil, err
+		}
+		offset = after + 1
+	}
+	total, err := bookkeeper.CountTransactionsWithFilters(ctx, s.db,
+		filter.clause, filter.values)
+	if err != nil {
+		return nil, err
+	}
+	var trans []bookkeeper.Transaction_
+	if limit > 0 {
+		trans, err = bookkeeper.GetTransactionsWithFilters(ctx, s.db,
+			filter.clause, filter.values, filter.orderBy, limit, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &transactionConnectionResolver{total: total, offset: offset, trans: trans}, nil
+}
+
+func (r *transactionConnectionResolver) TotalCount() int32 {
+	return int32(r.total)
+}
+
+func (r *transactionConnectionResolver) Edges() []*transactionEdgeResolver {
+	edges := []*transactionEdgeResolver{}
+	for i, t := range r.trans {
+		edges = append(edges, &transactionEdgeResolver{
+			cursor: encodeCursor(r.offset + i), node: &transactionResolver{t},
+		})
+	}
+	return edges
+}
+
+func (r *transactionConnectionResolver) PageInfo() *pageInfoResolver {
+	info := &pageInfoResolver{hasNextPage: r.offset+len(r.trans) < r.total}
+	if len(r.trans) > 0 {
+		cursor := encodeCursor(r.offset + len(r.trans) - 1)
+		info.endCursor = &cursor
+	}
+	return info
+}
+
+type transactionEdgeResolver struct {
+	cursor string
+	node   *transactionResolver
+}
+
+func (r *transactionEdgeResolver) Cursor() string {
+	return r.cursor
+}
+
+func (r *transactionEdgeResolver) Node() *transactionResolver {
+	return r.node
+}
+
+type pageInfoResolver struct {
+	hasNextPage bool
+	endCursor   *string
+}
+
+func (r *pageInfoResolver) HasNextPage() bool {
+	return r.hasNextPage
+}
+
+func (r *pageInfoResolver) EndCursor() *string {
+	return r.endCursor
+}
+
+type reportGroupResolver struct {
+	rg bookkeeper.ReportGroup
+}
+
+func (r *reportGroupResolver) Total() float64 {
+	return float64(r.rg.Total)
+}
+
+func (r *reportGroupResolver) Groups() []*reportGroupEntryResolver {
+	entries := []*reportGroupEntryResolver{}
+	for tag, amount := range r.rg.Groups {
+		entries = append(entries, &reportGroupEntryResolver{tag: tag, amount: amount})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+	return entries
+}
+
+type reportGroupEntryResolver struct {
+	tag    string
+	amount int64
+}
+
+func (r *reportGroupEntryResolver) Tag() string {
+	return r.tag
+}
+
+func (r *reportGroupEntryResolver) Amount() float64 {
+	return float64(r.amount)
+}
+
+type balanceSheetResolver struct {
+	date string
+	bs   bookkeeper.BalanceSheet
+}
+
+func (r *balanceSheetResolver) Date() string {
+	return r.date
+}
+
+func (r *balanceSheetResolver) Assets() *reportGroupResolver {
+	return &reportGroupResolver{r.bs.Assets}
+}
+
+func (r *balanceSheetResolver) Liabilities() *reportGroupResolver {
+	return &reportGroupResolver{r.bs.Liabilities}
+}
+
+func (r *balanceSheetResolver) Equities() float64 {
+	return float64(r.bs.Equities)
+}
+
+type incomeStatementResolver struct {
+	dr dateRange
+	is bookkeeper.IncomeStatement
+}
+
+func (r *incomeStatementResolver) StartDate() string {
+	return r.dr.startDate.Format("2006/01/02")
+}
+
+func (r *incomeStatementResolver) EndDate() string {
+	return r.dr.endDate.Format("2006/01/02")
+}
+
+func (r *incomeStatementResolver) Revenue() *reportGroupResolver {
+	return &reportGroupResolver{r.is.Revenue}
+}
+
+func (r *incomeStatementResolver) Taxes() *reportGroupResolver {
+	return &reportGroupResolver{r.is.Taxes}
+}
+
+func (r *incomeStatementResolver) RevenueNetTaxes() float64 {
+	return float64(r.is.RevenueNetTaxes)
+}
+
+func (r *incomeStatementResolver) Expenses() *reportGroupResolver {
+	return &reportGroupResolver{r.is.Expenses}
+}
+
+func (r *incomeStatementResolver) OperatingIncome() float64 {
+	return float64(r.is.OperatingIncome)
+}
+
+func (r *incomeStatementResolver) Investments() *reportGroupResolver {
+	return &reportGroupResolver{r.is.Investments}
+}
+
+func (r *incomeStatementResolver) TotalEarnings() float64 {
+	return float64(r.is.TotalEarnings)
+}
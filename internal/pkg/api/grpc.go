@@ -0,0 +1,770 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/api/_peg"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+	"github.com/lirenzhucn/bookkeeper/pkg/bookkeeperpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// the number of transactions ListTransactions reads from the database at a
+// time
+const grpcStreamPageSize = 500
+
+// the gRPC codes of the HTTP status codes that describeError returns
+var grpcCodes = map[int]codes.Code{
+	400: codes.InvalidArgument,
+	401: codes.Unauthenticated,
+	403: codes.PermissionDenied,
+	404: codes.NotFound,
+	409: codes.FailedPrecondition,
+	412: codes.Aborted,
+	423: codes.Unavailable,
+}
+
+// grpcServer implements the gRPC service on the same bookkeeper layer as the
+// REST API. Its calls are authenticated, logged and counted like requests.
+type grpcServer struct {
+	bookkeeperpb.UnimplementedBookkeeperServer
+	s *Server
+}
+
+func (s *Server) newGrpcServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(s.grpcUnaryInterceptor),
+		grpc.StreamInterceptor(s.grpcStreamInterceptor),
+	)
+	bookkeeperpb.RegisterBookkeeperServer(server, &grpcServer{s: s})
+	return server
+}
+
+// grpcUnaryInterceptor prepares the context of every unary call, which is
+// canceled after REQUEST_TIMEOUT like that of a request
+func (s *Server) grpcUnaryInterceptor(
+	ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, REQUEST_TIMEOUT)
+	defer cancel()
+	ctx, err := s.grpcCallContext(ctx, info.FullMethod)
+	var resp interface{}
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	observeGrpcCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// grpcStreamInterceptor prepares the context of every streaming call. Streams
+// have no timeout, since they may carry any number of transactions.
+func (s *Server) grpcStreamInterceptor(
+	srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	start := time.Now()
+	ctx, err := s.grpcCallContext(ss.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &grpcServerStream{ServerStream: ss, ctx: ctx})
+	}
+	observeGrpcCall(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// grpcServerStream replaces the context of a stream with the one prepared by
+// the interceptor
+type grpcServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcCallContext gives the call a request id, taken from the x-request-id
+// metadata if it looks sane, and a logger that tags every line with it. Then,
+// unless auth is disabled, it requires a valid bearer token in the
+// authorization metadata, and a read-write token for calls that may change
+// data, and attaches the user and the token. The logger is attached even if
+// the call fails.
+func (s *Server) grpcCallContext(
+	ctx context.Context, fullMethod string,
+) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestId := firstMetadataValue(md, "x-request-id")
+	if !rRequestId.MatchString(requestId) {
+		requestId = uuid.NewString()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", requestId))
+	ctx = context.WithValue(ctx, loggerContextKey,
+		s.logger.With("request_id", requestId))
+	if s.config.DisableAuth {
+		return ctx, nil
+	}
+
+	secret := strings.TrimSpace(strings.TrimPrefix(
+		firstMetadataValue(md, "authorization"), "Bearer "))
+	if secret == "" {
+		return ctx, status.Error(codes.Unauthenticated, "Missing bearer token")
+	}
+	token, err := bookkeeper.GetActiveApiTokenByHash(
+		ctx, s.db, bookkeeper.HashApiToken(secret))
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		return ctx, status.Error(codes.Unauthenticated, "Invalid or revoked token")
+	}
+	if err != nil {
+		return ctx, grpcError(ctx, err, 500, "Failed to look up token")
+	}
+	if !isReadOnlyGrpcMethod(fullMethod) && !token.AllowsWrite() {
+		return ctx, status.Error(codes.PermissionDenied, "The token is read-only")
+	}
+	user, err := bookkeeper.GetUserById(ctx, s.db, token.UserId)
+	if err != nil {
+		return ctx, grpcError(ctx, err, 500, "Failed to look up user",
+			"user_id", token.UserId)
+	}
+	ctx = context.WithValue(ctx, userContextKey, user)
+	ctx = context.WithValue(ctx, tokenContextKey, token)
+	return ctx, nil
+}
+
+func firstMetadataValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// the calls that cannot change data are named Get... or List...
+func isReadOnlyGrpcMethod(fullMethod string) bool {
+	name := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	return strings.HasPrefix(name, "Get") || strings.HasPrefix(name, "List")
+}
+
+// observeGrpcCall logs one line per call and records its latency
+func observeGrpcCall(
+	ctx context.Context, fullMethod string, start time.Time, err error,
+) {
+	sugar := loggerFromContext(ctx)
+	defer sugar.Sync()
+
+	duration := time.Since(start)
+	code := status.Code(err)
+	grpcRequestDuration.WithLabelValues(fullMethod, code.String()).
+		Observe(duration.Seconds())
+	sugar.Infow("handled call", "method", fullMethod, "code", code.String(),
+		"duration_ms", float64(duration.Microseconds())/1000)
+}
+
+// grpcError logs err and turns it into the status of a call, like checkErr
+// does for a request. The sentinel errors of the bookkeeper package take
+// precedence over statusCode, and the reasons of failed fields are added to
+// the message.
+func grpcError(
+	ctx context.Context, err error, statusCode int, msg string, a ...interface{},
+) error {
+	sugar := loggerFromContext(ctx)
+	defer sugar.Sync()
+
+	a = append(a, "error", err)
+	sugar.Errorw(msg, a...)
+	statusCode, details := describeError(err, statusCode, msg)
+	code, ok := grpcCodes[statusCode]
+	if !ok {
+		code = codes.Internal
+	}
+	msg = details.Message
+	for i, f := range details.Fields {
+		sep := "; "
+		if i == 0 {
+			sep = ": "
+		}
+		msg += sep + f.Field + " " + f.Reason
+	}
+	return status.Error(code, msg)
+}
+
+// checkCanPost fails the call unless the authenticated member may post to
+// all of the accounts
+func (s *Server) checkCanPost(ctx context.Context, accountIds ...int) error {
+	for _, id := range accountIds {
+		reason, err := s.postingDeniedReason(ctx, id)
+		if err != nil {
+			return grpcError(ctx, err, 500, "Failed to get account", "account_id", id)
+		}
+		if reason != "" {
+			return status.Error(codes.PermissionDenied, reason)
+		}
+	}
+	return nil
+}
+
+func accountToPb(account bookkeeper.Account) *bookkeeperpb.Account {
+	pb := &bookkeeperpb.Account{
+		Id: int64(account.Id), Name: account.Name, Desc: account.Desc,
+		Tags: account.Tags, Version: int64(account.Version),
+	}
+	for _, o := range account.Owners {
+		pb.Owners = append(pb.Owners, &bookkeeperpb.AccountOwner{
+			UserId: int64(o.UserId), UserName: o.UserName, Share: o.Share,
+		})
+	}
+	return pb
+}
+
+func accountFromPb(pb *bookkeeperpb.Account) bookkeeper.Account {
+	account := bookkeeper.Account{
+		Id: int(pb.Id), Name: pb.Name, Desc: pb.Desc, Tags: pb.Tags,
+		Version: int(pb.Version),
+	}
+	for _, o := range pb.Owners {
+		account.Owners = append(account.Owners, bookkeeper.AccountOwner{
+			UserId: int(o.UserId), UserName: o.UserName, Share: o.Share,
+		})
+	}
+	return account
+}
+
+func transactionToPb(trans bookkeeper.Transaction_) *bookkeeperpb.Transaction {
+	return &bookkeeperpb.Transaction{
+		Id: int64(trans.Id), Type: trans.Type, Date: timestamppb.New(trans.Date),
+		Category: trans.Category, SubCategory: trans.SubCategory,
+		AccountId: int64(trans.AccountId), AccountName: trans.AccountName,
+		Amount: trans.Amount, Notes: trans.Notes,
+		AssociationId: trans.AssociationId, Version: int64(trans.Version),
+	}
+}
+
+func transactionFromPb(pb *bookkeeperpb.Transaction) bookkeeper.Transaction_ {
+	var trans bookkeeper.Transaction_
+	trans.Id, trans.Type = int(pb.Id), pb.Type
+	if pb.Date != nil {
+		trans.Date = pb.Date.AsTime()
+	}
+	trans.Category, trans.SubCategory = pb.Category, pb.SubCategory
+	trans.AccountId, trans.AccountName = int(pb.AccountId), pb.AccountName
+	trans.Amount, trans.Notes = pb.Amount, pb.Notes
+	trans.AssociationId, trans.Version = pb.AssociationId, int(pb.Version)
+	return trans
+}
+
+func reportGroupToPb(rg bookkeeper.ReportGroup) *bookkeeperpb.ReportGroup {
+	return &bookkeeperpb.ReportGroup{Total: rg.Total, Groups: rg.Groups}
+}
+
+func (g *grpcServer) ListAccounts(
+	ctx context.Context, req *bookkeeperpb.ListAccountsRequest,
+) (*bookkeeperpb.ListAccountsResponse, error) {
+	accounts, err := bookkeeper.GetAllAccounts(ctx, g.s.db, g.s.config.MaxNumRecords, 0)
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to get accounts")
+	}
+	resp := &bookkeeperpb.ListAccountsResponse{}
+	for _, account := range accounts {
+		resp.Accounts = append(resp.Accounts, accountToPb(account))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) GetAccount(
+	ctx context.Context, req *bookkeeperpb.GetAccountRequest,
+) (*bookkeeperpb.Account, error) {
+	var (
+		account bookkeeper.Account
+		err     error
+	)
+	switch key := req.Key.(type) {
+	case *bookkeeperpb.GetAccountRequest_Id:
+		account, err = bookkeeper.GetSingleAccount(ctx, g.s.db, int(key.Id))
+	case *bookkeeperpb.GetAccountRequest_Name:
+		account, err = bookkeeper.GetSingleAccountByName(ctx, g.s.db, key.Name)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "An id or a name is required")
+	}
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "Account not found")
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to get account")
+	}
+	return accountToPb(account), nil
+}
+
+func (g *grpcServer) CreateAccount(
+	ctx context.Context, req *bookkeeperpb.CreateAccountRequest,
+) (*bookkeeperpb.Account, error) {
+	if req.Account == nil {
+		return nil, status.Error(codes.InvalidArgument, "The account is required")
+	}
+	account := accountFromPb(req.Account)
+	if err := account.Validate(); err != nil {
+		return nil, grpcError(ctx, err, 400, "Invalid account payload")
+	}
+	err := g.s.changeWithEvents(ctx, func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		err := bookkeeper.InsertAccount(ctx, tx, &account)
+		return []bookkeeper.Event{
+			newEvent(bookkeeper.EventAccountCreated, account.Id, account),
+		}, err
+	})
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to insert account")
+	}
+	return accountToPb(account), nil
+}
+
+func (g *grpcServer) UpdateAccount(
+	ctx context.Context, req *bookkeeperpb.UpdateAccountRequest,
+) (*bookkeeperpb.Account, error) {
+	if req.Account == nil {
+		return nil, status.Error(codes.InvalidArgument, "The account is required")
+	}
+	account := accountFromPb(req.Account)
+	if err := account.Validate(); err != nil {
+		return nil, grpcError(ctx, err, 400, "Invalid account payload")
+	}
+	// the current owners decide, not the ones in the request
+	if err := g.s.checkCanPost(ctx, account.Id); err != nil {
+		return nil, err
+	}
+	err := g.s.changeWithEvents(ctx, func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		err := bookkeeper.UpdateAccount(ctx, tx, &account)
+		return []bookkeeper.Event{
+			newEvent(bookkeeper.EventAccountUpdated, account.Id, account),
+		}, err
+	})
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "Cannot find account with the specified id")
+	}
+	if errors.Is(err, bookkeeper.ErrVersionMismatch) {
+		return nil, status.Error(codes.Aborted, "Account has been changed since it was fetched")
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to update account",
+			"account_id", account.Id)
+	}
+	return accountToPb(account), nil
+}
+
+func (g *grpcServer) DeleteAccount(
+	ctx context.Context, req *bookkeeperpb.DeleteAccountRequest,
+) (*emptypb.Empty, error) {
+	if err := g.s.checkCanPost(ctx, int(req.Id)); err != nil {
+		return nil, err
+	}
+	err := g.s.changeWithEvents(ctx, func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		err := bookkeeper.DeleteAccount(ctx, tx, int(req.Id), int(req.Version))
+		return []bookkeeper.Event{
+			newEvent(bookkeeper.EventAccountDeleted, int(req.Id), nil),
+		}, err
+	})
+	if errors.Is(err, bookkeeper.ErrConflict) {
+		return nil, status.Error(codes.FailedPrecondition,
+			"Failed to delete account. Account may be referenced by a transaction.")
+	}
+	if errors.Is(err, bookkeeper.ErrVersionMismatch) {
+		return nil, status.Error(codes.Aborted, "Account has been changed since it was fetched")
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to delete account",
+			"account_id", req.Id)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (g *grpcServer) GetTransaction(
+	ctx context.Context, req *bookkeeperpb.GetTransactionRequest,
+) (*bookkeeperpb.Transaction, error) {
+	trans, err := bookkeeper.GetSingleTransaction(ctx, g.s.db, int(req.Id))
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "Transaction not found")
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to get transaction",
+			"transaction_id", req.Id)
+	}
+	return transactionToPb(trans), nil
+}
+
+// ListTransactions streams the matches page by page, so that a listing of
+// any size never has to be held in memory
+func (g *grpcServer) ListTransactions(
+	req *bookkeeperpb.ListTransactionsRequest,
+	stream bookkeeperpb.Bookkeeper_ListTransactionsServer,
+) error {
+	ctx := stream.Context()
+	if req.Limit < 0 || req.Offset < 0 {
+		return status.Error(codes.InvalidArgument,
+			"The limit and the offset must not be negative")
+	}
+	filter := transactionFilter{clause: "true"}
+	switch {
+	case strings.TrimSpace(req.Query) != "":
+		if req.StartDate != nil || req.EndDate != nil {
+			return status.Error(codes.InvalidArgument,
+				"A query and a date range cannot be given together")
+		}
+		queryData, err := _peg.ParseString(strings.Trim(req.Query, "'"))
+		if err != nil {
+			return status.Error(codes.InvalidArgument, "Invalid query: "+err.Error())
+		}
+		prepQueryData(&queryData)
+		filter = transactionFilter{
+			clause: queryData.Clause, values: queryData.Values,
+			orderBy: queryData.OrderBy,
+		}
+	case req.StartDate != nil || req.EndDate != nil:
+		var conditions []string
+		if req.StartDate != nil {
+			filter.values = append(filter.values, req.StartDate.AsTime())
+			conditions = append(conditions, fmt.Sprintf("date >= $%d", len(filter.values)))
+		}
+		if req.EndDate != nil {
+			filter.values = append(filter.values, req.EndDate.AsTime())
+			conditions = append(conditions, fmt.Sprintf("date <= $%d", len(filter.values)))
+		}
+		filter.clause = strings.Join(conditions, " AND ")
+	}
+
+	offset, remaining := int(req.Offset), int(req.Limit)
+	for {
+		pageSize := grpcStreamPageSize
+		if req.Limit > 0 && remaining < pageSize {
+			pageSize = remaining
+		}
+		if pageSize == 0 {
+			return nil
+		}
+		trans, err := bookkeeper.GetTransactionsWithFilters(ctx, g.s.db,
+			filter.clause, filter.values, filter.orderBy, pageSize, offset)
+		if err != nil {
+			return grpcError(ctx, err, 500, "Failed to query transactions",
+				"clause", filter.clause, "offset", offset)
+		}
+		for _, t := range trans {
+			if err := stream.Send(transactionToPb(t)); err != nil {
+				return err
+			}
+		}
+		if len(trans) < pageSize {
+			return nil
+		}
+		offset += len(trans)
+		remaining -= len(trans)
+	}
+}
+
+func (g *grpcServer) CreateTransaction(
+	ctx context.Context, req *bookkeeperpb.CreateTransactionRequest,
+) (*bookkeeperpb.Transaction, error) {
+	if req.Transaction == nil {
+		return nil, status.Error(codes.InvalidArgument, "The transaction is required")
+	}
+	trans := transactionFromPb(req.Transaction).Transaction
+	if err := trans.Validate(); err != nil {
+		return nil, grpcError(ctx, err, 400, "Invalid transaction payload")
+	}
+	if err := g.s.checkCanPost(ctx, trans.AccountId); err != nil {
+		return nil, err
+	}
+	err := g.s.changeWithEvents(ctx, func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		err := bookkeeper.InsertTransaction(ctx, tx, &trans)
+		return []bookkeeper.Event{
+			newEvent(bookkeeper.EventTransactionCreated, trans.Id, trans),
+		}, err
+	})
+	if err != nil {
+		observeJournalPosting("grpc:CreateTransaction", 0)
+		return nil, grpcError(ctx, err, 500, "Failed to insert transaction")
+	}
+	observeJournalPosting("grpc:CreateTransaction", 1)
+	return transactionToPb(bookkeeper.Transaction_{Transaction: trans}), nil
+}
+
+func (g *grpcServer) UpdateTransaction(
+	ctx context.Context, req *bookkeeperpb.UpdateTransactionRequest,
+) (*bookkeeperpb.Transaction, error) {
+	if req.Transaction == nil {
+		return nil, status.Error(codes.InvalidArgument, "The transaction is required")
+	}
+	trans := transactionFromPb(req.Transaction).Transaction
+	old, err := bookkeeper.GetSingleTransaction(ctx, g.s.db, trans.Id)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		return nil, status.Error(codes.NotFound,
+			"Cannot find transaction with the specified id")
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to get transaction",
+			"transaction_id", trans.Id)
+	}
+	if err := trans.Validate(); err != nil {
+		return nil, grpcError(ctx, err, 400, "Invalid transaction payload")
+	}
+	// moving a transaction needs permission on both accounts
+	if err := g.s.checkCanPost(ctx, old.AccountId, trans.AccountId); err != nil {
+		return nil, err
+	}
+	err = g.s.changeWithEvents(ctx, func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		err := bookkeeper.UpdateTransaction(ctx, tx, &trans)
+		return []bookkeeper.Event{
+			newEvent(bookkeeper.EventTransactionUpdated, trans.Id, trans),
+		}, err
+	})
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		return nil, status.Error(codes.NotFound,
+			"Cannot find transaction with the specified id")
+	}
+	if errors.Is(err, bookkeeper.ErrVersionMismatch) {
+		return nil, status.Error(codes.Aborted,
+			"Transaction has been changed since it was fetched")
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to update transaction",
+			"transaction_id", trans.Id)
+	}
+	return transactionToPb(bookkeeper.Transaction_{Transaction: trans}), nil
+}
+
+func (g *grpcServer) DeleteTransaction(
+	ctx context.Context, req *bookkeeperpb.DeleteTransactionRequest,
+) (*emptypb.Empty, error) {
+	id := int(req.Id)
+	trans, err := bookkeeper.GetSingleTransaction(ctx, g.s.db, id)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "Transaction not found")
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to get transaction",
+			"transaction_id", id)
+	}
+	if err := g.s.checkCanPost(ctx, trans.AccountId); err != nil {
+		return nil, err
+	}
+	err = g.s.changeWithEvents(ctx, func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		err := bookkeeper.DeleteTransaction(ctx, tx, id, int(req.Version))
+		return []bookkeeper.Event{
+			newEvent(bookkeeper.EventTransactionDeleted, id, trans.Transaction),
+		}, err
+	})
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "Transaction not found")
+	}
+	if errors.Is(err, bookkeeper.ErrVersionMismatch) {
+		return nil, status.Error(codes.Aborted,
+			"Transaction has been changed since it was fetched")
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to delete transaction",
+			"transaction_id", id)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// PostJournalEntry checks the validators of the entry and every transaction
+// in it before any is inserted, and creates all of them in one database
+// transaction
+func (g *grpcServer) PostJournalEntry(
+	ctx context.Context, req *bookkeeperpb.PostJournalEntryRequest,
+) (*bookkeeperpb.PostJournalEntryResponse, error) {
+	if req.Entry == nil {
+		return nil, status.Error(codes.InvalidArgument, "The entry is required")
+	}
+	entry := bookkeeper.JournalEntry{
+		Title: req.Entry.Title, Desc: req.Entry.Desc,
+		Validators: req.Entry.Validators,
+	}
+	for _, t := range req.Entry.Transactions {
+		entry.Transactions = append(entry.Transactions, transactionFromPb(t))
+	}
+	n := entry.NumTransactions()
+	if n == 0 || n > bookkeeper.MAX_BATCH_SIZE {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"An entry must have 1 to %d transactions", bookkeeper.MAX_BATCH_SIZE)
+	}
+	accounts, err := bookkeeper.GetAllAccounts(ctx, g.s.db, g.s.config.MaxNumRecords, 0)
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to get accounts")
+	}
+	accountIds := make(map[string]int)
+	accountNames := make(map[int]string)
+	for _, account := range accounts {
+		accountIds[account.Name] = account.Id
+		accountNames[account.Id] = account.Name
+	}
+	for i, trans := range entry.Transactions {
+		if trans.AccountName == "" {
+			entry.Transactions[i].AccountName = accountNames[trans.AccountId]
+			continue
+		}
+		id, ok := accountIds[trans.AccountName]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Transaction %d: account %s is not found", i, trans.AccountName)
+		}
+		entry.Transactions[i].AccountId = id
+	}
+	if err := entry.Validate(); err != nil {
+		observeJournalPosting("grpc:PostJournalEntry", 0)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	transactions := make([]bookkeeper.Transaction, n)
+	for i, trans := range entry.Transactions {
+		if err := trans.Validate(); err != nil {
+			observeJournalPosting("grpc:PostJournalEntry", 0)
+			return nil, grpcError(ctx, err, 400,
+				fmt.Sprintf("Invalid transaction %d", i))
+		}
+		if err := g.s.checkCanPost(ctx, trans.AccountId); err != nil {
+			observeJournalPosting("grpc:PostJournalEntry", 0)
+			return nil, err
+		}
+		transactions[i] = trans.Transaction
+	}
+	failedIndex := -1
+	err = g.s.changeWithEvents(ctx, func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		var err error
+		failedIndex, err = bookkeeper.InsertTransactionsBatch(ctx, tx, transactions)
+		var evs []bookkeeper.Event
+		for _, trans := range transactions {
+			evs = append(evs, newEvent(bookkeeper.EventTransactionCreated, trans.Id, trans))
+		}
+		return evs, err
+	})
+	if err != nil {
+		observeJournalPosting("grpc:PostJournalEntry", 0)
+		return nil, grpcError(ctx, err, 500,
+			fmt.Sprintf("Failed to insert transaction %d; nothing was created", failedIndex))
+	}
+	observeJournalPosting("grpc:PostJournalEntry", n)
+	resp := &bookkeeperpb.PostJournalEntryResponse{}
+	for i, trans := range transactions {
+		entry.Transactions[i].Transaction = trans
+		resp.Transactions = append(resp.Transactions, transactionToPb(entry.Transactions[i]))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) GetAccountBalances(
+	ctx context.Context, req *bookkeeperpb.GetAccountBalancesRequest,
+) (*bookkeeperpb.GetAccountBalancesResponse, error) {
+	date := time.Now()
+	if req.Date != nil {
+		date = req.Date.AsTime()
+	}
+	var accounts []bookkeeper.AccountWithBalance
+	if req.AccountName != "" {
+		account, balance, err := bookkeeper.ComputeAccountBalanceByName(
+			ctx, g.s.db, req.AccountName, date)
+		if errors.Is(err, bookkeeper.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "Account not found")
+		}
+		if err != nil {
+			return nil, grpcError(ctx, err, 500, "Failed to query account balance",
+				"accountName", req.AccountName)
+		}
+		accounts = append(accounts,
+			bookkeeper.AccountWithBalance{Account: account, Balance: balance})
+	} else {
+		var err error
+		accounts, err = bookkeeper.GetAllAccountsBalanceOnDate(ctx, g.s.db, date)
+		if err != nil {
+			return nil, grpcError(ctx, err, 500, "Failed to get the balance of all accounts")
+		}
+	}
+	resp := &bookkeeperpb.GetAccountBalancesResponse{}
+	for _, a := range accounts {
+		resp.Balances = append(resp.Balances, &bookkeeperpb.AccountBalance{
+			Account: accountToPb(a.Account), Balance: a.Balance,
+		})
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) GetBalanceSheets(
+	ctx context.Context, req *bookkeeperpb.GetBalanceSheetsRequest,
+) (*bookkeeperpb.GetBalanceSheetsResponse, error) {
+	if len(req.Dates) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "At least one date is required")
+	}
+	owner, err := g.s.ownerArgument(ctx, &req.Owner)
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to look up owner")
+	}
+	householdSize := 0
+	if owner != "" {
+		if householdSize, err = bookkeeper.CountUsers(ctx, g.s.db); err != nil {
+			return nil, grpcError(ctx, err, 500, "Failed to count household members")
+		}
+	}
+	resp := &bookkeeperpb.GetBalanceSheetsResponse{}
+	for _, date := range req.Dates {
+		accounts, err := bookkeeper.GetAllAccountsBalanceOnDate(ctx, g.s.db, date.AsTime())
+		if err != nil {
+			return nil, grpcError(ctx, err, 500, "Failed to get the balance of all accounts")
+		}
+		if owner != "" {
+			accounts = bookkeeper.ApportionBalances(accounts, owner, householdSize)
+		}
+		bs := bookkeeper.ComputeBalanceSheet(accounts, req.AssetTags, req.LiabilityTags)
+		resp.BalanceSheets = append(resp.BalanceSheets, &bookkeeperpb.BalanceSheet{
+			Date:        date,
+			Assets:      reportGroupToPb(bs.Assets),
+			Liabilities: reportGroupToPb(bs.Liabilities),
+			Equities:    bs.Equities,
+		})
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) GetIncomeStatements(
+	ctx context.Context, req *bookkeeperpb.GetIncomeStatementsRequest,
+) (*bookkeeperpb.GetIncomeStatementsResponse, error) {
+	if len(req.DateRanges) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"At least one date range is required")
+	}
+	owner, err := g.s.ownerArgument(ctx, &req.Owner)
+	if err != nil {
+		return nil, grpcError(ctx, err, 500, "Failed to look up owner")
+	}
+	resp := &bookkeeperpb.GetIncomeStatementsResponse{}
+	for i, dr := range req.DateRanges {
+		if dr.StartDate == nil || dr.EndDate == nil {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Date range %d needs a start and an end date", i)
+		}
+		is, err := bookkeeper.ComputeIncomeStatement(
+			ctx, g.s.db, dr.StartDate.AsTime(), dr.EndDate.AsTime(),
+			req.RevenueTags, req.TaxesTags, req.ExpensesTags, req.InvestmentsTags,
+			owner,
+		)
+		if err != nil {
+			return nil, grpcError(ctx, err, 500,
+				"Failed to compute income statement for at least one period")
+		}
+		resp.IncomeStatements = append(resp.IncomeStatements, &bookkeeperpb.IncomeStatement{
+			DateRange:       dr,
+			Revenue:         reportGroupToPb(is.Revenue),
+			Taxes:           reportGroupToPb(is.Taxes),
+			RevenueNetTaxes: is.RevenueNetTaxes,
+			Expenses:        reportGroupToPb(is.Expenses),
+			OperatingIncome: is.OperatingIncome,
+			Investments:     reportGroupToPb(is.Investments),
+			TotalEarnings:   is.TotalEarnings,
+		})
+	}
+	return resp, nil
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// how long the readiness check waits for the database
+var READINESS_DB_TIMEOUT = 2 * time.Second
+
+type dbPoolStats struct {
+	TotalConns    int32 `json:"total_conns"`
+	IdleConns     int32 `json:"idle_conns"`
+	AcquiredConns int32 `json:"acquired_conns"`
+	MaxConns      int32 `json:"max_conns"`
+}
+
+type healthResponse struct {
+	Status string      `json:"status"`
+	DbPool dbPoolStats `json:"db_pool"`
+}
+
+func (s *Server) writeHealth(w http.ResponseWriter) {
+	stat := s.db.Stat()
+	json.NewEncoder(w).Encode(healthResponse{
+		Status: "ok",
+		DbPool: dbPoolStats{
+			TotalConns:    stat.TotalConns(),
+			IdleConns:     stat.IdleConns(),
+			AcquiredConns: stat.AcquiredConns(),
+			MaxConns:      stat.MaxConns(),
+		},
+	})
+}
+
+// returnHealth tells if the server is alive, i.e. it serves requests and has
+// a database pool; it does not wait for the database
+func (s *Server) returnHealth(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		writeError(w, "No database pool", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeHealth(w)
+}
+
+// returnReadiness tells if the server can handle requests right now, i.e.
+// the database answers and the server is not shutting down
+func (s *Server) returnReadiness(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		writeError(w, "Shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if s.db == nil {
+		writeError(w, "No database pool", http.StatusServiceUnavailable)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), READINESS_DB_TIMEOUT)
+	defer cancel()
+	if err := s.db.Ping(ctx); err != nil {
+		writeError(w, "Database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeHealth(w)
+}
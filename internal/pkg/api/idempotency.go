@@ -0,0 +1,136 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// how long storing or releasing a key may take after the request is handled
+const dbCleanupTimeout = 5 * time.Second
+
+// recordingResponseWriter keeps a copy of the response it writes through
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// idempotent makes it safe to retry a POST request with an Idempotency-Key
+// header. The first response to the key is stored and replayed to every
+// retry, so that a retry never creates anything twice. Server errors are not
+// stored, so that the request can be retried for real.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sugar := requestLogger(r)
+		defer sugar.Sync()
+
+		key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, "The Idempotency-Key header is too long", 400)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if !checkErr(err, w, 400, "Failed to read the request body") {
+			return
+		}
+		// the handler reads the body again
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		user, _ := userFromRequest(r)
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+		hash.Write(body)
+		resp := bookkeeper.IdempotentResponse{
+			UserId: user.Id, Key: key,
+			RequestHash: hex.EncodeToString(hash.Sum(nil)),
+		}
+		saved := resp
+		reserved, err := bookkeeper.ReserveIdempotencyKey(r.Context(), s.db, &saved)
+		if !checkErr(err, w, 500, "Failed to look up the idempotency key") {
+			return
+		}
+		if !reserved {
+			replayIdempotentResponse(w, resp, saved)
+			return
+		}
+
+		rw := &recordingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next(rw, r)
+		// the key must be settled even if the client is gone by now
+		ctx, cancel := context.WithTimeout(context.Background(), dbCleanupTimeout)
+		defer cancel()
+		if rw.statusCode < 500 {
+			resp.StatusCode = rw.statusCode
+			resp.ContentType = rw.Header().Get("Content-Type")
+			resp.Body = rw.body.Bytes()
+			err = bookkeeper.SaveIdempotentResponse(ctx, s.db, &resp)
+			if err == nil {
+				return
+			}
+			sugar.Errorw("failed to store the response to an idempotent request",
+				"key", key, "error", err)
+		}
+		if err = bookkeeper.ReleaseIdempotencyKey(ctx, s.db, user.Id, key); err != nil {
+			sugar.Errorw("failed to release an idempotency key",
+				"key", key, "error", err)
+		}
+	}
+}
+
+// answer a request whose key has been used before
+func replayIdempotentResponse(
+	w http.ResponseWriter, resp bookkeeper.IdempotentResponse,
+	saved bookkeeper.IdempotentResponse,
+) {
+	switch {
+	case saved.RequestHash != resp.RequestHash:
+		writeError(w, "The Idempotency-Key has been used for a different request",
+			http.StatusUnprocessableEntity)
+	case saved.StatusCode == 0:
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(bookkeeper.ErrorResponse{
+			Error: bookkeeper.ErrorDetails{
+				Code:    bookkeeper.ErrorCodeRequestInProgress,
+				Message: "A request with the same Idempotency-Key is in progress",
+			},
+		})
+	default:
+		if saved.ContentType != "" {
+			w.Header().Set("Content-Type", saved.ContentType)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(saved.StatusCode)
+		w.Write(saved.Body)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// request ids sent by clients or proxies are kept if they look sane
+var rRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// observedResponseWriter records the status and the size of a response, and
+// carries the logger of the request to code that only sees the writer
+type observedResponseWriter struct {
+	http.ResponseWriter
+	statusCode       int
+	size             int
+	validationFailed bool
+	sugar            *zap.SugaredLogger
+}
+
+func (w *observedResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *observedResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Flush lets streaming handlers, e.g. the change feed, flush through the
+// writer
+func (w *observedResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *observedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// find the observedResponseWriter under the writers wrapped around it
+func observedWriter(w http.ResponseWriter) *observedResponseWriter {
+	for {
+		switch ww := w.(type) {
+		case *observedResponseWriter:
+			return ww
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = ww.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// the logger of the request that w answers, which tags every line with the
+// request id
+func loggerOf(w http.ResponseWriter) *zap.SugaredLogger {
+	if ow := observedWriter(w); ow != nil {
+		return ow.sugar
+	}
+	return zap.L().Sugar()
+}
+
+// the logger of the request, which tags every line with the request id
+func requestLogger(r *http.Request) *zap.SugaredLogger {
+	return loggerFromContext(r.Context())
+}
+
+// the logger of a REST request or a gRPC call
+func loggerFromContext(ctx context.Context) *zap.SugaredLogger {
+	if sugar, ok := ctx.Value(loggerContextKey).(*zap.SugaredLogger); ok {
+		return sugar
+	}
+	return zap.L().Sugar()
+}
+
+// observeMiddleware gives every request an id, which is returned in the
+// X-Request-Id header and added to every log line of the request. It logs one
+// line per request and records the latency of every route.
+func (s *Server) observeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestId := r.Header.Get("X-Request-Id")
+		if !rRequestId.MatchString(requestId) {
+			requestId = uuid.NewString()
+		}
+		w.Header().Set("X-Request-Id", requestId)
+		sugar := s.logger.With("request_id", requestId)
+		defer sugar.Sync()
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		ow := &observedResponseWriter{
+			ResponseWriter: w, statusCode: http.StatusOK, sugar: sugar,
+		}
+		ctx := context.WithValue(r.Context(), loggerContextKey, sugar)
+		next.ServeHTTP(ow, r.WithContext(ctx))
+
+		duration := time.Since(start)
+		status := strconv.Itoa(ow.statusCode)
+		requestDuration.WithLabelValues(route, r.Method, status).
+			Observe(duration.Seconds())
+		if ow.validationFailed {
+			validationFailures.WithLabelValues(route).Inc()
+		}
+		sugar.Infow("handled request",
+			"method", r.Method, "route", route, "path", r.URL.Path,
+			"status", ow.statusCode, "bytes", ow.size,
+			"duration_ms", float64(duration.Microseconds())/1000)
+	})
+}
@@ -3,109 +3,321 @@ package api
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
-	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
+	graphql "github.com/graph-gophers/graphql-go"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
-var dbpool *pgxpool.Pool
-
 func homePage(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Welcome to the HomePage!")
 }
 
-func createDbPool(db_url string) error {
+// Timeouts of the server. The context of a request, and with it every
+// database call made for the request, is canceled after REQUEST_TIMEOUT.
+var (
+	READ_TIMEOUT       = 15 * time.Second
+	WRITE_TIMEOUT      = 60 * time.Second
+	IDLE_TIMEOUT       = 120 * time.Second
+	REQUEST_TIMEOUT    = 45 * time.Second
+	SHUTDOWN_TIMEOUT   = 30 * time.Second
+	DB_CONNECT_TIMEOUT = 10 * time.Second
+)
+
+// Config holds the settings of a Server
+type Config struct {
+	Port        int      // the port of the REST API
+	GrpcPort    int      // the port of the gRPC service, or 0 to turn it off
+	DisableAuth bool     // serve every request without a token
+	CorsOrigins []string // the origins of other sites whose pages may call the API
+	// the most records that a request may return, however many it asks for
+	MaxNumRecords int
+}
+
+// DefaultConfig has the default ports and limits, with authentication on and
+// no other origins allowed
+func DefaultConfig() Config {
+	return Config{Port: 10000, GrpcPort: 10001, MaxNumRecords: 1000}
+}
+
+// Server serves the REST API and the gRPC service on the bookkeeper layer,
+// with the database as its store
+type Server struct {
+	db     *pgxpool.Pool
+	config Config
+	// the base of the loggers of all requests and calls
+	logger *zap.SugaredLogger
+
+	handler       http.Handler
+	graphqlSchema *graphql.Schema
+	events        *eventHub
+	webhooks      *webhookDispatcher
+	// set to 1 once the server starts shutting down
+	shuttingDown int32
+}
+
+// NewServer sets up a server of the database. Nothing is served until Run,
+// but the server can already handle requests, e.g. in tests.
+func NewServer(db *pgxpool.Pool, config Config, logger *zap.SugaredLogger) *Server {
+	if config.MaxNumRecords <= 0 {
+		config.MaxNumRecords = DefaultConfig().MaxNumRecords
+	}
+	s := &Server{db: db, config: config, logger: logger, events: newEventHub()}
+	s.webhooks = newWebhookDispatcher(db, s.events, logger)
+	s.graphqlSchema = graphql.MustParseSchema(graphqlSchemaString,
+		&queryResolver{s}, graphql.MaxDepth(graphqlMaxDepth))
+	s.handler = corsMiddleware(config.CorsOrigins, s.newRouter())
+	return s
+}
+
+// ServeHTTP handles a request to the REST API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// ConnectDb creates a pool of connections to the database at dbUrl
+func ConnectDb(ctx context.Context, dbUrl string) (*pgxpool.Pool, error) {
 	sugar := zap.L().Sugar()
 	defer sugar.Sync()
-	var err error
-	sugar.Infow("connecting to db", "db_url", bookkeeper.MaskDbPassword(db_url))
-	dbpool, err = pgxpool.Connect(context.Background(), db_url)
+	sugar.Infow("connecting to db", "db_url", bookkeeper.MaskDbPassword(dbUrl))
+	ctx, cancel := context.WithTimeout(ctx, DB_CONNECT_TIMEOUT)
+	defer cancel()
+	db, err := pgxpool.Connect(ctx, dbUrl)
 	if err != nil {
-		sugar.Errorw("failed to obtain DB conn pool", "db_url", db_url)
-		return err
+		sugar.Errorw("failed to obtain DB conn pool",
+			"db_url", bookkeeper.MaskDbPassword(dbUrl))
+		return nil, err
 	}
-	return nil
+	return db, nil
 }
 
-func HandleRequests(port string, db_url string) {
-	sugar := zap.L().Sugar()
+// Run serves the API, and the gRPC service unless its port is 0, until either
+// server fails, or until ctx is canceled, upon which the requests in flight
+// are given SHUTDOWN_TIMEOUT to finish
+func (s *Server) Run(ctx context.Context) error {
+	sugar := s.logger
 	defer sugar.Sync()
 
-	if err := createDbPool(db_url); err != nil {
-		sugar.Errorw("Failed to connect to database", "error", err)
-		os.Exit(1)
+	if s.config.DisableAuth {
+		sugar.Warnw("authentication is disabled; do not expose this server")
+	}
+	if err := metricsRegistry.Register(dbPoolCollector{s.db}); err != nil {
+		sugar.Warnw("failed to register the database pool metrics", "error", err)
+	}
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", s.config.Port),
+		Handler:      s,
+		ReadTimeout:  READ_TIMEOUT,
+		WriteTimeout: WRITE_TIMEOUT,
+		IdleTimeout:  IDLE_TIMEOUT,
+	}
+	go s.webhooks.run(ctx)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	var rpcServer *grpc.Server
+	grpcErr := make(chan error, 1)
+	if s.config.GrpcPort != 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.GrpcPort))
+		if err != nil {
+			return fmt.Errorf("failed to listen for gRPC: %w", err)
+		}
+		rpcServer = s.newGrpcServer()
+		sugar.Infow("serving gRPC", "port", s.config.GrpcPort)
+		go func() {
+			grpcErr <- rpcServer.Serve(lis)
+		}()
+	}
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("web server failed: %w", err)
+	case err := <-grpcErr:
+		return fmt.Errorf("gRPC server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	sugar.Infow("shutting down", "timeout", SHUTDOWN_TIMEOUT)
+	// fail the readiness checks, so that no new requests are routed here
+	atomic.StoreInt32(&s.shuttingDown, 1)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if rpcServer != nil {
+		// streams may run for long, so they are cut off after the timeout
+		stopped := make(chan struct{})
+		go func() {
+			rpcServer.GracefulStop()
+			close(stopped)
+		}()
+		defer func() {
+			select {
+			case <-stopped:
+			case <-shutdownCtx.Done():
+				rpcServer.Stop()
+			}
+		}()
 	}
+	return server.Shutdown(shutdownCtx)
+}
+
+// timeoutMiddleware cancels the context of a request after REQUEST_TIMEOUT,
+// which aborts the database calls made for it
+func timeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), REQUEST_TIMEOUT)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-	// set up routes
+// newRouter sets up all routes of the API. Every route must be documented in
+// openapi.json.
+func (s *Server) newRouter() *mux.Router {
 	myRouter := mux.NewRouter().StrictSlash(true)
-	// home page
+	myRouter.Use(s.observeMiddleware)
+	myRouter.Use(timeoutMiddleware)
+	if !s.config.DisableAuth {
+		myRouter.Use(s.authMiddleware)
+	}
+	myRouter.Use(validationMiddleware)
+	// home page and API document
 	myRouter.Path("/").
 		Methods("GET").
 		HandlerFunc(homePage)
+	myRouter.Path("/openapi.json").
+		Methods("GET").
+		HandlerFunc(returnOpenApi)
+	// health checks and metrics
+	myRouter.Path("/healthz").
+		Methods("GET").
+		HandlerFunc(s.returnHealth)
+	myRouter.Path("/readyz").
+		Methods("GET").
+		HandlerFunc(s.returnReadiness)
+	myRouter.PathPrefix("/dashboard").
+		Methods("GET").
+		HandlerFunc(serveDashboard)
+	myRouter.Path("/metrics").
+		Methods("GET").
+		HandlerFunc(returnMetrics)
+	// authentication
+	myRouter.Path("/auth/login").
+		Methods("POST").
+		HandlerFunc(s.login)
+	myRouter.Path("/auth/me").
+		Methods("GET").
+		HandlerFunc(returnCurrentUser)
+	myRouter.Path("/auth/tokens").
+		Methods("GET").
+		HandlerFunc(s.returnApiTokens)
+	myRouter.Path("/auth/tokens").
+		Methods("POST").
+		HandlerFunc(s.postApiToken)
+	myRouter.Path("/auth/tokens/{id}").
+		Methods("DELETE").
+		HandlerFunc(s.revokeApiToken)
 	// accounts
 	myRouter.Path("/accounts").
 		Methods("GET").
 		Queries("accountName", "{accountName}").
-		HandlerFunc(returnAccountByName)
+		HandlerFunc(s.returnAccountByName)
 	myRouter.Path("/accounts/{id}").
 		Methods("GET").
-		HandlerFunc(returnSingleAccount)
+		HandlerFunc(s.returnSingleAccount)
 	myRouter.Path("/accounts").
 		Methods("GET").
-		HandlerFunc(returnAllAccounts)
+		HandlerFunc(s.returnAllAccounts)
 	myRouter.Path("/accounts").
 		Methods("POST").
-		HandlerFunc(postAccount)
+		HandlerFunc(s.idempotent(s.postAccount))
 	myRouter.Path("/accounts/{id}").
 		Methods("PATCH").
-		HandlerFunc(patchAccount)
+		HandlerFunc(s.patchAccount)
 	myRouter.Path("/accounts/{id}").
 		Methods("DELETE").
-		HandlerFunc(deleteAccount)
+		HandlerFunc(s.deleteAccount)
+	myRouter.Path("/accounts/{id}/merge").
+		Methods("POST").
+		Queries("into", "{into}").
+		HandlerFunc(s.mergeAccount)
 	// transactions
 	myRouter.Path("/transactions").
 		Methods("GET").
 		Queries("queryString", "{queryString}").
-		HandlerFunc(queryTransactions)
+		HandlerFunc(s.queryTransactions)
 	myRouter.Path("/transactions").
 		Methods("GET").
 		Queries("startDate", "{startDate}", "endDate", "{endDate}").
-		HandlerFunc(returnTransactionsBetweenDates)
+		HandlerFunc(s.returnTransactionsBetweenDates)
 	myRouter.Path("/transactions").
 		Methods("GET").
-		HandlerFunc(returnAllTransactions)
+		HandlerFunc(s.returnAllTransactions)
 	myRouter.Path("/transactions/{id}").
 		Methods("GET").
-		HandlerFunc(returnSingleTransaction)
+		HandlerFunc(s.returnSingleTransaction)
 	myRouter.Path("/transactions").
 		Methods("POST").
-		HandlerFunc(postTransaction)
+		HandlerFunc(s.idempotent(s.postTransaction))
+	myRouter.Path("/transactions:batch").
+		Methods("POST").
+		HandlerFunc(s.idempotent(s.postTransactionsBatch))
+	myRouter.Path("/transactions").
+		Methods("PATCH").
+		Queries("queryString", "{queryString}").
+		HandlerFunc(s.patchTransactionsByQuery)
 	myRouter.Path("/transactions/{id}").
 		Methods("PATCH").
-		HandlerFunc(patchTransaction)
+		HandlerFunc(s.patchTransaction)
 	myRouter.Path("/transactions/{id}").
 		Methods("DELETE").
-		HandlerFunc(deleteTransaction)
+		HandlerFunc(s.deleteTransaction)
+	myRouter.Path("/events").
+		Methods("GET").
+		HandlerFunc(s.streamEvents)
+	// GraphQL
+	myRouter.Path("/graphql").
+		Methods("GET", "POST").
+		HandlerFunc(s.serveGraphql)
+	// webhooks
+	myRouter.Path("/webhooks").
+		Methods("GET").
+		HandlerFunc(s.returnAllWebhooks)
+	myRouter.Path("/webhooks").
+		Methods("POST").
+		HandlerFunc(s.postWebhook)
+	myRouter.Path("/webhooks/{id}").
+		Methods("DELETE").
+		HandlerFunc(s.deleteWebhook)
+	myRouter.Path("/webhooks/{id}/deliveries").
+		Methods("GET").
+		HandlerFunc(s.returnWebhookDeliveries)
+	myRouter.Path("/search").
+		Methods("GET").
+		Queries("terms", "{terms}").
+		HandlerFunc(s.searchTransactions)
 	// reporting
 	myRouter.Path("/reporting/account_balance").
 		Methods("GET").
 		Queries("accountName", "{accountName}", "date", "{date}").
-		HandlerFunc(getAccountBalanceOnDateByName)
+		HandlerFunc(s.getAccountBalanceOnDateByName)
 	myRouter.Path("/reporting/account_balance").
 		Methods("GET").
 		Queries("date", "{date}").
-		HandlerFunc(getAllAccountsBalanceOnDate)
+		HandlerFunc(s.getAllAccountsBalanceOnDate)
 	myRouter.Path("/reporting/balance_sheet").
 		Methods("GET").
 		Queries(
 			"date", "{date}", "assetTags", "{assetTags}",
 			"liabilityTags", "{liabilityTags}",
 		).
-		HandlerFunc(getBalanceSheet)
+		HandlerFunc(s.getBalanceSheet)
 	myRouter.Path("/reporting/income_statement").
 		Methods("GET").
 		Queries(
@@ -113,10 +325,35 @@ func HandleRequests(port string, db_url string) {
 			"taxesTags", "{taxesTags}", "expensesTags", "{expensesTags}",
 			"investmentsTags", "{investmentsTags}",
 		).
-		HandlerFunc(getIncomeStatement)
-	err := http.ListenAndServe(":"+port, myRouter)
-	if err != nil {
-		sugar.Errorw("Web server failed", "error", err)
-		os.Exit(1)
-	}
+		HandlerFunc(s.getIncomeStatement)
+	myRouter.Path("/reporting/aggregate").
+		Methods("GET").
+		Queries("queryString", "{queryString}").
+		HandlerFunc(s.getTransactionAggregates)
+	// saved queries and report presets
+	myRouter.Path("/saved_queries").
+		Methods("GET").
+		HandlerFunc(s.returnAllSavedQueries)
+	myRouter.Path("/saved_queries/{name}").
+		Methods("GET").
+		HandlerFunc(s.returnSingleSavedQuery)
+	myRouter.Path("/saved_queries/{name}").
+		Methods("PUT").
+		HandlerFunc(s.putSavedQuery)
+	myRouter.Path("/saved_queries/{name}").
+		Methods("DELETE").
+		HandlerFunc(s.deleteSavedQuery)
+	myRouter.Path("/report_presets").
+		Methods("GET").
+		HandlerFunc(s.returnAllReportPresets)
+	myRouter.Path("/report_presets/{name}").
+		Methods("GET").
+		HandlerFunc(s.returnSingleReportPreset)
+	myRouter.Path("/report_presets/{name}").
+		Methods("PUT").
+		HandlerFunc(s.putReportPreset)
+	myRouter.Path("/report_presets/{name}").
+		Methods("DELETE").
+		HandlerFunc(s.deleteReportPreset)
+	return myRouter
 }
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// All metrics of the server are registered here and served at /metrics
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "bkpsrv",
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	grpcRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "bkpsrv",
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Latency of gRPC calls by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+	validationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bkpsrv",
+		Name:      "validation_failures_total",
+		Help:      "Requests rejected with field-level validation errors.",
+	}, []string{"route"})
+	journalPostings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bkpsrv",
+		Name:      "journal_postings_total",
+		Help:      "Requests that post transactions, by endpoint and result.",
+	}, []string{"endpoint", "result"})
+	transactionsPosted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "bkpsrv",
+		Name:      "transactions_posted_total",
+		Help:      "Transactions created through the API.",
+	})
+	webhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bkpsrv",
+		Name:      "webhook_deliveries_total",
+		Help:      "Attempts to deliver events to webhooks, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		requestDuration, grpcRequestDuration, validationFailures, journalPostings,
+		transactionsPosted, webhookDeliveries,
+	)
+}
+
+// count a request that posts n transactions; n is 0 if nothing was created
+func observeJournalPosting(endpoint string, n int) {
+	result := "committed"
+	if n == 0 {
+		result = "rejected"
+	}
+	journalPostings.WithLabelValues(endpoint, result).Inc()
+	transactionsPosted.Add(float64(n))
+}
+
+var (
+	dbPoolTotalConnsDesc = prometheus.NewDesc("bkpsrv_db_pool_total_conns",
+		"Connections in the database pool.", nil, nil)
+	dbPoolIdleConnsDesc = prometheus.NewDesc("bkpsrv_db_pool_idle_conns",
+		"Idle connections in the database pool.", nil, nil)
+	dbPoolAcquiredConnsDesc = prometheus.NewDesc("bkpsrv_db_pool_acquired_conns",
+		"Connections in use.", nil, nil)
+	dbPoolMaxConnsDesc = prometheus.NewDesc("bkpsrv_db_pool_max_conns",
+		"Maximum size of the database pool.", nil, nil)
+	dbPoolAcquiresDesc = prometheus.NewDesc("bkpsrv_db_pool_acquires_total",
+		"Connections acquired from the database pool.", nil, nil)
+	dbPoolEmptyAcquiresDesc = prometheus.NewDesc("bkpsrv_db_pool_empty_acquires_total",
+		"Acquires that had to wait for a connection.", nil, nil)
+	dbPoolAcquireSecondsDesc = prometheus.NewDesc("bkpsrv_db_pool_acquire_seconds_total",
+		"Time spent acquiring connections.", nil, nil)
+)
+
+// dbPoolCollector reports the statistics of the database pool when scraped;
+// the server registers it once it runs
+type dbPoolCollector struct {
+	db *pgxpool.Pool
+}
+
+func (dbPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbPoolTotalConnsDesc
+	ch <- dbPoolIdleConnsDesc
+	ch <- dbPoolAcquiredConnsDesc
+	ch <- dbPoolMaxConnsDesc
+	ch <- dbPoolAcquiresDesc
+	ch <- dbPoolEmptyAcquiresDesc
+	ch <- dbPoolAcquireSecondsDesc
+}
+
+func (c dbPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.db == nil {
+		return
+	}
+	stat := c.db.Stat()
+	gauge := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+	}
+	counter := func(desc *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v)
+	}
+	gauge(dbPoolTotalConnsDesc, float64(stat.TotalConns()))
+	gauge(dbPoolIdleConnsDesc, float64(stat.IdleConns()))
+	gauge(dbPoolAcquiredConnsDesc, float64(stat.AcquiredConns()))
+	gauge(dbPoolMaxConnsDesc, float64(stat.MaxConns()))
+	counter(dbPoolAcquiresDesc, float64(stat.AcquireCount()))
+	counter(dbPoolEmptyAcquiresDesc, float64(stat.EmptyAcquireCount()))
+	counter(dbPoolAcquireSecondsDesc, stat.AcquireDuration().Seconds())
+}
+
+var metricsHandler = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+
+func returnMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsHandler.ServeHTTP(w, r)
+}
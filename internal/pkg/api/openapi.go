@@ -0,0 +1,360 @@
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+)
+
+// The OpenAPI 3 document of every route registered in newRouter. The
+// contract test fails if a route is missing from it.
+//go:embed openapi.json
+var openApiJson []byte
+
+// The parts of an OpenAPI document needed to validate requests. Only the
+// subset of JSON schema used by openapi.json is supported; additionalProperties
+// in particular must be a schema, not a boolean.
+type openApiDoc struct {
+	Paths      map[string]map[string]*openApiOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*openApiSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openApiOperation struct {
+	Parameters  []openApiParameter `json:"parameters"`
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema *openApiSchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+type openApiParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *openApiSchema `json:"schema"`
+}
+
+type openApiSchema struct {
+	Ref                  string                    `json:"$ref"`
+	Type                 string                    `json:"type"`
+	Format               string                    `json:"format"`
+	Enum                 []interface{}             `json:"enum"`
+	Required             []string                  `json:"required"`
+	Properties           map[string]*openApiSchema `json:"properties"`
+	AdditionalProperties *openApiSchema            `json:"additionalProperties"`
+	Items                *openApiSchema            `json:"items"`
+	AllOf                []*openApiSchema          `json:"allOf"`
+	OneOf                []*openApiSchema          `json:"oneOf"`
+	Nullable             bool                      `json:"nullable"`
+	Minimum              *float64                  `json:"minimum"`
+	ExclusiveMinimum     bool                      `json:"exclusiveMinimum"`
+	Maximum              *float64                  `json:"maximum"`
+	MinLength            *int                      `json:"minLength"`
+}
+
+var openApi openApiDoc
+
+func init() {
+	if err := json.Unmarshal(openApiJson, &openApi); err != nil {
+		panic(fmt.Sprintf("invalid openapi.json: %s", err))
+	}
+}
+
+func returnOpenApi(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openApiJson)
+}
+
+// the documented operation of the route that matched the request, if any
+func findOperation(r *http.Request) (*openApiOperation, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil, false
+	}
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return nil, false
+	}
+	op, ok := openApi.Paths[tpl][strings.ToLower(r.Method)]
+	return op, ok && op != nil
+}
+
+// validationMiddleware checks the parameters and the JSON body of every
+// request against openapi.json, and fails with field-level errors before the
+// request reaches a handler
+func validationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, ok := findOperation(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var verr bookkeeper.ValidationError
+		vars := mux.Vars(r)
+		query := r.URL.Query()
+		for _, p := range op.Parameters {
+			var (
+				value   string
+				present bool
+			)
+			switch p.In {
+			case "path":
+				value, present = vars[p.Name]
+			case "query":
+				_, present = query[p.Name]
+				value = query.Get(p.Name)
+			default:
+				continue
+			}
+			if !present {
+				if p.Required {
+					verr.Add(p.Name, "is required")
+				}
+				continue
+			}
+			validateParameter(p.Schema, value, p.Name, &verr)
+		}
+		if op.RequestBody != nil {
+			body, err := ioutil.ReadAll(r.Body)
+			if !checkErr(err, w, 400, "Failed to read the request body") {
+				return
+			}
+			// the handler reads the body again
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			validateBody(op, r.Header.Get("Content-Type"), body, &verr)
+		}
+		if len(verr.Fields) > 0 {
+			writeError(w, "Invalid request", 400, verr.Fields...)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validateParameter(
+	schema *openApiSchema, value string, name string,
+	verr *bookkeeper.ValidationError,
+) {
+	schema = resolveSchema(schema)
+	switch schema.Type {
+	case "integer":
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			verr.Add(name, "must be an integer")
+			return
+		}
+		validateSchema(schema, json.Number(strconv.Itoa(i)), name, verr)
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			verr.Add(name, "must be a boolean")
+			return
+		}
+		validateSchema(schema, b, name, verr)
+	default:
+		validateSchema(schema, value, name, verr)
+	}
+}
+
+// validateBody checks body against the schema documented for its content
+// type, which defaults to JSON
+func validateBody(
+	op *openApiOperation, contentType string, body []byte,
+	verr *bookkeeper.ValidationError,
+) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	content, ok := op.RequestBody.Content[mediaType]
+	if !ok {
+		content, ok = op.RequestBody.Content["application/json"]
+	}
+	if !ok || content.Schema == nil {
+		return
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			verr.Add("body", "is required")
+		}
+		return
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		verr.Add("body", fmt.Sprintf("is not valid JSON: %s", err))
+		return
+	}
+	validateSchema(content.Schema, value, "", verr)
+}
+
+// follow $ref to the schemas in components
+func resolveSchema(schema *openApiSchema) *openApiSchema {
+	for schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		resolved, ok := openApi.Components.Schemas[name]
+		if !ok {
+			panic(fmt.Sprintf("unknown schema %s in openapi.json", schema.Ref))
+		}
+		schema = resolved
+	}
+	return schema
+}
+
+func joinField(parent string, child string) string {
+	if parent == "" {
+		return child
+	}
+	return parent + "." + child
+}
+
+// validateSchema adds an error to verr for every part of value that does not
+// match schema. Numbers in value must be json.Number.
+func validateSchema(
+	schema *openApiSchema, value interface{}, field string,
+	verr *bookkeeper.ValidationError,
+) {
+	schema = resolveSchema(schema)
+	fieldOrBody := field
+	if fieldOrBody == "" {
+		fieldOrBody = "body"
+	}
+	for _, s := range schema.AllOf {
+		validateSchema(s, value, field, verr)
+	}
+	if len(schema.OneOf) > 0 {
+		matched := false
+		for _, s := range schema.OneOf {
+			var e bookkeeper.ValidationError
+			if validateSchema(s, value, field, &e); len(e.Fields) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			verr.Add(fieldOrBody, "does not match any of the allowed schemas")
+		}
+	}
+	if value == nil {
+		if schema.Type != "" && !schema.Nullable {
+			verr.Add(fieldOrBody, "must not be null")
+		}
+		return
+	}
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			verr.Add(fieldOrBody, "must be an object")
+			return
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				verr.Add(joinField(field, name), "is required")
+			}
+		}
+		var names []string
+		for name := range obj {
+			names = append(names, name)
+		}
+		// report errors in a stable order
+		sort.Strings(names)
+		for _, name := range names {
+			v := obj[name]
+			if s, ok := schema.Properties[name]; ok {
+				validateSchema(s, v, joinField(field, name), verr)
+			} else if schema.AdditionalProperties != nil {
+				validateSchema(schema.AdditionalProperties, v,
+					joinField(field, name), verr)
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			verr.Add(fieldOrBody, "must be an array")
+			return
+		}
+		if schema.Items != nil {
+			for i, v := range arr {
+				validateSchema(schema.Items, v, fmt.Sprintf("%s[%d]", field, i), verr)
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			verr.Add(fieldOrBody, "must be a string")
+			return
+		}
+		if schema.MinLength != nil && len(s) < *schema.MinLength {
+			verr.Add(fieldOrBody, fmt.Sprintf("must be at least %d characters long",
+				*schema.MinLength))
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				verr.Add(fieldOrBody, "must be a date-time in RFC 3339 format")
+			}
+		}
+	case "integer", "number":
+		mustBe := "must be a number"
+		if schema.Type == "integer" {
+			mustBe = "must be an integer"
+		}
+		n, ok := value.(json.Number)
+		if !ok {
+			verr.Add(fieldOrBody, mustBe)
+			return
+		}
+		f, err := n.Float64()
+		if err != nil {
+			verr.Add(fieldOrBody, mustBe)
+			return
+		}
+		if _, err := n.Int64(); schema.Type == "integer" && err != nil {
+			verr.Add(fieldOrBody, mustBe)
+			return
+		}
+		if m := schema.Minimum; m != nil {
+			if f < *m || (schema.ExclusiveMinimum && f == *m) {
+				op := "at least"
+				if schema.ExclusiveMinimum {
+					op = "greater than"
+				}
+				verr.Add(fieldOrBody, fmt.Sprintf("must be %s %v", op, *m))
+			}
+		}
+		if m := schema.Maximum; m != nil && f > *m {
+			verr.Add(fieldOrBody, fmt.Sprintf("must be at most %v", *m))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			verr.Add(fieldOrBody, "must be a boolean")
+			return
+		}
+	}
+	if len(schema.Enum) > 0 {
+		for _, e := range schema.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				return
+			}
+		}
+		var allowed []string
+		for _, e := range schema.Enum {
+			allowed = append(allowed, fmt.Sprint(e))
+		}
+		verr.Add(fieldOrBody, fmt.Sprintf("must be one of %s",
+			strings.Join(allowed, ", ")))
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+)
+
+// every route must be documented in openapi.json, and every documented
+// operation must be routed
+func TestOpenApiCoversAllRoutes(t *testing.T) {
+	routed := make(map[string]bool)
+	err := newServerWithoutDb(false).newRouter().Walk(func(
+		route *mux.Route, router *mux.Router, ancestors []*mux.Route,
+	) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			t.Errorf("route %s does not restrict its methods", tpl)
+			return nil
+		}
+		for _, m := range methods {
+			m = strings.ToLower(m)
+			routed[m+" "+tpl] = true
+			if op, ok := openApi.Paths[tpl][m]; !ok || op == nil {
+				t.Errorf("route %s %s is not documented in openapi.json",
+					strings.ToUpper(m), tpl)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tpl, ops := range openApi.Paths {
+		for m := range ops {
+			if !routed[m+" "+tpl] {
+				t.Errorf("%s %s is documented in openapi.json but not routed",
+					strings.ToUpper(m), tpl)
+			}
+		}
+	}
+}
+
+// every $ref in openapi.json must point to a schema in components
+func TestOpenApiRefsResolve(t *testing.T) {
+	var check func(s *openApiSchema)
+	check = func(s *openApiSchema) {
+		if s == nil {
+			return
+		}
+		if s.Ref != "" {
+			name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+			if _, ok := openApi.Components.Schemas[name]; !ok {
+				t.Errorf("unresolved $ref %s", s.Ref)
+			}
+		}
+		for _, p := range s.Properties {
+			check(p)
+		}
+		for _, sub := range append(s.AllOf, s.OneOf...) {
+			check(sub)
+		}
+		check(s.Items)
+		check(s.AdditionalProperties)
+	}
+	for _, s := range openApi.Components.Schemas {
+		check(s)
+	}
+	for _, ops := range openApi.Paths {
+		for _, op := range ops {
+			for _, p := range op.Parameters {
+				check(p.Schema)
+			}
+			if op.RequestBody != nil {
+				for _, c := range op.RequestBody.Content {
+					check(c.Schema)
+				}
+			}
+		}
+	}
+}
+
+func TestValidationReportsFieldErrors(t *testing.T) {
+	body := `{"type": "Spend", "date": "yesterday", "account_id": "1"}`
+	req := httptest.NewRequest(http.MethodPost, "/transactions",
+		strings.NewReader(body))
+	w := httptest.NewRecorder()
+	newServerWithoutDb(true).ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+	var resp bookkeeper.ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, f := range resp.Error.Fields {
+		got[f.Field] = true
+	}
+	for _, field := range []string{"type", "date", "account_id", "amount"} {
+		if !got[field] {
+			t.Errorf("expected an error on field %s, got %v",
+				field, resp.Error.Fields)
+		}
+	}
+}
+
+func TestValidationChecksQueryParameters(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet,
+		"/transactions?queryString=true&limit=ten", nil)
+	w := httptest.NewRecorder()
+	newServerWithoutDb(true).ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"field":"limit"`) {
+		t.Errorf("expected an error on limit, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/api/_peg"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+)
+
+func (s *Server) returnAllSavedQueries(w http.ResponseWriter, r *http.Request) {
+	queries, err := bookkeeper.GetAllSavedQueries(r.Context(), s.db)
+	if !checkErr(err, w, 500, "Failed to get saved queries") {
+		return
+	}
+	json.NewEncoder(w).Encode(queries)
+}
+
+func (s *Server) returnSingleSavedQuery(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	query, err := bookkeeper.GetSingleSavedQuery(r.Context(), s.db, name)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, "Saved query not found", 404)
+		return
+	}
+	if !checkErr(err, w, 500, "Failed to get saved query", "name", name) {
+		return
+	}
+	json.NewEncoder(w).Encode(query)
+}
+
+func (s *Server) putSavedQuery(w http.ResponseWriter, r *http.Request) {
+	var query bookkeeper.SavedQuery
+
+	body, err := ioutil.ReadAll(r.Body)
+	if !checkErr(err, w, 400, "Failed to read the request body") {
+		return
+	}
+	err = json.Unmarshal(body, &query)
+	if !checkErr(err, w, 400, "Failed to parse the request body as a JSON string") {
+		return
+	}
+	// the name in the URL is authoritative
+	query.Name = mux.Vars(r)["name"]
+	if !checkErr(query.Validate(), w, 400, "Invalid saved query payload",
+		"query", query) {
+		return
+	}
+	// refuse to save a query that would fail whenever it is run
+	_, err = _peg.ParseString(query.QueryString)
+	if !checkErr(err, w, 400, "Invalid query string",
+		"queryString", query.QueryString) {
+		return
+	}
+	err = bookkeeper.UpsertSavedQuery(r.Context(), s.db, &query)
+	if !checkErr(err, w, 500, "Failed to save query", "name", query.Name) {
+		return
+	}
+	json.NewEncoder(w).Encode(query)
+}
+
+func (s *Server) deleteSavedQuery(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	err := bookkeeper.DeleteSavedQuery(r.Context(), s.db, name)
+	if !checkErr(err, w, 500, "Failed to delete saved query", "name", name) {
+		return
+	}
+}
+
+func (s *Server) returnAllReportPresets(w http.ResponseWriter, r *http.Request) {
+	presets, err := bookkeeper.GetAllReportPresets(r.Context(), s.db)
+	if !checkErr(err, w, 500, "Failed to get report presets") {
+		return
+	}
+	json.NewEncoder(w).Encode(presets)
+}
+
+func (s *Server) returnSingleReportPreset(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	preset, err := bookkeeper.GetSingleReportPreset(r.Context(), s.db, name)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, "Report preset not found", 404)
+		return
+	}
+	if !checkErr(err, w, 500, "Failed to get report preset", "name", name) {
+		return
+	}
+	json.NewEncoder(w).Encode(preset)
+}
+
+func (s *Server) putReportPreset(w http.ResponseWriter, r *http.Request) {
+	var preset bookkeeper.ReportPreset
+
+	body, err := ioutil.ReadAll(r.Body)
+	if !checkErr(err, w, 400, "Failed to read the request body") {
+		return
+	}
+	err = json.Unmarshal(body, &preset)
+	if !checkErr(err, w, 400, "Failed to parse the request body as a JSON string") {
+		return
+	}
+	// the name in the URL is authoritative
+	preset.Name = mux.Vars(r)["name"]
+	if !checkErr(preset.Validate(), w, 400, "Invalid report preset payload",
+		"preset", preset) {
+		return
+	}
+	err = bookkeeper.UpsertReportPreset(r.Context(), s.db, &preset)
+	if !checkErr(err, w, 500, "Failed to save report preset", "name", preset.Name) {
+		return
+	}
+	json.NewEncoder(w).Encode(preset)
+}
+
+func (s *Server) deleteReportPreset(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	err := bookkeeper.DeleteReportPreset(r.Context(), s.db, name)
+	if !checkErr(err, w, 500, "Failed to delete report preset", "name", name) {
+		return
+	}
+}
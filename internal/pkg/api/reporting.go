@@ -2,18 +2,21 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/api/_peg"
 	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
 )
 
-func getAccountBalanceOnDateByName(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getAccountBalanceOnDateByName(w http.ResponseWriter, r *http.Request) {
 	accountName := r.FormValue("accountName")
 	date, ok := parseDateTimeInQueryAndFail(w, r, "date")
 	if !ok {
 		return
 	}
-	account, balance, err := bookkeeper.ComputeAccountBalanceByName(dbpool, accountName, date)
+	account, balance, err := bookkeeper.ComputeAccountBalanceByName(r.Context(), s.db, accountName, date)
 	if !checkErr(err, w, 500, "Failed to query account balance",
 		"accountName", accountName) {
 		return
@@ -22,12 +25,12 @@ func getAccountBalanceOnDateByName(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(account_)
 }
 
-func getAllAccountsBalanceOnDate(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getAllAccountsBalanceOnDate(w http.ResponseWriter, r *http.Request) {
 	date, ok := parseDateTimeInQueryAndFail(w, r, "date")
 	if !ok {
 		return
 	}
-	accounts_, err := bookkeeper.GetAllAccountsBalanceOnDate(dbpool, date)
+	accounts_, err := bookkeeper.GetAllAccountsBalanceOnDate(r.Context(), s.db, date)
 	if !checkErr(err, w, 500, "Failed to get the balance of all accounts",
 		"error", err) {
 		return
@@ -35,7 +38,7 @@ func getAllAccountsBalanceOnDate(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(accounts_)
 }
 
-func getBalanceSheet(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getBalanceSheet(w http.ResponseWriter, r *http.Request) {
 	var balanceSheets []bookkeeper.BalanceSheet
 	dates, ok := parseMultipleDateTimesInQueryAndFail(w, r, "date")
 	if !ok {
@@ -49,21 +52,54 @@ func getBalanceSheet(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
+	owner, ok := s.parseOwnerInQueryAndFail(w, r)
+	if !ok {
+		return
+	}
+	format, ok := parseFormatInQueryAndFail(w, r)
+	if !ok {
+		return
+	}
+	schema, ok := parseReportSchemaInQueryAndFail(w, r,
+		bookkeeper.BalanceSheetSchema(assetTags, liabilityTags))
+	if !ok {
+		return
+	}
+	householdSize := 0
+	if owner != "" {
+		var err error
+		householdSize, err = bookkeeper.CountUsers(r.Context(), s.db)
+		if !checkErr(err, w, 500, "Failed to count household members") {
+			return
+		}
+	}
 	for _, date := range dates {
-		accounts, err := bookkeeper.GetAllAccountsBalanceOnDate(dbpool, date)
+		accounts, err := bookkeeper.GetAllAccountsBalanceOnDate(r.Context(), s.db, date)
 		if !checkErr(err, w, 500, "Failed to get the balance of all accounts",
 			"error", err) {
 			return
 		}
+		if owner != "" {
+			accounts = bookkeeper.ApportionBalances(accounts, owner, householdSize)
+		}
 		balanceSheets = append(
 			balanceSheets,
 			bookkeeper.ComputeBalanceSheet(accounts, assetTags, liabilityTags),
 		)
 	}
+	if format != formatJson {
+		var statements []bookkeeper.StatementWithFields
+		for _, bs := range balanceSheets {
+			statements = append(statements, bs)
+		}
+		writeStatements(w, format, schema, "Balance Sheet", statements,
+			strings.Split(r.FormValue("date"), ","), "balance_sheet")
+		return
+	}
 	json.NewEncoder(w).Encode(balanceSheets)
 }
 
-func getIncomeStatement(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getIncomeStatement(w http.ResponseWriter, r *http.Request) {
 	var isList []bookkeeper.IncomeStatement
 	var (
 		ok              bool
@@ -72,6 +108,7 @@ func getIncomeStatement(w http.ResponseWriter, r *http.Request) {
 		taxesTags       []string
 		expensesTags    []string
 		investmentsTags []string
+		owner           string
 	)
 	if dateRanges, ok = parseMultipleDateRangesInQueryAndFail(w, r, "dateRange"); !ok {
 		return
@@ -88,10 +125,22 @@ func getIncomeStatement(w http.ResponseWriter, r *http.Request) {
 	if investmentsTags, ok = parseTagsInQueryAndFail(w, r, "investmentsTags"); !ok {
 		return
 	}
+	if owner, ok = s.parseOwnerInQueryAndFail(w, r); !ok {
+		return
+	}
+	format, ok := parseFormatInQueryAndFail(w, r)
+	if !ok {
+		return
+	}
+	schema, ok := parseReportSchemaInQueryAndFail(w, r, bookkeeper.IncomeStatementSchema(
+		revenueTags, taxesTags, expensesTags, investmentsTags))
+	if !ok {
+		return
+	}
 	for _, dateRange_ := range dateRanges {
 		is, err := bookkeeper.ComputeIncomeStatement(
-			dbpool, dateRange_.startDate, dateRange_.endDate,
-			revenueTags, taxesTags, expensesTags, investmentsTags,
+			r.Context(), s.db, dateRange_.startDate, dateRange_.endDate,
+			revenueTags, taxesTags, expensesTags, investmentsTags, owner,
 		)
 		if !checkErr(
 			err, w, 500,
@@ -102,5 +151,54 @@ func getIncomeStatement(w http.ResponseWriter, r *http.Request) {
 		}
 		isList = append(isList, is)
 	}
+	if format != formatJson {
+		var statements []bookkeeper.StatementWithFields
+		for _, is := range isList {
+			statements = append(statements, is)
+		}
+		writeStatements(w, format, schema, "Income Statement", statements,
+			strings.Split(r.FormValue("dateRange"), ","), "income_statement")
+		return
+	}
 	json.NewEncoder(w).Encode(isList)
 }
+
+func (s *Server) getTransactionAggregates(w http.ResponseWriter, r *http.Request) {
+	queryString := strings.Trim(r.FormValue("queryString"), "'")
+	queryData, err := _peg.ParseString(queryString)
+	if !checkErr(err, w, 400, "Invalid query string", "queryString", queryString) {
+		return
+	}
+	if queryData.OrderBy != "" || queryData.Limit > 0 || queryData.Offset > 0 {
+		writeError(w, "ORDER BY, LIMIT and OFFSET are not supported in aggregates", 400)
+		return
+	}
+	prepQueryData(&queryData)
+	var groupBy []string
+	if groupByStr := r.FormValue("groupBy"); groupByStr != "" {
+		groupBy = strings.Split(groupByStr, ",")
+	}
+	for _, key := range groupBy {
+		if _, ok := bookkeeper.AGGREGATE_GROUP_KEYS[key]; !ok {
+			writeError(w, fmt.Sprintf("Invalid group by key %s", key), 400)
+			return
+		}
+	}
+	aggregates := []string{"sum"}
+	if aggregatesStr := r.FormValue("aggregates"); aggregatesStr != "" {
+		aggregates = strings.Split(aggregatesStr, ",")
+	}
+	for _, agg := range aggregates {
+		if _, ok := bookkeeper.AGGREGATE_FUNCTIONS[agg]; !ok {
+			writeError(w, fmt.Sprintf("Invalid aggregate %s", agg), 400)
+			return
+		}
+	}
+	rows, err := bookkeeper.AggregateTransactionsWithFilters(
+		r.Context(), s.db, queryData.Clause, queryData.Values, groupBy, aggregates)
+	if !checkErr(err, w, 500, "Failed to aggregate transactions",
+		"queryData.Clause", queryData.Clause) {
+		return
+	}
+	json.NewEncoder(w).Encode(rows)
+}
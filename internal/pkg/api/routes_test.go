@@ -0,0 +1,410 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+)
+
+// The ids of the rows seedTestDb creates, by name
+type testIds map[string]int
+
+// of returns the id of the named row; without ids, e.g. to match cases with
+// routes, every id is 0
+func (ids testIds) of(name string) string {
+	id, ok := ids[name]
+	if !ok && ids != nil {
+		panic("no test row named " + name)
+	}
+	return strconv.Itoa(id)
+}
+
+// seedTestDb adds the members alice and bob, and the rows the cases of
+// dbRouteCases work on. It returns the ids of the rows, and a read-write and
+// a read-only token of alice.
+func seedTestDb(t *testing.T, db *pgxpool.Pool) (ids testIds, token string, readOnly string) {
+	t.Helper()
+	ctx := context.Background()
+	ids = make(testIds)
+	alice := addTestUser(t, db, "alice")
+	bob := addTestUser(t, db, "bob")
+	_, token = addTestToken(t, db, alice, "test", bookkeeper.TokenScopeReadWrite)
+	_, readOnly = addTestToken(t, db, alice, "test read", bookkeeper.TokenScopeRead)
+	phone, _ := addTestToken(t, db, alice, "phone", bookkeeper.TokenScopeReadWrite)
+	ids["phone token"] = phone.Id
+
+	accounts := []struct {
+		key     string
+		account bookkeeper.Account
+	}{
+		{"checking", bookkeeper.Account{Name: "Checking", Tags: []string{"asset", "cash"}}},
+		{"credit card", bookkeeper.Account{Name: "Credit Card", Tags: []string{"liability", "credit card"}}},
+		{"bob's savings", bookkeeper.Account{
+			Name: "Bob's Savings", Tags: []string{"asset"},
+			Owners: []bookkeeper.AccountOwner{{UserId: bob.Id, Share: 1}},
+		}},
+		{"spare", bookkeeper.Account{Name: "Spare", Tags: []string{"asset"}}},
+		{"new credit card", bookkeeper.Account{Name: "New Credit Card", Tags: []string{"liability", "credit card"}}},
+	}
+	for _, a := range accounts {
+		if err := bookkeeper.InsertAccount(ctx, db, &a.account); err != nil {
+			t.Fatalf("failed to insert account %s: %v", a.account.Name, err)
+		}
+		ids[a.key] = a.account.Id
+	}
+
+	date := func(day int) time.Time { return time.Date(2021, 6, day, 0, 0, 0, 0, time.UTC) }
+	transactions := []struct {
+		key   string
+		trans bookkeeper.Transaction
+	}{
+		{"costco", bookkeeper.Transaction{
+			Type: "Out", Date: date(1), Category: "Food & Dining", SubCategory: "Groceries",
+			AccountId: ids["checking"], Amount: -2500, Notes: "costco run",
+		}},
+		{"lunch", bookkeeper.Transaction{
+			Type: "Out", Date: date(2), Category: "Food & Dining", SubCategory: "Restaurants",
+			AccountId: ids["checking"], Amount: -1000,
+		}},
+		{"salary", bookkeeper.Transaction{
+			Type: "In", Date: date(15), Category: "Professional Income", SubCategory: "Salary",
+			AccountId: ids["checking"], Amount: 500000,
+		}},
+		{"card payment out", bookkeeper.Transaction{
+			Type: "TransferOut", Date: date(20), AccountId: ids["checking"], Amount: -10000,
+			AssociationId: "pay-card",
+		}},
+		{"card payment in", bookkeeper.Transaction{
+			Type: "TransferIn", Date: date(20), AccountId: ids["credit card"], Amount: 10000,
+			AssociationId: "pay-card",
+		}},
+	}
+	for _, tr := range transactions {
+		if err := bookkeeper.InsertTransaction(ctx, db, &tr.trans); err != nil {
+			t.Fatalf("failed to insert transaction %s: %v", tr.key, err)
+		}
+		ids[tr.key] = tr.trans.Id
+	}
+	events := []bookkeeper.Event{
+		{Type: bookkeeper.EventTransactionCreated, EntityId: ids["costco"]},
+	}
+	if err := bookkeeper.InsertEvents(ctx, db, events); err != nil {
+		t.Fatalf("failed to insert events: %v", err)
+	}
+
+	webhook := bookkeeper.Webhook{Url: "http://127.0.0.1:1/hook", Secret: "secret"}
+	if err := bookkeeper.InsertWebhook(ctx, db, &webhook); err != nil {
+		t.Fatalf("failed to insert webhook: %v", err)
+	}
+	ids["webhook"] = webhook.Id
+	query := bookkeeper.SavedQuery{Name: "big", QueryString: "amount < -100000"}
+	if err := bookkeeper.UpsertSavedQuery(ctx, db, &query); err != nil {
+		t.Fatalf("failed to save query: %v", err)
+	}
+	preset := bookkeeper.ReportPreset{Name: "year-end", Report: "balance", Dates: "2021/12/31"}
+	if err := bookkeeper.UpsertReportPreset(ctx, db, &preset); err != nil {
+		t.Fatalf("failed to save report preset: %v", err)
+	}
+	return ids, token, readOnly
+}
+
+// dbRouteCases walks through the API as the member alice, whose read-only
+// token is readOnly. Every case runs on a copy of its own of the database of
+// seedTestDb, whose rows have ids.
+func dbRouteCases(ids testIds, readOnly string) []routeCase {
+	q := url.QueryEscape
+	ifMatch := func(version string) map[string]string {
+		return map[string]string{"If-Match": `"` + version + `"`}
+	}
+	checking := ids.of("checking")
+	creditCard := ids.of("credit card")
+	bobsSavings := ids.of("bob's savings")
+	newCreditCard := ids.of("new credit card")
+	costco := ids.of("costco")
+	lunch := ids.of("lunch")
+	cardPaymentIn := ids.of("card payment in")
+	webhook := ids.of("webhook")
+	lunchRequest := routeCase{name: "lunch", method: "POST", target: "/transactions",
+		body:   `{"type": "Out", "date": "2021-06-02T00:00:00Z", "category": "Food & Dining", "sub_category": "Restaurants", "account_id": ` + checking + `, "amount": -1000}`,
+		header: map[string]string{"Idempotency-Key": "lunch"}, status: 200}
+	merge := routeCase{name: "merge", method: "POST",
+		target: "/accounts/" + creditCard + "/merge?into=" + newCreditCard,
+		header: ifMatch("1"), status: 200}
+	return []routeCase{
+		// authentication
+		{name: "invalid token", method: "GET", target: "/accounts",
+			header: map[string]string{"Authorization": "Bearer nope"}, status: 401},
+		{name: "no token", method: "GET", target: "/accounts",
+			header: map[string]string{"Authorization": ""}, status: 401},
+		{name: "write with read-only token", method: "POST", target: "/accounts",
+			body:   `{"name": "Savings", "tags": ["asset"]}`,
+			header: map[string]string{"Authorization": "Bearer " + readOnly}, status: 403},
+		{name: "read with read-only token", method: "GET", target: "/accounts",
+			header: map[string]string{"Authorization": "Bearer " + readOnly}, status: 200},
+		{name: "current user", method: "GET", target: "/auth/me", status: 200,
+			check: bodyContains(`"alice"`)},
+		{name: "login", method: "POST", target: "/auth/login",
+			body:   `{"name": "alice", "password": "alice-password", "token_name": "laptop", "scope": "read"}`,
+			header: map[string]string{"Authorization": ""}, status: 200, check: bodyContains(`"token"`)},
+		{name: "login with wrong password", method: "POST", target: "/auth/login",
+			body:   `{"name": "alice", "password": "guess", "token_name": "laptop", "scope": "read"}`,
+			header: map[string]string{"Authorization": ""}, status: 401},
+		{name: "login of unknown user", method: "POST", target: "/auth/login",
+			body:   `{"name": "mallory", "password": "guess", "token_name": "laptop", "scope": "read"}`,
+			header: map[string]string{"Authorization": ""}, status: 401},
+		{name: "new token", method: "POST", target: "/auth/tokens",
+			body: `{"name": "tablet", "scope": "read-write"}`, status: 200},
+		{name: "new token of unknown scope", method: "POST", target: "/auth/tokens",
+			body: `{"name": "tablet", "scope": "admin"}`, status: 400},
+		{name: "tokens", method: "GET", target: "/auth/tokens", status: 200,
+			check: bodyContains(`"phone"`)},
+		{name: "revoke token", method: "DELETE", target: "/auth/tokens/" + ids.of("phone token"),
+			status: 200},
+		{name: "revoke missing token", method: "DELETE", target: "/auth/tokens/99", status: 404},
+		// accounts
+		{name: "new asset account", method: "POST", target: "/accounts",
+			body: `{"name": "Savings", "tags": ["asset", "cash"]}`, status: 200},
+		{name: "new liability account", method: "POST", target: "/accounts",
+			body: `{"name": "Store Card", "tags": ["liability", "credit card"]}`, status: 200},
+		{name: "new account of another member", method: "POST", target: "/accounts",
+			body:   `{"name": "Bob's Brokerage", "tags": ["asset"], "owners": [{"user_name": "bob", "share": 1}]}`,
+			status: 200},
+		{name: "new account of unknown owner", method: "POST", target: "/accounts",
+			body:   `{"name": "Mallory's", "tags": ["asset"], "owners": [{"user_name": "mallory", "share": 1}]}`,
+			status: 400},
+		{name: "accounts", method: "GET", target: "/accounts", status: 200,
+			check: bodyContains(`"Credit Card"`)},
+		{name: "account by name", method: "GET", target: "/accounts?accountName=Checking",
+			status: 200, check: hasHeader("ETag", `"1"`)},
+		{name: "missing account by name", method: "GET", target: "/accounts?accountName=Nope",
+			status: 404},
+		{name: "account", method: "GET", target: "/accounts/" + checking, status: 200,
+			check: hasHeader("ETag", `"1"`)},
+		{name: "missing account", method: "GET", target: "/accounts/99", status: 404},
+		{name: "patch account", method: "PATCH", target: "/accounts/" + checking,
+			body: `{"desc_": "main"}`, header: ifMatch("1"), status: 200,
+			check: hasHeader("ETag", `"2"`)},
+		{name: "patch stale account", method: "PATCH", target: "/accounts/" + checking,
+			body: `{"desc_": "old"}`, header: ifMatch("2"), status: 412},
+		{name: "patch account to no class", method: "PATCH", target: "/accounts/" + checking,
+			body: `{"tags": ["cash"]}`, header: ifMatch("1"), status: 400},
+		{name: "patch missing account", method: "PATCH", target: "/accounts/99",
+			body: `{"desc_": "main"}`, header: ifMatch("1"), status: 404},
+		{name: "patch account of another member", method: "PATCH", target: "/accounts/" + bobsSavings,
+			body:   `{"owners": [{"user_name": "alice", "share": 1}]}`,
+			header: ifMatch("1"), status: 403},
+		{name: "delete account of another member", method: "DELETE", target: "/accounts/" + bobsSavings,
+			header: ifMatch("1"), status: 403},
+		{name: "delete stale account", method: "DELETE", target: "/accounts/" + ids.of("spare"),
+			header: ifMatch("2"), status: 412},
+		{name: "delete account", method: "DELETE", target: "/accounts/" + ids.of("spare"),
+			header: ifMatch("1"), status: 200},
+		{name: "delete account in use", method: "DELETE", target: "/accounts/" + creditCard,
+			header: ifMatch("1"), status: 409},
+		// transactions
+		{name: "new expense", method: "POST", target: "/transactions",
+			body:   `{"type": "Out", "date": "2021-07-01T00:00:00Z", "category": "Food & Dining", "sub_category": "Groceries", "account_id": ` + checking + `, "amount": -2500, "notes": "costco run"}`,
+			status: 200},
+		{name: "new income", method: "POST", target: "/transactions",
+			body:   `{"type": "In", "date": "2021-07-15T00:00:00Z", "category": "Professional Income", "sub_category": "Salary", "account_id": ` + checking + `, "amount": 500000}`,
+			status: 200},
+		{name: "new transaction on account of another member", method: "POST", target: "/transactions",
+			body:   `{"type": "Out", "date": "2021-07-01T00:00:00Z", "category": "Food & Dining", "sub_category": "Groceries", "account_id": ` + bobsSavings + `, "amount": -100}`,
+			status: 403},
+		{name: "idempotent transaction", method: lunchRequest.method, target: lunchRequest.target,
+			body: lunchRequest.body, header: lunchRequest.header, status: 200},
+		{name: "replayed transaction", method: lunchRequest.method, target: lunchRequest.target,
+			body: lunchRequest.body, header: lunchRequest.header, status: 200,
+			check: hasHeader("Idempotent-Replayed", "true"), before: []routeCase{lunchRequest}},
+		{name: "reused idempotency key", method: "POST", target: "/transactions",
+			body:   strings.Replace(lunchRequest.body, "-1000", "-2000", 1),
+			header: lunchRequest.header, status: 422, before: []routeCase{lunchRequest}},
+		{name: "batch of a transfer", method: "POST", target: "/transactions:batch",
+			body: `{"transactions": [
+				{"type": "TransferOut", "date": "2021-07-20T00:00:00Z", "account_id": ` + checking + `, "amount": -10000, "association_id": "pay-card-2"},
+				{"type": "TransferIn", "date": "2021-07-20T00:00:00Z", "account_id": ` + creditCard + `, "amount": 10000, "association_id": "pay-card-2"}
+			]}`,
+			status: 200, check: bodyContains(`"committed":true`)},
+		{name: "batch on account of another member", method: "POST", target: "/transactions:batch",
+			body: `{"transactions": [
+				{"type": "TransferOut", "date": "2021-07-21T00:00:00Z", "account_id": ` + checking + `, "amount": -10000, "association_id": "to-bob"},
+				{"type": "TransferIn", "date": "2021-07-21T00:00:00Z", "account_id": ` + bobsSavings + `, "amount": 10000, "association_id": "to-bob"}
+			]}`,
+			status: 403, check: bodyContains(`"skipped"`)},
+		{name: "transactions", method: "GET", target: "/transactions", status: 200,
+			check: hasHeader("X-Total-Count", "5")},
+		{name: "page of transactions", method: "GET", target: "/transactions?limit=2&offset=2",
+			status: 200, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				if w.Header().Get("Link") == "" {
+					t.Error("expected a Link header")
+				}
+			}},
+		{name: "transactions as CSV", method: "GET", target: "/transactions?format=csv",
+			status: 200, check: bodyContains("costco run")},
+		{name: "transactions by query", method: "GET",
+			target: "/transactions?queryString=" + q("amount < 0 ORDER BY date DESC"), status: 200,
+			check: hasHeader("X-Total-Count", "3")},
+		{name: "transactions by query with limit", method: "GET",
+			target: "/transactions?queryString=" + q("amount < 0 LIMIT 2") + "&limit=1",
+			status: 200, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				hasHeader("X-Total-Count", "2")(t, w)
+				if !strings.Contains(w.Header().Get("Link"), `rel="next"`) {
+					t.Error("expected a link to the next page")
+				}
+			}},
+		{name: "last page of transactions by query with limit", method: "GET",
+			target: "/transactions?queryString=" + q("amount < 0 LIMIT 2") + "&limit=1&offset=1",
+			status: 200, check: func(t *testing.T, w *httptest.ResponseRecorder) {
+				hasHeader("X-Total-Count", "2")(t, w)
+				if strings.Contains(w.Header().Get("Link"), `rel="next"`) {
+					t.Error("expected no link to the next page")
+				}
+			}},
+		{name: "transactions between dates", method: "GET",
+			target: "/transactions?startDate=2021/06/01&endDate=2021/06/10", status: 200,
+			check: bodyContains("costco run")},
+		{name: "transaction", method: "GET", target: "/transactions/" + costco, status: 200,
+			check: hasHeader("ETag", `"1"`)},
+		{name: "missing transaction", method: "GET", target: "/transactions/99", status: 404},
+		{name: "patch transaction", method: "PATCH", target: "/transactions/" + costco,
+			body: `{"notes": "costco"}`, header: ifMatch("1"), status: 200,
+			check: hasHeader("ETag", `"2"`)},
+		{name: "patch stale transaction", method: "PATCH", target: "/transactions/" + costco,
+			body: `{"notes": "costco"}`, header: ifMatch("2"), status: 412},
+		{name: "patch expense to positive amount", method: "PATCH", target: "/transactions/" + costco,
+			body: `{"amount": 2500}`, header: ifMatch("1"), status: 400},
+		{name: "patch expense to absolute amount", method: "PATCH",
+			target: "/transactions/" + costco + "?normalizeAmounts=true",
+			body:   `{"amount": 2500}`, header: ifMatch("1"), status: 200,
+			check: bodyContains(`"amount":-2500`)},
+		{name: "patch transaction to no category", method: "PATCH", target: "/transactions/" + costco,
+			body: `{"category": ""}`, header: ifMatch("1"), status: 400},
+		{name: "bulk update", method: "PATCH",
+			target: "/transactions?queryString=" + q(`sub_category = "Restaurants"`),
+			body:   `{"sub_category": "Fast Food"}`, status: 200, check: bodyContains(`"updated":1`)},
+		{name: "bulk update onto account of another member", method: "PATCH",
+			target: "/transactions?queryString=" + q(`sub_category = "Restaurants"`),
+			body:   `{"account_id": ` + bobsSavings + `}`, status: 403},
+		{name: "bulk update onto account of other class", method: "PATCH",
+			target: "/transactions?queryString=" + q(`sub_category = "Restaurants"`),
+			body:   `{"account_id": ` + creditCard + `}`, status: 400},
+		{name: "search", method: "GET", target: "/search?terms=costco", status: 200,
+			check: bodyContains(`"costco run"`)},
+		{name: "delete transaction", method: "DELETE", target: "/transactions/" + lunch,
+			header: ifMatch("1"), status: 200},
+		{name: "delete missing transaction", method: "DELETE", target: "/transactions/99",
+			header: ifMatch("1"), status: 404},
+		// reporting
+		{name: "account balance", method: "GET",
+			target: "/reporting/account_balance?accountName=Checking&date=2021/12/31", status: 200,
+			check: bodyContains("486500")},
+		{name: "balance of missing account", method: "GET",
+			target: "/reporting/account_balance?accountName=Nope&date=2021/12/31", status: 404},
+		{name: "balances", method: "GET", target: "/reporting/account_balance?date=2021/12/31",
+			status: 200, check: bodyContains("Credit Card")},
+		{name: "balance sheet", method: "GET",
+			target: "/reporting/balance_sheet?date=2021/12/31&assetTags=cash&liabilityTags=" + q("credit card"),
+			status: 200},
+		{name: "balance sheet as XLSX", method: "GET",
+			target: "/reporting/balance_sheet?date=2021/12/31&assetTags=cash&liabilityTags=" + q("credit card") + "&format=xlsx",
+			status: 200},
+		{name: "balance sheet of unknown owner", method: "GET",
+			target: "/reporting/balance_sheet?date=2021/12/31&assetTags=cash&liabilityTags=" + q("credit card") + "&owner=mallory",
+			status: 400},
+		{name: "income statement", method: "GET",
+			target: "/reporting/income_statement?dateRange=2021&revenueTags=" + q("Professional Income/") +
+				"&taxesTags=" + q("Taxes/") + "&expensesTags=" + q("Food & Dining/") +
+				"&investmentsTags=" + q("Investment/"),
+			status: 200},
+		{name: "aggregate", method: "GET",
+			target: "/reporting/aggregate?queryString=" + q("amount < 0") + "&groupBy=category",
+			status: 200, check: bodyContains("Food & Dining")},
+		// saved queries and report presets
+		{name: "save query", method: "PUT", target: "/saved_queries/small",
+			body: `{"query_string": "amount > -1000"}`, status: 200},
+		{name: "save invalid query", method: "PUT", target: "/saved_queries/bad",
+			body: `{"query_string": "amount <"}`, status: 400},
+		{name: "saved queries", method: "GET", target: "/saved_queries", status: 200,
+			check: bodyContains(`"big"`)},
+		{name: "saved query", method: "GET", target: "/saved_queries/big", status: 200},
+		{name: "missing saved query", method: "GET", target: "/saved_queries/bad", status: 404},
+		{name: "delete saved query", method: "DELETE", target: "/saved_queries/big", status: 200},
+		{name: "save report preset", method: "PUT", target: "/report_presets/mid-year",
+			body: `{"report": "balance", "dates": "2021/06/30"}`, status: 200},
+		{name: "report presets", method: "GET", target: "/report_presets", status: 200,
+			check: bodyContains(`"year-end"`)},
+		{name: "report preset", method: "GET", target: "/report_presets/year-end", status: 200},
+		{name: "missing report preset", method: "GET", target: "/report_presets/q4", status: 404},
+		{name: "delete report preset", method: "DELETE", target: "/report_presets/year-end",
+			status: 200},
+		// webhooks
+		{name: "new webhook", method: "POST", target: "/webhooks",
+			body: `{"url": "http://127.0.0.1:1/hook"}`, status: 200, check: bodyContains(`"secret"`)},
+		{name: "new webhook of other scheme", method: "POST", target: "/webhooks",
+			body: `{"url": "ftp://127.0.0.1/hook"}`, status: 400},
+		{name: "webhooks", method: "GET", target: "/webhooks", status: 200},
+		{name: "webhook deliveries", method: "GET", target: "/webhooks/" + webhook + "/deliveries",
+			status: 200},
+		{name: "deliveries of missing webhook", method: "GET", target: "/webhooks/99/deliveries",
+			status: 404},
+		{name: "delete webhook", method: "DELETE", target: "/webhooks/" + webhook, status: 200},
+		{name: "delete missing webhook", method: "DELETE", target: "/webhooks/99", status: 404},
+		// events and GraphQL
+		{name: "events", method: "GET", target: "/events?lastEventId=0", status: 200,
+			check: bodyContains("event: transaction.created")},
+		{name: "graphql", method: "POST", target: "/graphql",
+			body: `{"query": "{ accounts { name } }"}`, status: 200, check: bodyContains("Checking")},
+		{name: "graphql over GET", method: "GET",
+			target: "/graphql?query=" + q(`{ transaction(id: "`+costco+`") { notes } }`), status: 200,
+			check: bodyContains("costco run")},
+		// merging accounts
+		{name: "dry run of merge", method: "POST",
+			target: "/accounts/" + creditCard + "/merge?into=" + newCreditCard + "&dryRun=true",
+			status: 200, check: bodyContains(`"num_transfers":1`)},
+		{name: "merge into account of other class", method: "POST",
+			target: "/accounts/" + creditCard + "/merge?into=" + checking,
+			header: ifMatch("1"), status: 400},
+		{name: "merge into itself", method: "POST",
+			target: "/accounts/" + creditCard + "/merge?into=" + creditCard,
+			header: ifMatch("1"), status: 400},
+		{name: "merge into account of another member", method: "POST",
+			target: "/accounts/" + checking + "/merge?into=" + bobsSavings,
+			header: ifMatch("1"), status: 403},
+		{name: "merge stale account", method: "POST",
+			target: "/accounts/" + creditCard + "/merge?into=" + newCreditCard,
+			header: ifMatch("2"), status: 412},
+		{name: "merge", method: merge.method, target: merge.target, header: merge.header,
+			status: 200, check: bodyContains(`"transaction_ids":[` + cardPaymentIn + `]`)},
+		{name: "merged account", method: "GET", target: "/accounts/" + creditCard, status: 404,
+			before: []routeCase{merge}},
+		{name: "transfer moved by merge", method: "GET", target: "/transactions/" + cardPaymentIn,
+			status: 200, before: []routeCase{merge},
+			check: bodyContains(`"account_id":` + newCreditCard + `,`)},
+		{name: "merge missing account", method: "POST", target: "/accounts/99/merge?into=" + newCreditCard,
+			header: ifMatch("1"), status: 404},
+		// health
+		{name: "health", method: "GET", target: "/healthz", status: 200},
+		{name: "readiness", method: "GET", target: "/readyz", status: 200},
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	var (
+		ids                  testIds
+		alice, aliceReadOnly string
+	)
+	db := newTestDb(t, func(db *pgxpool.Pool) {
+		ids, alice, aliceReadOnly = seedTestDb(t, db)
+	})
+	// the event stream ends after replaying the stored events
+	defer func(d time.Duration) { EVENT_STREAM_DURATION = d }(EVENT_STREAM_DURATION)
+	EVENT_STREAM_DURATION = 200 * time.Millisecond
+	runDbRouteCases(t, db, alice, dbRouteCases(ids, aliceReadOnly))
+}
@@ -0,0 +1,510 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The tests that need a database create temporary ones on the PostgreSQL
+// server at this URL, e.g. postgres://postgres@localhost:5432/postgres. If it
+// is not set, they start a server of their own, which needs the initdb and
+// pg_ctl programs of PostgreSQL.
+const testDbUrlEnv = "BKPSRV_TEST_DB_URL"
+
+// a server that fails every request reaching the database, for the error
+// paths that are taken before any query
+func newServerWithoutDb(disableAuth bool) *Server {
+	config := DefaultConfig()
+	config.DisableAuth = disableAuth
+	return NewServer(nil, config, zap.NewNop().Sugar())
+}
+
+// testDbUrl returns the URL of a PostgreSQL server for the test. Without
+// one, the test is skipped, except in CI, where it fails so that the cases
+// against the database cannot silently stop running.
+func testDbUrl(t *testing.T) string {
+	t.Helper()
+	if dbUrl := os.Getenv(testDbUrlEnv); dbUrl != "" {
+		return dbUrl
+	}
+	dbUrl, err := startTestPostgres(t)
+	if err != nil {
+		msg := fmt.Sprintf("%s is not set, and no PostgreSQL server could be started: %v",
+			testDbUrlEnv, err)
+		if os.Getenv("CI") != "" {
+			t.Fatal(msg)
+		}
+		t.Skip(msg)
+	}
+	return dbUrl
+}
+
+// postgresBin finds a program of PostgreSQL on the PATH, or where Debian and
+// Ubuntu install it
+func postgresBin(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	matches, _ := filepath.Glob(filepath.Join("/usr/lib/postgresql/*/bin", name))
+	if len(matches) == 0 {
+		return "", fmt.Errorf("%s is not installed", name)
+	}
+	sort.Strings(matches)
+	return matches[len(matches)-1], nil
+}
+
+// startTestPostgres runs a PostgreSQL server in a temporary directory until
+// the test ends. It listens on a Unix socket in that directory only.
+func startTestPostgres(t *testing.T) (string, error) {
+	initdb, err := postgresBin("initdb")
+	if err != nil {
+		return "", err
+	}
+	pgCtl, err := postgresBin("pg_ctl")
+	if err != nil {
+		return "", err
+	}
+	if os.Geteuid() == 0 {
+		return "", fmt.Errorf("PostgreSQL refuses to run as root")
+	}
+	// not t.TempDir, whose path may be too long for a socket
+	dir, err := os.MkdirTemp("", "bkpsrv")
+	if err != nil {
+		return "", err
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	data := filepath.Join(dir, "data")
+	out, err := exec.Command(
+		initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8",
+		"--no-locale", "--no-sync",
+	).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("initdb failed: %v: %s", err, out)
+	}
+	out, err = exec.Command(
+		pgCtl, "-D", data, "-l", filepath.Join(dir, "log"), "-w",
+		"-o", fmt.Sprintf("-k %s -h '' -F", dir), "start",
+	).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("pg_ctl failed to start PostgreSQL: %v: %s", err, out)
+	}
+	// registered after removing the directory, so it runs before it
+	t.Cleanup(func() { exec.Command(pgCtl, "-D", data, "-m", "immediate", "stop").Run() })
+	return "postgres://postgres@/postgres?host=" + url.QueryEscape(dir), nil
+}
+
+// A database with the tables of bkpsrv and the rows of a seed step, which is
+// copied for every case so that cases cannot see each other's changes
+type testDb struct {
+	admin    *pgx.Conn
+	config   *pgxpool.Config
+	template string
+	copies   int
+}
+
+// newTestDb creates the database, fills it in with seed, and drops it and
+// its copies when the test ends
+func newTestDb(t *testing.T, seed func(db *pgxpool.Pool)) *testDb {
+	t.Helper()
+	dbUrl := testDbUrl(t)
+	ctx := context.Background()
+	admin, err := pgx.Connect(ctx, dbUrl)
+	if err != nil {
+		t.Fatalf("failed to connect to %s: %v", dbUrl, err)
+	}
+	t.Cleanup(func() { admin.Close(ctx) })
+	config, err := pgxpool.ParseConfig(dbUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDb{
+		admin:    admin,
+		config:   config,
+		template: fmt.Sprintf("bkpsrv_test_%d_%d", time.Now().Unix(), rand.Intn(1000000)),
+	}
+	db := d.create(t, d.template, "")
+	defer db.Close()
+	if _, err = bookkeeper.InitDb(ctx, db, "", false); err != nil {
+		t.Fatalf("failed to initialize database %s: %v", d.template, err)
+	}
+	seed(db)
+	return d
+}
+
+// copy creates a copy of the database for a subtest, and drops it when the
+// subtest ends. The database cannot be copied while anyone is connected to
+// it, so its own pool must be closed by then.
+func (d *testDb) copy(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	d.copies++
+	name := fmt.Sprintf("%s_%d", d.template, d.copies)
+	db := d.create(t, name, d.template)
+	t.Cleanup(db.Close)
+	return db
+}
+
+// create creates a database, as a copy of template unless it is empty,
+// connects to it and drops it when the test ends
+func (d *testDb) create(t *testing.T, name string, template string) *pgxpool.Pool {
+	t.Helper()
+	ctx := context.Background()
+	sql := "create database " + name
+	if template != "" {
+		sql += " template " + template
+	}
+	if _, err := d.admin.Exec(ctx, sql); err != nil {
+		t.Fatalf("failed to create database %s: %v", name, err)
+	}
+	t.Cleanup(func() { d.admin.Exec(ctx, "drop database if exists "+name) })
+	config := d.config.Copy()
+	config.ConnConfig.Database = name
+	db, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("failed to connect to database %s: %v", name, err)
+	}
+	return db
+}
+
+// addTestUser creates a member, whose password is the name followed by
+// "-password"
+func addTestUser(t *testing.T, db *pgxpool.Pool, name string) bookkeeper.User {
+	t.Helper()
+	user := bookkeeper.User{Name: name}
+	if err := user.SetPassword(name + "-password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bookkeeper.InsertUser(context.Background(), db, &user); err != nil {
+		t.Fatalf("failed to insert user %s: %v", name, err)
+	}
+	return user
+}
+
+// addTestToken creates a token of the user, and returns it with its secret
+func addTestToken(
+	t *testing.T, db *pgxpool.Pool, user bookkeeper.User, name string, scope string,
+) (bookkeeper.ApiToken, string) {
+	t.Helper()
+	token := bookkeeper.ApiToken{UserId: user.Id, Name: name, Scope: scope}
+	secret, err := token.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bookkeeper.InsertApiToken(context.Background(), db, &token); err != nil {
+		t.Fatalf("failed to insert token %s of %s: %v", name, user.Name, err)
+	}
+	return token, secret
+}
+
+// A request to the server and the response it expects
+type routeCase struct {
+	name   string
+	method string
+	target string
+	body   string
+	// replace the default headers of the request; an empty Authorization
+	// sends no token
+	header map[string]string
+	status int
+	// further checks on the response, if any
+	check func(t *testing.T, w *httptest.ResponseRecorder)
+	// requests to send first, e.g. to replay a request
+	before []routeCase
+}
+
+func (c routeCase) request(token string) *http.Request {
+	var req *http.Request
+	if c.body == "" {
+		req = httptest.NewRequest(c.method, c.target, nil)
+	} else {
+		req = httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for k, v := range c.header {
+		if v == "" {
+			req.Header.Del(k)
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+	return req
+}
+
+// runRouteCases sends the requests in order, authenticated by token unless
+// it is empty
+func runRouteCases(t *testing.T, handler http.Handler, token string, cases []routeCase) {
+	t.Helper()
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			serveRouteCase(t, handler, token, c)
+		})
+	}
+}
+
+// runDbRouteCases sends every request to a server on a copy of db of its own
+func runDbRouteCases(t *testing.T, db *testDb, token string, cases []routeCase) {
+	t.Helper()
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			s := NewServer(db.copy(t), DefaultConfig(), zap.NewNop().Sugar())
+			serveRouteCase(t, s, token, c)
+		})
+	}
+}
+
+// serveRouteCase sends the requests the case needs first, then the request of
+// the case, and checks the responses
+func serveRouteCase(t *testing.T, handler http.Handler, token string, c routeCase) {
+	t.Helper()
+	for _, before := range c.before {
+		serveRouteCase(t, handler, token, before)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, c.request(token))
+	if w.Code != c.status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s",
+			c.method, c.target, c.status, w.Code, w.Body.String())
+	}
+	if c.check != nil {
+		c.check(t, w)
+	}
+}
+
+// checks that a response header has the given value
+func hasHeader(key string, value string) func(*testing.T, *httptest.ResponseRecorder) {
+	return func(t *testing.T, w *httptest.ResponseRecorder) {
+		if got := w.Header().Get(key); got != value {
+			t.Errorf("expected header %s to be %q, got %q", key, value, got)
+		}
+	}
+}
+
+// checks that the response body contains the given string
+func bodyContains(s string) func(*testing.T, *httptest.ResponseRecorder) {
+	return func(t *testing.T, w *httptest.ResponseRecorder) {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("expected the body to contain %q, got %s", s, w.Body.String())
+		}
+	}
+}
+
+// the error paths that are taken before the database is used, with auth
+// disabled
+var noDbRouteCases = []routeCase{
+	{name: "health without database", method: "GET", target: "/healthz", status: 503},
+	{name: "readiness without database", method: "GET", target: "/readyz", status: 503},
+	{name: "home page", method: "GET", target: "/", status: 200},
+	{name: "openapi", method: "GET", target: "/openapi.json", status: 200,
+		check: bodyContains(`"openapi"`)},
+	{name: "metrics", method: "GET", target: "/metrics", status: 200},
+	{name: "dashboard redirect", method: "GET", target: "/dashboard", status: 301,
+		check: hasHeader("Location", "/dashboard/")},
+	{name: "dashboard index", method: "GET", target: "/dashboard/", status: 200,
+		check: hasHeader("Cache-Control", "no-cache")},
+	{name: "dashboard missing file", method: "GET", target: "/dashboard/nope.js", status: 404},
+	{name: "unknown route", method: "GET", target: "/nope", status: 404},
+	{name: "method not allowed", method: "PUT", target: "/accounts", status: 405},
+	{name: "current user without auth", method: "GET", target: "/auth/me", status: 404},
+	{name: "tokens without auth", method: "GET", target: "/auth/tokens", status: 400},
+	{name: "new token without auth", method: "POST", target: "/auth/tokens",
+		body: `{"name": "cli", "scope": "read"}`, status: 400},
+	{name: "revoke token without auth", method: "DELETE", target: "/auth/tokens/1", status: 400},
+	{name: "login without fields", method: "POST", target: "/auth/login",
+		body: `{"name": "alice"}`, status: 400},
+	{name: "account id not a number", method: "GET", target: "/accounts/abc", status: 400},
+	{name: "account without tags", method: "POST", target: "/accounts",
+		body: `{"name": "Checking"}`, status: 400},
+	{name: "account of no class", method: "POST", target: "/accounts",
+		body: `{"name": "Checking", "tags": ["cash"]}`, status: 400},
+	{name: "account of both classes", method: "POST", target: "/accounts",
+		body: `{"name": "Checking", "tags": ["asset", "liability"]}`, status: 400},
+	{name: "patch account without If-Match", method: "PATCH", target: "/accounts/1",
+		body: `{"desc_": "main"}`, status: 428},
+	{name: "patch account with bad If-Match", method: "PATCH", target: "/accounts/1",
+		body: `{"desc_": "main"}`, header: map[string]string{"If-Match": "v1"}, status: 400},
+	{name: "delete account without If-Match", method: "DELETE", target: "/accounts/1", status: 428},
+	{name: "merge into no account", method: "POST", target: "/accounts/2/merge?into=x", status: 400},
+	{name: "merge with invalid dry run", method: "POST", target: "/accounts/2/merge?into=5&dryRun=maybe",
+		status: 400},
+	{name: "merge without If-Match", method: "POST", target: "/accounts/2/merge?into=5", status: 428},
+	{name: "transaction id not a number", method: "GET", target: "/transactions/abc", status: 400},
+	{name: "transaction not JSON", method: "POST", target: "/transactions",
+		body: `{"type": `, status: 400},
+	{name: "transaction of unknown type", method: "POST", target: "/transactions",
+		body:   `{"type": "Spend", "date": "2021-06-01T00:00:00Z", "account_id": 1, "amount": -100}`,
+		status: 400},
+	{name: "expense without category", method: "POST", target: "/transactions",
+		body:   `{"type": "Out", "date": "2021-06-01T00:00:00Z", "account_id": 1, "amount": -100}`,
+		status: 400},
+	{name: "transfer without association id", method: "POST", target: "/transactions",
+		body:   `{"type": "TransferOut", "date": "2021-06-01T00:00:00Z", "account_id": 1, "amount": -100}`,
+		status: 400},
+	{name: "positive expense", method: "POST", target: "/transactions",
+		body:   `{"type": "Out", "date": "2021-06-01T00:00:00Z", "category": "Food & Dining", "sub_category": "Groceries", "account_id": 1, "amount": 100}`,
+		status: 400, check: bodyContains(`"amount"`)},
+	{name: "negative income", method: "POST", target: "/transactions",
+		body:   `{"type": "In", "date": "2021-06-01T00:00:00Z", "category": "Professional Income", "sub_category": "Salary", "account_id": 1, "amount": -100}`,
+		status: 400, check: bodyContains(`"amount"`)},
+	{name: "invalid normalizeAmounts", method: "POST", target: "/transactions?normalizeAmounts=maybe",
+		body:   `{"type": "Out", "date": "2021-06-01T00:00:00Z", "category": "Food & Dining", "sub_category": "Groceries", "account_id": 1, "amount": 100}`,
+		status: 400},
+	{name: "long idempotency key", method: "POST", target: "/transactions",
+		body:   `{"type": "Out", "date": "2021-06-01T00:00:00Z", "account_id": 1, "amount": -100}`,
+		header: map[string]string{"Idempotency-Key": strings.Repeat("k", 1000)}, status: 400},
+	{name: "empty batch", method: "POST", target: "/transactions:batch",
+		body: `{"transactions": []}`, status: 400},
+	{name: "batch with an invalid item", method: "POST", target: "/transactions:batch",
+		body:   `{"transactions": [{"type": "Out", "date": "2021-06-01T00:00:00Z", "account_id": 1, "amount": -100}]}`,
+		status: 400, check: bodyContains(`"failed"`)},
+	{name: "batch with a positive transfer out", method: "POST", target: "/transactions:batch",
+		body:   `{"transactions": [{"type": "TransferOut", "date": "2021-06-01T00:00:00Z", "account_id": 1, "amount": 100, "association_id": "a"}]}`,
+		status: 400, check: bodyContains(`"failed"`)},
+	{name: "negative limit", method: "GET", target: "/transactions?limit=-1", status: 400},
+	{name: "unknown format", method: "GET", target: "/transactions?format=pdf", status: 400},
+	{name: "invalid query string", method: "GET", target: "/transactions?queryString=amount%20%3C", status: 400},
+	{name: "invalid dates", method: "GET", target: "/transactions?startDate=yesterday&endDate=today", status: 400},
+	{name: "bulk update with limit", method: "PATCH",
+		target: "/transactions?queryString=amount%20%3C%200%20LIMIT%201",
+		body:   `{"notes": "x"}`, status: 400},
+	{name: "bulk update with order", method: "PATCH",
+		target: "/transactions?queryString=amount%20%3C%200%20ORDER%20BY%20date",
+		body:   `{"notes": "x"}`, status: 400},
+	{name: "patch transaction without If-Match", method: "PATCH", target: "/transactions/1",
+		body: `{"notes": "x"}`, status: 428},
+	{name: "delete transaction without If-Match", method: "DELETE", target: "/transactions/1", status: 428},
+	{name: "blank search", method: "GET", target: "/search?terms=%20", status: 400},
+	{name: "balance on invalid date", method: "GET",
+		target: "/reporting/account_balance?accountName=Checking&date=2021-13-01", status: 400},
+	{name: "balances on invalid date", method: "GET",
+		target: "/reporting/account_balance?date=someday", status: 400},
+	{name: "balance sheet on invalid date", method: "GET",
+		target: "/reporting/balance_sheet?date=someday&assetTags=cash&liabilityTags=loan", status: 400},
+	{name: "income statement of invalid range", method: "GET",
+		target: "/reporting/income_statement?dateRange=someday&revenueTags=a&taxesTags=b&expensesTags=c&investmentsTags=d",
+		status: 400},
+	{name: "aggregate by unknown key", method: "GET",
+		target: "/reporting/aggregate?queryString=amount%20%3C%200&groupBy=color", status: 400},
+	{name: "aggregate with limit", method: "GET",
+		target: "/reporting/aggregate?queryString=amount%20%3C%200%20LIMIT%2010", status: 400},
+	{name: "unknown aggregate", method: "GET",
+		target: "/reporting/aggregate?queryString=amount%20%3C%200&aggregates=median", status: 400},
+	{name: "events of unknown type", method: "GET", target: "/events?types=account.burned", status: 400},
+	{name: "events after invalid id", method: "GET", target: "/events?lastEventId=-1", status: 400},
+	{name: "graphql without query", method: "POST", target: "/graphql",
+		body: `{"query": ""}`, status: 400},
+	{name: "graphql get without query", method: "GET", target: "/graphql", status: 400},
+	{name: "webhook without url", method: "POST", target: "/webhooks",
+		body: `{"event_types": []}`, status: 400},
+	{name: "webhook id not a number", method: "DELETE", target: "/webhooks/abc", status: 400},
+	{name: "deliveries of webhook id not a number", method: "GET",
+		target: "/webhooks/abc/deliveries", status: 400},
+	{name: "saved query without query string", method: "PUT", target: "/saved_queries/big",
+		body: `{"desc_": "big"}`, status: 400},
+	{name: "report preset of unknown report", method: "PUT", target: "/report_presets/q4",
+		body: `{"report": "cash"}`, status: 400},
+}
+
+func TestRoutesWithoutDatabase(t *testing.T) {
+	runRouteCases(t, newServerWithoutDb(true), "", noDbRouteCases)
+}
+
+// with auth enabled, only public paths are open without a token
+func TestAuthRequiresToken(t *testing.T) {
+	runRouteCases(t, newServerWithoutDb(false), "", []routeCase{
+		{name: "public home page", method: "GET", target: "/", status: 200},
+		{name: "public dashboard", method: "GET", target: "/dashboard/app.js", status: 200},
+		{name: "missing token", method: "GET", target: "/accounts", status: 401,
+			check: hasHeader("WWW-Authenticate", `Bearer realm="bkpsrv"`)},
+		{name: "missing token on write", method: "POST", target: "/transactions",
+			body: `{}`, status: 401},
+	})
+}
+
+func TestCors(t *testing.T) {
+	config := DefaultConfig()
+	config.DisableAuth = true
+	config.CorsOrigins = []string{"https://example.com/"}
+	s := NewServer(nil, config, zap.NewNop().Sugar())
+	runRouteCases(t, s, "", []routeCase{
+		{name: "preflight of allowed origin", method: "OPTIONS", target: "/transactions",
+			header: map[string]string{
+				"Origin": "https://example.com", "Access-Control-Request-Method": "POST",
+			},
+			status: 204, check: hasHeader("Access-Control-Allow-Origin", "https://example.com")},
+		{name: "preflight of other origin", method: "OPTIONS", target: "/transactions",
+			header: map[string]string{
+				"Origin": "https://evil.example", "Access-Control-Request-Method": "POST",
+			},
+			status: 405, check: hasHeader("Access-Control-Allow-Origin", "")},
+		{name: "request of allowed origin", method: "GET", target: "/",
+			header: map[string]string{"Origin": "https://example.com"},
+			status: 200, check: hasHeader("Access-Control-Expose-Headers", corsExposeHeaders)},
+	})
+}
+
+// every route must be requested by at least one case, so that adding a route
+// without tests fails. A route that takes several methods needs a case of
+// any of them.
+func TestRouteCasesCoverAllRoutes(t *testing.T) {
+	router := newServerWithoutDb(false).newRouter()
+	requested := make(map[*mux.Route]bool)
+	for _, cases := range [][]routeCase{noDbRouteCases, dbRouteCases(nil, "")} {
+		for _, c := range cases {
+			var match mux.RouteMatch
+			if !router.Match(c.request(""), &match) || match.Route == nil {
+				continue
+			}
+			requested[match.Route] = true
+		}
+	}
+	err := router.Walk(func(
+		route *mux.Route, router *mux.Router, ancestors []*mux.Route,
+	) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		if !requested[route] {
+			methods, _ := route.GetMethods()
+			queries, _ := route.GetQueriesTemplates()
+			t.Errorf("route %v %s %v is not tested", methods, tpl, queries)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// an invalid hash fails at once, which would tell unknown users apart again
+func TestUnknownUserHashCostsAsMuchAsPasswords(t *testing.T) {
+	cost, err := bcrypt.Cost([]byte(unknownUser.PasswordHash))
+	if err != nil {
+		t.Fatalf("invalid hash of the unknown user: %v", err)
+	}
+	if cost != bcrypt.DefaultCost {
+		t.Errorf("hash of the unknown user costs %d; want %d", cost, bcrypt.DefaultCost)
+	}
+}
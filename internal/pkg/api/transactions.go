@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,9 +11,9 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4"
 	"github.com/lirenzhucn/bookkeeper/internal/pkg/api/_peg"
 	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
-	"go.uber.org/zap"
 )
 
 func prepQueryData(queryData *_peg.QueryData) {
@@ -22,8 +23,21 @@ func prepQueryData(queryData *_peg.QueryData) {
 	}
 }
 
-func queryTransactions(w http.ResponseWriter, r *http.Request) {
-	sugar := zap.L().Sugar()
+// capQueryTotal returns the number of the matches of a query that its LIMIT
+// and OFFSET select, out of total; a limit of 0 means no limit
+func capQueryTotal(total int, limit int, offset int) int {
+	total -= offset
+	if total < 0 {
+		total = 0
+	}
+	if limit > 0 && total > limit {
+		total = limit
+	}
+	return total
+}
+
+func (s *Server) queryTransactions(w http.ResponseWriter, r *http.Request) {
+	sugar := requestLogger(r)
 	defer sugar.Sync()
 
 	queryString := strings.Trim(r.FormValue("queryString"), "'")
@@ -33,8 +47,34 @@ func queryTransactions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	prepQueryData(&queryData)
-	transactions, err := bookkeeper.GetTransactionsWithFilters(
-		dbpool, queryData.Clause, queryData.Values, MAX_NUM_RECORDS)
+	// the LIMIT and OFFSET of the query select the results, and the limit and
+	// offset in the URL page through them
+	limit, offset, ok := s.parsePaginationInQueryAndFail(w, r, queryData.Limit, 0)
+	if !ok {
+		return
+	}
+	format, ok := parseFormatInQueryAndFail(w, r)
+	if !ok {
+		return
+	}
+	var (
+		total        int
+		transactions []bookkeeper.Transaction_
+	)
+	total, err = bookkeeper.CountTransactionsWithFilters(
+		r.Context(), s.db, queryData.Clause, queryData.Values)
+	if err == nil {
+		total = capQueryTotal(total, queryData.Limit, queryData.Offset)
+		// the last page stops at the LIMIT of the query
+		if pageLimit := total - offset; pageLimit > 0 {
+			if pageLimit > limit {
+				pageLimit = limit
+			}
+			transactions, err = bookkeeper.GetTransactionsWithFilters(
+				r.Context(), s.db, queryData.Clause, queryData.Values, queryData.OrderBy,
+				pageLimit, queryData.Offset+offset)
+		}
+	}
 	if err != nil {
 		sugar.Errorw(
 			"failed to query transactions with filters",
@@ -42,16 +82,16 @@ func queryTransactions(w http.ResponseWriter, r *http.Request) {
 			"queryData.Clause", queryData.Clause,
 			"queryData.Values", queryData.Values,
 		)
-		http.Error(w, "Internal Server Error", 500)
+		writeError(w, "Internal Server Error", 500)
 		return
 	}
 	// write the response
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(transactions)
+	writePaginationHeaders(w, r, total, limit, offset)
+	writeTransactions(w, format, transactions)
 }
 
-func returnTransactionsBetweenDates(w http.ResponseWriter, r *http.Request) {
-	sugar := zap.L().Sugar()
+func (s *Server) returnTransactionsBetweenDates(w http.ResponseWriter, r *http.Request) {
+	sugar := requestLogger(r)
 	defer sugar.Sync()
 
 	startDateStr := r.FormValue("startDate")
@@ -59,71 +99,107 @@ func returnTransactionsBetweenDates(w http.ResponseWriter, r *http.Request) {
 	// parse date string
 	start, err := time.Parse("2006/01/02", startDateStr)
 	if err != nil {
-		http.Error(w, "Invalid query term startDate", 400)
+		writeError(w, "Invalid query term startDate", 400)
 		return
 	}
 	end, err := time.Parse("2006/01/02", endDateStr)
 	if err != nil {
-		http.Error(w, "Invalid query term endDate", 400)
+		writeError(w, "Invalid query term endDate", 400)
 		return
 	}
 	// move end time from the beginning of the day to the end of the day
-	offset, _ := time.ParseDuration("23h59m59s")
-	end = end.Add(offset)
+	endOfDay, _ := time.ParseDuration("23h59m59s")
+	end = end.Add(endOfDay)
+	limit, offset, ok := s.parsePaginationInQueryAndFail(w, r, s.config.MaxNumRecords, 0)
+	if !ok {
+		return
+	}
+	format, ok := parseFormatInQueryAndFail(w, r)
+	if !ok {
+		return
+	}
 	// query the database
+	total, err := bookkeeper.CountTransactionsBetweenDates(r.Context(), s.db, start, end)
+	if err != nil {
+		sugar.Errorw("failed to count transactions between two dates", "error", err)
+		writeError(w, "Internal Server Error", 500)
+		return
+	}
 	transactions, err := bookkeeper.GetTransactionsBetweenDates(
-		dbpool, start, end, MAX_NUM_RECORDS)
+		r.Context(), s.db, start, end, limit, offset)
 	if err != nil {
 		sugar.Errorw("failed to query transactions between two dates", "error", err)
-		http.Error(w, "Internal Server Error", 500)
+		writeError(w, "Internal Server Error", 500)
 		return
 	}
 	// write the response
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(transactions)
+	writePaginationHeaders(w, r, total, limit, offset)
+	writeTransactions(w, format, transactions)
 }
 
-func returnAllTransactions(w http.ResponseWriter, r *http.Request) {
-	transactions, err := bookkeeper.GetAllTransactions(dbpool, MAX_NUM_RECORDS, 0)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+func (s *Server) returnAllTransactions(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := s.parsePaginationInQueryAndFail(w, r, s.config.MaxNumRecords, 0)
+	if !ok {
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(transactions)
+	format, ok := parseFormatInQueryAndFail(w, r)
+	if !ok {
+		return
+	}
+	total, err := bookkeeper.CountAllTransactions(r.Context(), s.db)
+	if !checkErr(err, w, 500, "Failed to count transactions") {
+		return
+	}
+	transactions, err := bookkeeper.GetAllTransactions(r.Context(), s.db, limit, offset)
+	if !checkErr(err, w, 500, "Failed to get transactions") {
+		return
+	}
+	writePaginationHeaders(w, r, total, limit, offset)
+	writeTransactions(w, format, transactions)
 }
 
-func returnSingleTransaction(w http.ResponseWriter, r *http.Request) {
-	sugar := zap.L().Sugar()
-	defer sugar.Sync()
-
+func (s *Server) returnSingleTransaction(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["id"]
 	id, err := strconv.Atoi(key)
 	if err != nil {
-		http.Error(w, "Invalid id in query", 400)
+		writeError(w, "Invalid id in query", 400)
 		return
 	}
-	transaction, err := bookkeeper.GetSingleTransaction(dbpool, id)
-	if err != nil {
-		if strings.Contains(err.Error(), "no rows in result set") {
-			http.Error(w, "Transaction not found", 404)
-		} else {
-			http.Error(w, "Internal server error", 500)
-			sugar.Errorw("failed to get transaction", "transaction_id", id, "error", err)
-		}
+	transaction, err := bookkeeper.GetSingleTransaction(r.Context(), s.db, id)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, "Transaction not found", 404)
 		return
 	}
+	if !checkErr(err, w, 500, "failed to get transaction", "transaction_id", id) {
+		return
+	}
+	w.Header().Set("ETag", etagFor(transaction.Version))
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(transaction)
 }
 
-func postTransaction(w http.ResponseWriter, r *http.Request) {
-	postOrPatchTransaction(w, r, -1)
+// parseNormalizeAmountsAndFail reads the normalizeAmounts query parameter.
+// When it is true, clients send absolute amounts and the server gives them
+// the signs that their types require.
+func parseNormalizeAmountsAndFail(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	normalize := false
+	if normalizeStr := r.FormValue("normalizeAmounts"); normalizeStr != "" {
+		var err error
+		normalize, err = strconv.ParseBool(normalizeStr)
+		if !checkErr(err, w, 400, "Invalid normalizeAmounts provided") {
+			return false, false
+		}
+	}
+	return normalize, true
+}
+
+func (s *Server) postTransaction(w http.ResponseWriter, r *http.Request) {
+	s.postOrPatchTransaction(w, r, -1, 0)
 }
 
-func patchTransaction(w http.ResponseWriter, r *http.Request) {
-	sugar := zap.L().Sugar()
+func (s *Server) patchTransaction(w http.ResponseWriter, r *http.Request) {
+	sugar := requestLogger(r)
 	defer sugar.Sync()
 
 	vars := mux.Vars(r)
@@ -131,48 +207,319 @@ func patchTransaction(w http.ResponseWriter, r *http.Request) {
 	if !checkErr(err, w, 400, "Invalid transaction id provided") {
 		return
 	}
-	sugar.Infow("received valid PATCH request", "id", id)
-	postOrPatchTransaction(w, r, id)
+	version, ok := parseIfMatchAndFail(w, r)
+	if !ok {
+		return
+	}
+	sugar.Infow("received valid PATCH request", "id", id, "version", version)
+	s.postOrPatchTransaction(w, r, id, version)
 }
 
-func postOrPatchTransaction(w http.ResponseWriter, r *http.Request, transId int) {
+func (s *Server) postOrPatchTransaction(
+	w http.ResponseWriter, r *http.Request, transId int, version int,
+) {
 	var trans bookkeeper.Transaction
 
+	normalize, ok := parseNormalizeAmountsAndFail(w, r)
+	if !ok {
+		return
+	}
 	body, err := ioutil.ReadAll(r.Body)
 	if !checkErr(err, w, 400, "Failed to read the request body") {
 		return
 	}
-	err = json.Unmarshal(body, &trans)
-	if !checkErr(err, w, 400, "Failed to parse the request body") {
+	var accountIds []int
+	if transId < 0 {
+		err = json.Unmarshal(body, &trans)
+		if !checkErr(err, w, 400, "Failed to parse the request body") {
+			return
+		}
+	} else {
+		// only the fields in the payload change
+		old, err := bookkeeper.GetSingleTransaction(r.Context(), s.db, transId)
+		if errors.Is(err, bookkeeper.ErrNotFound) {
+			writeError(w, "Cannot find transaction with the specified id", 404)
+			return
+		}
+		if !checkErr(err, w, 500, "Failed to get transaction", "transaction_id", transId) {
+			return
+		}
+		err = applyMergePatch(old.Transaction, body, &trans)
+		if !checkErr(err, w, 400, "Failed to apply the request body as a JSON merge patch") {
+			return
+		}
+		// overwrite the id and the version in the payload
+		trans.Id, trans.Version = transId, version
+		// moving a transaction needs permission on both accounts
+		accountIds = append(accountIds, old.AccountId)
+	}
+	if normalize {
+		trans.NormalizeAmount()
+	}
+	if !checkErr(trans.Validate(), w, 400, "Invalid transaction payload",
+		"transaction", trans) {
 		return
 	}
-	if !trans.Validate() {
-		checkErr(
-			fmt.Errorf("validation of transaction failed"), w, 400,
-			"Invalid account payload", "transaction", trans,
-		)
+	accountIds = append(accountIds, trans.AccountId)
+	if !s.checkCanPostAndFail(w, r, accountIds...) {
 		return
 	}
 
+	eventType := bookkeeper.EventTransactionCreated
+	if transId >= 0 {
+		eventType = bookkeeper.EventTransactionUpdated
+	}
+	err = s.changeWithEvents(r.Context(), func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		var err error
+		if transId < 0 {
+			err = bookkeeper.InsertTransaction(r.Context(), tx, &trans)
+		} else {
+			err = bookkeeper.UpdateTransaction(r.Context(), tx, &trans)
+		}
+		return []bookkeeper.Event{newEvent(eventType, trans.Id, trans)}, err
+	})
 	if transId < 0 {
-		err = bookkeeper.InsertTransaction(dbpool, &trans)
-	} else {
-		err = bookkeeper.UpdateTransaction(dbpool, &trans)
+		if err == nil {
+			observeJournalPosting("transactions", 1)
+		} else {
+			observeJournalPosting("transactions", 0)
+		}
+	}
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, "Cannot find transaction with the specified id", 404)
+		return
+	}
+	if errors.Is(err, bookkeeper.ErrVersionMismatch) {
+		writeError(w, "Transaction has been changed since it was fetched", 412)
+		return
 	}
 	if !checkErr(err, w, 500, "Failed to insert or update transaction") {
 		return
 	}
+	w.Header().Set("ETag", etagFor(trans.Version))
 	json.NewEncoder(w).Encode(trans)
 }
 
-func deleteTransaction(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteTransaction(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
-	if !checkErr(err, w, 400, "Invalid account id provided") {
+	if !checkErr(err, w, 400, "Invalid transaction id provided") {
+		return
+	}
+	version, ok := parseIfMatchAndFail(w, r)
+	if !ok {
+		return
+	}
+	trans, err := bookkeeper.GetSingleTransaction(r.Context(), s.db, id)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, "Transaction not found", 404)
+		return
+	}
+	if !checkErr(err, w, 500, "Failed to get transaction", "transaction_id", id) {
+		return
+	}
+	if !s.checkCanPostAndFail(w, r, trans.AccountId) {
+		return
+	}
+	err = s.changeWithEvents(r.Context(), func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		err := bookkeeper.DeleteTransaction(r.Context(), tx, id, version)
+		return []bookkeeper.Event{
+			newEvent(bookkeeper.EventTransactionDeleted, id, trans.Transaction),
+		}, err
+	})
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, "Transaction not found", 404)
+		return
+	}
+	if errors.Is(err, bookkeeper.ErrVersionMismatch) {
+		writeError(w, "Transaction has been changed since it was fetched", 412)
+		return
+	}
+	if !checkErr(err, w, 500, "Failed to delete transaction", "transaction_id", id) {
+		return
+	}
+}
+
+func (s *Server) searchTransactions(w http.ResponseWriter, r *http.Request) {
+	terms := r.FormValue("terms")
+	if strings.TrimSpace(terms) == "" {
+		writeError(w, "Invalid query term terms", 400)
+		return
+	}
+	limit, offset, ok := s.parsePaginationInQueryAndFail(w, r, s.config.MaxNumRecords, 0)
+	if !ok {
+		return
+	}
+	var queryData _peg.QueryData
+	queryData.Clause, queryData.Values = bookkeeper.SearchCondition(terms)
+	prepQueryData(&queryData)
+	total, err := bookkeeper.CountTransactionsWithFilters(
+		r.Context(), s.db, queryData.Clause, queryData.Values)
+	if !checkErr(err, w, 500, "Failed to count search results", "terms", terms) {
+		return
+	}
+	results, err := bookkeeper.SearchTransactions(r.Context(), s.db, terms, limit, offset)
+	if !checkErr(err, w, 500, "Failed to search transactions", "terms", terms) {
+		return
+	}
+	writePaginationHeaders(w, r, total, limit, offset)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
+
+func writeBatchResponse(
+	w http.ResponseWriter, statusCode int, resp bookkeeper.BatchResponse,
+) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// postTransactionsBatch creates many transactions at once. Every item is
+// checked before any is inserted, and either all are created or none is.
+func (s *Server) postTransactionsBatch(w http.ResponseWriter, r *http.Request) {
+	sugar := requestLogger(r)
+	defer sugar.Sync()
+
+	var batch bookkeeper.BatchRequest
+	normalize, ok := parseNormalizeAmountsAndFail(w, r)
+	if !ok {
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if !checkErr(err, w, 400, "Failed to read the request body") {
+		return
+	}
+	err = json.Unmarshal(body, &batch)
+	if !checkErr(err, w, 400, "Failed to parse the request body") {
+		return
+	}
+	n := len(batch.Transactions)
+	if n == 0 || n > bookkeeper.MAX_BATCH_SIZE {
+		writeError(w, fmt.Sprintf("A batch must have 1 to %d transactions",
+			bookkeeper.MAX_BATCH_SIZE), 400)
+		return
+	}
+	resp := bookkeeper.BatchResponse{
+		Results: make([]bookkeeper.BatchItemResult, n),
+	}
+	for i := range resp.Results {
+		resp.Results[i] = bookkeeper.BatchItemResult{
+			Index: i, Status: bookkeeper.BatchItemSkipped,
+		}
+	}
+	// the first failure decides the status code and the top-level error
+	statusCode := 0
+	fail := func(i int, code int, details bookkeeper.ErrorDetails) {
+		resp.Results[i].Status = bookkeeper.BatchItemFailed
+		resp.Results[i].Error = &details
+		if statusCode == 0 {
+			statusCode = code
+			resp.Error = &bookkeeper.ErrorDetails{
+				Code:    details.Code,
+				Message: fmt.Sprintf("Transaction %d failed; nothing was created", i),
+			}
+		}
+	}
+	for i := range batch.Transactions {
+		trans := &batch.Transactions[i]
+		if normalize {
+			trans.NormalizeAmount()
+		}
+		if err := trans.Validate(); err != nil {
+			code, details := describeError(err, 400, "Invalid transaction")
+			fail(i, code, details)
+			continue
+		}
+		reason, err := s.postingDeniedReason(r.Context(), trans.AccountId)
+		if !checkErr(err, w, 500, "Failed to get account",
+			"account_id", trans.AccountId) {
+			return
+		}
+		if reason != "" {
+			fail(i, 403, bookkeeper.ErrorDetails{
+				Code: errorCode(403, nil), Message: reason,
+			})
+		}
+	}
+	if statusCode != 0 {
+		observeJournalPosting("transactions:batch", 0)
+		writeBatchResponse(w, statusCode, resp)
+		return
+	}
+	failedIndex := -1
+	err = s.changeWithEvents(r.Context(), func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		var err error
+		failedIndex, err = bookkeeper.InsertTransactionsBatch(r.Context(), tx, batch.Transactions)
+		var evs []bookkeeper.Event
+		for _, trans := range batch.Transactions {
+			evs = append(evs, newEvent(bookkeeper.EventTransactionCreated, trans.Id, trans))
+		}
+		return evs, err
+	})
+	if err != nil {
+		sugar.Errorw("Failed to insert batch", "index", failedIndex, "error", err)
+		code, details := describeError(err, 500, "Failed to insert transaction")
+		if failedIndex >= 0 {
+			fail(failedIndex, code, details)
+		} else {
+			resp.Error = &details
+		}
+		observeJournalPosting("transactions:batch", 0)
+		writeBatchResponse(w, code, resp)
+		return
+	}
+	observeJournalPosting("transactions:batch", n)
+	resp.Committed = true
+	for i := range batch.Transactions {
+		resp.Results[i].Status = bookkeeper.BatchItemCreated
+		resp.Results[i].Transaction = &batch.Transactions[i]
+	}
+	writeBatchResponse(w, 200, resp)
+}
+
+// patchTransactionsByQuery sets the fields in the body on every transaction
+// that matches the query string
+func (s *Server) patchTransactionsByQuery(w http.ResponseWriter, r *http.Request) {
+	queryString := strings.Trim(r.FormValue("queryString"), "'")
+	queryData, err := _peg.ParseString(queryString)
+	if !checkErr(err, w, 400, "Invalid query string", "queryString", queryString) {
+		return
+	}
+	if queryData.OrderBy != "" || queryData.Limit > 0 || queryData.Offset > 0 {
+		writeError(w, "ORDER BY, LIMIT and OFFSET are not supported in bulk updates", 400)
+		return
+	}
+	prepQueryData(&queryData)
+	var patch bookkeeper.TransactionPatch
+	body, err := ioutil.ReadAll(r.Body)
+	if !checkErr(err, w, 400, "Failed to read the request body") {
 		return
 	}
-	err = bookkeeper.DeleteTransaction(dbpool, id)
-	if !checkErr(err, w, 500, "Failed to update account", "accout_id", id) {
+	err = json.Unmarshal(body, &patch)
+	if !checkErr(err, w, 400, "Failed to parse the request body") {
+		return
+	}
+	if !checkErr(patch.Validate(), w, 400, "Invalid bulk update payload") {
+		return
+	}
+	// the member needs permission on the accounts of all matches, and on the
+	// account they are moved to, which is checked on the locked rows
+	var updated []bookkeeper.Transaction
+	err = s.changeWithEvents(r.Context(), func(tx pgx.Tx) ([]bookkeeper.Event, error) {
+		var err error
+		updated, err = bookkeeper.UpdateTransactionsWithFilters(
+			r.Context(), tx, queryData.Clause, queryData.Values, patch,
+			canPostCheck(r.Context()))
+		var evs []bookkeeper.Event
+		for _, trans := range updated {
+			evs = append(evs, newEvent(bookkeeper.EventTransactionUpdated, trans.Id, trans))
+		}
+		return evs, err
+	})
+	if !checkErr(err, w, 500, "Failed to update transactions",
+		"queryData.Clause", queryData.Clause) {
 		return
 	}
+	json.NewEncoder(w).Encode(bookkeeper.BulkUpdateResponse{Updated: len(updated)})
 }
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
@@ -8,26 +11,100 @@ import (
 	"strings"
 	"time"
 
-	"go.uber.org/zap"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
 )
 
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusNotFound:            "not_found",
+	http.StatusConflict:            "conflict",
+	http.StatusLocked:              "locked",
+	http.StatusPreconditionFailed:  "precondition_failed",
+	http.StatusInternalServerError: "internal_error",
+}
+
+func errorCode(statusCode int, fields []bookkeeper.FieldError) string {
+	if len(fields) > 0 {
+		return "validation_failed"
+	}
+	code, ok := errorCodes[statusCode]
+	if !ok {
+		code = strings.ToLower(strings.ReplaceAll(
+			http.StatusText(statusCode), " ", "_"))
+	}
+	return code
+}
+
+// a drop-in replacement of http.Error that writes the JSON envelope shared
+// by all error responses
+func writeError(w http.ResponseWriter, msg string, statusCode int,
+	fields ...bookkeeper.FieldError) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if ow := observedWriter(w); ow != nil && len(fields) > 0 {
+		ow.validationFailed = true
+	}
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(bookkeeper.ErrorResponse{
+		Error: bookkeeper.ErrorDetails{
+			Code: errorCode(statusCode, fields), Message: msg, Fields: fields,
+		},
+	})
+}
+
+// describeError maps err to the status code and the details of an error
+// response. The sentinel errors of the bookkeeper package take precedence
+// over statusCode, and the message of internal errors is not exposed.
+func describeError(
+	err error, statusCode int, msg string,
+) (int, bookkeeper.ErrorDetails) {
+	var fields []bookkeeper.FieldError
+	switch {
+	case errors.Is(err, bookkeeper.ErrValidation):
+		statusCode = http.StatusBadRequest
+		var verr *bookkeeper.ValidationError
+		if errors.As(err, &verr) {
+			fields = verr.Fields
+		}
+	case errors.Is(err, bookkeeper.ErrForbidden):
+		statusCode = http.StatusForbidden
+		var perr *bookkeeper.PermissionError
+		if errors.As(err, &perr) {
+			msg = perr.Reason
+		}
+	case errors.Is(err, bookkeeper.ErrNotFound):
+		statusCode = http.StatusNotFound
+	case errors.Is(err, bookkeeper.ErrConflict):
+		statusCode = http.StatusConflict
+	case errors.Is(err, bookkeeper.ErrLocked):
+		statusCode = http.StatusLocked
+	case errors.Is(err, bookkeeper.ErrVersionMismatch):
+		statusCode = http.StatusPreconditionFailed
+	}
+	if statusCode == 500 {
+		msg = "Internal Server Error"
+	}
+	return statusCode, bookkeeper.ErrorDetails{
+		Code: errorCode(statusCode, fields), Message: msg, Fields: fields,
+	}
+}
+
+// checkErr writes an error response and returns false if err is not nil. The
+// sentinel errors of the bookkeeper package take precedence over statusCode.
 func checkErr(err error, w http.ResponseWriter, statusCode int,
 	msg string, a ...interface{}) bool {
-	sugar := zap.L().Sugar()
+	sugar := loggerOf(w)
 	defer sugar.Sync()
 
-	if err != nil {
-		a = append(a, "error")
-		a = append(a, err)
-		sugar.Errorw(msg, a...)
-		var outMsg = msg
-		if statusCode == 500 {
-			outMsg = "Internal Server Error"
-		}
-		http.Error(w, outMsg, statusCode)
-		return false
+	if err == nil {
+		return true
 	}
-	return true
+	a = append(a, "error")
+	a = append(a, err)
+	sugar.Errorw(msg, a...)
+	statusCode, details := describeError(err, statusCode, msg)
+	writeError(w, details.Message, statusCode, details.Fields...)
+	return false
 }
 
 type dateRange struct {
@@ -85,49 +162,63 @@ func parseMultipleDateRangesInQueryAndFail(
 	ok = false
 	dateRangeStr := r.FormValue(queryTerm)
 	if dateRangeStr == "" {
-		http.Error(w, "Invalid query string", 400)
+		writeError(w, "Invalid query string", 400)
 		return
 	}
-	offset, _ := time.ParseDuration("23h59m59s")
 	for _, s := range strings.Split(dateRangeStr, ",") {
-		// check for shorhands first
-		dr, drOk := parseDateRangeShorthand(s)
-		if drOk {
-			dateRanges = append(dateRanges, dr)
-			continue
-		}
-		// otherwise
-		p := strings.Split(s, "-")
-		if len(p) != 2 {
-			http.Error(w, "Invalid date range", 400)
-			return
-		}
-		startDate, err := time.Parse("2006/01/02", p[0])
+		dr, err := parseDateRange(s)
 		if err != nil {
-			http.Error(w, "Invalid date", 400)
+			writeError(w, err.Error(), 400)
 			return
 		}
-		endDate, err := time.Parse("2006/01/02", p[1])
-		if err != nil {
-			http.Error(w, "Invalid date", 400)
-			return
-		}
-		// shift endDate to the end of that day
-		endDate = endDate.Add(offset)
-		dateRanges = append(dateRanges,
-			dateRange{startDate: startDate, endDate: endDate})
+		dateRanges = append(dateRanges, dr)
 	}
 	ok = true
 	return
 }
 
+// parse a date range like 2021/01/01-2021/06/30, which includes the whole of
+// its last day, or a shorthand like 2021, 2021H1 or 2021Q1
+func parseDateRange(s string) (dateRange, error) {
+	// check for shorhands first
+	if dr, ok := parseDateRangeShorthand(s); ok {
+		return dr, nil
+	}
+	// otherwise
+	p := strings.Split(s, "-")
+	if len(p) != 2 {
+		return dateRange{}, errors.New("Invalid date range")
+	}
+	startDate, err := time.Parse("2006/01/02", p[0])
+	if err != nil {
+		return dateRange{}, errors.New("Invalid date")
+	}
+	// shift endDate to the end of that day
+	endDate, err := parseEndOfDay(p[1])
+	if err != nil {
+		return dateRange{}, errors.New("Invalid date")
+	}
+	return dateRange{startDate: startDate, endDate: endDate}, nil
+}
+
+// parse a date like 2021/12/31 as the last second of that day, so that a
+// balance on the date includes the transactions of the day
+func parseEndOfDay(s string) (time.Time, error) {
+	date, err := time.Parse("2006/01/02", s)
+	if err != nil {
+		return date, err
+	}
+	offset, _ := time.ParseDuration("23h59m59s")
+	return date.Add(offset), nil
+}
+
 func parseMultipleDateTimesInQueryAndFail(
 	w http.ResponseWriter, r *http.Request, queryTerm string,
 ) (dates []time.Time, ok bool) {
 	ok = true
 	dateStr := r.FormValue(queryTerm)
 	if dateStr == "" {
-		http.Error(w, "Invalid query string", 400)
+		writeError(w, "Invalid query string", 400)
 		ok = false
 		return
 	}
@@ -135,7 +226,7 @@ func parseMultipleDateTimesInQueryAndFail(
 	for _, s := range strings.Split(dateStr, ",") {
 		d, err := time.Parse("2006/01/02", s)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid query term %s", queryTerm), 400)
+			writeError(w, fmt.Sprintf("Invalid query term %s", queryTerm), 400)
 			ok = false
 			return
 		}
@@ -152,13 +243,13 @@ func parseDateTimeInQueryAndFail(
 	ok = true
 	dateStr := r.FormValue(queryTerm)
 	if dateStr == "" {
-		http.Error(w, "Invalid query string", 400)
+		writeError(w, "Invalid query string", 400)
 		ok = false
 		return
 	}
 	date, err := time.Parse("2006/01/02", dateStr)
 	if err != nil {
-		http.Error(w, "Invalid query term date", 400)
+		writeError(w, "Invalid query term date", 400)
 		ok = false
 		return
 	}
@@ -173,10 +264,163 @@ func parseTagsInQueryAndFail(
 	ok = true
 	tagsStr := r.FormValue(queryTerm)
 	if tagsStr == "" {
-		http.Error(w, "Invalid query string", 400)
+		writeError(w, "Invalid query string", 400)
 		ok = false
 		return
 	}
 	tags = strings.Split(tagsStr, ",")
 	return
 }
+
+// parse the optional owner term, which must name a household member; an empty
+// owner stands for the whole household
+func (s *Server) parseOwnerInQueryAndFail(
+	w http.ResponseWriter, r *http.Request,
+) (owner string, ok bool) {
+	owner = r.FormValue("owner")
+	if owner == "" {
+		return owner, true
+	}
+	_, err := bookkeeper.GetUserByName(r.Context(), s.db, owner)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, fmt.Sprintf("Unknown owner %s", owner), 400)
+		return owner, false
+	}
+	ok = checkErr(err, w, 500, "Failed to look up owner", "owner", owner)
+	return
+}
+
+// mergePatch applies a JSON Merge Patch (RFC 7386) to target, both decoded
+// from JSON into interface{}
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+		} else {
+			targetObj[k] = mergePatch(targetObj[k], v)
+		}
+	}
+	return targetObj
+}
+
+// applyMergePatch decodes original with the patch in body applied into
+// result; fields of original that the patch leaves out are kept, and fields
+// set to null are reset
+func applyMergePatch(original interface{}, body []byte, result interface{}) error {
+	decode := func(data []byte, v interface{}) error {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		return decoder.Decode(v)
+	}
+	originalJson, err := json.Marshal(original)
+	if err != nil {
+		return err
+	}
+	var target, patch interface{}
+	if err = decode(originalJson, &target); err != nil {
+		return err
+	}
+	if err = decode(body, &patch); err != nil {
+		return err
+	}
+	if _, ok := patch.(map[string]interface{}); !ok {
+		return errors.New("a merge patch must be a JSON object")
+	}
+	merged, err := json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(merged, result)
+}
+
+// the entity tag of an account or a transaction is its version
+func etagFor(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// parse the version the client has last seen from the If-Match header, which
+// updates and deletions of accounts and transactions require
+func parseIfMatchAndFail(
+	w http.ResponseWriter, r *http.Request,
+) (version int, ok bool) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		writeError(w, "The If-Match header is required", http.StatusPreconditionRequired)
+		return 0, false
+	}
+	// versions are compared as is, so weak tags are as good as strong ones
+	tag := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+	version, err := strconv.Atoi(tag)
+	if err != nil || version < 1 {
+		writeError(w, "Invalid If-Match header", 400)
+		return 0, false
+	}
+	return version, true
+}
+
+// parse the optional limit and offset terms; the defaults are used when the
+// terms are absent, and the limit is always capped at the MaxNumRecords of the config
+func (s *Server) parsePaginationInQueryAndFail(
+	w http.ResponseWriter, r *http.Request, defaultLimit int, defaultOffset int,
+) (limit int, offset int, ok bool) {
+	ok = true
+	limit, offset = defaultLimit, defaultOffset
+	var err error
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			writeError(w, "Invalid query term limit", 400)
+			ok = false
+			return
+		}
+	}
+	if offsetStr := r.FormValue("offset"); offsetStr != "" {
+		if offset, err = strconv.Atoi(offsetStr); err != nil || offset < 0 {
+			writeError(w, "Invalid query term offset", 400)
+			ok = false
+			return
+		}
+	}
+	if limit <= 0 || limit > s.config.MaxNumRecords {
+		limit = s.config.MaxNumRecords
+	}
+	return
+}
+
+// write the total count and the links to the neighboring pages (RFC 8288)
+func writePaginationHeaders(
+	w http.ResponseWriter, r *http.Request, total int, limit int, offset int,
+) {
+	pageUrl := func(offset int) string {
+		u := *r.URL
+		q := u.Query()
+		q.Set("limit", strconv.Itoa(limit))
+		q.Set("offset", strconv.Itoa(offset))
+		u.RawQuery = q.Encode()
+		return u.RequestURI()
+	}
+	var links []string
+	if offset+limit < total {
+		links = append(links,
+			fmt.Sprintf(`<%s>; rel="next"`, pageUrl(offset+limit)))
+	}
+	if offset > 0 {
+		prevOffset := offset - limit
+		if prevOffset < 0 {
+			prevOffset = 0
+		}
+		links = append(links,
+			fmt.Sprintf(`<%s>; rel="prev"`, pageUrl(prevOffset)))
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
@@ -0,0 +1,323 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+	"go.uber.org/zap"
+)
+
+// Delivery of webhooks. An event is retried with exponential backoff until
+// the receiver answers with a 2xx status, or until WEBHOOK_MAX_ATTEMPTS, after
+// which the webhook moves on to the next event.
+var (
+	WEBHOOK_TIMEOUT         = 10 * time.Second
+	WEBHOOK_MAX_ATTEMPTS    = 6
+	WEBHOOK_INITIAL_BACKOFF = 2 * time.Second
+	WEBHOOK_MAX_BACKOFF     = 5 * time.Minute
+	// how often the dispatcher looks for webhooks added or removed elsewhere
+	WEBHOOK_SYNC_INTERVAL = 30 * time.Second
+)
+
+// the number of deliveries returned when no limit is given
+const defaultDeliveriesLimit = 50
+
+// webhookDispatcher runs one worker per webhook, which delivers the events of
+// its webhook in order
+type webhookDispatcher struct {
+	db     *pgxpool.Pool
+	events *eventHub
+	logger *zap.SugaredLogger
+	client *http.Client
+	// wakes up the dispatcher when a webhook is added or removed
+	reload chan struct{}
+}
+
+func newWebhookDispatcher(
+	db *pgxpool.Pool, events *eventHub, logger *zap.SugaredLogger,
+) *webhookDispatcher {
+	return &webhookDispatcher{
+		db:     db,
+		events: events,
+		logger: logger,
+		client: &http.Client{},
+		reload: make(chan struct{}, 1),
+	}
+}
+
+// ask the dispatcher to pick up a change to the webhooks
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.reload <- struct{}{}:
+	default:
+	}
+}
+
+// run keeps a worker running for every webhook until ctx is canceled
+func (d *webhookDispatcher) run(ctx context.Context) {
+	sugar := d.logger
+	defer sugar.Sync()
+
+	workers := make(map[int]context.CancelFunc)
+	var wg sync.WaitGroup
+	defer func() {
+		for _, cancel := range workers {
+			cancel()
+		}
+		wg.Wait()
+	}()
+	ticker := time.NewTicker(WEBHOOK_SYNC_INTERVAL)
+	defer ticker.Stop()
+	for {
+		webhooks, err := bookkeeper.GetAllWebhooks(ctx, d.db, true)
+		if err != nil && ctx.Err() == nil {
+			sugar.Errorw("failed to get webhooks", "error", err)
+		}
+		if err == nil {
+			current := make(map[int]bool)
+			for _, webhook := range webhooks {
+				current[webhook.Id] = true
+				if _, ok := workers[webhook.Id]; ok {
+					continue
+				}
+				workerCtx, cancel := context.WithCancel(ctx)
+				workers[webhook.Id] = cancel
+				wg.Add(1)
+				go func(webhook bookkeeper.Webhook) {
+					defer wg.Done()
+					d.serve(workerCtx, webhook)
+				}(webhook)
+			}
+			for id, cancel := range workers {
+				if !current[id] {
+					cancel()
+					delete(workers, id)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.reload:
+		case <-ticker.C:
+		}
+	}
+}
+
+// serve delivers the events after the last one the webhook is done with,
+// as they are recorded, until ctx is canceled
+func (d *webhookDispatcher) serve(ctx context.Context, webhook bookkeeper.Webhook) {
+	sugar := d.logger.With("webhook_id", webhook.Id)
+	defer sugar.Sync()
+
+	wakeUp := d.events.subscribe()
+	defer d.events.unsubscribe(wakeUp)
+	ticker := time.NewTicker(EVENT_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		evs, err := bookkeeper.GetEventsAfter(ctx, d.db, webhook.LastEventId, eventPageSize)
+		if err != nil && ctx.Err() == nil {
+			sugar.Errorw("failed to read events", "after", webhook.LastEventId,
+				"error", err)
+		}
+		for _, e := range evs {
+			if webhook.Wants(e.Type) {
+				if !d.deliver(ctx, webhook, e) {
+					return
+				}
+			}
+			webhook.LastEventId = e.Id
+			// skipped events are settled together, at the end of the page
+			if webhook.Wants(e.Type) || e.Id == evs[len(evs)-1].Id {
+				err = bookkeeper.AdvanceWebhook(ctx, d.db, webhook.Id, e.Id)
+				if err != nil && ctx.Err() == nil {
+					sugar.Errorw("failed to advance webhook", "event_id", e.Id,
+						"error", err)
+				}
+			}
+		}
+		if len(evs) == eventPageSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wakeUp:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver sends the event to the webhook until it is accepted or the attempts
+// run out, and logs every attempt. It returns false if ctx is canceled before
+// then, in which case the event is delivered again by the next worker.
+func (d *webhookDispatcher) deliver(
+	ctx context.Context, webhook bookkeeper.Webhook, event bookkeeper.Event,
+) bool {
+	sugar := d.logger.With("webhook_id", webhook.Id, "event_id", event.Id)
+	defer sugar.Sync()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		sugar.Errorw("failed to encode event", "error", err)
+		return true
+	}
+	backoff := WEBHOOK_INITIAL_BACKOFF
+	for attempt := 1; ; attempt++ {
+		delivery := d.attempt(ctx, webhook, event, body)
+		if ctx.Err() != nil {
+			return false
+		}
+		delivery.Attempt = attempt
+		if err := bookkeeper.InsertWebhookDelivery(ctx, d.db, &delivery); err != nil {
+			sugar.Errorw("failed to log webhook delivery", "error", err)
+		}
+		if delivery.Succeeded() {
+			webhookDeliveries.WithLabelValues("succeeded").Inc()
+			return true
+		}
+		webhookDeliveries.WithLabelValues("failed").Inc()
+		if attempt >= WEBHOOK_MAX_ATTEMPTS {
+			sugar.Warnw("giving up on webhook delivery", "attempts", attempt,
+				"status", delivery.StatusCode, "error", delivery.Error)
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > WEBHOOK_MAX_BACKOFF {
+			backoff = WEBHOOK_MAX_BACKOFF
+		}
+	}
+}
+
+// attempt POSTs the event to the webhook once, signed with its secret
+func (d *webhookDispatcher) attempt(
+	ctx context.Context, webhook bookkeeper.Webhook, event bookkeeper.Event,
+	body []byte,
+) (delivery bookkeeper.WebhookDelivery) {
+	delivery = bookkeeper.WebhookDelivery{
+		WebhookId: webhook.Id, EventId: event.Id, EventType: event.Type,
+	}
+	start := time.Now()
+	defer func() {
+		delivery.DurationMs = time.Since(start).Milliseconds()
+	}()
+	reqCtx, cancel := context.WithTimeout(ctx, WEBHOOK_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, webhook.Url,
+		bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bkpsrv-webhook")
+	req.Header.Set("X-Bookkeeper-Event", event.Type)
+	req.Header.Set("X-Bookkeeper-Event-Id", strconv.FormatInt(event.Id, 10))
+	req.Header.Set("X-Bookkeeper-Signature", webhook.Sign(body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+	// the body is not used, but reading it lets the connection be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	delivery.StatusCode = resp.StatusCode
+	if !delivery.Succeeded() {
+		delivery.Error = "unexpected response status: " + resp.Status
+	}
+	return delivery
+}
+
+func (s *Server) returnAllWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := bookkeeper.GetAllWebhooks(r.Context(), s.db, false)
+	if !checkErr(err, w, 500, "Failed to get webhooks") {
+		return
+	}
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+func (s *Server) postWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhook bookkeeper.Webhook
+
+	body, err := ioutil.ReadAll(r.Body)
+	if !checkErr(err, w, 400, "Failed to read the request body") {
+		return
+	}
+	err = json.Unmarshal(body, &webhook)
+	if !checkErr(err, w, 400, "Failed to parse the request body as a JSON string") {
+		return
+	}
+	if !checkErr(webhook.Validate(), w, 400, "Invalid webhook payload") {
+		return
+	}
+	if webhook.Secret == "" {
+		if !checkErr(webhook.NewSecret(), w, 500, "Failed to generate secret") {
+			return
+		}
+	}
+	err = bookkeeper.InsertWebhook(r.Context(), s.db, &webhook)
+	if !checkErr(err, w, 500, "Failed to insert webhook", "url", webhook.Url) {
+		return
+	}
+	s.webhooks.notify()
+	// the only response that includes the secret
+	json.NewEncoder(w).Encode(webhook)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if !checkErr(err, w, 400, "Invalid webhook id provided") {
+		return
+	}
+	err = bookkeeper.DeleteWebhook(r.Context(), s.db, id)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, "Webhook not found", 404)
+		return
+	}
+	if !checkErr(err, w, 500, "Failed to delete webhook", "webhook_id", id) {
+		return
+	}
+	s.webhooks.notify()
+}
+
+func (s *Server) returnWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if !checkErr(err, w, 400, "Invalid webhook id provided") {
+		return
+	}
+	limit, offset, ok := s.parsePaginationInQueryAndFail(w, r, defaultDeliveriesLimit, 0)
+	if !ok {
+		return
+	}
+	_, err = bookkeeper.GetSingleWebhook(r.Context(), s.db, id)
+	if errors.Is(err, bookkeeper.ErrNotFound) {
+		writeError(w, "Webhook not found", 404)
+		return
+	}
+	if !checkErr(err, w, 500, "Failed to get webhook", "webhook_id", id) {
+		return
+	}
+	deliveries, err := bookkeeper.GetWebhookDeliveries(r.Context(), s.db, id,
+		limit, offset)
+	if !checkErr(err, w, 500, "Failed to get webhook deliveries", "webhook_id", id) {
+		return
+	}
+	json.NewEncoder(w).Encode(deliveries)
+}
@@ -7,9 +7,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/AlecAivazis/survey/v2"
 	"github.com/leekchan/accounting"
 	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
 	"github.com/olekukonko/tablewriter"
@@ -31,6 +33,68 @@ var accountBalanceCmd = &cobra.Command{
 	Args:  cobra.NoArgs,
 	Run:   accountBalance,
 }
+var accountOwnCmd = &cobra.Command{
+	Use:   "own <account> [<member>[=<share>] ...]",
+	Short: "Set the household members who own an account",
+	Long: `own sets the owners of an account and their shares, e.g.
+"bkpctl account own 'LZ CHA C' lz" or "bkpctl account own JOINT lz=0.6 ws=0.4".
+Members without a share split what is left equally. With no members, the
+account belongs to the whole household.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  accountOwn,
+}
+var accountCreateCmd = &cobra.Command{
+	Use:   "create [<name>]",
+	Short: "Create an account",
+	Long: `create adds an account from the flags, e.g.
+"bkpctl account create 'LZ CHA C' --tags asset,cash,chase --owner lz", or asks
+for every field without a name. An account must be tagged with exactly one of
+asset and liability.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  accountCreate,
+}
+var accountEditCmd = &cobra.Command{
+	Use:   "edit <account>",
+	Short: "Change the name, description or tags of an account",
+	Long: `edit sets the fields given by the flags, e.g.
+"bkpctl account edit 'LZ CHA C' --desc 'Chase checking'", or asks for every
+field with the current values as defaults if no flag is given. Use
+"bkpctl account own" to change the owners.`,
+	Args: cobra.ExactArgs(1),
+	Run:  accountEdit,
+}
+var accountDeleteCmd = &cobra.Command{
+	Use:   "delete <account>",
+	Short: "Delete an account that no transaction refers to",
+	Args:  cobra.ExactArgs(1),
+	Run:   accountDelete,
+}
+var accountTagCmd = &cobra.Command{
+	Use:   "tag",
+	Short: "Add or remove tags of an account",
+}
+var accountTagAddCmd = &cobra.Command{
+	Use:   "add <account> <tag> [<tag> ...]",
+	Short: "Add tags to an account",
+	Args:  cobra.MinimumNArgs(2),
+	Run:   accountTagAdd,
+}
+var accountTagRmCmd = &cobra.Command{
+	Use:   "rm <account> <tag> [<tag> ...]",
+	Short: "Remove tags from an account",
+	Args:  cobra.MinimumNArgs(2),
+	Run:   accountTagRm,
+}
+var accountMergeCmd = &cobra.Command{
+	Use:   "merge <account> <into>",
+	Short: "Move every transaction of an account onto another and delete it",
+	Long: `merge moves every transaction of an account onto another account of the
+same class, e.g. when a bank replaces a card with a new number, and then
+deletes the old account, all or nothing. Transfers keep their association ids.
+A summary of the merge is shown before asking for confirmation.`,
+	Args: cobra.ExactArgs(2),
+	Run:  accountMerge,
+}
 
 func initAccountCmd(rootCmd *cobra.Command) {
 	accountLsCmd.Flags().IntP("id", "i", -1, "specify an specific id to list")
@@ -39,6 +103,27 @@ func initAccountCmd(rootCmd *cobra.Command) {
 		"date", "d", "", "specify the date (default: today local time)")
 	accountCmd.AddCommand(accountLsCmd)
 	accountCmd.AddCommand(accountBalanceCmd)
+	accountMergeCmd.Flags().Bool("dry-run", false,
+		"only show what the merge would do")
+	accountMergeCmd.Flags().BoolP("yes", "y", false, "merge without confirmation")
+	accountCreateCmd.Flags().StringP("desc", "d", "", "description of the account")
+	accountCreateCmd.Flags().StringSliceP("tags", "t", nil,
+		"tags of the account, including either asset or liability")
+	accountCreateCmd.Flags().StringSlice("owner", nil,
+		"owners as member or member=share (default: the whole household)")
+	accountEditCmd.Flags().StringP("name", "n", "", "new name of the account")
+	accountEditCmd.Flags().StringP("desc", "d", "", "new description of the account")
+	accountEditCmd.Flags().StringSliceP("tags", "t", nil,
+		"new tags of the account, replacing all, including either asset or liability")
+	accountDeleteCmd.Flags().BoolP("yes", "y", false, "delete without confirmation")
+	accountTagCmd.AddCommand(accountTagAddCmd)
+	accountTagCmd.AddCommand(accountTagRmCmd)
+	accountCmd.AddCommand(accountCreateCmd)
+	accountCmd.AddCommand(accountEditCmd)
+	accountCmd.AddCommand(accountDeleteCmd)
+	accountCmd.AddCommand(accountTagCmd)
+	accountCmd.AddCommand(accountOwnCmd)
+	accountCmd.AddCommand(accountMergeCmd)
 	rootCmd.AddCommand(accountCmd)
 }
 
@@ -84,6 +169,10 @@ func allAccountsBalance(date string) ([]bookkeeper.AccountWithBalance, error) {
 		return accounts_, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return accounts_, readApiError(resp,
+			"failed to get the balance of all accounts")
+	}
 	json.NewDecoder(resp.Body).Decode(&accounts_)
 	return accounts_, nil
 }
@@ -99,12 +188,8 @@ func singleAccountBalance(
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		fmt.Printf(
-			"Failed to get the balance for account %s (response status: %s)\n",
-			name, resp.Status)
-		return account_, fmt.Errorf(
-			"failed to get the balance for account %s (response status: %s)",
-			name, resp.Status)
+		return account_, readApiError(resp, fmt.Sprintf(
+			"failed to get the balance for account %s", name))
 	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -137,10 +222,7 @@ func lsAccounts(cmd *cobra.Command, args []string) {
 	cobra.CheckErr(err)
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		fmt.Printf(
-			"Failed to get the account(s). Response status: %s\n", resp.Status,
-		)
-		return
+		cobra.CheckErr(readApiError(resp, "failed to get the account(s)"))
 	}
 	body, err := io.ReadAll(resp.Body)
 	cobra.CheckErr(err)
@@ -155,10 +237,323 @@ func lsAccounts(cmd *cobra.Command, args []string) {
 
 func tablePrintAccounts(accounts []bookkeeper.Account) {
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Id", "Name", "Desc", "Tags"})
+	table.SetHeader([]string{"Id", "Name", "Desc", "Tags", "Owners"})
 	for _, a := range accounts {
-		row := []string{fmt.Sprintf("%d", a.Id), a.Name, a.Desc, strings.Join(a.Tags, ", ")}
+		row := []string{
+			fmt.Sprintf("%d", a.Id), a.Name, a.Desc, strings.Join(a.Tags, ", "),
+			formatOwners(a.Owners),
+		}
 		table.Append(row)
 	}
 	table.Render()
 }
+
+func formatOwners(owners []bookkeeper.AccountOwner) string {
+	var res []string
+	for _, o := range owners {
+		res = append(res, fmt.Sprintf("%s %.4g%%", o.UserName, o.Share*100))
+	}
+	return strings.Join(res, ", ")
+}
+
+// parse owners given as member or member=share; members without a share
+// split the remainder equally
+func parseOwners(args []string) ([]bookkeeper.AccountOwner, error) {
+	var (
+		owners    []bookkeeper.AccountOwner
+		remainder float64 = 1
+		numEqual  int
+	)
+	for _, arg := range args {
+		owner := bookkeeper.AccountOwner{UserName: arg}
+		if i := strings.LastIndex(arg, "="); i >= 0 {
+			share, err := strconv.ParseFloat(arg[i+1:], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid share in %s: %w", arg, err)
+			}
+			owner.UserName, owner.Share = arg[:i], share
+			remainder -= share
+		} else {
+			numEqual++
+		}
+		owners = append(owners, owner)
+	}
+	for i := range owners {
+		if owners[i].Share == 0 {
+			owners[i].Share = remainder / float64(numEqual)
+		}
+	}
+	return owners, nil
+}
+
+func accountOwn(cmd *cobra.Command, args []string) {
+	account, err := getAccountByName(args[0])
+	cobra.CheckErr(err)
+	owners, err := parseOwners(args[1:])
+	cobra.CheckErr(err)
+	account, err = patchAccount(account, map[string]interface{}{"owners": owners})
+	cobra.CheckErr(err)
+	tablePrintAccounts([]bookkeeper.Account{account})
+}
+
+// the account classes, one of which every account is tagged with
+var accountClasses = []string{"asset", "liability"}
+
+// The answers of the interactive mode of create and edit
+type accountAnswers struct {
+	Name   string
+	Desc   string
+	Class  string
+	Tags   string // other than the class, separated by commas
+	Owners string // as member or member=share, separated by spaces
+}
+
+// askAccount asks for the fields of an account, with those of account as the
+// defaults; owners are only asked for if askOwners is set
+func askAccount(account bookkeeper.Account, askOwners bool) (bookkeeper.Account, error) {
+	var otherTags []string
+	for _, tag := range account.Tags {
+		if !stringInList(tag, accountClasses) {
+			otherTags = append(otherTags, tag)
+		}
+	}
+	answers := accountAnswers{
+		Name: account.Name, Desc: account.Desc,
+		Tags: strings.Join(otherTags, ", "),
+	}
+	classPrompt := &survey.Select{
+		Message: "Is it an asset or a liability account?",
+		Options: accountClasses,
+	}
+	if class := account.Class(); class != "" {
+		classPrompt.Default = class
+	}
+	qs := []*survey.Question{
+		{
+			Name:     "name",
+			Prompt:   &survey.Input{Message: "Name of the account", Default: answers.Name},
+			Validate: survey.Required,
+		},
+		{
+			Name:   "desc",
+			Prompt: &survey.Input{Message: "A description", Default: answers.Desc},
+		},
+		{Name: "class", Prompt: classPrompt},
+		{
+			Name: "tags",
+			Prompt: &survey.Input{
+				Message: "Other tags, separated by commas",
+				Default: answers.Tags,
+			},
+			Validate: func(ans interface{}) error {
+				str, _ := ans.(string)
+				for _, tag := range splitTags(str) {
+					if stringInList(tag, accountClasses) {
+						return fmt.Errorf("the class %s is chosen above", tag)
+					}
+				}
+				return nil
+			},
+		},
+	}
+	if askOwners {
+		qs = append(qs, &survey.Question{
+			Name: "owners",
+			Prompt: &survey.Input{
+				Message: "Owners as member or member=share, separated by spaces (empty for the whole household)",
+			},
+			Validate: func(ans interface{}) error {
+				str, _ := ans.(string)
+				_, err := parseOwners(strings.Fields(str))
+				return err
+			},
+		})
+	}
+	if err := survey.Ask(qs, &answers); err != nil {
+		return account, err
+	}
+	account.Name = strings.TrimSpace(answers.Name)
+	account.Desc = answers.Desc
+	account.Tags = append([]string{answers.Class}, splitTags(answers.Tags)...)
+	if askOwners {
+		owners, err := parseOwners(strings.Fields(answers.Owners))
+		if err != nil {
+			return account, err
+		}
+		account.Owners = owners
+	}
+	return account, nil
+}
+
+// split tags separated by commas, dropping blanks and duplicates
+func splitTags(str string) []string {
+	var tags []string
+	for _, tag := range strings.Split(str, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !stringInList(tag, tags) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func accountCreate(cmd *cobra.Command, args []string) {
+	var (
+		account bookkeeper.Account
+		err     error
+	)
+	if len(args) == 0 {
+		account, err = askAccount(account, true)
+		cobra.CheckErr(err)
+	} else {
+		account.Name = args[0]
+		account.Desc, err = cmd.Flags().GetString("desc")
+		cobra.CheckErr(err)
+		tags, err := cmd.Flags().GetStringSlice("tags")
+		cobra.CheckErr(err)
+		account.Tags = splitTags(strings.Join(tags, ","))
+		owners, err := cmd.Flags().GetStringSlice("owner")
+		cobra.CheckErr(err)
+		account.Owners, err = parseOwners(owners)
+		cobra.CheckErr(err)
+	}
+	// fail before anything is sent
+	cobra.CheckErr(account.Validate())
+	account, err = postAccount(account)
+	cobra.CheckErr(err)
+	tablePrintAccounts([]bookkeeper.Account{account})
+}
+
+func accountEdit(cmd *cobra.Command, args []string) {
+	account, err := getAccountByName(args[0])
+	cobra.CheckErr(err)
+	edited := account
+	flags := cmd.Flags()
+	if !flags.Changed("name") && !flags.Changed("desc") && !flags.Changed("tags") {
+		edited, err = askAccount(account, false)
+		cobra.CheckErr(err)
+	}
+	if flags.Changed("name") {
+		edited.Name, err = flags.GetString("name")
+		cobra.CheckErr(err)
+	}
+	if flags.Changed("desc") {
+		edited.Desc, err = flags.GetString("desc")
+		cobra.CheckErr(err)
+	}
+	if flags.Changed("tags") {
+		tags, err := flags.GetStringSlice("tags")
+		cobra.CheckErr(err)
+		edited.Tags = splitTags(strings.Join(tags, ","))
+	}
+	cobra.CheckErr(edited.Validate())
+	account, err = patchAccount(account, map[string]interface{}{
+		"name": edited.Name, "desc_": edited.Desc, "tags": edited.Tags,
+	})
+	cobra.CheckErr(err)
+	tablePrintAccounts([]bookkeeper.Account{account})
+}
+
+func accountDelete(cmd *cobra.Command, args []string) {
+	yes, err := cmd.Flags().GetBool("yes")
+	cobra.CheckErr(err)
+	account, err := getAccountByName(args[0])
+	cobra.CheckErr(err)
+	tablePrintAccounts([]bookkeeper.Account{account})
+	if !yes {
+		survey.AskOne(&survey.Confirm{
+			Message: "Are you sure that you want to delete this account?",
+		}, &yes)
+	}
+	if yes {
+		cobra.CheckErr(deleteAccount(account))
+	}
+}
+
+func accountTagAdd(cmd *cobra.Command, args []string) {
+	account, err := getAccountByName(args[0])
+	cobra.CheckErr(err)
+	edited := account
+	edited.Tags = splitTags(strings.Join(append(account.Tags, args[1:]...), ","))
+	setAccountTags(account, edited)
+}
+
+func accountTagRm(cmd *cobra.Command, args []string) {
+	account, err := getAccountByName(args[0])
+	cobra.CheckErr(err)
+	edited := account
+	edited.Tags = nil
+	for _, tag := range account.Tags {
+		if !stringInList(tag, args[1:]) {
+			edited.Tags = append(edited.Tags, tag)
+		}
+	}
+	setAccountTags(account, edited)
+}
+
+// replace the tags of account with those of edited, which must still make a
+// valid account
+func setAccountTags(account bookkeeper.Account, edited bookkeeper.Account) {
+	if err := edited.Validate(); err != nil {
+		cobra.CheckErr(fmt.Errorf("%s cannot be tagged %s: %w",
+			account.Name, strings.Join(edited.Tags, ", "), err))
+	}
+	account, err := patchAccount(account, map[string]interface{}{"tags": edited.Tags})
+	cobra.CheckErr(err)
+	tablePrintAccounts([]bookkeeper.Account{account})
+}
+
+func accountMerge(cmd *cobra.Command, args []string) {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	cobra.CheckErr(err)
+	yes, err := cmd.Flags().GetBool("yes")
+	cobra.CheckErr(err)
+	from, err := getAccountByName(args[0])
+	cobra.CheckErr(err)
+	into, err := getAccountByName(args[1])
+	cobra.CheckErr(err)
+	merge, err := mergeAccounts(from, into, true)
+	cobra.CheckErr(err)
+	printAccountMerge(merge)
+	if dryRun {
+		return
+	}
+	if !yes {
+		survey.AskOne(&survey.Confirm{
+			Message: fmt.Sprintf("Are you sure that you want to merge %s into %s?",
+				from.Name, into.Name),
+		}, &yes)
+	}
+	if !yes {
+		return
+	}
+	// fails if the account has changed since the summary
+	merge, err = mergeAccounts(merge.From, merge.Into, false)
+	cobra.CheckErr(err)
+	fmt.Printf("Moved %d transactions to %s and deleted %s\n",
+		len(merge.TransactionIds), merge.Into.Name, merge.From.Name)
+}
+
+func printAccountMerge(merge bookkeeper.AccountMerge) {
+	ac := accounting.Accounting{Symbol: "$", Precision: 2}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"", "Id", "Name", "Tags", "Owners"})
+	for _, a := range []struct {
+		role    string
+		account bookkeeper.Account
+	}{{"From (deleted)", merge.From}, {"Into", merge.Into}} {
+		table.Append([]string{
+			a.role, fmt.Sprintf("%d", a.account.Id), a.account.Name,
+			strings.Join(a.account.Tags, ", "), formatOwners(a.account.Owners),
+		})
+	}
+	table.Render()
+	fmt.Printf("Transactions to move: %d, totaling %s\n",
+		merge.NumTransactions, ac.FormatMoney(float64(merge.Amount)/100))
+	fmt.Printf("Transfer legs among them, which keep their association ids: %d\n",
+		merge.NumTransfers)
+	if merge.NumInternalTransfers > 0 {
+		fmt.Printf("Transfers between the two accounts, which will have both legs in %s: %d\n",
+			merge.Into.Name, merge.NumInternalTransfers)
+	}
+}
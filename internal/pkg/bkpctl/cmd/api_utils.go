@@ -2,16 +2,77 @@ package cmd
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
 )
 
+// apiError is an error response from the server, decoded from the JSON
+// error envelope whenever possible
+type apiError struct {
+	msg        string
+	status     string
+	statusCode int
+	details    bookkeeper.ErrorDetails
+}
+
+func (e *apiError) Error() string {
+	detail := e.details.Message
+	if detail == "" {
+		detail = "response status: " + e.status
+	} else {
+		detail = fmt.Sprintf("%s (%s)", detail, e.status)
+	}
+	for _, f := range e.details.Fields {
+		detail += fmt.Sprintf("\n  - %s %s", f.Field, f.Reason)
+	}
+	return fmt.Sprintf("%s: %s", e.msg, detail)
+}
+
+// readApiError reads the body of a failed response and returns it as an error
+// prefixed with msg
+func readApiError(resp *http.Response, msg string) error {
+	apiErr := &apiError{msg: msg, status: resp.Status, statusCode: resp.StatusCode}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apiErr
+	}
+	var errResp bookkeeper.ErrorResponse
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error.Message != "" {
+		apiErr.details = errResp.Error
+	} else {
+		// not an error envelope, e.g. a 404 from the router itself
+		apiErr.details.Message = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
+
+// isVersionConflict tells if err is the server rejecting an update or a
+// deletion because the resource has changed since it was fetched
+func isVersionConflict(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) &&
+		apiErr.statusCode == http.StatusPreconditionFailed
+}
+
+// the entity tag of a version of an account or a transaction, to be sent in
+// If-Match so that an update or a deletion fails if someone else got there
+// first
+func etagOf(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
 func getTransactionById(transId int, trans *bookkeeper.Transaction_) (err error) {
 	url_ := fmt.Sprintf("%stransactions/%d", BASE_URL, transId)
 	resp, err := http.Get(url_)
@@ -20,10 +81,8 @@ func getTransactionById(transId int, trans *bookkeeper.Transaction_) (err error)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return fmt.Errorf(
-			"failed to get transaction %d; response status: %s",
-			transId, resp.Status,
-		)
+		return readApiError(resp,
+			fmt.Sprintf("failed to get transaction %d", transId))
 	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -34,26 +93,90 @@ func getTransactionById(transId int, trans *bookkeeper.Transaction_) (err error)
 }
 
 func postAccounts(accountMap *map[string]bookkeeper.Account) error {
-	var newAccount bookkeeper.Account
-	url_ := BASE_URL + "accounts"
 	for key, account := range *accountMap {
-		buffer := new(bytes.Buffer)
-		json.NewEncoder(buffer).Encode(account)
-		resp, err := http.Post(url_, "application/json", buffer)
+		newAccount, err := postAccount(account)
 		if err != nil {
 			return err
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode != 200 {
-			return fmt.Errorf(
-				"failed to insert account with name %s", account.Name)
-		}
-		json.NewDecoder(resp.Body).Decode(&newAccount)
 		(*accountMap)[key] = newAccount
 	}
 	return nil
 }
 
+func postAccount(account bookkeeper.Account) (newAccount bookkeeper.Account, err error) {
+	err = retryIdempotent(func(idempotencyKey string) error {
+		return sendJsonRequestWithHeaders(http.MethodPost, BASE_URL+"accounts",
+			map[string]string{"Idempotency-Key": idempotencyKey},
+			account, &newAccount)
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to insert account with name %s: %w",
+			account.Name, err)
+	}
+	return
+}
+
+// patchAccount sets the fields in patch on the account, which must still be
+// at account.Version, and leaves the others alone
+func patchAccount(
+	account bookkeeper.Account, patch map[string]interface{},
+) (bookkeeper.Account, error) {
+	var patched bookkeeper.Account
+	err := sendJsonRequestWithHeaders(http.MethodPatch,
+		fmt.Sprintf("%saccounts/%d", BASE_URL, account.Id),
+		map[string]string{
+			"If-Match":     etagOf(account.Version),
+			"Content-Type": "application/merge-patch+json",
+		}, patch, &patched)
+	return patched, err
+}
+
+func deleteAccount(account bookkeeper.Account) error {
+	return sendJsonRequestWithHeaders(http.MethodDelete,
+		fmt.Sprintf("%saccounts/%d", BASE_URL, account.Id),
+		map[string]string{"If-Match": etagOf(account.Version)}, nil, nil)
+}
+
+// the number of times a request with an idempotency key is sent before
+// giving up
+const maxIdempotentAttempts = 4
+
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// retryIdempotent calls send with a new idempotency key, and calls it again
+// with the same key after network errors and server errors. The server
+// replays its first response to a key, so nothing is created twice.
+func retryIdempotent(send func(idempotencyKey string) error) error {
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = send(key)
+		if err == nil || attempt == maxIdempotentAttempts || !isRetryable(err) {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// tell if a request failed on the way, or for a reason that may go away
+func isRetryable(err error) bool {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode >= 500 ||
+			apiErr.details.Code == bookkeeper.ErrorCodeRequestInProgress
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
 func getAllAccounts(accounts *[]bookkeeper.Account) error {
 	url_ := BASE_URL + "accounts"
 	resp, err := http.Get(url_)
@@ -62,7 +185,7 @@ func getAllAccounts(accounts *[]bookkeeper.Account) error {
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("failed to get accounts; response status: %s", resp.Status)
+		return readApiError(resp, "failed to get accounts")
 	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -83,6 +206,11 @@ func getAccountByName(accountName string) (account bookkeeper.Account, err error
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = readApiError(resp,
+			fmt.Sprintf("failed to get account with name %s", accountName))
+		return
+	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return
@@ -91,21 +219,100 @@ func getAccountByName(accountName string) (account bookkeeper.Account, err error
 	return
 }
 
-func postSingleTransaction(trans bookkeeper.Transaction) (bookkeeper.Transaction, error) {
-	var newTrans bookkeeper.Transaction
-	url_ := BASE_URL + "transactions"
+// mergeAccounts moves the transactions of from onto into and deletes from,
+// which must still be at from.Version; with dryRun, it only tells what the
+// merge would do
+func mergeAccounts(
+	from bookkeeper.Account, into bookkeeper.Account, dryRun bool,
+) (merge bookkeeper.AccountMerge, err error) {
+	url_ := fmt.Sprintf("%saccounts/%d/merge?into=%d", BASE_URL, from.Id, into.Id)
+	headers := map[string]string{"If-Match": etagOf(from.Version)}
+	if dryRun {
+		url_ += "&dryRun=true"
+		headers = nil
+	}
+	err = sendJsonRequestWithHeaders(http.MethodPost, url_, headers, nil, &merge)
+	return
+}
+
+// postTransactionsBatch creates all transactions in one database transaction
+// on the server. If any of them fails, none is created, and the error lists
+// the ones that failed.
+func postTransactionsBatch(
+	transactions []bookkeeper.Transaction,
+) (created []bookkeeper.Transaction, err error) {
+	err = retryIdempotent(func(idempotencyKey string) error {
+		created, err = sendTransactionsBatch(transactions, idempotencyKey)
+		return err
+	})
+	return
+}
+
+func sendTransactionsBatch(
+	transactions []bookkeeper.Transaction, idempotencyKey string,
+) ([]bookkeeper.Transaction, error) {
 	buffer := new(bytes.Buffer)
-	json.NewEncoder(buffer).Encode(trans)
-	resp, err := http.Post(url_, "application/json", buffer)
+	json.NewEncoder(buffer).Encode(
+		bookkeeper.BatchRequest{Transactions: transactions})
+	req, err := http.NewRequest(http.MethodPost, BASE_URL+"transactions:batch", buffer)
 	if err != nil {
-		return trans, err
+		return transactions, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return transactions, err
 	}
 	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return transactions, err
+	}
+	var batchResp bookkeeper.BatchResponse
 	if resp.StatusCode != 200 {
-		return trans, fmt.Errorf("failed to insert transaction")
+		apiErr := &apiError{
+			msg: "failed to insert transactions", status: resp.Status,
+			statusCode: resp.StatusCode,
+		}
+		if json.Unmarshal(body, &batchResp) != nil || batchResp.Error == nil {
+			apiErr.details.Message = strings.TrimSpace(string(body))
+			return transactions, apiErr
+		}
+		apiErr.details = *batchResp.Error
+		for _, r := range batchResp.Results {
+			if r.Status != bookkeeper.BatchItemFailed || r.Error == nil {
+				continue
+			}
+			reason := r.Error.Message
+			for _, f := range r.Error.Fields {
+				reason += fmt.Sprintf("; %s %s", f.Field, f.Reason)
+			}
+			apiErr.details.Fields = append(apiErr.details.Fields,
+				bookkeeper.FieldError{
+					Field: fmt.Sprintf("transactions[%d]", r.Index), Reason: reason,
+				})
+		}
+		return transactions, apiErr
 	}
-	json.NewDecoder(resp.Body).Decode(&newTrans)
-	return newTrans, nil
+	if err = json.Unmarshal(body, &batchResp); err != nil {
+		return transactions, err
+	}
+	var created []bookkeeper.Transaction
+	for _, r := range batchResp.Results {
+		created = append(created, *r.Transaction)
+	}
+	return created, nil
+}
+
+func patchTransactionsByQuery(
+	queryStr string, patch bookkeeper.TransactionPatch,
+) (int, error) {
+	var resp bookkeeper.BulkUpdateResponse
+	err := sendJsonRequest(http.MethodPatch, fmt.Sprintf(
+		"%stransactions?queryString=%s", BASE_URL, url.QueryEscape(queryStr)),
+		patch, &resp)
+	return resp.Updated, err
 }
 
 func patchSingleTransaction(trans bookkeeper.Transaction) (bookkeeper.Transaction, error) {
@@ -115,12 +322,13 @@ func patchSingleTransaction(trans bookkeeper.Transaction) (bookkeeper.Transactio
 	json.NewEncoder(buffer).Encode(trans)
 
 	// prepare a PATCH
-	client := &http.Client{}
+	client := http.DefaultClient
 	req, err := http.NewRequest(http.MethodPatch, url_, bytes.NewBuffer(buffer.Bytes()))
 	if err != nil {
 		return trans, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", etagOf(trans.Version))
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -128,43 +336,195 @@ func patchSingleTransaction(trans bookkeeper.Transaction) (bookkeeper.Transactio
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return trans, fmt.Errorf("failed to update transaction; status: %s", resp.Status)
+		return trans, readApiError(resp,
+			fmt.Sprintf("failed to update transaction %d", trans.Id))
 	}
 	json.NewDecoder(resp.Body).Decode(&newTrans)
 	return newTrans, nil
 }
 
-func deleteSingleTransaction(transId int) (err error) {
+func deleteSingleTransaction(transId int, version int) (err error) {
 	url_ := fmt.Sprintf("%stransactions/%d", BASE_URL, transId)
 	// prepare a DELETE
-	client := &http.Client{}
+	client := http.DefaultClient
 	req, err := http.NewRequest(http.MethodDelete, url_, strings.NewReader(""))
 	if err != nil {
 		return
 	}
+	req.Header.Set("If-Match", etagOf(version))
 	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		err = fmt.Errorf("failed to delete transaction %d; status: %s", transId, resp.Status)
+		err = readApiError(resp,
+			fmt.Sprintf("failed to delete transaction %d", transId))
 		return
 	}
 	return
 }
 
 func getTransactionsByQuery(queryStr string) (transactions []bookkeeper.Transaction_, err error) {
+	// follow the next-page links until all matching transactions are fetched
+	url_ := transactionsQueryUrl(queryStr, 0)
+	for url_ != "" {
+		var page transactionsPage
+		if page, err = getTransactionsPage(url_); err != nil {
+			return
+		}
+		transactions = append(transactions, page.Transactions...)
+		url_ = page.NextUrl
+	}
+	return
+}
+
+type transactionsPage struct {
+	Transactions []bookkeeper.Transaction_
+	Total        int
+	NextUrl      string // empty if this is the last page
+}
+
+func transactionsQueryUrl(queryStr string, pageSize int) string {
 	url_ := fmt.Sprintf("%stransactions?queryString=%s", BASE_URL,
 		url.QueryEscape(queryStr))
+	if pageSize > 0 {
+		url_ += fmt.Sprintf("&limit=%d", pageSize)
+	}
+	return url_
+}
+
+var rNextLink = regexp.MustCompile(`<([^>]*)>;\s*rel="next"`)
+
+func getTransactionsPage(url_ string) (page transactionsPage, err error) {
 	resp, err := http.Get(url_)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = readApiError(resp, "failed to query transactions")
+		return
+	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return
 	}
-	err = json.Unmarshal(body, &transactions)
+	if err = json.Unmarshal(body, &page.Transactions); err != nil {
+		return
+	}
+	page.Total, _ = strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	if m := rNextLink.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+		page.NextUrl = BASE_URL + strings.TrimPrefix(m[1], "/")
+	}
+	return
+}
+
+func getTransactionAggregates(
+	queryStr string, groupBy []string, aggregates []string,
+) (rows []bookkeeper.AggregateRow, err error) {
+	url_ := fmt.Sprintf(
+		"%sreporting/aggregate?queryString=%s&groupBy=%s&aggregates=%s",
+		BASE_URL, url.QueryEscape(queryStr),
+		url.QueryEscape(strings.Join(groupBy, ",")),
+		url.QueryEscape(strings.Join(aggregates, ",")),
+	)
+	resp, err := http.Get(url_)
 	if err != nil {
 		return
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = readApiError(resp, "failed to aggregate transactions")
+		return
+	}
+	err = json.NewDecoder(resp.Body).Decode(&rows)
 	return
 }
+
+// send a request with an optional JSON payload to the API and decode the JSON
+// response into result, if it is not nil
+func sendJsonRequest(
+	method string, url_ string, payload interface{}, result interface{},
+) error {
+	return sendJsonRequestWithHeaders(method, url_, nil, payload, result)
+}
+
+func sendJsonRequestWithHeaders(
+	method string, url_ string, headers map[string]string,
+	payload interface{}, result interface{},
+) error {
+	buffer := new(bytes.Buffer)
+	if payload != nil {
+		json.NewEncoder(buffer).Encode(payload)
+	}
+	req, err := http.NewRequest(method, url_, buffer)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return readApiError(resp, fmt.Sprintf("%s %s failed", method, url_))
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
+
+func getCurrentUser() (user bookkeeper.User, err error) {
+	err = sendJsonRequest(http.MethodGet, BASE_URL+"auth/me", nil, &user)
+	return
+}
+
+func getAllSavedQueries() (queries []bookkeeper.SavedQuery, err error) {
+	err = sendJsonRequest(http.MethodGet, BASE_URL+"saved_queries", nil, &queries)
+	return
+}
+
+func getSavedQuery(name string) (query bookkeeper.SavedQuery, err error) {
+	url_ := BASE_URL + "saved_queries/" + url.PathEscape(name)
+	err = sendJsonRequest(http.MethodGet, url_, nil, &query)
+	return
+}
+
+func putSavedQuery(query bookkeeper.SavedQuery) error {
+	url_ := BASE_URL + "saved_queries/" + url.PathEscape(query.Name)
+	return sendJsonRequest(http.MethodPut, url_, query, nil)
+}
+
+func deleteSavedQuery(name string) error {
+	url_ := BASE_URL + "saved_queries/" + url.PathEscape(name)
+	return sendJsonRequest(http.MethodDelete, url_, nil, nil)
+}
+
+func getAllReportPresets() (presets []bookkeeper.ReportPreset, err error) {
+	err = sendJsonRequest(http.MethodGet, BASE_URL+"report_presets", nil, &presets)
+	return
+}
+
+func getReportPreset(name string) (preset bookkeeper.ReportPreset, err error) {
+	url_ := BASE_URL + "report_presets/" + url.PathEscape(name)
+	err = sendJsonRequest(http.MethodGet, url_, nil, &preset)
+	return
+}
+
+func putReportPreset(preset bookkeeper.ReportPreset) error {
+	url_ := BASE_URL + "report_presets/" + url.PathEscape(preset.Name)
+	return sendJsonRequest(http.MethodPut, url_, preset, nil)
+}
+
+func deleteReportPreset(name string) error {
+	url_ := BASE_URL + "report_presets/" + url.PathEscape(name)
+	return sendJsonRequest(http.MethodDelete, url_, nil, nil)
+}
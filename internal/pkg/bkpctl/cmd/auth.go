@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Log in to the server and manage API tokens",
+}
+var authLoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in with a password and store a new token for later commands",
+	Args:  cobra.NoArgs,
+	Run:   authLogin,
+}
+var authTokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Create, list, and revoke API tokens",
+}
+var authTokenCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a new token, e.g. for a script or another machine",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, err := cmd.Flags().GetString("name")
+		cobra.CheckErr(err)
+		scope, err := cmd.Flags().GetString("scope")
+		cobra.CheckErr(err)
+		var resp bookkeeper.NewApiTokenResponse
+		err = sendJsonRequest(http.MethodPost, BASE_URL+"auth/tokens",
+			bookkeeper.ApiToken{Name: name, Scope: scope}, &resp)
+		cobra.CheckErr(err)
+		fmt.Printf("Created token %d (%s). It will not be shown again:\n%s\n",
+			resp.ApiToken.Id, resp.ApiToken.Scope, resp.Token)
+	},
+}
+var authTokenLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the tokens of the logged in user",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var tokens []bookkeeper.ApiToken
+		err := sendJsonRequest(http.MethodGet, BASE_URL+"auth/tokens", nil, &tokens)
+		cobra.CheckErr(err)
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Id", "Name", "Scope", "Created", "Revoked"})
+		for _, t := range tokens {
+			revoked := ""
+			if t.RevokedAt != nil {
+				revoked = t.RevokedAt.Format("2006/01/02")
+			}
+			table.Append([]string{
+				fmt.Sprintf("%d", t.Id), t.Name, t.Scope,
+				t.CreatedAt.Format("2006/01/02"), revoked,
+			})
+		}
+		table.Render()
+	},
+}
+var authTokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke a token",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		cobra.CheckErr(err)
+		err = sendJsonRequest(http.MethodDelete,
+			fmt.Sprintf("%sauth/tokens/%d", BASE_URL, id), nil, nil)
+		cobra.CheckErr(err)
+	},
+}
+
+func initAuthCmd(rootCmd *cobra.Command) {
+	authLoginCmd.Flags().StringP("user", "u", "", "User name")
+	authLoginCmd.Flags().String("token-name", defaultTokenName(),
+		"Name of the token to create")
+	authLoginCmd.Flags().String("scope", bookkeeper.TokenScopeReadWrite,
+		"Scope of the token to create (read or read-write)")
+	authTokenCreateCmd.Flags().StringP("name", "n", "", "Name of the token")
+	authTokenCreateCmd.MarkFlagRequired("name")
+	authTokenCreateCmd.Flags().String("scope", bookkeeper.TokenScopeRead,
+		"Scope of the token (read or read-write)")
+	authTokenCmd.AddCommand(authTokenCreateCmd)
+	authTokenCmd.AddCommand(authTokenLsCmd)
+	authTokenCmd.AddCommand(authTokenRevokeCmd)
+	authCmd.AddCommand(authLoginCmd)
+	authCmd.AddCommand(authTokenCmd)
+	rootCmd.AddCommand(authCmd)
+	// send the stored token with every request to the server
+	http.DefaultClient.Transport = &tokenTransport{base: http.DefaultTransport}
+}
+
+func defaultTokenName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "bkpctl"
+	}
+	return "bkpctl@" + hostname
+}
+
+func authLogin(cmd *cobra.Command, args []string) {
+	var req bookkeeper.LoginRequest
+	var err error
+	req.Name, err = cmd.Flags().GetString("user")
+	cobra.CheckErr(err)
+	req.TokenName, err = cmd.Flags().GetString("token-name")
+	cobra.CheckErr(err)
+	req.Scope, err = cmd.Flags().GetString("scope")
+	cobra.CheckErr(err)
+	if req.Name == "" {
+		err = survey.AskOne(&survey.Input{Message: "User name"}, &req.Name)
+		cobra.CheckErr(err)
+	}
+	err = survey.AskOne(&survey.Password{Message: "Password"}, &req.Password)
+	cobra.CheckErr(err)
+	var resp bookkeeper.NewApiTokenResponse
+	err = sendJsonRequest(http.MethodPost, BASE_URL+"auth/login", req, &resp)
+	cobra.CheckErr(err)
+	p, err := tokenFilePath()
+	cobra.CheckErr(err)
+	err = os.MkdirAll(path.Dir(p), 0700)
+	cobra.CheckErr(err)
+	err = os.WriteFile(p, []byte(resp.Token+"\n"), 0600)
+	cobra.CheckErr(err)
+	fmt.Printf("Logged in as %s; token %d is stored in %s\n",
+		req.Name, resp.ApiToken.Id, p)
+}
+
+func tokenFilePath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(configDir, "bkpctl", "token"), nil
+}
+
+// the token from the BKPCTL_TOKEN environment variable, or the stored one
+func readToken() string {
+	if token := os.Getenv("BKPCTL_TOKEN"); token != "" {
+		return token
+	}
+	p, err := tokenFilePath()
+	if err != nil {
+		return ""
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+type tokenTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := readToken()
+	if token == "" || !strings.HasPrefix(req.URL.String(), BASE_URL) ||
+		req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	// a RoundTripper must not modify the original request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(req)
+}
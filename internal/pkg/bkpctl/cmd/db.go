@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/AlecAivazis/survey/v2"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -48,6 +51,34 @@ var dbInitCmd = &cobra.Command{
 	Run:   dbInit,
 }
 
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Update the schema of an existing database without wiping it",
+	Run:   dbMigrate,
+}
+
+var dbCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Find problems in the ledger and suggest fixes",
+	Long: `check scans the database for transfers that do not balance or have no
+partner, transactions of invalid types or with amounts of the wrong sign,
+categories missing from the category definition file, and accounts that are
+neither an asset nor a liability. With --fix, the problems that can be fixed
+without a human decision are repaired.`,
+	Run: dbCheck,
+}
+
+var dbUserCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage the users who can log in to the API server",
+}
+var dbUserAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a user with a password",
+	Args:  cobra.ExactArgs(1),
+	Run:   dbUserAdd,
+}
+
 func initDbCmd(rootCmd *cobra.Command) {
 	cobra.OnInitialize(initDbConfig)
 	dbCmd.PersistentFlags().StringVar(&dbConfigFile, "config", "",
@@ -57,8 +88,20 @@ func initDbCmd(rootCmd *cobra.Command) {
 		"set this flag to print actions without taking them")
 	dbInitCmd.Flags().StringP("data-file", "d", "",
 		"path to initial data (default is empty)")
+	dbMigrateCmd.Flags().Bool("dry-run", false,
+		"set this flag to print actions without taking them")
+	dbCheckCmd.Flags().Bool("fix", false,
+		"set this flag to apply the safe repairs")
+	dbCheckCmd.Flags().StringP(
+		"categories", "c", "",
+		"Path to the Category definition file (default: ./configs/category_map.json)",
+	)
 	dbCmd.AddCommand(dbInitCmd)
+	dbCmd.AddCommand(dbCheckCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
 	dbCmd.AddCommand(dbTestCmd)
+	dbUserCmd.AddCommand(dbUserAddCmd)
+	dbCmd.AddCommand(dbUserCmd)
 	rootCmd.AddCommand(dbCmd)
 }
 
@@ -80,7 +123,33 @@ func dbInit(cmd *cobra.Command, args []string) {
 		dbpool, err = pgxpool.Connect(context.Background(), db_url)
 		cobra.CheckErr(err)
 	}
-	commands, err := bookkeeper.InitDb(dbpool, dataFile, dryRun)
+	commands, err := bookkeeper.InitDb(context.Background(), dbpool, dataFile, dryRun)
+	cobra.CheckErr(err)
+	if dryRun {
+		fmt.Println(
+			"Dry run is on. The following commands would have been executed:",
+		)
+		fmt.Println(">>>")
+		for _, c := range commands {
+			fmt.Println(c)
+		}
+		fmt.Println("<<<")
+	}
+}
+
+func dbMigrate(cmd *cobra.Command, args []string) {
+	db_url, err := cmd.Flags().GetString("db-url")
+	cobra.CheckErr(err)
+	fmt.Printf("Migrating the database at %s...\n",
+		bookkeeper.MaskDbPassword(db_url))
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	cobra.CheckErr(err)
+	var dbpool *pgxpool.Pool = nil
+	if !dryRun {
+		dbpool, err = pgxpool.Connect(context.Background(), db_url)
+		cobra.CheckErr(err)
+	}
+	commands, err := bookkeeper.MigrateDb(context.Background(), dbpool, dryRun)
 	cobra.CheckErr(err)
 	if dryRun {
 		fmt.Println(
@@ -93,3 +162,76 @@ func dbInit(cmd *cobra.Command, args []string) {
 		fmt.Println("<<<")
 	}
 }
+
+func dbCheck(cmd *cobra.Command, args []string) {
+	db_url, err := cmd.Flags().GetString("db-url")
+	cobra.CheckErr(err)
+	fix, err := cmd.Flags().GetBool("fix")
+	cobra.CheckErr(err)
+	categoriesFile, err := cmd.Flags().GetString("categories")
+	cobra.CheckErr(err)
+	var categoryMap CategoryMap
+	cobra.CheckErr(readCategoryMap(categoriesFile, &categoryMap))
+	categories := map[string][]string{}
+	for _, c := range categoryMap {
+		categories[c.Category] = c.SubCategories
+	}
+	dbpool, err := pgxpool.Connect(context.Background(), db_url)
+	cobra.CheckErr(err)
+	defer dbpool.Close()
+	findings, err := bookkeeper.CheckLedger(context.Background(), dbpool, categories)
+	cobra.CheckErr(err)
+	if len(findings) == 0 {
+		fmt.Println("No problems found")
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Kind", "Transactions", "Account", "Problem",
+		"Suggestion", "Repair"})
+	table.SetRowLine(true)
+	for _, f := range findings {
+		ids := make([]string, len(f.TransactionIds))
+		for i, id := range f.TransactionIds {
+			ids[i] = fmt.Sprintf("%d", id)
+		}
+		account := ""
+		if f.AccountId != 0 {
+			account = fmt.Sprintf("%d", f.AccountId)
+		}
+		table.Append([]string{f.Kind, strings.Join(ids, ", "), account,
+			f.Problem, f.Suggestion, f.Repair})
+	}
+	table.Render()
+	repaired := 0
+	if fix {
+		repaired, err = bookkeeper.RepairFindings(context.Background(), dbpool, findings)
+		cobra.CheckErr(err)
+		fmt.Printf("Repaired %d of %d problems\n", repaired, len(findings))
+	}
+	if repaired < len(findings) {
+		cobra.CheckErr(fmt.Sprintf("%d problems need attention",
+			len(findings)-repaired))
+	}
+}
+
+func dbUserAdd(cmd *cobra.Command, args []string) {
+	var password, confirm string
+	db_url, err := cmd.Flags().GetString("db-url")
+	cobra.CheckErr(err)
+	err = survey.AskOne(&survey.Password{Message: "Password"}, &password,
+		survey.WithValidator(survey.MinLength(8)))
+	cobra.CheckErr(err)
+	err = survey.AskOne(&survey.Password{Message: "Confirm password"}, &confirm)
+	cobra.CheckErr(err)
+	if password != confirm {
+		cobra.CheckErr("passwords do not match")
+	}
+	user := bookkeeper.User{Name: args[0]}
+	cobra.CheckErr(user.SetPassword(password))
+	dbpool, err := pgxpool.Connect(context.Background(), db_url)
+	cobra.CheckErr(err)
+	defer dbpool.Close()
+	err = bookkeeper.InsertUser(context.Background(), dbpool, &user)
+	cobra.CheckErr(err)
+	fmt.Printf("Added user %s (id %d)\n", user.Name, user.Id)
+}
@@ -103,8 +103,9 @@ func readAndPostTransactions(dataPath string, config ImportConfig) error {
 
 	// read headers
 	var (
-		keys   []string
-		record []string
+		keys         []string
+		record       []string
+		transactions []bookkeeper.Transaction
 	)
 	keys, err = reader.Read()
 	if err != nil {
@@ -122,10 +123,19 @@ func readAndPostTransactions(dataPath string, config ImportConfig) error {
 		if err != nil {
 			return err
 		}
-		_, err = postSingleTransaction(trans)
-		if err != nil {
-			return err
+		transactions = append(transactions, trans)
+	}
+	// post in as few round trips as the server allows
+	for start := 0; start < len(transactions); start += bookkeeper.MAX_BATCH_SIZE {
+		end := start + bookkeeper.MAX_BATCH_SIZE
+		if end > len(transactions) {
+			end = len(transactions)
+		}
+		if _, err = postTransactionsBatch(transactions[start:end]); err != nil {
+			return fmt.Errorf("rows %d to %d were not imported: %w",
+				start+1, len(transactions), err)
 		}
+		fmt.Printf("Imported %d of %d transactions\n", end, len(transactions))
 	}
 
 	return nil
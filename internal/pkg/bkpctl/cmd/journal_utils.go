@@ -482,6 +482,28 @@ func (entry *JournalEntry) interactivePaycheckOtherExp(
 	return
 }
 
+// the payroll account of the logged in member, or of anyone if auth is
+// disabled on the server
+func defaultPayrollAccount(accounts []bookkeeper.Account) string {
+	var candidates []bookkeeper.Account
+	for _, a := range accounts {
+		if strings.Contains(strings.ToUpper(a.Name), "PAYROLL") {
+			candidates = append(candidates, a)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	if user, err := getCurrentUser(); err == nil {
+		for _, a := range candidates {
+			if a.ShareOf(user.Name) > 0 {
+				return a.Name
+			}
+		}
+	}
+	return candidates[0].Name
+}
+
 func (entry *JournalEntry) InteractivePaycheck(
 	accounts []bookkeeper.Account, categoryMap CategoryMap,
 ) (err error) {
@@ -498,7 +520,7 @@ func (entry *JournalEntry) InteractivePaycheck(
 		Type:        "In",
 		Category:    "Professional Income",
 		SubCategory: "Salary",
-		AccountName: "WS PAYROLL",
+		AccountName: defaultPayrollAccount(accounts),
 	}
 	colorHeading := color.New(color.FgCyan).Add(color.Bold).Add(color.Underline)
 	colorHeading.Println("Some general info of the paycheck")
@@ -619,16 +641,11 @@ func (entry *JournalEntry) InteractiveInvest(
 	return
 }
 
+// InteractiveSingleUpdate asks for a transaction id and edits the transaction
+// with that id; the transaction as fetched is returned
 func (entry *JournalEntry) InteractiveSingleUpdate(
 	accounts []bookkeeper.Account, categoryMap CategoryMap,
-) (err error) {
-	var accountNames []string
-	for _, a := range accounts {
-		accountNames = append(accountNames, a.Name)
-	}
-	entry.Clear()
-	entry.Transactions = append(entry.Transactions, bookkeeper.Transaction_{})
-
+) (base bookkeeper.Transaction_, err error) {
 	// get the id and fetch the transaction
 	var transId int
 	if err = survey.AskOne(&survey.Input{
@@ -636,9 +653,24 @@ func (entry *JournalEntry) InteractiveSingleUpdate(
 	}, &transId); err != nil {
 		return
 	}
-	if err = getTransactionById(transId, &entry.Transactions[0]); err != nil {
+	if err = getTransactionById(transId, &base); err != nil {
 		return
 	}
+	err = entry.InteractiveEditTransaction(accounts, categoryMap, base)
+	return
+}
+
+// InteractiveEditTransaction replaces the entry with an edited copy of trans
+func (entry *JournalEntry) InteractiveEditTransaction(
+	accounts []bookkeeper.Account, categoryMap CategoryMap,
+	trans bookkeeper.Transaction_,
+) (err error) {
+	var accountNames []string
+	for _, a := range accounts {
+		accountNames = append(accountNames, a.Name)
+	}
+	entry.Clear()
+	entry.Transactions = append(entry.Transactions, trans)
 	if entry.Transactions[0].Type == "Out" ||
 		entry.Transactions[0].Type == "TransferOut" ||
 		entry.Transactions[0].Type == "LiabilityChange" {
@@ -716,14 +748,15 @@ func (entry *JournalEntry) InteractiveConfirm() bool {
 	return confirmed
 }
 
+// PostToServer posts all transactions of the entry at once, so that a failure
+// does not leave half of the entry on the server
 func (entry *JournalEntry) PostToServer() error {
+	var transactions []bookkeeper.Transaction
 	for _, trans := range entry.Transactions {
-		_, err := postSingleTransaction(trans.Transaction)
-		if err != nil {
-			return err
-		}
+		transactions = append(transactions, trans.Transaction)
 	}
-	return nil
+	_, err := postTransactionsBatch(transactions)
+	return err
 }
 
 func (entry *JournalEntry) PatchToServer() error {
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Manage named transaction queries saved on the server",
+}
+var querySaveCmd = &cobra.Command{
+	Use:   "save <name> <query string>",
+	Short: "Save (or overwrite) a named query",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		desc, err := cmd.Flags().GetString("desc")
+		cobra.CheckErr(err)
+		err = putSavedQuery(bookkeeper.SavedQuery{
+			Name: args[0], QueryString: args[1], Desc: desc,
+		})
+		cobra.CheckErr(err)
+	},
+}
+var queryLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List all saved queries",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		queries, err := getAllSavedQueries()
+		cobra.CheckErr(err)
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Name", "Query String", "Desc"})
+		for _, q := range queries {
+			table.Append([]string{q.Name, q.QueryString, q.Desc})
+		}
+		table.Render()
+	},
+}
+var queryRmCmd = &cobra.Command{
+	Use:   "rm <name>",
+	Short: "Remove a saved query",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := deleteSavedQuery(args[0])
+		cobra.CheckErr(err)
+	},
+}
+
+func initQueryCmd(rootCmd *cobra.Command) {
+	querySaveCmd.Flags().StringP("desc", "d", "", "A description of the query")
+	queryCmd.AddCommand(querySaveCmd)
+	queryCmd.AddCommand(queryLsCmd)
+	queryCmd.AddCommand(queryRmCmd)
+	rootCmd.AddCommand(queryCmd)
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -33,6 +34,28 @@ var incomeCmd = &cobra.Command{
 	Run:   generateIncomeStatement,
 }
 
+var reportRunCmd = &cobra.Command{
+	Use:   "run <preset>",
+	Short: "Generate a report from a preset saved on the server",
+	Args:  cobra.ExactArgs(1),
+	Run:   runReport,
+}
+var reportLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List all report presets saved on the server",
+	Args:  cobra.NoArgs,
+	Run:   lsReportPresets,
+}
+var reportRmCmd = &cobra.Command{
+	Use:   "rm <preset>",
+	Short: "Remove a report preset from the server",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := deleteReportPreset(args[0])
+		cobra.CheckErr(err)
+	},
+}
+
 func initReportCmd(rootCmd *cobra.Command) {
 	balanceCmd.Flags().StringSliceP(
 		"asset-tags", "a",
@@ -87,18 +110,33 @@ func initReportCmd(rootCmd *cobra.Command) {
 		"report-schema", "configs/tpl/income_statement_tpl.json",
 		"Specify a report schema using a JSON string",
 	)
+	balanceCmd.Flags().StringSlice("owner", nil,
+		"Also report the shares of these household members separately")
+	incomeCmd.Flags().StringSlice("owner", nil,
+		"Also report the shares of these household members separately")
+	balanceCmd.Flags().String("save-as", "",
+		"Save the parameters of this report as a named preset on the server")
+	incomeCmd.Flags().String("save-as", "",
+		"Save the parameters of this report as a named preset on the server")
+	balanceCmd.Flags().StringP("output", "o", "",
+		"Write the report to a .csv or .xlsx file instead of printing it")
+	incomeCmd.Flags().StringP("output", "o", "",
+		"Write the report to a .csv or .xlsx file instead of printing it")
+	reportRunCmd.Flags().StringP("output", "o", "",
+		"Write the report to a .csv or .xlsx file instead of printing it")
+	reportRunCmd.Flags().StringP("date", "d", "",
+		"Override the date(s) or date range(s) saved in the preset")
+	reportRunCmd.Flags().StringSlice("owner", nil,
+		"Override the household members saved in the preset")
 	reportCmd.AddCommand(balanceCmd)
 	reportCmd.AddCommand(incomeCmd)
+	reportCmd.AddCommand(reportRunCmd)
+	reportCmd.AddCommand(reportLsCmd)
+	reportCmd.AddCommand(reportRmCmd)
 	rootCmd.AddCommand(reportCmd)
 }
 
-type ReportSchema struct {
-	Mapping    map[string][]string `json:"mapping"`
-	Order      []string            `json:"order"`
-	Formatters map[string][]string `json:"formatters"`
-}
-
-func readReportSchema(p string) (r ReportSchema, err error) {
+func readReportSchema(p string) (r bookkeeper.ReportSchema, err error) {
 	var (
 		f *os.File
 		b []byte
@@ -122,33 +160,108 @@ func generateBalanceSheet(cmd *cobra.Command, args []string) {
 	liabilityTags, err := cmd.Flags().GetStringSlice("liability-tags")
 	cobra.CheckErr(err)
 	dateStr, _ := cmd.Flags().GetString("date")
-	if dateStr == "" {
-		dateStr = time.Now().Format("2006/01/02")
-	}
 	reportSchemaPath, err := cmd.Flags().GetString("report-schema")
 	cobra.CheckErr(err)
 	reportSchema, err := readReportSchema(reportSchemaPath)
 	cobra.CheckErr(err)
-
-	url_ := fmt.Sprintf(
-		"%sreporting/balance_sheet?date=%s&assetTags=%s&liabilityTags=%s",
-		BASE_URL, url.QueryEscape(dateStr),
-		url.QueryEscape(strings.Join(assetTags, ",")),
-		url.QueryEscape(strings.Join(liabilityTags, ",")),
-	)
-	resp, err := http.Get(url_)
+	owners, err := cmd.Flags().GetStringSlice("owner")
 	cobra.CheckErr(err)
-	defer resp.Body.Close()
-	var balanceSheets []bookkeeper.BalanceSheet
-	json.NewDecoder(resp.Body).Decode(&balanceSheets)
-	var statements []bookkeeper.StatementWithFields
-	for _, bs := range balanceSheets {
-		statements = append(statements, bs)
+	preset := bookkeeper.ReportPreset{
+		Report: "balance",
+		Dates:  dateStr,
+		Tags: map[string][]string{
+			"assetTags":     assetTags,
+			"liabilityTags": liabilityTags,
+		},
+		Schema: &reportSchema,
+		Owners: owners,
 	}
-	err = printStatements(statements, reportSchema, dateStr)
+	output, err := cmd.Flags().GetString("output")
+	cobra.CheckErr(err)
+	saveReportPresetIfRequested(cmd, preset)
+	err = runBalanceSheet(preset, output)
 	cobra.CheckErr(err)
 }
 
+// runBalanceSheet prints the balance sheet, or writes it to output if it is
+// not empty
+func runBalanceSheet(preset bookkeeper.ReportPreset, output string) error {
+	dateStr := preset.Dates
+	if dateStr == "" {
+		dateStr = time.Now().Format("2006/01/02")
+	}
+	reportSchema := bookkeeper.BalanceSheetSchema(
+		preset.Tags["assetTags"], preset.Tags["liabilityTags"])
+	if preset.Schema != nil {
+		reportSchema = *preset.Schema
+	}
+
+	statements, headers, err := collectStatementsByOwner(
+		strings.Split(dateStr, ","), preset.Owners,
+		func(owner string) ([]bookkeeper.StatementWithFields, error) {
+			url_ := fmt.Sprintf(
+				"%sreporting/balance_sheet?date=%s&assetTags=%s&liabilityTags=%s&owner=%s",
+				BASE_URL, url.QueryEscape(dateStr),
+				url.QueryEscape(strings.Join(preset.Tags["assetTags"], ",")),
+				url.QueryEscape(strings.Join(preset.Tags["liabilityTags"], ",")),
+				url.QueryEscape(owner),
+			)
+			resp, err := http.Get(url_)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return nil, readApiError(resp, "failed to generate the balance sheet")
+			}
+			var balanceSheets []bookkeeper.BalanceSheet
+			json.NewDecoder(resp.Body).Decode(&balanceSheets)
+			var statements []bookkeeper.StatementWithFields
+			for _, bs := range balanceSheets {
+				statements = append(statements, bs)
+			}
+			return statements, nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	return outputStatements(statements, reportSchema, "Balance Sheet", headers, output)
+}
+
+// collectStatementsByOwner fetches the statements of the whole household and,
+// if any owners are given, those of every owner. The columns are ordered by
+// date, then by owner, with the combined figures last.
+func collectStatementsByOwner(
+	dates []string, owners []string,
+	fetch func(owner string) ([]bookkeeper.StatementWithFields, error),
+) (statements []bookkeeper.StatementWithFields, headers []string, err error) {
+	if len(owners) == 0 {
+		statements, err = fetch("")
+		return statements, dates, err
+	}
+	var byOwner [][]bookkeeper.StatementWithFields
+	for _, owner := range append(owners, "") {
+		var curr []bookkeeper.StatementWithFields
+		if curr, err = fetch(owner); err != nil {
+			return
+		}
+		if len(curr) != len(dates) {
+			err = fmt.Errorf("expected %d statements, got %d",
+				len(dates), len(curr))
+			return
+		}
+		byOwner = append(byOwner, curr)
+	}
+	for i, date := range dates {
+		for j, owner := range append(owners, "combined") {
+			statements = append(statements, byOwner[j][i])
+			headers = append(headers, fmt.Sprintf("%s (%s)", date, owner))
+		}
+	}
+	return
+}
+
 func buildTablewriterColors(formatters []string) tablewriter.Colors {
 	var colors tablewriter.Colors
 	for _, f := range formatters {
@@ -168,61 +281,36 @@ func buildTablewriterColors(formatters []string) tablewriter.Colors {
 	return colors
 }
 
-func printStatements(
+// outputStatements lays out the statements by the schema, and prints them as
+// a table, or writes them to output if it is not empty
+func outputStatements(
 	statements []bookkeeper.StatementWithFields,
-	reportSchema ReportSchema,
-	dateStr string,
+	reportSchema bookkeeper.ReportSchema,
+	title string,
+	columns []string,
+	output string,
 ) error {
+	t, err := reportSchema.Table(title, statements, columns)
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		return writeTableFile(output, t)
+	}
 	ac := accounting.Accounting{Symbol: "$", Precision: 2}
 	table := tablewriter.NewWriter(os.Stdout)
-	headers := []string{""}
-	headers = append(headers, strings.Split(dateStr, ",")...)
-	table.SetHeader(headers)
-	emptyRow := []string{}
-	for i := 0; i < len(headers); i++ {
-		emptyRow = append(emptyRow, "")
-	}
-	// append data
-	for _, itemName := range reportSchema.Order {
-		if itemName == "-" {
-			table.Append(emptyRow)
-			continue
-		}
-		tags, ok := reportSchema.Mapping[itemName]
-		if !ok {
-			return fmt.Errorf("missing item (%s) in mapping", itemName)
-		}
-		row := []string{itemName}
-		for _, statement := range statements {
-			var itemValue int64 = 0
-			for _, tag := range tags {
-				parts := strings.SplitN(tag, "/", 2)
-				if len(parts) != 2 {
-					return fmt.Errorf("invalid tag (%s) in schema", tag)
-				}
-				rg, ok := statement.GetFieldAsReportGroup(parts[0])
-				if ok {
-					if parts[1] == "TOTAL" {
-						itemValue += rg.Total
-					} else {
-						itemValue += rg.Groups[parts[1]]
-					}
-					continue
-				}
-				val, ok := statement.GetFieldAsInt64(parts[0])
-				if ok {
-					if parts[1] == "TOTAL" {
-						itemValue += val
-					}
-					continue
-				}
-				return fmt.Errorf("invalid tag (%s) in schema", tag)
+	table.SetHeader(t.Header)
+	for _, r := range t.Rows {
+		row := make([]string, len(t.Header))
+		for i, cell := range r.Cells {
+			if amount, ok := cell.(bookkeeper.Amount); ok {
+				row[i] = ac.FormatMoney(amount.Dollars())
+			} else {
+				row[i] = fmt.Sprint(cell)
 			}
-			row = append(row, ac.FormatMoney(float64(itemValue)/100))
 		}
-		itemFormatter, ok := reportSchema.Formatters[itemName]
-		if ok {
-			cellColors := buildTablewriterColors(itemFormatter)
+		if r.Formatters != nil {
+			cellColors := buildTablewriterColors(r.Formatters)
 			var colorSlice []tablewriter.Colors
 			for i := 0; i < len(row); i++ {
 				colorSlice = append(colorSlice, cellColors)
@@ -251,24 +339,135 @@ func generateIncomeStatement(cmd *cobra.Command, args []string) {
 	cobra.CheckErr(err)
 	reportSchema, err := readReportSchema(reportSchemaPath)
 	cobra.CheckErr(err)
+	owners, err := cmd.Flags().GetStringSlice("owner")
+	cobra.CheckErr(err)
+	preset := bookkeeper.ReportPreset{
+		Report: "income",
+		Dates:  dateRangeStr,
+		Tags: map[string][]string{
+			"revenueTags":     revenueTags,
+			"taxesTags":       taxesTags,
+			"expensesTags":    expensesTags,
+			"investmentsTags": investmentsTags,
+		},
+		Schema: &reportSchema,
+		Owners: owners,
+	}
+	output, err := cmd.Flags().GetString("output")
+	cobra.CheckErr(err)
+	saveReportPresetIfRequested(cmd, preset)
+	err = runIncomeStatement(preset, output)
+	cobra.CheckErr(err)
+}
 
-	url_ := fmt.Sprintf(
-		"%sreporting/income_statement?dateRange=%s&revenueTags=%s&taxesTags=%s&expensesTags=%s&investmentsTags=%s",
-		BASE_URL, url.QueryEscape(dateRangeStr),
-		url.QueryEscape(strings.Join(revenueTags, ",")),
-		url.QueryEscape(strings.Join(taxesTags, ",")),
-		url.QueryEscape(strings.Join(expensesTags, ",")),
-		url.QueryEscape(strings.Join(investmentsTags, ",")),
+// runIncomeStatement prints the income statement, or writes it to output if
+// it is not empty
+func runIncomeStatement(preset bookkeeper.ReportPreset, output string) error {
+	if preset.Dates == "" {
+		return fmt.Errorf("no date range is specified for the income statement")
+	}
+	reportSchema := bookkeeper.IncomeStatementSchema(
+		preset.Tags["revenueTags"], preset.Tags["taxesTags"],
+		preset.Tags["expensesTags"], preset.Tags["investmentsTags"])
+	if preset.Schema != nil {
+		reportSchema = *preset.Schema
+	}
+
+	statements, headers, err := collectStatementsByOwner(
+		strings.Split(preset.Dates, ","), preset.Owners,
+		func(owner string) ([]bookkeeper.StatementWithFields, error) {
+			url_ := fmt.Sprintf(
+				"%sreporting/income_statement?dateRange=%s&revenueTags=%s&taxesTags=%s&expensesTags=%s&investmentsTags=%s&owner=%s",
+				BASE_URL, url.QueryEscape(preset.Dates),
+				url.QueryEscape(strings.Join(preset.Tags["revenueTags"], ",")),
+				url.QueryEscape(strings.Join(preset.Tags["taxesTags"], ",")),
+				url.QueryEscape(strings.Join(preset.Tags["expensesTags"], ",")),
+				url.QueryEscape(strings.Join(preset.Tags["investmentsTags"], ",")),
+				url.QueryEscape(owner),
+			)
+			resp, err := http.Get(url_)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return nil, readApiError(resp, "failed to generate the income statement")
+			}
+			var isList []bookkeeper.IncomeStatement
+			json.NewDecoder(resp.Body).Decode(&isList)
+			var statements []bookkeeper.StatementWithFields
+			for _, is := range isList {
+				statements = append(statements, is)
+			}
+			return statements, nil
+		},
 	)
-	resp, err := http.Get(url_)
+	if err != nil {
+		return err
+	}
+	return outputStatements(statements, reportSchema, "Income Statement", headers, output)
+}
+
+func saveReportPresetIfRequested(
+	cmd *cobra.Command, preset bookkeeper.ReportPreset,
+) {
+	name, err := cmd.Flags().GetString("save-as")
+	cobra.CheckErr(err)
+	if name == "" {
+		return
+	}
+	preset.Name = name
+	err = putReportPreset(preset)
 	cobra.CheckErr(err)
-	defer resp.Body.Close()
-	var isList []bookkeeper.IncomeStatement
-	json.NewDecoder(resp.Body).Decode(&isList)
-	var statements []bookkeeper.StatementWithFields
-	for _, is := range isList {
-		statements = append(statements, is)
+	fmt.Printf("Saved report preset %s\n", name)
+}
+
+func runReport(cmd *cobra.Command, args []string) {
+	preset, err := getReportPreset(args[0])
+	cobra.CheckErr(err)
+	if cmd.Flags().Changed("date") {
+		preset.Dates, err = cmd.Flags().GetString("date")
+		cobra.CheckErr(err)
 	}
-	err = printStatements(statements, reportSchema, dateRangeStr)
+	if cmd.Flags().Changed("owner") {
+		preset.Owners, err = cmd.Flags().GetStringSlice("owner")
+		cobra.CheckErr(err)
+	}
+	output, err := cmd.Flags().GetString("output")
 	cobra.CheckErr(err)
+	switch preset.Report {
+	case "balance":
+		err = runBalanceSheet(preset, output)
+	case "income":
+		err = runIncomeStatement(preset, output)
+	default:
+		err = fmt.Errorf("invalid report type %s in preset %s",
+			preset.Report, preset.Name)
+	}
+	cobra.CheckErr(err)
+}
+
+func lsReportPresets(cmd *cobra.Command, args []string) {
+	presets, err := getAllReportPresets()
+	cobra.CheckErr(err)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "Report", "Dates", "Tags", "Owners", "Schema"})
+	table.SetAutoWrapText(false)
+	for _, p := range presets {
+		schema := "every tag and total"
+		if p.Schema != nil {
+			schema = fmt.Sprintf("%d rows", len(p.Schema.Order))
+		}
+		var tags []string
+		for key, values := range p.Tags {
+			tags = append(tags, fmt.Sprintf("%s: %s", key,
+				strings.Join(values, ", ")))
+		}
+		sort.Strings(tags)
+		table.Append([]string{
+			p.Name, p.Report, p.Dates, strings.Join(tags, "\n"),
+			strings.Join(p.Owners, ", "), schema,
+		})
+	}
+	table.Render()
 }
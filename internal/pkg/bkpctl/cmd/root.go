@@ -25,4 +25,9 @@ func Init() {
 	initTransCmd(rootCmd)
 	initReportCmd(rootCmd)
 	initRecordCmd(rootCmd)
+	initQueryCmd(rootCmd)
+	initSearchCmd(rootCmd)
+	initAuthCmd(rootCmd)
+	initWatchCmd(rootCmd)
+	initWebhookCmd(rootCmd)
 }
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search <terms>...",
+	Short: "Full-text search over the notes of transactions",
+	Long: `Search the notes of all transactions for the terms (English or Chinese)
+and list the matches from the most to the least relevant.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  searchTransactions,
+}
+
+func initSearchCmd(rootCmd *cobra.Command) {
+	searchCmd.Flags().IntP("limit", "n", 20, "Maximum number of results to show")
+	rootCmd.AddCommand(searchCmd)
+}
+
+func searchTransactions(cmd *cobra.Command, args []string) {
+	limit, err := cmd.Flags().GetInt("limit")
+	cobra.CheckErr(err)
+	terms := strings.Join(args, " ")
+	var results []bookkeeper.SearchResult
+	url_ := fmt.Sprintf("%ssearch?terms=%s&limit=%d", BASE_URL,
+		url.QueryEscape(terms), limit)
+	err = sendJsonRequest(http.MethodGet, url_, nil, &results)
+	cobra.CheckErr(err)
+	tablePrintSearchResults(results)
+}
+
+func tablePrintSearchResults(results []bookkeeper.SearchResult) {
+	highlight := color.New(color.FgYellow, color.Bold).SprintFunc()
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Rank", "Id", "Date", "Category", "Sub-Category", "Account Name",
+		"Amount", "Notes",
+	})
+	for _, r := range results {
+		// replace the <b></b> markers from the server with terminal colors
+		var notes strings.Builder
+		for i, part := range strings.Split(r.Headline, "<b>") {
+			if i == 0 {
+				notes.WriteString(part)
+				continue
+			}
+			matched := strings.SplitN(part, "</b>", 2)
+			notes.WriteString(highlight(matched[0]))
+			if len(matched) == 2 {
+				notes.WriteString(matched[1])
+			}
+		}
+		table.Append([]string{
+			fmt.Sprintf("%.3f", r.Rank), fmt.Sprintf("%d", r.Id),
+			r.Date.Format("2006/01/02"), r.Category, r.SubCategory,
+			r.AccountName, fmt.Sprintf("%.2f", float32(r.Amount)/100.0),
+			notes.String(),
+		})
+	}
+	table.Render()
+}
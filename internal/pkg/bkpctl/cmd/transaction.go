@@ -79,12 +79,34 @@ var transDeleteCmd = &cobra.Command{
 			}, &yes)
 		}
 		if yes {
-			err = deleteSingleTransaction(transId)
+			err = deleteSingleTransaction(transId, trans.Version)
 			cobra.CheckErr(err)
 		}
 	},
 }
 
+var transBulkUpdateCmd = &cobra.Command{
+	Use:   "bulk-update",
+	Short: "Set fields on every transaction that matches a query",
+	Long: `Set fields on every transaction that matches a query, e.g.
+  bkpctl trans bulk-update -q 'notes ~ "costco"' --set category=Shopping \
+    --set sub_category=Groceries
+Fields that can be set are category, sub_category, notes, and account (by
+name). The matches are previewed before asking for confirmation.`,
+	Args: cobra.NoArgs,
+	Run:  bulkUpdateTransactions,
+}
+
+var transAggCmd = &cobra.Command{
+	Use:   "agg",
+	Short: "Aggregate transactions into a pivot table",
+	Long: `Aggregate the transactions matching a query and render the result as a
+pivot table. The last group by key spreads across the columns, and the
+other keys make up the rows.`,
+	Args: cobra.NoArgs,
+	Run:  aggTransactions,
+}
+
 func parseQueryString(original string) (parsed string) {
 	phrases := strings.SplitN(original, "on", 2)
 	if len(phrases) < 2 {
@@ -105,6 +127,14 @@ func parseQueryString(original string) (parsed string) {
 
 func initTransCmd(rootCmd *cobra.Command) {
 	transLsCmd.Flags().StringP("query", "q", "", "Query string for transactions")
+	transLsCmd.Flags().StringP("saved", "s", "",
+		"Name of a query saved on the server (see the query command)")
+	transLsCmd.Flags().IntP("page-size", "n", 50,
+		"Number of transactions to show per page")
+	transLsCmd.Flags().Bool("all", false,
+		"List all matching transactions without paging")
+	transLsCmd.Flags().StringP("output", "o", "",
+		"Write all matching transactions to a .csv or .xlsx file instead")
 	transUpdateCmd.Flags().StringP(
 		"categories", "c", "",
 		"Path to the Category definition file (default: ./configs/category_map.json)",
@@ -121,8 +151,24 @@ func initTransCmd(rootCmd *cobra.Command) {
 	transDeleteCmd.MarkFlagRequired("id")
 	transDeleteCmd.Flags().BoolP("yes", "y", false, "Skip confirmation if set")
 	transReconCmd.MarkFlagRequired("account")
+	transBulkUpdateCmd.Flags().StringP("query", "q", "",
+		"Query string of the transactions to update")
+	transBulkUpdateCmd.MarkFlagRequired("query")
+	transBulkUpdateCmd.Flags().StringArray("set", nil,
+		"A field to set, as field=value; can be repeated")
+	transBulkUpdateCmd.MarkFlagRequired("set")
+	transBulkUpdateCmd.Flags().BoolP("yes", "y", false, "Skip confirmation if set")
+	transAggCmd.Flags().StringP("query", "q", "",
+		"Query string (in the API query language) to filter transactions")
+	transAggCmd.MarkFlagRequired("query")
+	transAggCmd.Flags().StringSliceP("group-by", "g", []string{"month"},
+		"Keys to group by (month, week, category, sub_category, account, type)")
+	transAggCmd.Flags().StringSliceP("aggregates", "a", []string{"sum"},
+		"Aggregates to compute (sum, count, avg, min, max)")
 	transCmd.AddCommand(transLsCmd)
+	transCmd.AddCommand(transAggCmd)
 	transCmd.AddCommand(transUpdateCmd)
+	transCmd.AddCommand(transBulkUpdateCmd)
 	transCmd.AddCommand(transReconCmd)
 	transCmd.AddCommand(transDeleteCmd)
 	rootCmd.AddCommand(transCmd)
@@ -136,13 +182,160 @@ func lsTransactions(cmd *cobra.Command, args []string) {
 
 	queryStr, err = cmd.Flags().GetString("query")
 	cobra.CheckErr(err)
-	if queryStr == "" {
-		queryStr = "past week"
+	savedName, err := cmd.Flags().GetString("saved")
+	cobra.CheckErr(err)
+	if savedName != "" {
+		// saved queries are already in the API query language
+		saved, err := getSavedQuery(savedName)
+		cobra.CheckErr(err)
+		queryStr = saved.QueryString
+	} else {
+		if queryStr == "" {
+			queryStr = "past week"
+		}
+		queryStr = parseQueryString(queryStr)
+	}
+	output, err := cmd.Flags().GetString("output")
+	cobra.CheckErr(err)
+	if output != "" {
+		transactions, err := getTransactionsByQuery(queryStr)
+		cobra.CheckErr(err)
+		err = writeTableFile(output, bookkeeper.TransactionsTable(transactions))
+		cobra.CheckErr(err)
+		return
+	}
+	all, err := cmd.Flags().GetBool("all")
+	cobra.CheckErr(err)
+	if all {
+		transactions, err := getTransactionsByQuery(queryStr)
+		cobra.CheckErr(err)
+		tablePrintTransactions(transactions)
+		return
+	}
+	pageSize, err := cmd.Flags().GetInt("page-size")
+	cobra.CheckErr(err)
+	shown := 0
+	url_ := transactionsQueryUrl(queryStr, pageSize)
+	for url_ != "" {
+		page, err := getTransactionsPage(url_)
+		cobra.CheckErr(err)
+		tablePrintTransactions(page.Transactions)
+		if len(page.Transactions) > 0 {
+			fmt.Printf("Showing %d-%d of %d transactions\n",
+				shown+1, shown+len(page.Transactions), page.Total)
+		}
+		shown += len(page.Transactions)
+		url_ = page.NextUrl
+		if url_ == "" {
+			break
+		}
+		more := false
+		survey.AskOne(&survey.Confirm{
+			Message: "Show the next page?",
+			Default: true,
+		}, &more)
+		if !more {
+			break
+		}
 	}
-	queryStr = parseQueryString(queryStr)
-	transactions, err := getTransactionsByQuery(queryStr)
+}
+
+func aggTransactions(cmd *cobra.Command, args []string) {
+	queryStr, err := cmd.Flags().GetString("query")
 	cobra.CheckErr(err)
-	tablePrintTransactions(transactions)
+	groupBy, err := cmd.Flags().GetStringSlice("group-by")
+	cobra.CheckErr(err)
+	aggregates, err := cmd.Flags().GetStringSlice("aggregates")
+	cobra.CheckErr(err)
+	rows, err := getTransactionAggregates(queryStr, groupBy, aggregates)
+	cobra.CheckErr(err)
+	tablePrintPivot(rows, groupBy, aggregates)
+}
+
+func tablePrintPivot(
+	rows []bookkeeper.AggregateRow, groupBy []string, aggregates []string,
+) {
+	ac := accounting.Accounting{Symbol: "$", Precision: 2}
+	formatValue := func(agg string, val int64) string {
+		if agg == "count" {
+			return fmt.Sprintf("%d", val)
+		}
+		return ac.FormatMoney(float64(val) / 100)
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	if len(groupBy) < 2 {
+		// nothing to pivot on; one column per aggregate
+		table.SetHeader(append(append([]string{}, groupBy...), aggregates...))
+		for _, r := range rows {
+			var row []string
+			for _, key := range groupBy {
+				row = append(row, r.Keys[key])
+			}
+			for _, agg := range aggregates {
+				row = append(row, formatValue(agg, r.Values[agg]))
+			}
+			table.Append(row)
+		}
+		table.Render()
+		return
+	}
+	rowKeys := groupBy[:len(groupBy)-1]
+	colKey := groupBy[len(groupBy)-1]
+	// rows keep the order of first appearance, which is that of the group
+	// keys, and columns are sorted by label
+	var (
+		rowLabels [][]string
+		colLabels []string
+	)
+	seenRows := make(map[string]bool)
+	seenCols := make(map[string]bool)
+	cells := make(map[string]map[string]bookkeeper.AggregateRow)
+	for _, r := range rows {
+		var labels []string
+		for _, key := range rowKeys {
+			labels = append(labels, r.Keys[key])
+		}
+		rowId := strings.Join(labels, "\x00")
+		if !seenRows[rowId] {
+			seenRows[rowId] = true
+			rowLabels = append(rowLabels, labels)
+			cells[rowId] = make(map[string]bookkeeper.AggregateRow)
+		}
+		col := r.Keys[colKey]
+		if !seenCols[col] {
+			seenCols[col] = true
+			colLabels = append(colLabels, col)
+		}
+		cells[rowId][col] = r
+	}
+	sort.Strings(colLabels)
+	header := append([]string{}, rowKeys...)
+	for _, col := range colLabels {
+		for _, agg := range aggregates {
+			if len(aggregates) > 1 {
+				header = append(header, fmt.Sprintf("%s (%s)", col, agg))
+			} else {
+				header = append(header, col)
+			}
+		}
+	}
+	table.SetHeader(header)
+	for _, labels := range rowLabels {
+		rowId := strings.Join(labels, "\x00")
+		row := append([]string{}, labels...)
+		for _, col := range colLabels {
+			r, ok := cells[rowId][col]
+			for _, agg := range aggregates {
+				if ok {
+					row = append(row, formatValue(agg, r.Values[agg]))
+				} else {
+					row = append(row, "")
+				}
+			}
+		}
+		table.Append(row)
+	}
+	table.Render()
 }
 
 func tablePrintTransactions(transactions []bookkeeper.Transaction_) {
@@ -163,6 +356,32 @@ func tablePrintTransactions(transactions []bookkeeper.Transaction_) {
 	table.Render()
 }
 
+// print the fields that differ between two versions of a transaction
+func tablePrintTransactionChanges(before bookkeeper.Transaction_, after bookkeeper.Transaction_) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Field", "Before", "After"})
+	fields := []struct {
+		name          string
+		before, after string
+	}{
+		{"Type", before.Type, after.Type},
+		{"Date", before.Date.Format("2006/01/02"), after.Date.Format("2006/01/02")},
+		{"Category", before.Category, after.Category},
+		{"Sub-Category", before.SubCategory, after.SubCategory},
+		{"Account Name", before.AccountName, after.AccountName},
+		{"Amount", fmt.Sprintf("%.2f", float32(before.Amount)/100.0),
+			fmt.Sprintf("%.2f", float32(after.Amount)/100.0)},
+		{"Notes", before.Notes, after.Notes},
+		{"Association Id", before.AssociationId, after.AssociationId},
+	}
+	for _, f := range fields {
+		if f.before != f.after {
+			table.Append([]string{f.name, f.before, f.after})
+		}
+	}
+	table.Render()
+}
+
 func tablePrintReconcile(transactions []bookkeeper.Transaction_, startingBalance int64) {
 	// sort transactions
 	sort.Slice(transactions, func(i, j int) bool {
@@ -203,13 +422,91 @@ func updateTransactions(cmd *cobra.Command, args []string) {
 	getAllAccounts(&accounts)
 
 	var entry JournalEntry
-	err = entry.InteractiveSingleUpdate(accounts, categoryMap)
+	base, err := entry.InteractiveSingleUpdate(accounts, categoryMap)
 	cobra.CheckErr(err)
-	if entry.InteractiveConfirm() {
+	for entry.InteractiveConfirm() {
 		fmt.Println("Updating the journal entry to the server...")
 		err = entry.PatchToServer()
+		if !isVersionConflict(err) {
+			cobra.CheckErr(err)
+			return
+		}
+		// someone else has updated the transaction since it was fetched; show
+		// what they changed and start over from their version
+		var current bookkeeper.Transaction_
+		err = getTransactionById(base.Id, &current)
 		cobra.CheckErr(err)
-	} else {
-		fmt.Println("No journal entries or transactions are updated.")
+		fmt.Println("The transaction has been changed on the server in the meantime:")
+		tablePrintTransactionChanges(base, current)
+		err = entry.InteractiveEditTransaction(accounts, categoryMap, current)
+		cobra.CheckErr(err)
+		base = current
 	}
+	fmt.Println("No journal entries or transactions are updated.")
+}
+
+// the number of matches to show before a bulk update
+const bulkUpdatePreviewSize = 20
+
+func parseTransactionPatch(sets []string) (patch bookkeeper.TransactionPatch, err error) {
+	for _, set := range sets {
+		parts := strings.SplitN(set, "=", 2)
+		if len(parts) != 2 {
+			return patch, fmt.Errorf("invalid --set %s, expecting field=value", set)
+		}
+		value := parts[1]
+		switch parts[0] {
+		case "category":
+			patch.Category = &value
+		case "sub_category":
+			patch.SubCategory = &value
+		case "notes":
+			patch.Notes = &value
+		case "account":
+			var account bookkeeper.Account
+			if account, err = getAccountByName(value); err != nil {
+				return
+			}
+			patch.AccountId = &account.Id
+		default:
+			return patch, fmt.Errorf("cannot set field %s", parts[0])
+		}
+	}
+	return
+}
+
+func bulkUpdateTransactions(cmd *cobra.Command, args []string) {
+	queryStr, err := cmd.Flags().GetString("query")
+	cobra.CheckErr(err)
+	sets, err := cmd.Flags().GetStringArray("set")
+	cobra.CheckErr(err)
+	yes, err := cmd.Flags().GetBool("yes")
+	cobra.CheckErr(err)
+	patch, err := parseTransactionPatch(sets)
+	cobra.CheckErr(err)
+
+	page, err := getTransactionsPage(
+		transactionsQueryUrl(queryStr, bulkUpdatePreviewSize))
+	cobra.CheckErr(err)
+	if page.Total == 0 {
+		fmt.Println("No transactions match the query")
+		return
+	}
+	tablePrintTransactions(page.Transactions)
+	if page.Total > len(page.Transactions) {
+		fmt.Printf("... and %d more\n", page.Total-len(page.Transactions))
+	}
+	fmt.Printf("%d transaction(s) will be updated with: %s\n",
+		page.Total, strings.Join(sets, ", "))
+	if !yes {
+		survey.AskOne(&survey.Confirm{
+			Message: "Are you sure that you want to update these transactions?",
+		}, &yes)
+	}
+	if !yes {
+		return
+	}
+	updated, err := patchTransactionsByQuery(queryStr, patch)
+	cobra.CheckErr(err)
+	fmt.Printf("Updated %d transaction(s)\n", updated)
 }
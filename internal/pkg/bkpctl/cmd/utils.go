@@ -2,9 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
 )
 
 type dateRange struct {
@@ -59,3 +65,38 @@ func dateRangeToQuery(dateRangeStr string) (parsed string, ok bool) {
 	)
 	return
 }
+
+// writeTableFile writes the table as CSV or XLSX, by the extension of the path
+func writeTableFile(p string, table bookkeeper.Table) error {
+	var write func(io.Writer) error
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".csv":
+		write = table.WriteCsv
+	case ".xlsx":
+		write = table.WriteXlsx
+	default:
+		return fmt.Errorf("cannot tell the format of %s; use .csv or .xlsx", p)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d rows to %s\n", len(table.Rows), p)
+	return nil
+}
+
+func stringInList(s string, l []string) bool {
+	for _, ss := range l {
+		if s == ss {
+			return true
+		}
+	}
+	return false
+}
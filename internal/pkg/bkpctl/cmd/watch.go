@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Tail the changes to transactions and accounts",
+	Long: `Print the transactions and accounts created, updated and deleted by
+anyone, as they happen, until interrupted. The feed picks up where it left off
+after a dropped connection.`,
+	Args: cobra.NoArgs,
+	Run:  watchEvents,
+}
+
+func initWatchCmd(rootCmd *cobra.Command) {
+	watchCmd.Flags().StringSlice("types", nil,
+		"Event types to show, e.g. transaction.created (default: all)")
+	watchCmd.Flags().Int64("since", -1,
+		"Also show the events after this event id (default: only new events)")
+	rootCmd.AddCommand(watchCmd)
+}
+
+// how long to wait before reconnecting, unless the server says otherwise
+const defaultWatchRetry = time.Second
+
+func watchEvents(cmd *cobra.Command, args []string) {
+	types, err := cmd.Flags().GetStringSlice("types")
+	cobra.CheckErr(err)
+	since, err := cmd.Flags().GetInt64("since")
+	cobra.CheckErr(err)
+	url_ := BASE_URL + "events"
+	if len(types) > 0 {
+		url_ += "?types=" + url.QueryEscape(strings.Join(types, ","))
+	}
+	lastId := ""
+	if since >= 0 {
+		lastId = strconv.FormatInt(since, 10)
+	}
+	retry := defaultWatchRetry
+	for {
+		err = readEventStream(url_, &lastId, &retry)
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.statusCode < 500 {
+			// e.g. an unknown event type or an expired token; retrying won't help
+			cobra.CheckErr(err)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Lost the event stream, reconnecting: %v\n", err)
+		}
+		time.Sleep(retry)
+	}
+}
+
+// readEventStream prints the events of one stream until the server ends it.
+// lastId and retry are updated as the stream goes, for the next reconnection.
+func readEventStream(url_ string, lastId *string, retry *time.Duration) error {
+	req, err := http.NewRequest(http.MethodGet, url_, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if *lastId != "" {
+		req.Header.Set("Last-Event-ID", *lastId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return readApiError(resp, "Failed to watch events")
+	}
+
+	var id, data string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// a blank line ends a message
+			if data != "" {
+				printEvent(data)
+			}
+			if id != "" {
+				*lastId = id
+			}
+			id, data = "", ""
+			continue
+		}
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "id":
+			id = value
+		case "data":
+			if data != "" {
+				data += "\n"
+			}
+			data += value
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil {
+				*retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func printEvent(data string) {
+	var event bookkeeper.Event
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		fmt.Fprintf(os.Stderr, "Skipping a malformed event: %v\n", err)
+		return
+	}
+	typeColor := color.New(color.FgGreen).SprintFunc()
+	switch {
+	case strings.HasSuffix(event.Type, ".updated"):
+		typeColor = color.New(color.FgYellow).SprintFunc()
+	case strings.HasSuffix(event.Type, ".deleted"):
+		typeColor = color.New(color.FgRed).SprintFunc()
+	}
+	fmt.Printf("%s %-20s #%-6d %s\n",
+		event.CreatedAt.Local().Format("2006/01/02 15:04:05"),
+		typeColor(event.Type), event.EntityId, summarizeEvent(event))
+}
+
+// a one-line description of the entity in an event, if the event carries it
+func summarizeEvent(event bookkeeper.Event) string {
+	if len(event.Data) == 0 {
+		return ""
+	}
+	switch {
+	case strings.HasPrefix(event.Type, "transaction."):
+		var trans bookkeeper.Transaction
+		if json.Unmarshal(event.Data, &trans) != nil {
+			return ""
+		}
+		return fmt.Sprintf("%s %s/%s account #%d %.2f %s",
+			trans.Date.Format(BKPCTL_DATE_FORMAT), trans.Category,
+			trans.SubCategory, trans.AccountId,
+			float32(trans.Amount)/100.0, trans.Notes)
+	case strings.HasPrefix(event.Type, "account."):
+		var account bookkeeper.Account
+		if json.Unmarshal(event.Data, &account) != nil {
+			return ""
+		}
+		return account.Name
+	}
+	return ""
+}
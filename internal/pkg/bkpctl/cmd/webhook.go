@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var webhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Have the server POST the changes to transactions and accounts to a URL",
+	Long: `Manage webhooks. The server POSTs every event of the change feed (see
+bkpctl watch) to the URL of every webhook that subscribes to its type, signed
+with the secret of the webhook in the X-Bookkeeper-Signature header
+(sha256=<hex HMAC-SHA256 of the body>). Failed deliveries are retried with
+exponential backoff.`,
+}
+var webhookAddCmd = &cobra.Command{
+	Use:   "add <url>",
+	Short: "Add a webhook, which gets the events from now on",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		types, err := cmd.Flags().GetStringSlice("types")
+		cobra.CheckErr(err)
+		secret, err := cmd.Flags().GetString("secret")
+		cobra.CheckErr(err)
+		if types == nil {
+			types = []string{}
+		}
+		webhook := bookkeeper.Webhook{Url: args[0], EventTypes: types, Secret: secret}
+		err = sendJsonRequest(http.MethodPost, BASE_URL+"webhooks", webhook, &webhook)
+		cobra.CheckErr(err)
+		fmt.Printf("Added webhook %d. Its secret will not be shown again:\n%s\n",
+			webhook.Id, webhook.Secret)
+	},
+}
+var webhookLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the webhooks",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var webhooks []bookkeeper.Webhook
+		err := sendJsonRequest(http.MethodGet, BASE_URL+"webhooks", nil, &webhooks)
+		cobra.CheckErr(err)
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Id", "Url", "Events", "Last Event", "Created"})
+		for _, w := range webhooks {
+			events := strings.Join(w.EventTypes, ", ")
+			if events == "" {
+				events = "all"
+			}
+			table.Append([]string{
+				fmt.Sprintf("%d", w.Id), w.Url, events,
+				fmt.Sprintf("%d", w.LastEventId), w.CreatedAt.Format("2006/01/02"),
+			})
+		}
+		table.Render()
+	},
+}
+var webhookRmCmd = &cobra.Command{
+	Use:   "rm <id>",
+	Short: "Remove a webhook and its delivery log",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		cobra.CheckErr(err)
+		err = sendJsonRequest(http.MethodDelete,
+			fmt.Sprintf("%swebhooks/%d", BASE_URL, id), nil, nil)
+		cobra.CheckErr(err)
+	},
+}
+var webhookLogCmd = &cobra.Command{
+	Use:   "log <id>",
+	Short: "Show the latest delivery attempts to a webhook",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		cobra.CheckErr(err)
+		limit, err := cmd.Flags().GetInt("limit")
+		cobra.CheckErr(err)
+		var deliveries []bookkeeper.WebhookDelivery
+		err = sendJsonRequest(http.MethodGet,
+			fmt.Sprintf("%swebhooks/%d/deliveries?limit=%d", BASE_URL, id, limit),
+			nil, &deliveries)
+		cobra.CheckErr(err)
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{
+			"Time", "Event", "Type", "Attempt", "Status", "Duration", "Error",
+		})
+		for _, d := range deliveries {
+			status := "-"
+			if d.StatusCode != 0 {
+				status = strconv.Itoa(d.StatusCode)
+			}
+			table.Append([]string{
+				d.CreatedAt.Local().Format("2006/01/02 15:04:05"),
+				fmt.Sprintf("%d", d.EventId), d.EventType,
+				fmt.Sprintf("%d", d.Attempt), status,
+				fmt.Sprintf("%dms", d.DurationMs), d.Error,
+			})
+		}
+		table.Render()
+	},
+}
+
+func initWebhookCmd(rootCmd *cobra.Command) {
+	webhookAddCmd.Flags().StringSlice("types", nil,
+		"Event types to deliver, e.g. transaction.created (default: all)")
+	webhookAddCmd.Flags().String("secret", "",
+		"Secret to sign the deliveries with (default: generated)")
+	webhookLogCmd.Flags().IntP("limit", "n", 20, "Maximum number of attempts to show")
+	webhookCmd.AddCommand(webhookAddCmd)
+	webhookCmd.AddCommand(webhookLsCmd)
+	webhookCmd.AddCommand(webhookRmCmd)
+	webhookCmd.AddCommand(webhookLogCmd)
+	rootCmd.AddCommand(webhookCmd)
+}
@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/lirenzhucn/bookkeeper/internal/pkg/api"
 	"github.com/lirenzhucn/bookkeeper/internal/pkg/bookkeeper"
@@ -15,17 +19,38 @@ var rootCmd = &cobra.Command{
 	Use:   "bkpsrv",
 	Short: "The bookkeeper server",
 	Long: `The bookkeeper server (bkpsrv) provides a RESTful API endpoint for
-keeping financial records and getting various reports.`,
+keeping financial records and getting various reports, and the same
+operations as a gRPC service on a separate port. A web dashboard is served
+at /dashboard/.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		sugar := zap.L().Sugar()
 		defer sugar.Sync()
 		sugar.Infow("running server",
 			"port", cmd.Flags().Lookup("port").Value.String())
-		port, err := cmd.Flags().GetInt("port")
+		config := api.DefaultConfig()
+		var err error
+		config.Port, err = cmd.Flags().GetInt("port")
 		cobra.CheckErr(err)
 		db_url, err := cmd.Flags().GetString("db-url")
 		cobra.CheckErr(err)
-		api.HandleRequests(fmt.Sprintf("%d", port), db_url)
+		config.GrpcPort, err = cmd.Flags().GetInt("grpc-port")
+		cobra.CheckErr(err)
+		config.DisableAuth, err = cmd.Flags().GetBool("disable-auth")
+		cobra.CheckErr(err)
+		config.CorsOrigins, err = cmd.Flags().GetStringSlice("cors-origins")
+		cobra.CheckErr(err)
+
+		// requests in flight are given time to finish on SIGINT or SIGTERM
+		ctx, stop := signal.NotifyContext(
+			context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		db, err := api.ConnectDb(ctx, db_url)
+		if err != nil {
+			cobra.CheckErr(fmt.Errorf("failed to connect to database: %w", err))
+		}
+		defer db.Close()
+		err = api.NewServer(db, config, sugar).Run(ctx)
+		cobra.CheckErr(err)
 	},
 }
 
@@ -38,7 +63,13 @@ func Init() {
 	rootCmd.Flags().StringVar(&cfgFile, "config", "",
 		"config file (default is ./configs/private/.bkpsrv.yaml)")
 	rootCmd.Flags().IntP("port", "p", 10000, "the port of the server")
+	rootCmd.Flags().Int("grpc-port", 10001,
+		"the port of the gRPC service (0 to disable it)")
 	rootCmd.Flags().StringP("db-url", "d", "", "URL to the database service")
+	rootCmd.Flags().Bool("disable-auth", false,
+		"serve every request without a token (only for local development)")
+	rootCmd.Flags().StringSlice("cors-origins", nil,
+		"the origins of other sites whose pages may call the API, or * for any")
 }
 
 func initConfig() {
@@ -1,18 +1,115 @@
 package bookkeeper
 
+import (
+	"fmt"
+	"math"
+)
+
 // Notes on tags: use an array column and a GIN-index in Postgres is proven to
 // be faster than table join
 type Account struct {
-	Id   int      `json:"id"`
-	Name string   `json:"name"`
-	Desc string   `json:"desc_"`
-	Tags []string `json:"tags"`
+	Id      int            `json:"id"`
+	Name    string         `json:"name"`
+	Desc    string         `json:"desc_"`
+	Tags    []string       `json:"tags"`
+	Owners  []AccountOwner `json:"owners"`  // empty if owned by the whole household
+	Version int            `json:"version"` // Bumped by every update
+}
+
+// A household member's share of an account. Shares are fractions of 1, and
+// the shares of all owners of an account add up to 1.
+type AccountOwner struct {
+	UserId   int     `json:"user_id"`
+	UserName string  `json:"user_name"`
+	Share    float64 `json:"share"`
+}
+
+// tolerance for shares like 1/3 that cannot be written out exactly
+const shareEpsilon = 1e-4
+
+func (account *Account) Validate() error {
+	var verr ValidationError
+	// the balance sheet counts an account by its class, so it must have
+	// exactly one
+	isAsset := stringInList("asset", account.Tags)
+	isLiability := stringInList("liability", account.Tags)
+	if !isAsset && !isLiability {
+		verr.Add("tags", `must contain either "asset" or "liability"`)
+	} else if isAsset && isLiability {
+		verr.Add("tags", `must not contain both "asset" and "liability"`)
+	}
+	if len(account.Owners) > 0 {
+		var total float64
+		for i, o := range account.Owners {
+			if o.UserId == 0 && o.UserName == "" {
+				verr.Add(fmt.Sprintf("owners[%d]", i),
+					"user_id or user_name is required")
+			}
+			if o.Share <= 0 || o.Share > 1 {
+				verr.Add(fmt.Sprintf("owners[%d].share", i),
+					"must be greater than 0 and at most 1")
+			}
+			total += o.Share
+		}
+		if math.Abs(total-1) > shareEpsilon {
+			verr.Add("owners", "shares must add up to 1")
+		}
+	}
+	return verr.OrNil()
+}
+
+// ShareOf returns the share of the named member in the account. Accounts
+// without owners belong to the whole household, and nobody owns a share of
+// them; reports split them evenly, see ApportionBalances.
+func (account *Account) ShareOf(userName string) float64 {
+	for _, o := range account.Owners {
+		if o.UserName == userName {
+			return o.Share
+		}
+	}
+	return 0
 }
 
-func (account *Account) Validate() bool {
-	valid := stringInList("asset", account.Tags) ||
-		stringInList("liability", account.Tags)
-	return valid
+// AllowsPostingBy tells if the member can post transactions to the account
+func (account *Account) AllowsPostingBy(userId int) bool {
+	if len(account.Owners) == 0 {
+		return true
+	}
+	for _, o := range account.Owners {
+		if o.UserId == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// Class tells if the account is an "asset" or a "liability" account, or ""
+// if it is neither
+func (account *Account) Class() string {
+	for _, class := range []string{"asset", "liability"} {
+		if stringInList(class, account.Tags) {
+			return class
+		}
+	}
+	return ""
+}
+
+// What merging an account into another does, or would do on a dry run
+type AccountMerge struct {
+	From Account `json:"from"` // Deleted by the merge
+	Into Account `json:"into"`
+	// Transactions moved from one account to the other
+	NumTransactions int `json:"num_transactions"`
+	// Of the transactions moved, the legs of transfers; their association ids
+	// are kept, so the transfers stay paired
+	NumTransfers int `json:"num_transfers"`
+	// Transfers between the two accounts, both legs of which end up in the
+	// surviving account
+	NumInternalTransfers int   `json:"num_internal_transfers"`
+	Amount               int64 `json:"amount"` // Sum of the transactions moved
+	// Ids of the transactions moved; empty on a dry run
+	TransactionIds []int `json:"transaction_ids"`
+	DryRun         bool  `json:"dry_run"`
 }
 
 func GetSqlCreateAccounts() string {
@@ -21,6 +118,23 @@ func GetSqlCreateAccounts() string {
 		name text,
 		desc_ text,
 		tags text[],
+		version int not null default 1,
 		primary key(id)
 	);`
 }
+
+func GetSqlCreateAccountOwners() string {
+	return `create table account_owners (
+		account_id int not null,
+		user_id    int not null,
+		share      double precision not null check (share > 0 and share <= 1),
+		primary key(account_id, user_id),
+		constraint fk_account
+			foreign key(account_id)
+				references accounts(id)
+				on delete cascade,
+		constraint fk_user
+			foreign key(user_id)
+				references users(id)
+	);`
+}
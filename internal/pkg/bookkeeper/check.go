@@ -0,0 +1,446 @@
+package bookkeeper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Kinds of the problems found by CheckLedger
+const (
+	FindingUnbalancedTransfer  = "unbalanced-transfer"
+	FindingUnpairedTransfer    = "unpaired-transfer"
+	FindingInvalidType         = "invalid-type"
+	FindingWrongSign           = "wrong-sign"
+	FindingUnknownCategory     = "unknown-category"
+	FindingUnclassifiedAccount = "unclassified-account"
+)
+
+// A problem in the ledger that reports would silently get wrong
+type Finding struct {
+	Kind           string
+	TransactionIds []int // the transactions involved, if any
+	AccountId      int   // the account involved, or 0
+	Problem        string
+	Suggestion     string // how to fix it by hand
+	// what RepairFindings does about it, or "" if it needs a human decision
+	Repair     string
+	repairSql  string
+	repairArgs []interface{}
+}
+
+// CheckLedger scans the whole database for transfers that do not balance or
+// have no partner, transactions of invalid types or with amounts of the
+// wrong sign, In and Out transactions of categories missing from categories
+// (sub-categories by category), and accounts that are neither an asset nor a
+// liability
+func CheckLedger(
+	ctx context.Context, dbpool *pgxpool.Pool, categories map[string][]string,
+) ([]Finding, error) {
+	var findings []Finding
+	for _, check := range []func(context.Context, *pgxpool.Pool) ([]Finding, error){
+		checkTransactionTypes,
+		checkTransactionSigns,
+		checkTransfers,
+		func(ctx context.Context, dbpool *pgxpool.Pool) ([]Finding, error) {
+			return checkCategories(ctx, dbpool, categories)
+		},
+		checkAccountClasses,
+	} {
+		found, err := check(ctx, dbpool)
+		if err != nil {
+			return findings, wrapDbError(err)
+		}
+		findings = append(findings, found...)
+	}
+	return findings, nil
+}
+
+// RepairFindings applies the safe repairs of the findings in one database
+// transaction, and returns the number of findings repaired. A repair only
+// changes rows that are still as they were found, so a stale finding is
+// skipped rather than applied twice. The repaired transactions are recorded
+// in the change feed in the same transaction.
+func RepairFindings(ctx context.Context, dbpool *pgxpool.Pool, findings []Finding) (int, error) {
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	n := 0
+	var events []Event
+	for _, f := range findings {
+		if f.repairSql == "" {
+			continue
+		}
+		// the repairs only apply to rows still as they were found
+		repaired, err := scanTransactions(tx.Query(ctx, f.repairSql+`
+returning id, type, date, category, sub_category, account_id, amount, notes, association_id, version`,
+			f.repairArgs...))
+		if err != nil {
+			return 0, wrapDbError(err)
+		}
+		if len(repaired) > 0 {
+			n++
+		}
+		for _, t := range repaired {
+			data, err := json.Marshal(t)
+			if err != nil {
+				return 0, err
+			}
+			events = append(events, Event{
+				Type: EventTransactionUpdated, EntityId: t.Id, Data: data,
+			})
+		}
+	}
+	if len(events) > 0 {
+		if err = InsertEvents(ctx, tx, events); err != nil {
+			return 0, err
+		}
+	}
+	return n, wrapDbError(tx.Commit(ctx))
+}
+
+// types that differ from a valid one only in case or spaces are repaired
+func checkTransactionTypes(ctx context.Context, dbpool *pgxpool.Pool) ([]Finding, error) {
+	rows, err := dbpool.Query(ctx,
+		`select type, array_agg(id order by id) from transactions
+where not (type = any($1)) or type is null
+group by type`,
+		VALID_TRANSACTION_TYPES)
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	err = forEachRow(rows, func(row pgx.Rows) error {
+		var (
+			transType *string
+			ids       []int32
+		)
+		if err := row.Scan(&transType, &ids); err != nil {
+			return err
+		}
+		f := Finding{
+			Kind:           FindingInvalidType,
+			TransactionIds: intsOf(ids),
+			Suggestion: fmt.Sprintf("set the type to one of %s",
+				strings.Join(VALID_TRANSACTION_TYPES, ", ")),
+		}
+		if transType == nil {
+			f.Problem = "the type is missing"
+		} else {
+			f.Problem = fmt.Sprintf("%q is not a valid type", *transType)
+			if valid, found := matchTransactionType(*transType); found {
+				f.Suggestion = fmt.Sprintf("set the type to %s", valid)
+				f.Repair = f.Suggestion
+				f.repairSql = `update transactions set type = $1, version = version + 1
+where id = any($2) and type = $3`
+				f.repairArgs = []interface{}{valid, ids, *transType}
+			}
+		}
+		findings = append(findings, f)
+		return nil
+	})
+	return findings, err
+}
+
+// find the valid type that differs from the given one only in case and spaces
+func matchTransactionType(transType string) (string, bool) {
+	for _, valid := range VALID_TRANSACTION_TYPES {
+		if strings.EqualFold(strings.Join(strings.Fields(transType), ""), valid) {
+			return valid, true
+		}
+	}
+	return "", false
+}
+
+// the signs of transfer legs are repaired by checkTransfers when that
+// balances them; the others may be refunds or corrections, and are left to a
+// human
+func checkTransactionSigns(ctx context.Context, dbpool *pgxpool.Pool) ([]Finding, error) {
+	var positive, negative []string
+	for t, sign := range TRANSACTION_TYPE_SIGNS {
+		if sign > 0 {
+			positive = append(positive, t)
+		} else {
+			negative = append(negative, t)
+		}
+	}
+	rows, err := dbpool.Query(ctx,
+		`select id, type, amount from transactions
+where (type = any($1) and amount < 0) or (type = any($2) and amount > 0)
+order by id`,
+		positive, negative)
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	err = forEachRow(rows, func(row pgx.Rows) error {
+		var (
+			id        int
+			transType string
+			amount    int64
+		)
+		if err := row.Scan(&id, &transType, &amount); err != nil {
+			return err
+		}
+		f := Finding{
+			Kind:           FindingWrongSign,
+			TransactionIds: []int{id},
+			Problem: fmt.Sprintf("%s transactions must not be %s, but the amount is %s",
+				transType, signWord(amount), formatCents(amount)),
+			Suggestion: fmt.Sprintf("change the amount to %s", formatCents(-amount)),
+		}
+		switch transType {
+		case "Out":
+			f.Suggestion += ", or change the type to In if it is a refund"
+		case "In":
+			f.Suggestion += ", or change the type to Out if it is a payment"
+		}
+		findings = append(findings, f)
+		return nil
+	})
+	return findings, err
+}
+
+func checkTransfers(ctx context.Context, dbpool *pgxpool.Pool) ([]Finding, error) {
+	var findings []Finding
+	// transfers without an association id have no partner by definition
+	rows, err := dbpool.Query(ctx,
+		`select id, type from transactions
+where type in ('TransferIn', 'TransferOut') and coalesce(association_id, '') = ''
+order by id`)
+	if err != nil {
+		return nil, err
+	}
+	err = forEachRow(rows, func(row pgx.Rows) error {
+		var (
+			id        int
+			transType string
+		)
+		if err := row.Scan(&id, &transType); err != nil {
+			return err
+		}
+		findings = append(findings, Finding{
+			Kind:           FindingUnpairedTransfer,
+			TransactionIds: []int{id},
+			Problem:        fmt.Sprintf("the %s has no association id", transType),
+			Suggestion: "set the association id to that of the other leg, " +
+				"or add the other leg with a new association id",
+		})
+		return nil
+	})
+	if err != nil {
+		return findings, err
+	}
+	rows, err = dbpool.Query(ctx,
+		`select association_id, array_agg(id order by id), array_agg(type order by id),
+	array_agg(amount order by id)
+from transactions
+where coalesce(association_id, '') <> ''
+group by association_id
+having sum(amount) <> 0
+	or count(*) filter (where type = 'TransferIn') = 0
+	or count(*) filter (where type = 'TransferOut') = 0
+order by min(id)`)
+	if err != nil {
+		return findings, err
+	}
+	err = forEachRow(rows, func(row pgx.Rows) error {
+		var (
+			associationId string
+			ids           []int32
+			types         []string
+			amounts       []int64
+		)
+		if err := row.Scan(&associationId, &ids, &types, &amounts); err != nil {
+			return err
+		}
+		findings = append(findings, transferFinding(associationId, ids, types, amounts))
+		return nil
+	})
+	return findings, err
+}
+
+func transferFinding(associationId string, ids []int32, types []string, amounts []int64) Finding {
+	f := Finding{TransactionIds: intsOf(ids)}
+	var sum int64
+	legs := make(map[string]int)
+	for i, t := range types {
+		sum += amounts[i]
+		legs[t]++
+	}
+	if legs["TransferIn"] == 0 || legs["TransferOut"] == 0 {
+		f.Kind = FindingUnpairedTransfer
+		missing := "TransferIn"
+		if legs["TransferIn"] > 0 {
+			missing = "TransferOut"
+		}
+		f.Problem = fmt.Sprintf("association id %s has no %s", associationId, missing)
+		f.Suggestion = fmt.Sprintf("add the %s of %s to the other account", missing,
+			formatCents(-sum))
+		return f
+	}
+	f.Kind = FindingUnbalancedTransfer
+	f.Problem = fmt.Sprintf("the amounts of association id %s add up to %s",
+		associationId, formatCents(sum))
+	f.Suggestion = "correct the amount of the leg that is off"
+	// a leg of the wrong sign that balances the transfer once flipped is
+	// surely a typo
+	for i, t := range types {
+		wrongSign := amounts[i]*int64(TRANSACTION_TYPE_SIGNS[t]) < 0
+		if wrongSign && sum-2*amounts[i] == 0 {
+			f.Suggestion = fmt.Sprintf("change the amount of transaction %d to %s",
+				ids[i], formatCents(-amounts[i]))
+			f.Repair = f.Suggestion
+			f.repairSql = `update transactions set amount = $2, version = version + 1
+where id = $1 and amount = $3`
+			f.repairArgs = []interface{}{ids[i], -amounts[i], amounts[i]}
+			break
+		}
+	}
+	return f
+}
+
+// categories that differ from those in the map only in case or surrounding
+// spaces are repaired
+func checkCategories(
+	ctx context.Context, dbpool *pgxpool.Pool, categories map[string][]string,
+) ([]Finding, error) {
+	rows, err := dbpool.Query(ctx,
+		`select coalesce(category, ''), coalesce(sub_category, ''), array_agg(id order by id)
+from transactions
+where type in ('In', 'Out')
+group by 1, 2
+order by 1, 2`)
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	err = forEachRow(rows, func(row pgx.Rows) error {
+		var (
+			category, subCategory string
+			ids                   []int32
+		)
+		if err := row.Scan(&category, &subCategory, &ids); err != nil {
+			return err
+		}
+		subCategories, ok := categories[category]
+		if ok && stringInList(subCategory, subCategories) {
+			return nil
+		}
+		f := Finding{Kind: FindingUnknownCategory, TransactionIds: intsOf(ids)}
+		if ok {
+			f.Problem = fmt.Sprintf("%q is not a sub-category of %q", subCategory, category)
+			f.Suggestion = fmt.Sprintf("use one of %s, or add %q to the category map",
+				strings.Join(subCategories, ", "), subCategory)
+		} else {
+			f.Problem = fmt.Sprintf("%q is not in the category map", category)
+			f.Suggestion = fmt.Sprintf("use one of %s, or add %q to the category map",
+				strings.Join(sortedKeys(categories), ", "), category)
+		}
+		if c, sc, found := matchCategory(categories, category, subCategory); found {
+			f.Suggestion = fmt.Sprintf("change the category to %s/%s", c, sc)
+			f.Repair = f.Suggestion
+			f.repairSql = `update transactions set category = $1, sub_category = $2,
+	version = version + 1
+where id = any($3) and coalesce(category, '') = $4 and coalesce(sub_category, '') = $5`
+			f.repairArgs = []interface{}{c, sc, ids, category, subCategory}
+		}
+		findings = append(findings, f)
+		return nil
+	})
+	return findings, err
+}
+
+// find the category and sub-category in the map that differ from the given
+// ones only in case and surrounding spaces
+func matchCategory(
+	categories map[string][]string, category string, subCategory string,
+) (string, string, bool) {
+	for c, subCategories := range categories {
+		if !strings.EqualFold(c, strings.TrimSpace(category)) {
+			continue
+		}
+		for _, sc := range subCategories {
+			if strings.EqualFold(sc, strings.TrimSpace(subCategory)) {
+				return c, sc, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func checkAccountClasses(ctx context.Context, dbpool *pgxpool.Pool) ([]Finding, error) {
+	rows, err := dbpool.Query(ctx, "select id, name, tags from accounts order by id")
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	err = forEachRow(rows, func(row pgx.Rows) error {
+		var a Account
+		if err := row.Scan(&a.Id, &a.Name, &a.Tags); err != nil {
+			return err
+		}
+		isAsset := stringInList("asset", a.Tags)
+		isLiability := stringInList("liability", a.Tags)
+		f := Finding{Kind: FindingUnclassifiedAccount, AccountId: a.Id}
+		switch {
+		case !isAsset && !isLiability:
+			f.Problem = fmt.Sprintf("%s is neither an asset nor a liability", a.Name)
+			f.Suggestion = fmt.Sprintf("bkpctl account tag add '%s' asset (or liability)", a.Name)
+		case isAsset && isLiability:
+			f.Problem = fmt.Sprintf("%s is both an asset and a liability", a.Name)
+			f.Suggestion = fmt.Sprintf("bkpctl account tag rm '%s' liability (or asset)", a.Name)
+		default:
+			return nil
+		}
+		findings = append(findings, f)
+		return nil
+	})
+	return findings, err
+}
+
+// call fn on every row, and close them
+func forEachRow(rows pgx.Rows, fn func(pgx.Rows) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func intsOf(ids []int32) []int {
+	res := make([]int, len(ids))
+	for i, id := range ids {
+		res[i] = int(id)
+	}
+	return res
+}
+
+func sortedKeys(m map[string][]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func signWord(amount int64) string {
+	if amount > 0 {
+		return "positive"
+	}
+	return "negative"
+}
+
+func formatCents(amount int64) string {
+	return fmt.Sprintf("%.2f", float64(amount)/100)
+}
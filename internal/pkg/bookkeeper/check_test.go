@@ -0,0 +1,134 @@
+package bookkeeper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchTransactionType(t *testing.T) {
+	cases := []struct {
+		transType string
+		want      string
+		found     bool
+	}{
+		{"Out", "Out", true},
+		{"out", "Out", true},
+		{" transferin ", "TransferIn", true},
+		{"Transfer Out", "TransferOut", true},
+		{"LIABILITY CHANGE", "LiabilityChange", true},
+		{"Spend", "", false},
+		{"Transfer", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		got, found := matchTransactionType(c.transType)
+		if got != c.want || found != c.found {
+			t.Errorf("matchTransactionType(%q) = %q, %v; want %q, %v",
+				c.transType, got, found, c.want, c.found)
+		}
+	}
+}
+
+func TestMatchCategory(t *testing.T) {
+	categories := map[string][]string{
+		"Food & Dining": {"Groceries", "Restaurants"},
+		"Auto":          {"Gas"},
+	}
+	cases := []struct {
+		category, subCategory string
+		wantCategory, wantSub string
+		found                 bool
+	}{
+		{"food & dining", "groceries", "Food & Dining", "Groceries", true},
+		{" Auto ", "GAS ", "Auto", "Gas", true},
+		{"Food & Dining", "Gas", "", "", false},
+		{"Food and Dining", "Groceries", "", "", false},
+		{"", "", "", "", false},
+	}
+	for _, c := range cases {
+		category, subCategory, found := matchCategory(categories, c.category, c.subCategory)
+		if category != c.wantCategory || subCategory != c.wantSub || found != c.found {
+			t.Errorf("matchCategory(%q, %q) = %q, %q, %v; want %q, %q, %v",
+				c.category, c.subCategory, category, subCategory, found,
+				c.wantCategory, c.wantSub, c.found)
+		}
+	}
+}
+
+func TestTransferFinding(t *testing.T) {
+	cases := []struct {
+		name       string
+		ids        []int32
+		types      []string
+		amounts    []int64
+		kind       string
+		repairArgs []interface{} // nil if there is no repair
+	}{
+		{
+			name:    "missing transfer in",
+			ids:     []int32{1},
+			types:   []string{"TransferOut"},
+			amounts: []int64{-100},
+			kind:    FindingUnpairedTransfer,
+		},
+		{
+			name:    "missing transfer out",
+			ids:     []int32{1, 2},
+			types:   []string{"TransferIn", "TransferIn"},
+			amounts: []int64{100, 200},
+			kind:    FindingUnpairedTransfer,
+		},
+		{
+			name:       "transfer out of the wrong sign",
+			ids:        []int32{3, 4},
+			types:      []string{"TransferOut", "TransferIn"},
+			amounts:    []int64{100, 100},
+			kind:       FindingUnbalancedTransfer,
+			repairArgs: []interface{}{int32(3), int64(-100), int64(100)},
+		},
+		{
+			name:       "transfer in of the wrong sign",
+			ids:        []int32{5, 6},
+			types:      []string{"TransferOut", "TransferIn"},
+			amounts:    []int64{-100, -100},
+			kind:       FindingUnbalancedTransfer,
+			repairArgs: []interface{}{int32(6), int64(100), int64(-100)},
+		},
+		{
+			name:    "amounts that differ",
+			ids:     []int32{7, 8},
+			types:   []string{"TransferOut", "TransferIn"},
+			amounts: []int64{-100, 90},
+			kind:    FindingUnbalancedTransfer,
+		},
+		{
+			name:    "wrong sign that does not balance once flipped",
+			ids:     []int32{9, 10},
+			types:   []string{"TransferOut", "TransferIn"},
+			amounts: []int64{100, 90},
+			kind:    FindingUnbalancedTransfer,
+		},
+	}
+	for _, c := range cases {
+		f := transferFinding("a", c.ids, c.types, c.amounts)
+		if f.Kind != c.kind {
+			t.Errorf("%s: kind is %s; want %s", c.name, f.Kind, c.kind)
+		}
+		if !reflect.DeepEqual(f.TransactionIds, intsOf(c.ids)) {
+			t.Errorf("%s: transaction ids are %v; want %v", c.name, f.TransactionIds, c.ids)
+		}
+		if c.repairArgs == nil {
+			if f.repairSql != "" || f.Repair != "" {
+				t.Errorf("%s: unexpected repair %q", c.name, f.Repair)
+			}
+			continue
+		}
+		if f.repairSql == "" || f.Repair == "" {
+			t.Errorf("%s: no repair", c.name)
+			continue
+		}
+		if !reflect.DeepEqual(f.repairArgs, c.repairArgs) {
+			t.Errorf("%s: repair args are %v; want %v", c.name, f.repairArgs, c.repairArgs)
+		}
+	}
+}
@@ -3,30 +3,34 @@ package bookkeeper
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"go.uber.org/zap"
 )
 
 func GetAllAccountsBalanceOnDate(
-	dbpool *pgxpool.Pool, date time.Time,
+	ctx context.Context, dbpool *pgxpool.Pool, date time.Time,
 ) ([]AccountWithBalance, error) {
 	sugar := zap.L().Sugar()
 	defer sugar.Sync()
 
 	var accounts_ []AccountWithBalance
-	ids, err := GetAllAccountIds(dbpool)
+	ids, err := GetAllAccountIds(ctx, dbpool)
 	if err != nil {
 		sugar.Errorw("Failed to get all account ids", "error", err)
 		return accounts_, err
 	}
 	for _, id := range ids {
-		account, balance, err := ComputeAccountBalanceById(dbpool, id, date)
+		account, balance, err := ComputeAccountBalanceById(ctx, dbpool, id, date)
 		if err != nil {
 			sugar.Errorw(
 				fmt.Sprintf("Failed to get balance for account id %d", id),
@@ -42,7 +46,7 @@ func GetAllAccountsBalanceOnDate(
 	return accounts_, nil
 }
 
-func InitDb(dbpool *pgxpool.Pool, dataFile string, dryRun bool) ([]string, error) {
+func InitDb(ctx context.Context, dbpool *pgxpool.Pool, dataFile string, dryRun bool) ([]string, error) {
 	sugar := zap.L().Sugar()
 	defer sugar.Sync()
 	var (
@@ -51,11 +55,26 @@ func InitDb(dbpool *pgxpool.Pool, dataFile string, dryRun bool) ([]string, error
 		commands []string
 		dbDump   DbDump
 	)
-	// drop and create tables
+	// drop and create the ledger tables, and the responses cached for them.
+	// The rest, users and their tokens in particular, are only created if
+	// missing: the data file has none of them, and dropping them would lock
+	// every client out.
+	commands = append(commands, "drop table if exists account_owners;")
 	commands = append(commands, "drop table if exists transactions;")
 	commands = append(commands, "drop table if exists accounts;")
+	commands = append(commands, "drop table if exists idempotency_keys;")
 	commands = append(commands, GetSqlCreateAccounts())
 	commands = append(commands, GetSqlCreateTransactions())
+	commands = append(commands, GetSqlCreateTransactionsSearchIndex())
+	commands = append(commands, ifNotExists(GetSqlCreateSavedQueries()))
+	commands = append(commands, ifNotExists(GetSqlCreateReportPresets()))
+	commands = append(commands, ifNotExists(GetSqlCreateUsers()))
+	commands = append(commands, ifNotExists(GetSqlCreateApiTokens()))
+	commands = append(commands, GetSqlCreateAccountOwners())
+	commands = append(commands, GetSqlCreateIdempotencyKeys())
+	commands = append(commands, ifNotExists(GetSqlCreateEvents()))
+	commands = append(commands, ifNotExists(GetSqlCreateWebhooks()))
+	commands = append(commands, ifNotExists(GetSqlCreateWebhookDeliveries()))
 	// read accounts and transactions data
 	if dataFile != "" {
 		err = loadDataFromFile(dataFile, &dbDump)
@@ -76,32 +95,32 @@ func InitDb(dbpool *pgxpool.Pool, dataFile string, dryRun bool) ([]string, error
 		for _, c := range commands {
 			sugar.Infow("Execute command", "command", c)
 		}
-		tx, err = dbpool.Begin(context.Background())
+		tx, err = dbpool.Begin(ctx)
 		if err != nil {
 			return commands, err
 		}
-		defer tx.Rollback(context.Background())
+		defer tx.Rollback(ctx)
 		// execute drop and create table commands
-		if err := execCommands(tx, commands); err != nil {
+		if err := execCommands(ctx, tx, commands); err != nil {
 			return commands, err
 		}
 		// insert records
-		if err := insertAccounts(tx, dbDump.Accounts); err != nil {
+		if err := insertAccounts(ctx, tx, dbDump.Accounts); err != nil {
 			return commands, err
 		}
-		if err := insertTransactions(tx, dbDump.Transactions); err != nil {
+		if err := insertTransactions(ctx, tx, dbDump.Transactions); err != nil {
 			return commands, err
 		}
 		// reset sequence counts
 		_, err = tx.Exec(
-			context.Background(),
+			ctx,
 			"select setval('accounts_id_seq', coalesce((select max(id)+1 from accounts), 1), false)",
 		)
 		if err != nil {
 			return commands, err
 		}
 		_, err = tx.Exec(
-			context.Background(),
+			ctx,
 			"select setval('transactions_id_seq', coalesce((select max(id)+1 from transactions), 1), false)",
 		)
 		if err != nil {
@@ -111,21 +130,110 @@ func InitDb(dbpool *pgxpool.Pool, dataFile string, dryRun bool) ([]string, error
 	return commands, err
 }
 
+// make the create table and create index statements in sql skip what exists
+func ifNotExists(sql string) string {
+	sql = strings.Replace(sql, "create table", "create table if not exists", 1)
+	return strings.Replace(sql, "create index", "create index if not exists", 1)
+}
+
+// MigrateDb brings the schema of an existing database up to date without
+// wiping it, which makes it safe to run repeatedly
+func MigrateDb(ctx context.Context, dbpool *pgxpool.Pool, dryRun bool) ([]string, error) {
+	sugar := zap.L().Sugar()
+	defer sugar.Sync()
+	commands := []string{
+		ifNotExists(GetSqlCreateSavedQueries()),
+		ifNotExists(GetSqlCreateReportPresets()),
+		"alter table transactions add column if not exists search_vector tsvector;",
+		ifNotExists(GetSqlCreateTransactionsSearchIndex()),
+		ifNotExists(GetSqlCreateUsers()),
+		ifNotExists(GetSqlCreateApiTokens()),
+		ifNotExists(GetSqlCreateAccountOwners()),
+		"alter table report_presets add column if not exists owners text[];",
+		"alter table report_presets add column if not exists schema jsonb;",
+		"alter table accounts add column if not exists version int not null default 1;",
+		"alter table transactions add column if not exists version int not null default 1;",
+		ifNotExists(GetSqlCreateIdempotencyKeys()),
+		ifNotExists(GetSqlCreateEvents()),
+		ifNotExists(GetSqlCreateWebhooks()),
+		ifNotExists(GetSqlCreateWebhookDeliveries()),
+	}
+	if dryRun {
+		commands = append(commands, "<To fill in missing search vectors>")
+		return commands, nil
+	}
+	for _, c := range commands {
+		sugar.Infow("Execute command", "command", c)
+	}
+	tx, err := dbpool.Begin(ctx)
+	if err != nil {
+		return commands, err
+	}
+	defer tx.Rollback(ctx)
+	if err := execCommands(ctx, tx, commands); err != nil {
+		return commands, err
+	}
+	err = fillSearchVectors(ctx, dbpool)
+	return commands, err
+}
+
+func fillSearchVectors(ctx context.Context, dbpool *pgxpool.Pool) error {
+	type idAndNotes struct {
+		id    int
+		notes string
+	}
+	var missing []idAndNotes
+	rows, err := dbpool.Query(ctx,
+		"select id, coalesce(notes, '') from transactions where search_vector is null")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var curr idAndNotes
+		if err := rows.Scan(&curr.id, &curr.notes); err != nil {
+			return err
+		}
+		missing = append(missing, curr)
+	}
+	rows.Close()
+	for _, m := range missing {
+		latin, cjk := SplitSearchText(m.notes)
+		_, err := dbpool.Exec(
+			ctx,
+			fmt.Sprintf("update transactions set search_vector = %s where id = $3",
+				fmt.Sprintf(sqlSearchVector, "$1", "$2")),
+			latin, cjk, m.id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NOTE: orderBy is interpolated into the query as-is, so it must come from a
+// trusted source (e.g. the query parser), never directly from user input
 func GetTransactionsWithFilters(
-	dbpool *pgxpool.Pool, whereClause string, values []interface{}, limit int,
+	ctx context.Context, dbpool *pgxpool.Pool, whereClause string, values []interface{},
+	orderBy string, limit int, offset int,
 ) ([]Transaction_, error) {
 	var (
 		transactions []Transaction_
 		curr         Transaction_
 	)
+	if orderBy == "" {
+		orderBy = "date DESC"
+	}
 	rows, err := dbpool.Query(
-		context.Background(),
-		fmt.Sprintf(`select t.id, type, date, category, sub_category, account_id, amount, notes, association_id, a.name
+		ctx,
+		// always break ties by id so that paging through results is stable
+		fmt.Sprintf(`select t.id, type, date, category, sub_category, account_id, amount, notes, association_id, t.version, a.name
 from transactions t
 inner join accounts a on t.account_id = a.id
 where %s
-order by date desc
-limit %d`, whereClause, limit),
+order by %s, t.id DESC
+limit %d offset %d`, whereClause, orderBy, limit, offset),
 		values...,
 	)
 	if err != nil {
@@ -136,7 +244,7 @@ limit %d`, whereClause, limit),
 		if err := rows.Scan(
 			&curr.Id, &curr.Type, &curr.Date, &curr.Category, &curr.SubCategory,
 			&curr.AccountId, &curr.Amount, &curr.Notes, &curr.AssociationId,
-			&curr.AccountName,
+			&curr.Version, &curr.AccountName,
 		); err != nil {
 			return transactions, err
 		}
@@ -145,24 +253,46 @@ limit %d`, whereClause, limit),
 	return transactions, nil
 }
 
+func CountTransactionsWithFilters(
+	ctx context.Context, dbpool *pgxpool.Pool, whereClause string, values []interface{},
+) (count int, err error) {
+	row := dbpool.QueryRow(
+		ctx,
+		fmt.Sprintf(`select count(*)
+from transactions t
+inner join accounts a on t.account_id = a.id
+where %s`, whereClause),
+		values...,
+	)
+	err = row.Scan(&count)
+	return
+}
+
 func GetTransactionsBetweenDates(
-	dbpool *pgxpool.Pool, start time.Time, end time.Time, limit int,
+	ctx context.Context, dbpool *pgxpool.Pool, start time.Time, end time.Time, limit int, offset int,
 ) ([]Transaction_, error) {
-	return GetTransactionsWithFilters(dbpool, "(date >= $1 AND date <= $2)",
-		[]interface{}{start, end}, limit)
+	return GetTransactionsWithFilters(ctx, dbpool, "(date >= $1 AND date <= $2)",
+		[]interface{}{start, end}, "", limit, offset)
+}
+
+func CountTransactionsBetweenDates(
+	ctx context.Context, dbpool *pgxpool.Pool, start time.Time, end time.Time,
+) (int, error) {
+	return CountTransactionsWithFilters(ctx, dbpool, "(date >= $1 AND date <= $2)",
+		[]interface{}{start, end})
 }
 
-func GetAllTransactions(dbpool *pgxpool.Pool, limit int, offset int) ([]Transaction_, error) {
+func GetAllTransactions(ctx context.Context, dbpool *pgxpool.Pool, limit int, offset int) ([]Transaction_, error) {
 	var (
 		transactions []Transaction_
 		curr         Transaction_
 	)
 	rows, err := dbpool.Query(
-		context.Background(),
-		`select t.id, type, date, category, sub_category, account_id, amount, notes, association_id, a.name
+		ctx,
+		`select t.id, type, date, category, sub_category, account_id, amount, notes, association_id, t.version, a.name
 from transactions t
 inner join accounts a on t.account_id = a.id
-order by date desc
+order by date desc, t.id desc
 limit $1 offset $2`,
 		limit,
 		offset,
@@ -182,7 +312,7 @@ limit $1 offset $2`,
 			&curr.Amount,
 			&curr.Notes,
 			&curr.AssociationId,
-			&curr.AccountName,
+			&curr.Version, &curr.AccountName,
 		); err != nil {
 			return transactions, err
 		}
@@ -191,44 +321,58 @@ limit $1 offset $2`,
 	return transactions, nil
 }
 
-func GetAllAccounts(dbpool *pgxpool.Pool, limit int, offset int) ([]Account, error) {
+func CountAllTransactions(ctx context.Context, dbpool *pgxpool.Pool) (int, error) {
+	return CountTransactionsWithFilters(ctx, dbpool, "true", nil)
+}
+
+func GetAllAccounts(ctx context.Context, dbpool *pgxpool.Pool, limit int, offset int) ([]Account, error) {
 	var (
 		accounts []Account
 		curr     Account
 	)
-	rows, err := dbpool.Query(context.Background(), "select id, name, desc_, tags from accounts limit $1 offset $2", limit, offset)
+	rows, err := dbpool.Query(ctx, "select id, name, desc_, tags, version from accounts limit $1 offset $2", limit, offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		if err := rows.Scan(&curr.Id, &curr.Name, &curr.Desc, &curr.Tags); err != nil {
+		if err := rows.Scan(&curr.Id, &curr.Name, &curr.Desc, &curr.Tags, &curr.Version); err != nil {
 			return accounts, err
 		}
 		accounts = append(accounts, curr)
 	}
-	return accounts, nil
+	var ptrs []*Account
+	for i := range accounts {
+		ptrs = append(ptrs, &accounts[i])
+	}
+	return accounts, loadAccountOwners(ctx, dbpool, ptrs...)
 }
 
-func GetSingleAccount(dbpool *pgxpool.Pool, id int) (Account, error) {
+func GetSingleAccount(ctx context.Context, dbpool *pgxpool.Pool, id int) (Account, error) {
 	var account Account
-	row := dbpool.QueryRow(context.Background(), "select id, name, desc_, tags from accounts where id = $1", id)
-	err := row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags)
-	return account, err
+	row := dbpool.QueryRow(ctx, "select id, name, desc_, tags, version from accounts where id = $1", id)
+	err := row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags, &account.Version)
+	if err != nil {
+		return account, wrapDbError(err)
+	}
+	return account, loadAccountOwners(ctx, dbpool, &account)
 }
 
-func GetSingleAccountByName(dbpool *pgxpool.Pool, name string) (Account, error) {
+func GetSingleAccountByName(ctx context.Context, dbpool *pgxpool.Pool, name string) (Account, error) {
 	var account Account
-	row := dbpool.QueryRow(context.Background(), "select id, name, desc_, tags from accounts where name = $1", name)
-	err := row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags)
-	return account, err
+	row := dbpool.QueryRow(ctx, "select id, name, desc_, tags, version from accounts where name = $1", name)
+	err := row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags, &account.Version)
+	if err != nil {
+		return account, wrapDbError(err)
+	}
+	return account, loadAccountOwners(ctx, dbpool, &account)
 }
 
-func GetSingleTransaction(dbpool *pgxpool.Pool, id int) (Transaction_, error) {
+func GetSingleTransaction(ctx context.Context, dbpool *pgxpool.Pool, id int) (Transaction_, error) {
 	var transaction Transaction_
 	row := dbpool.QueryRow(
-		context.Background(),
-		`select t.id, type, date, category, sub_category, account_id, amount, notes, association_id, a.name
+		ctx,
+		`select t.id, type, date, category, sub_category, account_id, amount, notes, association_id, t.version, a.name
 from transactions t
 inner join accounts a on t.account_id = a.id
 where t.id = $1`,
@@ -238,88 +382,1063 @@ where t.id = $1`,
 		&transaction.Id, &transaction.Type, &transaction.Date,
 		&transaction.Category, &transaction.SubCategory, &transaction.AccountId,
 		&transaction.Amount, &transaction.Notes, &transaction.AssociationId,
-		&transaction.AccountName,
+		&transaction.Version, &transaction.AccountName,
 	)
-	return transaction, err
+	return transaction, wrapDbError(err)
 }
 
-func InsertAccount(dbpool *pgxpool.Pool, account *Account) error {
-	row := dbpool.QueryRow(
-		context.Background(),
+func InsertAccount(ctx context.Context, db Conn, account *Account) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	row := tx.QueryRow(
+		ctx,
 		`insert into accounts (name, desc_, tags) values ($1, $2, $3)
-returning id, name, desc_, tags`,
+returning id, name, desc_, tags, version`,
 		account.Name, account.Desc, account.Tags,
 	)
-	err := row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags)
-	return err
+	err = row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags, &account.Version)
+	if err != nil {
+		return wrapDbError(err)
+	}
+	if err = setAccountOwners(ctx, tx, account); err != nil {
+		return err
+	}
+	return wrapDbError(tx.Commit(ctx))
 }
 
-func UpdateAccount(dbpool *pgxpool.Pool, account *Account) error {
-	row := dbpool.QueryRow(
-		context.Background(),
-		`update accounts set name = $1, desc_ = $2, tags = $3 where id = $4
-returning id, name, desc_, tags`,
-		account.Name, account.Desc, account.Tags, account.Id,
+// UpdateAccount updates the account only if it is still at account.Version,
+// and bumps the version
+func UpdateAccount(ctx context.Context, db Conn, account *Account) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	row := tx.QueryRow(
+		ctx,
+		`update accounts set name = $1, desc_ = $2, tags = $3, version = version + 1
+where id = $4 and version = $5
+returning id, name, desc_, tags, version`,
+		account.Name, account.Desc, account.Tags, account.Id, account.Version,
 	)
-	err := row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags)
-	return err
+	err = row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags, &account.Version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return versionMismatchOrNotFound(ctx, tx, "accounts", account.Id)
+	}
+	if err != nil {
+		return wrapDbError(err)
+	}
+	if err = setAccountOwners(ctx, tx, account); err != nil {
+		return err
+	}
+	return wrapDbError(tx.Commit(ctx))
 }
 
-func DeleteAccount(dbpool *pgxpool.Pool, account_id int) error {
-	_, err := dbpool.Exec(
-		context.Background(),
-		"delete from accounts where id = $1",
-		account_id,
+// replace the owners of the account with account.Owners, which may refer to
+// users by id or by name; both are filled in on return
+func setAccountOwners(ctx context.Context, tx pgx.Tx, account *Account) error {
+	_, err := tx.Exec(ctx,
+		"delete from account_owners where account_id = $1", account.Id)
+	if err != nil {
+		return err
+	}
+	var verr ValidationError
+	for i := range account.Owners {
+		o := &account.Owners[i]
+		row := tx.QueryRow(
+			ctx,
+			`insert into account_owners (account_id, user_id, share)
+select $1, u.id, $2 from users u
+where case when $3 > 0 then u.id = $3 else u.name = $4 end
+returning user_id, (select name from users where id = user_id), share`,
+			account.Id, o.Share, o.UserId, o.UserName,
+		)
+		err = row.Scan(&o.UserId, &o.UserName, &o.Share)
+		if errors.Is(err, pgx.ErrNoRows) {
+			verr.Add(fmt.Sprintf("owners[%d]", i), "no such user")
+			continue
+		}
+		if err != nil {
+			return wrapDbError(err)
+		}
+	}
+	return verr.OrNil()
+}
+
+// fill in the owners of the accounts
+func loadAccountOwners(ctx context.Context, q querier, accounts ...*Account) error {
+	if len(accounts) == 0 {
+		return nil
+	}
+	byId := make(map[int]*Account)
+	var ids []int
+	for _, a := range accounts {
+		a.Owners = nil
+		byId[a.Id] = a
+		ids = append(ids, a.Id)
+	}
+	rows, err := q.Query(
+		ctx,
+		`select o.account_id, o.user_id, u.name, o.share from account_owners o
+inner join users u on o.user_id = u.id
+where o.account_id = any($1)
+order by o.account_id, u.name`,
+		ids,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			accountId int
+			owner     AccountOwner
+		)
+		if err := rows.Scan(&accountId, &owner.UserId, &owner.UserName,
+			&owner.Share); err != nil {
+			return err
+		}
+		a := byId[accountId]
+		a.Owners = append(a.Owners, owner)
+	}
+	return rows.Err()
 }
 
-func InsertTransaction(dbpool *pgxpool.Pool, trans *Transaction) error {
-	row := dbpool.QueryRow(
-		context.Background(),
-		`insert into transactions
-(type, date, category, sub_category, account_id, amount, notes, association_id)
-values ($1, $2, $3, $4, $5, $6, $7, $8)
-returning id, type, date, category, sub_category, account_id, amount, notes, association_id`,
+// DeleteAccount deletes the account only if it is still at the given version
+func DeleteAccount(ctx context.Context, db Conn, account_id int, version int) error {
+	tag, err := db.Exec(
+		ctx,
+		"delete from accounts where id = $1 and version = $2",
+		account_id, version,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		return versionMismatchOrNotFound(ctx, db, "accounts", account_id)
+	}
+	return wrapDbError(err)
+}
+
+// MergeAccounts moves every transaction of the account fromId, which must
+// still be at version, onto the account intoId and deletes the former, all or
+// nothing. Both must be of the same class. The association ids of transfers
+// are left alone. canPost is checked on both accounts once they are locked.
+// With dryRun, nothing changes and the version is not checked.
+func MergeAccounts(
+	ctx context.Context, db Conn, fromId int, intoId int, version int,
+	dryRun bool, canPost func(Account) error,
+) (AccountMerge, error) {
+	merge := AccountMerge{TransactionIds: []int{}, DryRun: dryRun}
+	if fromId == intoId {
+		var verr ValidationError
+		verr.Add("into", "cannot merge an account into itself")
+		return merge, verr.OrNil()
+	}
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return merge, err
+	}
+	defer tx.Rollback(ctx)
+	// lock both accounts, so that nobody posts to the merged account meanwhile
+	accounts, err := lockAccounts(ctx, tx, []int{fromId, intoId})
+	if err != nil {
+		return merge, err
+	}
+	var foundFrom, foundInto bool
+	merge.From, foundFrom = accounts[fromId]
+	merge.Into, foundInto = accounts[intoId]
+	if !foundFrom || !foundInto {
+		return merge, wrapDbError(pgx.ErrNoRows)
+	}
+	for _, id := range sortedAccountIds(accounts) {
+		if err = canPost(accounts[id]); err != nil {
+			return merge, err
+		}
+	}
+	if !dryRun && merge.From.Version != version {
+		return merge, ErrVersionMismatch
+	}
+	if merge.From.Class() != merge.Into.Class() {
+		var verr ValidationError
+		verr.Add("into", fmt.Sprintf("%s is not an account of the same class as %s",
+			merge.Into.Name, merge.From.Name))
+		return merge, verr.OrNil()
+	}
+	row := tx.QueryRow(
+		ctx,
+		`select count(*),
+	count(*) filter (where coalesce(association_id, '') <> ''),
+	count(*) filter (where coalesce(association_id, '') <> '' and association_id in (
+		select association_id from transactions where account_id = $2)),
+	coalesce(sum(amount), 0)
+from transactions where account_id = $1`,
+		fromId, intoId,
+	)
+	err = row.Scan(&merge.NumTransactions, &merge.NumTransfers,
+		&merge.NumInternalTransfers, &merge.Amount)
+	if err != nil || dryRun {
+		return merge, wrapDbError(err)
+	}
+	rows, err := tx.Query(
+		ctx,
+		`update transactions set account_id = $2, version = version + 1
+where account_id = $1
+returning id`,
+		fromId, intoId,
+	)
+	if err != nil {
+		return merge, wrapDbError(err)
+	}
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return merge, wrapDbError(err)
+		}
+		merge.TransactionIds = append(merge.TransactionIds, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return merge, wrapDbError(err)
+	}
+	// the owners of the merged account go with it
+	if _, err = tx.Exec(ctx, "delete from accounts where id = $1", fromId); err != nil {
+		return merge, wrapDbError(err)
+	}
+	return merge, wrapDbError(tx.Commit(ctx))
+}
+
+// tell apart the reasons why a statement conditioned on the version of a row
+// did not touch it: the row is gone, or someone else has changed it
+func versionMismatchOrNotFound(ctx context.Context, q queryRower, table string, id int) error {
+	var exists bool
+	row := q.QueryRow(ctx,
+		fmt.Sprintf("select exists(select 1 from %s where id = $1)", table), id)
+	if err := row.Scan(&exists); err != nil {
+		return wrapDbError(err)
+	}
+	if exists {
+		return ErrVersionMismatch
+	}
+	return wrapDbError(pgx.ErrNoRows)
+}
+
+// Conn is where the functions that change data make their changes: a
+// *pgxpool.Pool, or a pgx.Tx to make them part of a transaction of the
+// caller, e.g. one that also records their events
+type Conn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// implemented by both *pgxpool.Pool and pgx.Tx
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// implemented by both *pgxpool.Pool and pgx.Tx
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+func InsertTransaction(ctx context.Context, db Conn, trans *Transaction) error {
+	return insertTransaction(ctx, db, trans)
+}
+
+func insertTransaction(ctx context.Context, q queryRower, trans *Transaction) error {
+	latin, cjk := SplitSearchText(trans.Notes)
+	row := q.QueryRow(
+		ctx,
+		fmt.Sprintf(`insert into transactions
+(type, date, category, sub_category, account_id, amount, notes, association_id, search_vector)
+values ($1, $2, $3, $4, $5, $6, $7, $8, %s)
+returning id, type, date, category, sub_category, account_id, amount, notes, association_id, version`,
+			fmt.Sprintf(sqlSearchVector, "$9", "$10")),
 		trans.Type, trans.Date, trans.Category, trans.SubCategory,
 		trans.AccountId, trans.Amount, trans.Notes, trans.AssociationId,
+		latin, cjk,
 	)
 	err := row.Scan(
 		&trans.Id, &trans.Type, &trans.Date, &trans.Category,
 		&trans.SubCategory, &trans.AccountId, &trans.Amount, &trans.Notes,
-		&trans.AssociationId,
+		&trans.AssociationId, &trans.Version,
 	)
-	return err
+	return wrapDbError(err)
 }
 
-func UpdateTransaction(dbpool *pgxpool.Pool, trans *Transaction) (err error) {
-	row := dbpool.QueryRow(
-		context.Background(),
-		`update transactions
+// InsertTransactionsBatch inserts all transactions in one database
+// transaction. If one fails, none is inserted, and its index is returned with
+// the error.
+func InsertTransactionsBatch(
+	ctx context.Context, db Conn, transactions []Transaction,
+) (failedIndex int, err error) {
+	failedIndex = -1
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+	for i := range transactions {
+		if err = insertTransaction(ctx, tx, &transactions[i]); err != nil {
+			failedIndex = i
+			return
+		}
+	}
+	err = wrapDbError(tx.Commit(ctx))
+	return
+}
+
+// UpdateTransactionsWithFilters sets the fields of the patch on every
+// transaction that matches the where clause, all or nothing, and returns the
+// updated transactions. The matches are locked first, and canPost is called
+// with the account of every match and the account they are moved to, if any,
+// before anything changes. Every updated transaction must be valid, stay on
+// an account of the same class, and not end up on the same account as
+// another leg of its transfer.
+func UpdateTransactionsWithFilters(
+	ctx context.Context, db Conn, whereClause string, values []interface{},
+	patch TransactionPatch, canPost func(Account) error,
+) ([]Transaction, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	matches, err := scanTransactions(tx.Query(
+		ctx,
+		fmt.Sprintf(`select t.id, type, date, category, sub_category, account_id, amount, notes, association_id, t.version
+from transactions t
+inner join accounts a on t.account_id = a.id
+where %s
+order by t.id
+for update of t`, whereClause),
+		values...,
+	))
+	if err != nil || len(matches) == 0 {
+		return nil, wrapDbError(err)
+	}
+	// lock the accounts too, so that their owners and classes hold until the
+	// end
+	accountIds := make([]int, 0, len(matches)+1)
+	var ids []int
+	for _, t := range matches {
+		accountIds = append(accountIds, t.AccountId)
+		ids = append(ids, t.Id)
+	}
+	if patch.AccountId != nil {
+		accountIds = append(accountIds, *patch.AccountId)
+	}
+	accounts, err := lockAccounts(ctx, tx, accountIds)
+	if err != nil {
+		return nil, err
+	}
+	if patch.AccountId != nil {
+		if _, ok := accounts[*patch.AccountId]; !ok {
+			var verr ValidationError
+			verr.Add("account_id", "no such account")
+			return nil, verr.OrNil()
+		}
+	}
+	for _, id := range sortedAccountIds(accounts) {
+		if err := canPost(accounts[id]); err != nil {
+			return nil, err
+		}
+	}
+	var verr ValidationError
+	for _, t := range matches {
+		from := accounts[t.AccountId]
+		patch.apply(&t)
+		if err := t.Validate(); err != nil {
+			var terr *ValidationError
+			if errors.As(err, &terr) {
+				for _, f := range terr.Fields {
+					verr.Add(fmt.Sprintf("transactions[id=%d].%s", t.Id, f.Field), f.Reason)
+				}
+			}
+		}
+		if to := accounts[t.AccountId]; from.Class() != to.Class() {
+			verr.Add(fmt.Sprintf("transactions[id=%d].account_id", t.Id),
+				fmt.Sprintf("%s is not an account of the same class as %s",
+					to.Name, from.Name))
+		}
+	}
+	if err := verr.OrNil(); err != nil {
+		return nil, err
+	}
+
+	var sets []string
+	args := []interface{}{ids}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if patch.Category != nil {
+		set("category", *patch.Category)
+	}
+	if patch.SubCategory != nil {
+		set("sub_category", *patch.SubCategory)
+	}
+	if patch.AccountId != nil {
+		set("account_id", *patch.AccountId)
+	}
+	if patch.Notes != nil {
+		set("notes", *patch.Notes)
+		latin, cjk := SplitSearchText(*patch.Notes)
+		args = append(args, latin, cjk)
+		sets = append(sets, "search_vector = "+fmt.Sprintf(sqlSearchVector,
+			fmt.Sprintf("$%d", len(args)-1), fmt.Sprintf("$%d", len(args))))
+	}
+	sets = append(sets, "version = version + 1")
+	updated, err := scanTransactions(tx.Query(
+		ctx,
+		fmt.Sprintf(`update transactions set %s
+where id = any($1)
+returning id, type, date, category, sub_category, account_id, amount, notes, association_id, version`,
+			strings.Join(sets, ", ")),
+		args...,
+	))
+	if err != nil {
+		return nil, wrapDbError(err)
+	}
+	if patch.AccountId != nil {
+		if err := checkTransferAccounts(ctx, tx, updated); err != nil {
+			return nil, err
+		}
+	}
+	return updated, wrapDbError(tx.Commit(ctx))
+}
+
+// set the fields of the patch on the transaction
+func (patch TransactionPatch) apply(trans *Transaction) {
+	if patch.Category != nil {
+		trans.Category = *patch.Category
+	}
+	if patch.SubCategory != nil {
+		trans.SubCategory = *patch.SubCategory
+	}
+	if patch.Notes != nil {
+		trans.Notes = *patch.Notes
+	}
+	if patch.AccountId != nil {
+		trans.AccountId = *patch.AccountId
+	}
+}
+
+// scanTransactions reads all rows of the columns of Transaction, in order
+func scanTransactions(rows pgx.Rows, err error) ([]Transaction, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var transactions []Transaction
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(
+			&t.Id, &t.Type, &t.Date, &t.Category, &t.SubCategory, &t.AccountId,
+			&t.Amount, &t.Notes, &t.AssociationId, &t.Version,
+		); err != nil {
+			return transactions, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
+
+// lockAccounts locks the accounts in the order of their ids, so that two
+// transactions locking the same accounts cannot deadlock, and returns them by
+// id with their owners. Missing accounts are left out.
+func lockAccounts(ctx context.Context, tx pgx.Tx, ids []int) (map[int]Account, error) {
+	rows, err := tx.Query(ctx,
+		`select id, name, desc_, tags, version from accounts
+where id = any($1)
+order by id
+for update`,
+		ids)
+	if err != nil {
+		return nil, wrapDbError(err)
+	}
+	var locked []*Account
+	for rows.Next() {
+		var a Account
+		if err := rows.Scan(&a.Id, &a.Name, &a.Desc, &a.Tags, &a.Version); err != nil {
+			rows.Close()
+			return nil, wrapDbError(err)
+		}
+		locked = append(locked, &a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, wrapDbError(err)
+	}
+	if err := loadAccountOwners(ctx, tx, locked...); err != nil {
+		return nil, wrapDbError(err)
+	}
+	accounts := make(map[int]Account, len(locked))
+	for _, a := range locked {
+		accounts[a.Id] = *a
+	}
+	return accounts, nil
+}
+
+func sortedAccountIds(accounts map[int]Account) []int {
+	ids := make([]int, 0, len(accounts))
+	for id := range accounts {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// checkTransferAccounts fails if a transfer of the transactions has more than
+// one leg on the same account
+func checkTransferAccounts(ctx context.Context, tx pgx.Tx, transactions []Transaction) error {
+	var associationIds []string
+	for _, t := range transactions {
+		if t.AssociationId != "" {
+			associationIds = append(associationIds, t.AssociationId)
+		}
+	}
+	if len(associationIds) == 0 {
+		return nil
+	}
+	rows, err := tx.Query(ctx,
+		`select association_id from transactions
+where association_id = any($1)
+group by association_id, account_id
+having count(*) > 1
+order by association_id`,
+		associationIds)
+	if err != nil {
+		return wrapDbError(err)
+	}
+	defer rows.Close()
+	var verr ValidationError
+	for rows.Next() {
+		var associationId string
+		if err := rows.Scan(&associationId); err != nil {
+			return wrapDbError(err)
+		}
+		verr.Add("account_id", fmt.Sprintf(
+			"would put two legs of the transfer %s on the same account", associationId))
+	}
+	if err := rows.Err(); err != nil {
+		return wrapDbError(err)
+	}
+	return verr.OrNil()
+}
+
+// UpdateTransaction updates the transaction only if it is still at
+// trans.Version, and bumps the version
+func UpdateTransaction(ctx context.Context, db Conn, trans *Transaction) (err error) {
+	latin, cjk := SplitSearchText(trans.Notes)
+	row := db.QueryRow(
+		ctx,
+		fmt.Sprintf(`update transactions
 set type=$1, date=$2, category=$3, sub_category=$4, account_id=$5, amount=$6,
-notes=$7, association_id=$8 where id=$9
-returning id, type, date, category, sub_category, account_id, amount, notes, association_id`,
+notes=$7, association_id=$8, search_vector=%s, version=version+1
+where id=$9 and version=$12
+returning id, type, date, category, sub_category, account_id, amount, notes, association_id, version`,
+			fmt.Sprintf(sqlSearchVector, "$10", "$11")),
 		trans.Type, trans.Date, trans.Category, trans.SubCategory,
 		trans.AccountId, trans.Amount, trans.Notes, trans.AssociationId,
-		trans.Id,
+		trans.Id, latin, cjk, trans.Version,
 	)
 	err = row.Scan(
 		&trans.Id, &trans.Type, &trans.Date, &trans.Category,
 		&trans.SubCategory, &trans.AccountId, &trans.Amount, &trans.Notes,
-		&trans.AssociationId,
+		&trans.AssociationId, &trans.Version,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return versionMismatchOrNotFound(ctx, db, "transactions", trans.Id)
+	}
+	err = wrapDbError(err)
 	return
 }
 
-func DeleteTransaction(dbpool *pgxpool.Pool, trans_id int) error {
+// DeleteTransaction deletes the transaction only if it is still at the given
+// version
+func DeleteTransaction(ctx context.Context, db Conn, trans_id int, version int) error {
+	tag, err := db.Exec(
+		ctx,
+		"delete from transactions where id = $1 and version = $2",
+		trans_id, version,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		return versionMismatchOrNotFound(ctx, db, "transactions", trans_id)
+	}
+	return wrapDbError(err)
+}
+
+func GetAllSavedQueries(ctx context.Context, dbpool *pgxpool.Pool) ([]SavedQuery, error) {
+	var (
+		queries []SavedQuery
+		curr    SavedQuery
+	)
+	rows, err := dbpool.Query(ctx,
+		"select name, query_string, desc_ from saved_queries order by name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&curr.Name, &curr.QueryString, &curr.Desc); err != nil {
+			return queries, err
+		}
+		queries = append(queries, curr)
+	}
+	return queries, nil
+}
+
+func GetSingleSavedQuery(ctx context.Context, dbpool *pgxpool.Pool, name string) (SavedQuery, error) {
+	var query SavedQuery
+	row := dbpool.QueryRow(ctx,
+		"select name, query_string, desc_ from saved_queries where name = $1",
+		name)
+	err := row.Scan(&query.Name, &query.QueryString, &query.Desc)
+	return query, wrapDbError(err)
+}
+
+// insert the saved query, or overwrite the one with the same name
+func UpsertSavedQuery(ctx context.Context, dbpool *pgxpool.Pool, query *SavedQuery) error {
+	row := dbpool.QueryRow(
+		ctx,
+		`insert into saved_queries (name, query_string, desc_) values ($1, $2, $3)
+on conflict (name) do update
+set query_string = excluded.query_string, desc_ = excluded.desc_
+returning name, query_string, desc_`,
+		query.Name, query.QueryString, query.Desc,
+	)
+	err := row.Scan(&query.Name, &query.QueryString, &query.Desc)
+	return wrapDbError(err)
+}
+
+func DeleteSavedQuery(ctx context.Context, dbpool *pgxpool.Pool, name string) error {
+	tag, err := dbpool.Exec(ctx,
+		"delete from saved_queries where name = $1", name)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+	return wrapDbError(err)
+}
+
+func GetAllReportPresets(ctx context.Context, dbpool *pgxpool.Pool) ([]ReportPreset, error) {
+	var presets []ReportPreset
+	rows, err := dbpool.Query(ctx,
+		`select name, report, dates, tags, schema, owners from report_presets
+order by name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var curr ReportPreset
+		if err := rows.Scan(
+			&curr.Name, &curr.Report, &curr.Dates, &curr.Tags, &curr.Schema,
+			&curr.Owners,
+		); err != nil {
+			return presets, err
+		}
+		presets = append(presets, curr)
+	}
+	return presets, nil
+}
+
+func GetSingleReportPreset(ctx context.Context, dbpool *pgxpool.Pool, name string) (ReportPreset, error) {
+	var preset ReportPreset
+	row := dbpool.QueryRow(
+		ctx,
+		`select name, report, dates, tags, schema, owners from report_presets
+where name = $1`,
+		name,
+	)
+	err := row.Scan(&preset.Name, &preset.Report, &preset.Dates, &preset.Tags,
+		&preset.Schema, &preset.Owners)
+	return preset, wrapDbError(err)
+}
+
+// insert the report preset, or overwrite the one with the same name
+func UpsertReportPreset(ctx context.Context, dbpool *pgxpool.Pool, preset *ReportPreset) error {
+	row := dbpool.QueryRow(
+		ctx,
+		`insert into report_presets (name, report, dates, tags, schema, owners)
+values ($1, $2, $3, $4, $5, $6)
+on conflict (name) do update
+set report = excluded.report, dates = excluded.dates, tags = excluded.tags,
+schema = excluded.schema, owners = excluded.owners
+returning name, report, dates, tags, schema, owners`,
+		preset.Name, preset.Report, preset.Dates, preset.Tags, preset.Schema,
+		preset.Owners,
+	)
+	err := row.Scan(&preset.Name, &preset.Report, &preset.Dates, &preset.Tags,
+		&preset.Schema, &preset.Owners)
+	return wrapDbError(err)
+}
+
+func DeleteReportPreset(ctx context.Context, dbpool *pgxpool.Pool, name string) error {
+	tag, err := dbpool.Exec(ctx,
+		"delete from report_presets where name = $1", name)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+	return wrapDbError(err)
+}
+
+func InsertUser(ctx context.Context, dbpool *pgxpool.Pool, user *User) error {
+	row := dbpool.QueryRow(
+		ctx,
+		`insert into users (name, password_hash) values ($1, $2)
+returning id, name, password_hash`,
+		user.Name, user.PasswordHash,
+	)
+	err := row.Scan(&user.Id, &user.Name, &user.PasswordHash)
+	return wrapDbError(err)
+}
+
+func GetUserByName(ctx context.Context, dbpool *pgxpool.Pool, name string) (User, error) {
+	var user User
+	row := dbpool.QueryRow(ctx,
+		"select id, name, password_hash from users where name = $1", name)
+	err := row.Scan(&user.Id, &user.Name, &user.PasswordHash)
+	return user, wrapDbError(err)
+}
+
+// the number of members of the household
+func CountUsers(ctx context.Context, dbpool *pgxpool.Pool) (int, error) {
+	var n int
+	err := dbpool.QueryRow(ctx, "select count(*) from users").Scan(&n)
+	return n, wrapDbError(err)
+}
+
+func GetUserById(ctx context.Context, dbpool *pgxpool.Pool, id int) (User, error) {
+	var user User
+	row := dbpool.QueryRow(ctx,
+		"select id, name, password_hash from users where id = $1", id)
+	err := row.Scan(&user.Id, &user.Name, &user.PasswordHash)
+	return user, wrapDbError(err)
+}
+
+func InsertApiToken(ctx context.Context, dbpool *pgxpool.Pool, token *ApiToken) error {
+	row := dbpool.QueryRow(
+		ctx,
+		`insert into api_tokens (user_id, name, scope, token_hash)
+values ($1, $2, $3, $4)
+returning id, user_id, name, scope, token_hash, created_at, revoked_at`,
+		token.UserId, token.Name, token.Scope, token.TokenHash,
+	)
+	err := row.Scan(&token.Id, &token.UserId, &token.Name, &token.Scope,
+		&token.TokenHash, &token.CreatedAt, &token.RevokedAt)
+	return wrapDbError(err)
+}
+
+// look up a token that has not been revoked by its hash
+func GetActiveApiTokenByHash(ctx context.Context, dbpool *pgxpool.Pool, hash string) (ApiToken, error) {
+	var token ApiToken
+	row := dbpool.QueryRow(
+		ctx,
+		`select id, user_id, name, scope, token_hash, created_at, revoked_at
+from api_tokens where token_hash = $1 and revoked_at is null`,
+		hash,
+	)
+	err := row.Scan(&token.Id, &token.UserId, &token.Name, &token.Scope,
+		&token.TokenHash, &token.CreatedAt, &token.RevokedAt)
+	return token, wrapDbError(err)
+}
+
+func GetApiTokensByUser(ctx context.Context, dbpool *pgxpool.Pool, userId int) ([]ApiToken, error) {
+	var tokens []ApiToken
+	rows, err := dbpool.Query(
+		ctx,
+		`select id, user_id, name, scope, token_hash, created_at, revoked_at
+from api_tokens where user_id = $1 order by id`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var curr ApiToken
+		if err := rows.Scan(&curr.Id, &curr.UserId, &curr.Name, &curr.Scope,
+			&curr.TokenHash, &curr.CreatedAt, &curr.RevokedAt); err != nil {
+			return tokens, err
+		}
+		tokens = append(tokens, curr)
+	}
+	return tokens, nil
+}
+
+// revoke one of the tokens of a user; tokens of other users are not found
+func RevokeApiToken(ctx context.Context, dbpool *pgxpool.Pool, userId int, tokenId int) error {
+	tag, err := dbpool.Exec(
+		ctx,
+		`update api_tokens set revoked_at = now()
+where id = $1 and user_id = $2 and revoked_at is null`,
+		tokenId, userId,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+	return wrapDbError(err)
+}
+
+// ReserveIdempotencyKey claims the key of resp for a new request. If the key
+// has been used within IDEMPOTENCY_KEY_RETENTION, reserved is false and resp
+// is overwritten with what is stored under the key.
+func ReserveIdempotencyKey(
+	ctx context.Context, dbpool *pgxpool.Pool, resp *IdempotentResponse,
+) (reserved bool, err error) {
+	_, err = dbpool.Exec(
+		ctx,
+		"delete from idempotency_keys where created_at < now() - $1::interval",
+		fmt.Sprintf("%d seconds", int(IDEMPOTENCY_KEY_RETENTION.Seconds())),
+	)
+	if err != nil {
+		return false, err
+	}
+	tag, err := dbpool.Exec(
+		ctx,
+		`insert into idempotency_keys (user_id, key, request_hash)
+values ($1, $2, $3) on conflict do nothing`,
+		resp.UserId, resp.Key, resp.RequestHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 1 {
+		return true, nil
+	}
+	row := dbpool.QueryRow(
+		ctx,
+		`select request_hash, status_code, coalesce(content_type, ''), body, created_at
+from idempotency_keys where user_id = $1 and key = $2`,
+		resp.UserId, resp.Key,
+	)
+	err = row.Scan(&resp.RequestHash, &resp.StatusCode, &resp.ContentType,
+		&resp.Body, &resp.CreatedAt)
+	return false, wrapDbError(err)
+}
+
+// store the response to the request that reserved the key
+func SaveIdempotentResponse(ctx context.Context, dbpool *pgxpool.Pool, resp *IdempotentResponse) error {
+	tag, err := dbpool.Exec(
+		ctx,
+		`update idempotency_keys set status_code = $3, content_type = $4, body = $5
+where user_id = $1 and key = $2`,
+		resp.UserId, resp.Key, resp.StatusCode, resp.ContentType, resp.Body,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+	return wrapDbError(err)
+}
+
+// give up a reserved key, so that the request can be retried
+func ReleaseIdempotencyKey(ctx context.Context, dbpool *pgxpool.Pool, userId int, key string) error {
 	_, err := dbpool.Exec(
-		context.Background(),
-		"delete from transactions where id = $1",
-		trans_id,
+		ctx,
+		"delete from idempotency_keys where user_id = $1 and key = $2",
+		userId, key,
 	)
 	return err
 }
 
+// append the events to the change feed, and fill in their ids. Readers page
+// through the feed by id, so the inserts are serialized: ids are taken and
+// committed in the same order, and no reader can skip past an event that
+// commits late. In a transaction of the caller, the feed stays locked until
+// it commits, so the events are committed with the change they describe.
+func InsertEvents(ctx context.Context, db Conn, events []Event) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	// conflicts with itself but not with reads
+	if _, err := tx.Exec(ctx, "lock table events in share row exclusive mode"); err != nil {
+		return wrapDbError(err)
+	}
+	for i := range events {
+		e := &events[i]
+		var data interface{}
+		if len(e.Data) > 0 {
+			data = string(e.Data)
+		}
+		row := tx.QueryRow(
+			ctx,
+			`insert into events (type, entity_id, data) values ($1, $2, $3)
+returning id, created_at`,
+			e.Type, e.EntityId, data,
+		)
+		if err := row.Scan(&e.Id, &e.CreatedAt); err != nil {
+			return wrapDbError(err)
+		}
+	}
+	return wrapDbError(tx.Commit(ctx))
+}
+
+// the events after the one with the given id, oldest first
+func GetEventsAfter(
+	ctx context.Context, dbpool *pgxpool.Pool, afterId int64, limit int,
+) ([]Event, error) {
+	var events []Event
+	rows, err := dbpool.Query(
+		ctx,
+		`select id, type, entity_id, coalesce(data::text, ''), created_at
+from events where id > $1 order by id limit $2`,
+		afterId, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			curr Event
+			data string
+		)
+		if err := rows.Scan(&curr.Id, &curr.Type, &curr.EntityId, &data,
+			&curr.CreatedAt); err != nil {
+			return events, err
+		}
+		if data != "" {
+			curr.Data = json.RawMessage(data)
+		}
+		events = append(events, curr)
+	}
+	return events, rows.Err()
+}
+
+// the id of the latest event, or 0 if there are none
+func GetLatestEventId(ctx context.Context, dbpool *pgxpool.Pool) (int64, error) {
+	var id int64
+	row := dbpool.QueryRow(ctx, "select coalesce(max(id), 0) from events")
+	err := row.Scan(&id)
+	return id, wrapDbError(err)
+}
+
+// InsertWebhook creates a webhook that starts with the next event
+func InsertWebhook(ctx context.Context, dbpool *pgxpool.Pool, webhook *Webhook) error {
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []string{}
+	}
+	row := dbpool.QueryRow(
+		ctx,
+		`insert into webhooks (url, event_types, secret, last_event_id)
+values ($1, $2, $3, (select coalesce(max(id), 0) from events))
+returning id, url, event_types, secret, last_event_id, created_at`,
+		webhook.Url, webhook.EventTypes, webhook.Secret,
+	)
+	err := row.Scan(&webhook.Id, &webhook.Url, &webhook.EventTypes,
+		&webhook.Secret, &webhook.LastEventId, &webhook.CreatedAt)
+	return wrapDbError(err)
+}
+
+// GetAllWebhooks lists the webhooks; secrets are left out unless withSecrets
+func GetAllWebhooks(ctx context.Context, dbpool *pgxpool.Pool, withSecrets bool) ([]Webhook, error) {
+	var webhooks []Webhook
+	rows, err := dbpool.Query(
+		ctx,
+		`select id, url, event_types, secret, last_event_id, created_at
+from webhooks order by id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var curr Webhook
+		if err := rows.Scan(&curr.Id, &curr.Url, &curr.EventTypes,
+			&curr.Secret, &curr.LastEventId, &curr.CreatedAt); err != nil {
+			return webhooks, err
+		}
+		if !withSecrets {
+			curr.Secret = ""
+		}
+		webhooks = append(webhooks, curr)
+	}
+	return webhooks, rows.Err()
+}
+
+func GetSingleWebhook(ctx context.Context, dbpool *pgxpool.Pool, id int) (Webhook, error) {
+	var webhook Webhook
+	row := dbpool.QueryRow(
+		ctx,
+		`select id, url, event_types, secret, last_event_id, created_at
+from webhooks where id = $1`,
+		id,
+	)
+	err := row.Scan(&webhook.Id, &webhook.Url, &webhook.EventTypes,
+		&webhook.Secret, &webhook.LastEventId, &webhook.CreatedAt)
+	return webhook, wrapDbError(err)
+}
+
+// DeleteWebhook removes a webhook together with its delivery log
+func DeleteWebhook(ctx context.Context, dbpool *pgxpool.Pool, id int) error {
+	tag, err := dbpool.Exec(ctx, "delete from webhooks where id = $1", id)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = pgx.ErrNoRows
+	}
+	return wrapDbError(err)
+}
+
+// AdvanceWebhook marks the events up to eventId as done for the webhook. It
+// never moves the webhook backwards.
+func AdvanceWebhook(ctx context.Context, dbpool *pgxpool.Pool, id int, eventId int64) error {
+	_, err := dbpool.Exec(
+		ctx,
+		"update webhooks set last_event_id = $2 where id = $1 and last_event_id < $2",
+		id, eventId,
+	)
+	return err
+}
+
+func InsertWebhookDelivery(ctx context.Context, dbpool *pgxpool.Pool, delivery *WebhookDelivery) error {
+	row := dbpool.QueryRow(
+		ctx,
+		`insert into webhook_deliveries
+(webhook_id, event_id, event_type, attempt, status_code, error, duration_ms)
+values ($1, $2, $3, $4, $5, $6, $7)
+returning id, created_at`,
+		delivery.WebhookId, delivery.EventId, delivery.EventType,
+		delivery.Attempt, delivery.StatusCode, delivery.Error,
+		delivery.DurationMs,
+	)
+	err := row.Scan(&delivery.Id, &delivery.CreatedAt)
+	return wrapDbError(err)
+}
+
+// the latest delivery attempts to a webhook, latest first
+func GetWebhookDeliveries(
+	ctx context.Context, dbpool *pgxpool.Pool, webhookId int, limit int, offset int,
+) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	rows, err := dbpool.Query(
+		ctx,
+		`select id, webhook_id, event_id, event_type, attempt, status_code, error,
+duration_ms, created_at
+from webhook_deliveries where webhook_id = $1 order by id desc
+limit $2 offset $3`,
+		webhookId, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var curr WebhookDelivery
+		if err := rows.Scan(&curr.Id, &curr.WebhookId, &curr.EventId,
+			&curr.EventType, &curr.Attempt, &curr.StatusCode, &curr.Error,
+			&curr.DurationMs, &curr.CreatedAt); err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, curr)
+	}
+	return deliveries, rows.Err()
+}
+
 type DbDump struct {
 	Accounts     []Account     `json:"accounts"`
 	Transactions []Transaction `json:"transactions"`
@@ -342,10 +1461,10 @@ func loadDataFromFile(dataFile string, dbDump *DbDump) error {
 	return nil
 }
 
-func insertAccounts(tx pgx.Tx, accounts []Account) error {
+func insertAccounts(ctx context.Context, tx pgx.Tx, accounts []Account) error {
 	for _, account := range accounts {
 		_, err := tx.Exec(
-			context.Background(),
+			ctx,
 			"insert into accounts (id, name, desc_, tags) values ($1, $2, $3, $4)",
 			account.Id,
 			account.Name,
@@ -359,13 +1478,15 @@ func insertAccounts(tx pgx.Tx, accounts []Account) error {
 	return nil
 }
 
-func insertTransactions(tx pgx.Tx, transactions []Transaction) error {
+func insertTransactions(ctx context.Context, tx pgx.Tx, transactions []Transaction) error {
 	for _, transaction := range transactions {
+		latin, cjk := SplitSearchText(transaction.Notes)
 		_, err := tx.Exec(
-			context.Background(),
-			`insert into transactions
-(id, type, date, category, sub_category, account_id, amount, notes, association_id)
-values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			ctx,
+			fmt.Sprintf(`insert into transactions
+(id, type, date, category, sub_category, account_id, amount, notes, association_id, search_vector)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, %s)`,
+				fmt.Sprintf(sqlSearchVector, "$10", "$11")),
 			transaction.Id,
 			transaction.Type,
 			transaction.Date,
@@ -375,6 +1496,8 @@ values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
 			transaction.Amount,
 			transaction.Notes,
 			transaction.AssociationId,
+			latin,
+			cjk,
 		)
 		if err != nil {
 			return err
@@ -383,13 +1506,13 @@ values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
 	return nil
 }
 
-func execCommands(tx pgx.Tx, commands []string) error {
+func execCommands(ctx context.Context, tx pgx.Tx, commands []string) error {
 	for _, c := range commands {
-		_, err := tx.Exec(context.Background(), c)
+		_, err := tx.Exec(ctx, c)
 		if err != nil {
 			return err
 		}
 	}
-	tx.Commit(context.Background())
+	tx.Commit(ctx)
 	return nil
 }
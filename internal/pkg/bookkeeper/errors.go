@@ -0,0 +1,128 @@
+package bookkeeper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// Sentinel errors returned (wrapped) by the functions of this package. Use
+// errors.Is to test for them.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrValidation = errors.New("validation failed")
+	ErrLocked     = errors.New("locked")
+	ErrForbidden  = errors.New("forbidden")
+	// the row was changed since the version the caller has seen
+	ErrVersionMismatch = errors.New("version mismatch")
+)
+
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// ValidationError carries the details of the fields that failed validation,
+// and matches ErrValidation
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var details []string
+	for _, f := range e.Fields {
+		details = append(details, fmt.Sprintf("%s: %s", f.Field, f.Reason))
+	}
+	return fmt.Sprintf("%s (%s)", ErrValidation, strings.Join(details, "; "))
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+func (e *ValidationError) Add(field string, reason string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Reason: reason})
+}
+
+// returns nil if no field failed, so that Validate methods can end with it
+func (e *ValidationError) OrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// PermissionError tells why the caller may not do something, and matches
+// ErrForbidden
+type PermissionError struct {
+	Reason string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrForbidden, e.Reason)
+}
+
+func (e *PermissionError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
+// dbError ties a database error to one of the sentinel errors, while keeping
+// the original error in the chain
+type dbError struct {
+	sentinel error
+	err      error
+}
+
+func (e dbError) Error() string {
+	return fmt.Sprintf("%s: %s", e.sentinel, e.err)
+}
+
+func (e dbError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+func (e dbError) Unwrap() error {
+	return e.err
+}
+
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgForeignKeyViolation = "23503"
+	pgUniqueViolation     = "23505"
+	pgLockNotAvailable    = "55P03"
+)
+
+// wrapDbError maps the errors from pgx to the sentinel errors of this package
+func wrapDbError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return dbError{sentinel: ErrNotFound, err: err}
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgForeignKeyViolation, pgUniqueViolation:
+			return dbError{sentinel: ErrConflict, err: err}
+		case pgLockNotAvailable:
+			return dbError{sentinel: ErrLocked, err: err}
+		}
+	}
+	return err
+}
+
+// The body of every error response from the API
+type ErrorResponse struct {
+	Error ErrorDetails `json:"error"`
+}
+
+type ErrorDetails struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
@@ -0,0 +1,48 @@
+package bookkeeper
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The types of the events in the change feed
+const (
+	EventTransactionCreated = "transaction.created"
+	EventTransactionUpdated = "transaction.updated"
+	EventTransactionDeleted = "transaction.deleted"
+	EventAccountCreated     = "account.created"
+	EventAccountUpdated     = "account.updated"
+	EventAccountDeleted     = "account.deleted"
+)
+
+var VALID_EVENT_TYPES = []string{
+	EventTransactionCreated, EventTransactionUpdated, EventTransactionDeleted,
+	EventAccountCreated, EventAccountUpdated, EventAccountDeleted,
+}
+
+func IsValidEventType(eventType string) bool {
+	return stringInList(eventType, VALID_EVENT_TYPES)
+}
+
+// A change to a transaction or an account. Ids increase with every event, so
+// that a client can resume the feed after the last event it has seen. Data is
+// the transaction or the account after the change (before it, for a
+// deletion), if it was at hand.
+type Event struct {
+	Id        int64           `json:"id"`
+	Type      string          `json:"type"`
+	EntityId  int             `json:"entity_id"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func GetSqlCreateEvents() string {
+	return `create table events (
+		id         bigserial,
+		type       text not null,
+		entity_id  int not null,
+		data       jsonb,
+		created_at timestamp not null default now(),
+		primary key(id)
+	);`
+}
@@ -0,0 +1,177 @@
+package bookkeeper
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Table is a report or a listing laid out for a spreadsheet, and written out
+// as CSV or XLSX
+type Table struct {
+	Title  string // the name of the sheet
+	Header []string
+	Rows   []TableRow
+}
+
+// A row of a table. A cell is a string, an int, an Amount or a time.Time,
+// which is written as a date. A blank row has no cells.
+type TableRow struct {
+	Cells      []interface{}
+	Formatters []string // e.g. bold, as in ReportSchema
+}
+
+// Amount is a sum of money in cents, which is written out in dollars
+type Amount int64
+
+func (a Amount) Dollars() float64 {
+	return float64(a) / 100
+}
+
+func (a Amount) String() string {
+	return strconv.FormatFloat(a.Dollars(), 'f', 2, 64)
+}
+
+// formatCell writes out a cell as text, the way CSV has it
+func formatCell(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format("2006/01/02")
+	case string:
+		return escapeFormula(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// escapeFormula keeps a spreadsheet from running text, such as notes, that
+// looks like a formula, by prefixing it with a quote
+func escapeFormula(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// WriteCsv writes the header and the rows, with amounts like 1234.56. Text
+// that starts like a formula is prefixed with a quote.
+func (t Table) WriteCsv(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(t.Header))
+	for i, h := range t.Header {
+		header[i] = escapeFormula(h)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		record := make([]string, len(row.Cells))
+		for i, cell := range row.Cells {
+			record[i] = formatCell(cell)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteXlsx writes the table as the only sheet of a workbook. Amounts and
+// dates are numbers formatted as such, so that they add up in a spreadsheet;
+// the header and the rows formatted as bold are bold.
+func (t Table) WriteXlsx(w io.Writer) error {
+	f := excelize.NewFile()
+	sheet := t.Title
+	if sheet == "" {
+		sheet = "Sheet1"
+	}
+	f.SetSheetName("Sheet1", sheet)
+
+	amountFormat := "#,##0.00;[Red]-#,##0.00"
+	dateFormat := "yyyy/mm/dd"
+	styles := make(map[string]int)
+	for name, style := range map[string]*excelize.Style{
+		"bold":        {Font: &excelize.Font{Bold: true}},
+		"amount":      {CustomNumFmt: &amountFormat},
+		"bold amount": {Font: &excelize.Font{Bold: true}, CustomNumFmt: &amountFormat},
+		"date":        {CustomNumFmt: &dateFormat},
+	} {
+		id, err := f.NewStyle(style)
+		if err != nil {
+			return err
+		}
+		styles[name] = id
+	}
+
+	setCell := func(col int, row int, value interface{}, style string) error {
+		axis, err := excelize.CoordinatesToCellName(col, row)
+		if err != nil {
+			return err
+		}
+		if err := f.SetCellValue(sheet, axis, value); err != nil {
+			return err
+		}
+		if style == "" {
+			return nil
+		}
+		return f.SetCellStyle(sheet, axis, axis, styles[style])
+	}
+	for i, h := range t.Header {
+		if err := setCell(i+1, 1, h, "bold"); err != nil {
+			return err
+		}
+	}
+	for i, row := range t.Rows {
+		bold := stringInList("bold", row.Formatters)
+		for j, cell := range row.Cells {
+			var (
+				value interface{} = cell
+				style string
+			)
+			switch v := cell.(type) {
+			case Amount:
+				value, style = v.Dollars(), "amount"
+				if bold {
+					style = "bold amount"
+				}
+			case time.Time:
+				style = "date"
+			default:
+				if bold {
+					style = "bold"
+				}
+			}
+			if err := setCell(j+1, i+2, value, style); err != nil {
+				return err
+			}
+		}
+	}
+	return f.Write(w)
+}
+
+// TransactionsTable lists the transactions, one per row, with the same
+// columns as bkpctl trans ls
+func TransactionsTable(transactions []Transaction_) Table {
+	table := Table{
+		Title: "Transactions",
+		Header: []string{
+			"Id", "Type", "Date", "Category", "Sub-Category", "Account Name",
+			"Amount", "Notes", "Association Id",
+		},
+	}
+	for _, t := range transactions {
+		table.Rows = append(table.Rows, TableRow{Cells: []interface{}{
+			t.Id, t.Type, t.Date, t.Category, t.SubCategory, t.AccountName,
+			Amount(t.Amount), t.Notes, t.AssociationId,
+		}})
+	}
+	return table
+}
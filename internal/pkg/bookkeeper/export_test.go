@@ -0,0 +1,96 @@
+package bookkeeper
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+var formulaCases = []struct {
+	name string
+	cell interface{}
+	csv  string // the cell as written in CSV
+}{
+	{"equals sign", "=1+1", "'=1+1"},
+	{"plus sign", "+1", "'+1"},
+	{"minus sign", "-1", "'-1"},
+	{"at sign", "@SUM(A1)", "'@SUM(A1)"},
+	{"tab", "\t=1+1", "'\t=1+1"},
+	{"carriage return", "\r=1+1", "'\r=1+1"},
+	{"plain text", "costco run", "costco run"},
+	{"formula later in the text", "a=1", "a=1"},
+	{"negative amount", Amount(-2500), "-25.00"},
+	{"negative number", -3, "-3"},
+}
+
+func TestWriteCsvEscapesFormulas(t *testing.T) {
+	for _, c := range formulaCases {
+		table := Table{Header: []string{c.name}, Rows: []TableRow{{Cells: []interface{}{c.cell}}}}
+		var b bytes.Buffer
+		if err := table.WriteCsv(&b); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		records, err := csv.NewReader(&b).ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(records) != 2 || len(records[1]) != 1 {
+			t.Fatalf("%s: expected a header and a cell, got %q", c.name, records)
+		}
+		if got := records[1][0]; got != c.csv {
+			t.Errorf("%s: cell is %q; want %q", c.name, got, c.csv)
+		}
+	}
+}
+
+func TestWriteCsvEscapesHeader(t *testing.T) {
+	var b bytes.Buffer
+	if err := (Table{Header: []string{"=Id", "Notes"}}).WriteCsv(&b); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "'=Id,Notes\n"; got != want {
+		t.Errorf("header is %q; want %q", got, want)
+	}
+}
+
+// cells of XLSX are typed, so text is never run as a formula and is written
+// as is
+func TestWriteXlsxKeepsText(t *testing.T) {
+	table := Table{Title: "Cells", Header: []string{"=Id"}}
+	for _, c := range formulaCases {
+		if _, ok := c.cell.(string); ok {
+			table.Rows = append(table.Rows, TableRow{Cells: []interface{}{c.cell}})
+		}
+	}
+	var b bytes.Buffer
+	if err := table.WriteXlsx(&b); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := f.GetRows("Cells")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) == 0 || len(rows[0]) == 0 || rows[0][0] != "=Id" {
+		t.Fatalf("header is %q; want %q", rows, "=Id")
+	}
+	for i, row := range table.Rows {
+		want := row.Cells[0].(string)
+		got := ""
+		if i+1 < len(rows) && len(rows[i+1]) > 0 {
+			got = rows[i+1][0]
+		}
+		if got != want {
+			t.Errorf("row %d is %q; want %q", i+1, got, want)
+		}
+		axis, _ := excelize.CoordinatesToCellName(1, i+2)
+		if formula, _ := f.GetCellFormula("Cells", axis); formula != "" {
+			t.Errorf("row %d has the formula %q", i+1, formula)
+		}
+	}
+}
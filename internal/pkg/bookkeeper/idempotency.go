@@ -0,0 +1,37 @@
+package bookkeeper
+
+import "time"
+
+// How long the response to a request with an Idempotency-Key is kept for
+// replaying to retries of the request
+const IDEMPOTENCY_KEY_RETENTION = 24 * time.Hour
+
+// The error code of the response to a retry that arrives while the original
+// request is still being handled; it is safe to retry again later
+const ErrorCodeRequestInProgress = "request_in_progress"
+
+// The response to a POST request with an Idempotency-Key header. Keys are
+// scoped to the user who sent them. StatusCode is 0 while the original request
+// is still being handled.
+type IdempotentResponse struct {
+	UserId      int
+	Key         string
+	RequestHash string // Tells retries from other requests reusing the key
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+func GetSqlCreateIdempotencyKeys() string {
+	return `create table idempotency_keys (
+		user_id      int not null,
+		key          text not null,
+		request_hash text not null,
+		status_code  int not null default 0,
+		content_type text,
+		body         bytea,
+		created_at   timestamp not null default now(),
+		primary key(user_id, key)
+	);`
+}
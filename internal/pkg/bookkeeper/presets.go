@@ -0,0 +1,76 @@
+package bookkeeper
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A named query string in the API query language, so that long filters do not
+// need to be retyped on every machine
+type SavedQuery struct {
+	Name        string `json:"name"`
+	QueryString string `json:"query_string"`
+	Desc        string `json:"desc_"`
+}
+
+func (query *SavedQuery) Validate() error {
+	var verr ValidationError
+	if query.Name == "" {
+		verr.Add("name", "is required")
+	}
+	if query.QueryString == "" {
+		verr.Add("query_string", "is required")
+	}
+	return verr.OrNil()
+}
+
+var VALID_REPORT_TYPES = []string{"balance", "income"}
+
+// The parameters of a balance sheet or an income statement. Tags are keyed by
+// the names of the reporting API query terms, e.g. assetTags or revenueTags.
+// Dates holds the dates of a balance sheet or the date ranges of an income
+// statement. Schema lays out the rows, and is kept with the preset so that it
+// runs the same from any machine; without one, the report lists every tag and
+// total. Owners lists the household members to report on separately, next to
+// the combined figures.
+type ReportPreset struct {
+	Name       string              `json:"name"`
+	Report     string              `json:"report"`
+	Dates      string              `json:"dates"`
+	Tags       map[string][]string `json:"tags"`
+	Schema     *ReportSchema       `json:"schema"`
+	Owners     []string            `json:"owners"`
+}
+
+func (preset *ReportPreset) Validate() error {
+	var verr ValidationError
+	if preset.Name == "" {
+		verr.Add("name", "is required")
+	}
+	if !stringInList(preset.Report, VALID_REPORT_TYPES) {
+		verr.Add("report", fmt.Sprintf("must be one of %s",
+			strings.Join(VALID_REPORT_TYPES, ", ")))
+	}
+	return verr.OrNil()
+}
+
+func GetSqlCreateSavedQueries() string {
+	return `create table saved_queries (
+		name         text,
+		query_string text,
+		desc_        text,
+		primary key(name)
+	);`
+}
+
+func GetSqlCreateReportPresets() string {
+	return `create table report_presets (
+		name        text,
+		report      text,
+		dates       text,
+		tags        jsonb,
+		schema      jsonb,
+		owners      text[],
+		primary key(name)
+	);`
+}
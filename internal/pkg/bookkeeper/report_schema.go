@@ -0,0 +1,129 @@
+package bookkeeper
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReportSchema lays out the rows of a report. Every item in Order is a row,
+// or a blank row if it is "-", and adds up the figures that Mapping lists for
+// it, e.g. "Assets/cash", or "Assets/TOTAL" for the total of a group.
+type ReportSchema struct {
+	Mapping    map[string][]string `json:"mapping"`
+	Order      []string            `json:"order"`
+	Formatters map[string][]string `json:"formatters"`
+}
+
+// itemValue adds up the figures of the statement mapped to an item
+func (s ReportSchema) itemValue(
+	statement StatementWithFields, itemName string,
+) (int64, error) {
+	tags, ok := s.Mapping[itemName]
+	if !ok {
+		return 0, fmt.Errorf("missing item (%s) in mapping", itemName)
+	}
+	var value int64
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "/", 2)
+		if len(parts) != 2 {
+			return 0, fmt.Errorf("invalid tag (%s) in schema", tag)
+		}
+		if rg, ok := statement.GetFieldAsReportGroup(parts[0]); ok {
+			if parts[1] == "TOTAL" {
+				value += rg.Total
+			} else {
+				value += rg.Groups[parts[1]]
+			}
+			continue
+		}
+		if val, ok := statement.GetFieldAsInt64(parts[0]); ok {
+			if parts[1] == "TOTAL" {
+				value += val
+			}
+			continue
+		}
+		return 0, fmt.Errorf("invalid tag (%s) in schema", tag)
+	}
+	return value, nil
+}
+
+// Table lays out the statements as the columns, under the given headers, and
+// the items of the schema as the rows, in its order
+func (s ReportSchema) Table(
+	title string, statements []StatementWithFields, columns []string,
+) (Table, error) {
+	table := Table{Title: title, Header: append([]string{""}, columns...)}
+	for _, itemName := range s.Order {
+		if itemName == "-" {
+			table.Rows = append(table.Rows, TableRow{})
+			continue
+		}
+		row := TableRow{
+			Cells:      []interface{}{itemName},
+			Formatters: s.Formatters[itemName],
+		}
+		for _, statement := range statements {
+			value, err := s.itemValue(statement, itemName)
+			if err != nil {
+				return table, err
+			}
+			row.Cells = append(row.Cells, Amount(value))
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
+
+// add a row per tag of a group, then a bold row of its total
+func (s *ReportSchema) addGroup(field string, totalName string, tags []string) {
+	for _, tag := range tags {
+		itemName := field + "/" + tag
+		s.Mapping[itemName] = []string{itemName}
+		s.Order = append(s.Order, itemName)
+	}
+	s.addTotal(field, totalName)
+}
+
+func (s *ReportSchema) addTotal(field string, totalName string) {
+	s.Mapping[totalName] = []string{field + "/TOTAL"}
+	s.Order = append(s.Order, totalName)
+	s.Formatters[totalName] = []string{"bold"}
+}
+
+func newReportSchema() ReportSchema {
+	return ReportSchema{
+		Mapping:    make(map[string][]string),
+		Formatters: make(map[string][]string),
+	}
+}
+
+// BalanceSheetSchema has a row for every asset and liability tag and for
+// every total, for balance sheets exported without a schema
+func BalanceSheetSchema(assetTags []string, liabilityTags []string) ReportSchema {
+	s := newReportSchema()
+	s.addGroup("Assets", "Total Assets", assetTags)
+	s.Order = append(s.Order, "-")
+	s.addGroup("Liabilities", "Total Liabilities", liabilityTags)
+	s.Order = append(s.Order, "-")
+	s.addTotal("Equities", "Equities")
+	return s
+}
+
+// IncomeStatementSchema has a row for every tag and for every total, for
+// income statements exported without a schema
+func IncomeStatementSchema(
+	revenueTags []string, taxesTags []string, expensesTags []string,
+	investmentsTags []string,
+) ReportSchema {
+	s := newReportSchema()
+	s.addGroup("Revenue", "Total Revenue", revenueTags)
+	s.addGroup("Taxes", "Total Taxes", taxesTags)
+	s.addTotal("RevenueNetTaxes", "Revenue Net Taxes")
+	s.Order = append(s.Order, "-")
+	s.addGroup("Expenses", "Total Expenses", expensesTags)
+	s.addTotal("OperatingIncome", "Operating Income")
+	s.Order = append(s.Order, "-")
+	s.addGroup("Investments", "Total Investments", investmentsTags)
+	s.addTotal("TotalEarnings", "Total Earnings")
+	return s
+}
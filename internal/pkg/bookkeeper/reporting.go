@@ -2,6 +2,8 @@ package bookkeeper
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"strings"
 	"time"
 
@@ -15,7 +17,7 @@ type AccountWithBalance struct {
 }
 
 func ComputeAccountBalanceByName(
-	dbpool *pgxpool.Pool, accountName string, date time.Time,
+	ctx context.Context, dbpool *pgxpool.Pool, accountName string, date time.Time,
 ) (Account, int64, error) {
 	var (
 		account Account
@@ -24,17 +26,20 @@ func ComputeAccountBalanceByName(
 		row     pgx.Row
 	)
 	row = dbpool.QueryRow(
-		context.Background(),
-		"select id, name, desc_, tags from accounts where name = $1 limit 1",
+		ctx,
+		"select id, name, desc_, tags, version from accounts where name = $1 limit 1",
 		accountName,
 	)
-	err = row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags)
+	err = row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags, &account.Version)
 	if err != nil {
+		return account, amount, wrapDbError(err)
+	}
+	if err = loadAccountOwners(ctx, dbpool, &account); err != nil {
 		return account, amount, err
 	}
 	row = dbpool.QueryRow(
-		context.Background(),
-		`select sum(t.amount) from transactions t
+		ctx,
+		`select coalesce(sum(t.amount), 0) from transactions t
 inner join accounts a on t.account_id = a.id
 where a.name = $1 and t.date <= $2`,
 		accountName, date,
@@ -47,7 +52,7 @@ where a.name = $1 and t.date <= $2`,
 }
 
 func ComputeAccountBalanceById(
-	dbpool *pgxpool.Pool, accountId int, date time.Time,
+	ctx context.Context, dbpool *pgxpool.Pool, accountId int, date time.Time,
 ) (Account, int64, error) {
 	var (
 		account Account
@@ -56,33 +61,33 @@ func ComputeAccountBalanceById(
 		row     pgx.Row
 	)
 	row = dbpool.QueryRow(
-		context.Background(),
-		"select id, name, desc_, tags from accounts where id = $1 limit 1",
+		ctx,
+		"select id, name, desc_, tags, version from accounts where id = $1 limit 1",
 		accountId,
 	)
-	err = row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags)
+	err = row.Scan(&account.Id, &account.Name, &account.Desc, &account.Tags, &account.Version)
 	if err != nil {
+		return account, amount, wrapDbError(err)
+	}
+	if err = loadAccountOwners(ctx, dbpool, &account); err != nil {
 		return account, amount, err
 	}
 	row = dbpool.QueryRow(
-		context.Background(),
-		"select sum(amount) from transactions where account_id = $1 and date <= $2",
+		ctx,
+		// the sum is NULL if there are no transactions for this account
+		"select coalesce(sum(amount), 0) from transactions where account_id = $1 and date <= $2",
 		accountId, date,
 	)
 	err = row.Scan(&amount)
-	if err != nil && strings.Contains(err.Error(), "cannot assign NULL") {
-		// no transactions for this account
-		return account, 0, nil
-	}
 	if err != nil {
 		return account, amount, err
 	}
 	return account, amount, err
 }
 
-func GetAllAccountIds(dbpool *pgxpool.Pool) ([]int, error) {
+func GetAllAccountIds(ctx context.Context, dbpool *pgxpool.Pool) ([]int, error) {
 	var ids []int
-	rows, err := dbpool.Query(context.Background(), "select id from accounts")
+	rows, err := dbpool.Query(ctx, "select id from accounts")
 	if err != nil {
 		return ids, err
 	}
@@ -97,6 +102,29 @@ func GetAllAccountIds(dbpool *pgxpool.Pool) ([]int, error) {
 	return ids, nil
 }
 
+// ApportionBalances scales the balance of every account by the share of the
+// named member in it. Accounts without owners belong to the whole household
+// and are split evenly among its householdSize members, so that the reports
+// of all members add up to that of the household. Accounts the member has no
+// share of are left out.
+func ApportionBalances(
+	accounts []AccountWithBalance, owner string, householdSize int,
+) []AccountWithBalance {
+	var res []AccountWithBalance
+	for _, account := range accounts {
+		share := account.ShareOf(owner)
+		if len(account.Owners) == 0 && householdSize > 0 {
+			share = 1 / float64(householdSize)
+		}
+		if share == 0 {
+			continue
+		}
+		account.Balance = int64(math.Round(float64(account.Balance) * share))
+		res = append(res, account)
+	}
+	return res
+}
+
 type ReportGroup struct {
 	Total  int64            `json:"total"`
 	Groups map[string]int64 `json:"groups"`
@@ -239,20 +267,42 @@ func (is *IncomeStatement) Init() {
 }
 
 func ComputeIncomeStatement(
-	dbpool *pgxpool.Pool, startDate time.Time, endDate time.Time,
+	ctx context.Context, dbpool *pgxpool.Pool, startDate time.Time, endDate time.Time,
 	revenueTags []string, taxesTags []string, expensesTags []string,
-	investmentsTags []string,
+	investmentsTags []string, owner string,
 ) (is IncomeStatement, err error) {
 	is.Init()
-	rows, err := dbpool.Query(
-		context.Background(),
-		`select type, category, sub_category, amount from transactions
+	var rows pgx.Rows
+	if owner == "" {
+		rows, err = dbpool.Query(
+			ctx,
+			`select type, category, sub_category, amount from transactions
 where date >= $1 and date <= $2`,
-		startDate, endDate,
-	)
+			startDate, endDate,
+		)
+	} else {
+		// only the owner's share of the transactions on their accounts, and an
+		// even split of those on the accounts of the whole household, like
+		// ApportionBalances
+		rows, err = dbpool.Query(
+			ctx,
+			`select t.type, t.category, t.sub_category,
+round(t.amount * o.share)::bigint from transactions t
+inner join account_owners o on o.account_id = t.account_id
+inner join users u on o.user_id = u.id
+where t.date >= $1 and t.date <= $2 and u.name = $3
+union all
+select t.type, t.category, t.sub_category,
+round(t.amount::numeric / (select count(*) from users))::bigint from transactions t
+where t.date >= $1 and t.date <= $2
+and not exists (select 1 from account_owners o where o.account_id = t.account_id)`,
+			startDate, endDate, owner,
+		)
+	}
 	if err != nil {
 		return
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var (
 			type_       string
@@ -291,3 +341,95 @@ func accumulateByTags(
 		}
 	}
 }
+
+// SQL expressions of the keys that transactions can be grouped by
+var AGGREGATE_GROUP_KEYS = map[string]string{
+	"month":        "to_char(t.date, 'YYYY-MM')",
+	"week":         `to_char(t.date, 'IYYY-"W"IW')`,
+	"category":     "t.category",
+	"sub_category": "t.sub_category",
+	"account":      "a.name",
+	"type":         "t.type",
+}
+
+// SQL expressions of the supported aggregates over transaction amounts
+var AGGREGATE_FUNCTIONS = map[string]string{
+	"sum":   "sum(t.amount)",
+	"count": "count(*)",
+	"avg":   "round(avg(t.amount))::bigint",
+	"min":   "min(t.amount)",
+	"max":   "max(t.amount)",
+}
+
+type AggregateRow struct {
+	Keys   map[string]string `json:"keys"`
+	Values map[string]int64  `json:"values"`
+}
+
+func AggregateTransactionsWithFilters(
+	ctx context.Context, dbpool *pgxpool.Pool, whereClause string, values []interface{},
+	groupBy []string, aggregates []string,
+) (rows_ []AggregateRow, err error) {
+	var (
+		columns []string
+		groups  []string
+	)
+	for _, key := range groupBy {
+		expr, ok := AGGREGATE_GROUP_KEYS[key]
+		if !ok {
+			return nil, fmt.Errorf("invalid group by key %s", key)
+		}
+		columns = append(columns, expr)
+		groups = append(groups, fmt.Sprintf("%d", len(columns)))
+	}
+	for _, agg := range aggregates {
+		expr, ok := AGGREGATE_FUNCTIONS[agg]
+		if !ok {
+			return nil, fmt.Errorf("invalid aggregate %s", agg)
+		}
+		columns = append(columns, expr)
+	}
+	query := fmt.Sprintf(`select %s
+from transactions t
+inner join accounts a on t.account_id = a.id
+where %s`, strings.Join(columns, ", "), whereClause)
+	if len(groups) > 0 {
+		query += fmt.Sprintf("\ngroup by %s\norder by %s",
+			strings.Join(groups, ", "), strings.Join(groups, ", "))
+	}
+	rows, err := dbpool.Query(ctx, query, values...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		keys := make([]string, len(groupBy))
+		aggs := make([]*int64, len(aggregates))
+		dest := make([]interface{}, 0, len(columns))
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		for i := range aggs {
+			dest = append(dest, &aggs[i])
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+		row := AggregateRow{
+			Keys:   make(map[string]string),
+			Values: make(map[string]int64),
+		}
+		for i, key := range groupBy {
+			row.Keys[key] = keys[i]
+		}
+		for i, agg := range aggregates {
+			// aggregates over an empty set are NULL
+			if aggs[i] != nil {
+				row.Values[agg] = *aggs[i]
+			}
+		}
+		rows_ = append(rows_, row)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,221 @@
+package bookkeeper
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Postgres has no built-in parser for Chinese, which is written without spaces
+// between words. Notes are therefore split into a Latin part, which goes
+// through the english configuration (stemming, stop words), and a Chinese part,
+// which is indexed as overlapping character bigrams with the simple
+// configuration. Search terms are split the same way, so any two consecutive
+// characters of a Chinese word match. A single character is not a bigram, so
+// it is matched against the notes with ilike instead.
+
+// SQL expression of the search vector given the two parts of the notes
+const sqlSearchVector = "(to_tsvector('english', %s) || to_tsvector('simple', %s))"
+
+// SQL expression of the search query given the two parts of the terms
+const sqlSearchQuery = "(plainto_tsquery('english', %s) && plainto_tsquery('simple', %s))"
+
+func isCjk(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+// split text into runs of CJK characters and whatever is left in between
+func splitCjkRuns(s string) (latin string, runs []string) {
+	var (
+		latinSb strings.Builder
+		run     []rune
+	)
+	flush := func() {
+		if len(run) > 0 {
+			runs = append(runs, string(run))
+			run = nil
+		}
+	}
+	for _, r := range s {
+		if isCjk(r) {
+			run = append(run, r)
+			continue
+		}
+		flush()
+		latinSb.WriteRune(r)
+	}
+	flush()
+	// keep word boundaries where CJK runs were removed
+	latin = strings.Join(strings.Fields(latinSb.String()), " ")
+	return
+}
+
+// SplitSearchText splits text into the part handled by the english text search
+// configuration, and space separated CJK bigrams (or single characters for
+// runs of length one) handled by the simple configuration
+func SplitSearchText(s string) (latin string, cjk string) {
+	latin, runs := splitCjkRuns(s)
+	var tokens []string
+	for _, run := range runs {
+		chars := []rune(run)
+		if len(chars) == 1 {
+			tokens = append(tokens, run)
+			continue
+		}
+		for i := 0; i+1 < len(chars); i++ {
+			tokens = append(tokens, string(chars[i:i+2]))
+		}
+	}
+	cjk = strings.Join(tokens, " ")
+	return
+}
+
+// splitSearchTerms splits terms like SplitSearchText, except that single CJK
+// characters are returned apart: notes are indexed as bigrams, so a single
+// character has to be matched against the notes themselves
+func splitSearchTerms(terms string) (latin string, cjk string, chars []string) {
+	latin, runs := splitCjkRuns(terms)
+	var longRuns []string
+	for _, run := range runs {
+		if len([]rune(run)) == 1 {
+			chars = append(chars, run)
+		} else {
+			longRuns = append(longRuns, run)
+		}
+	}
+	_, cjk = SplitSearchText(strings.Join(longRuns, " "))
+	return
+}
+
+// searchCondition returns a where clause that matches transactions against
+// the terms, with placeholders from next, and its values. query is the text
+// search query to rank the matches by, or "" if the terms have no words.
+func searchCondition(terms string, next func() string) (
+	clause string, query string, values []interface{},
+) {
+	latin, cjk, chars := splitSearchTerms(terms)
+	var conds []string
+	if latin != "" || cjk != "" || len(chars) == 0 {
+		query = fmt.Sprintf(sqlSearchQuery, next(), next())
+		conds = append(conds, "t.search_vector @@ "+query)
+		values = append(values, latin, cjk)
+	}
+	for _, c := range chars {
+		conds = append(conds, "t.notes ilike "+next())
+		values = append(values, "%"+c+"%")
+	}
+	clause = "(" + strings.Join(conds, " and ") + ")"
+	return
+}
+
+// SearchCondition returns a where clause (with $$ placeholders, as produced by
+// the query parser) and its values that match transactions against the terms
+func SearchCondition(terms string) (clause string, values []interface{}) {
+	clause, _, values = searchCondition(terms, func() string { return "$$" })
+	return
+}
+
+type SearchResult struct {
+	Transaction_
+	Rank float32 `json:"rank"`
+	// HTML-escaped notes with matches wrapped in <b></b>
+	Headline string `json:"headline"`
+}
+
+func SearchTransactions(
+	ctx context.Context, dbpool *pgxpool.Pool, terms string, limit int, offset int,
+) ([]SearchResult, error) {
+	var (
+		results []SearchResult
+		curr    SearchResult
+	)
+	n := 0
+	clause, query, values := searchCondition(terms, func() string {
+		n++
+		return fmt.Sprintf("$%d", n)
+	})
+	rank := "0"
+	if query != "" {
+		rank = fmt.Sprintf("ts_rank(t.search_vector, %s)", query)
+	}
+	rows, err := dbpool.Query(
+		ctx,
+		fmt.Sprintf(`select t.id, type, date, category, sub_category, account_id, amount, notes, association_id, t.version, a.name,
+%s as rank
+from transactions t
+inner join accounts a on t.account_id = a.id
+where %s
+order by rank desc, date desc, t.id desc
+limit $%d offset $%d`, rank, clause, n+1, n+2),
+		append(values, limit, offset)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(
+			&curr.Id, &curr.Type, &curr.Date, &curr.Category, &curr.SubCategory,
+			&curr.AccountId, &curr.Amount, &curr.Notes, &curr.AssociationId,
+			&curr.Version, &curr.AccountName, &curr.Rank,
+		); err != nil {
+			return results, err
+		}
+		curr.Headline = HighlightTerms(curr.Notes, terms, "<b>", "</b>")
+		results = append(results, curr)
+	}
+	return results, rows.Err()
+}
+
+// HighlightTerms escapes text as HTML and wraps every case-insensitive
+// occurrence of the words of terms in it with startSel and stopSel. Words are
+// prefix-matched so that e.g. "purchase" highlights "purchases" like the
+// english stemmer would match it. Matches are found in the raw text, so that
+// searching e.g. "amp" does not match inside "&amp;".
+func HighlightTerms(text string, terms string, startSel string, stopSel string) string {
+	latin, runs := splitCjkRuns(terms)
+	var patterns []string
+	for _, word := range strings.FieldsFunc(latin, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		patterns = append(patterns, `\b`+regexp.QuoteMeta(word)+`\w*`)
+	}
+	for _, run := range runs {
+		patterns = append(patterns, regexp.QuoteMeta(run))
+		chars := []rune(run)
+		for i := 0; i+1 < len(chars); i++ {
+			patterns = append(patterns, regexp.QuoteMeta(string(chars[i:i+2])))
+		}
+	}
+	if len(patterns) == 0 {
+		return html.EscapeString(text)
+	}
+	// prefer the longest match at any position
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return len(patterns[i]) > len(patterns[j])
+	})
+	r, err := regexp.Compile("(?i)(" + strings.Join(patterns, "|") + ")")
+	if err != nil {
+		return html.EscapeString(text)
+	}
+	var b strings.Builder
+	last := 0
+	for _, span := range r.FindAllStringIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:span[0]]))
+		b.WriteString(startSel)
+		b.WriteString(html.EscapeString(text[span[0]:span[1]]))
+		b.WriteString(stopSel)
+		last = span[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
@@ -0,0 +1,24 @@
+package bookkeeper
+
+import "testing"
+
+func TestHighlightTerms(t *testing.T) {
+	cases := []struct {
+		text, terms, want string
+	}{
+		{"Costco purchases", "purchase", "Costco <b>purchases</b>"},
+		{"COSTCO run", "costco", "<b>COSTCO</b> run"},
+		{"Tom & Jerry's", "amp", "Tom &amp; Jerry&#39;s"},
+		{"Tom & Jerry's", "39 quot lt", "Tom &amp; Jerry&#39;s"},
+		{"<b>bold</b> & co", "bold", "&lt;b&gt;<b>bold</b>&lt;/b&gt; &amp; co"},
+		{"A&W root beer", "root", "A&amp;W <b>root</b> beer"},
+		{"在超市买菜", "超市", "在<b>超市</b>买菜"},
+		{"plain notes", "", "plain notes"},
+		{"1 < 2", "", "1 &lt; 2"},
+	}
+	for _, c := range cases {
+		if got := HighlightTerms(c.text, c.terms, "<b>", "</b>"); got != c.want {
+			t.Errorf("HighlightTerms(%q, %q) = %q; want %q", c.text, c.terms, got, c.want)
+		}
+	}
+}
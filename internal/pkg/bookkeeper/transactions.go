@@ -1,6 +1,7 @@
 package bookkeeper
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -17,6 +18,7 @@ type Transaction struct {
 	Amount        int64     `json:"amount"`
 	Notes         string    `json:"notes"`
 	AssociationId string    `json:"association_id"` // Links TransferIn with TransferOut
+	Version       int       `json:"version"`        // Bumped by every update
 }
 
 type Transaction_ struct {
@@ -28,15 +30,56 @@ var VALID_TRANSACTION_TYPES = []string{
 	"TransferIn", "TransferOut", "In", "Out", "BalanceChange", "LiabilityChange",
 }
 
-func (trans Transaction) Validate() bool {
-	valid := stringInList(trans.Type, VALID_TRANSACTION_TYPES)
+// The sign that the amounts of some types of transactions must have: 1 for
+// money coming in and -1 for money going out. Amounts of the other types may
+// have either, and zero amounts are always allowed.
+var TRANSACTION_TYPE_SIGNS = map[string]int{
+	"In": 1, "TransferIn": 1, "Out": -1, "TransferOut": -1, "LiabilityChange": -1,
+}
+
+func (trans Transaction) Validate() error {
+	var verr ValidationError
+	if !stringInList(trans.Type, VALID_TRANSACTION_TYPES) {
+		verr.Add("type", fmt.Sprintf("must be one of %s",
+			strings.Join(VALID_TRANSACTION_TYPES, ", ")))
+	}
 	switch {
 	case strings.HasPrefix(trans.Type, "Transfer"):
-		valid = valid && trans.AssociationId != ""
+		if trans.AssociationId == "" {
+			verr.Add("association_id", "is required for transfers")
+		}
 	case trans.Type == "In" || trans.Type == "Out":
-		valid = valid && trans.Category != "" && trans.SubCategory != ""
+		if trans.Category == "" {
+			verr.Add("category", "is required for In and Out transactions")
+		}
+		if trans.SubCategory == "" {
+			verr.Add("sub_category", "is required for In and Out transactions")
+		}
+	}
+	if sign, ok := TRANSACTION_TYPE_SIGNS[trans.Type]; ok && trans.Amount*int64(sign) < 0 {
+		direction := "coming in"
+		if sign < 0 {
+			direction = "going out"
+		}
+		verr.Add("amount", fmt.Sprintf(
+			"must be %s or zero for %s transactions (money %s), got %d",
+			signWord(int64(sign)), trans.Type, direction, trans.Amount))
+	}
+	return verr.OrNil()
+}
+
+// NormalizeAmount gives the amount the sign that its type requires, so that
+// clients can send absolute amounts. Amounts of the other types are left
+// alone.
+func (trans *Transaction) NormalizeAmount() {
+	sign, ok := TRANSACTION_TYPE_SIGNS[trans.Type]
+	if !ok {
+		return
 	}
-	return valid
+	if trans.Amount < 0 {
+		trans.Amount = -trans.Amount
+	}
+	trans.Amount *= int64(sign)
 }
 
 func (trans Transaction) FormatAmount() string {
@@ -48,6 +91,62 @@ func (trans Transaction) FormatDate() string {
 	return trans.Date.Format("2006/01/02")
 }
 
+// The maximum number of transactions in a batch
+const MAX_BATCH_SIZE = 5000
+
+type BatchRequest struct {
+	Transactions []Transaction `json:"transactions"`
+}
+
+const (
+	BatchItemCreated = "created"
+	BatchItemFailed  = "failed"
+	BatchItemSkipped = "skipped" // not attempted or rolled back
+)
+
+type BatchItemResult struct {
+	Index       int           `json:"index"`
+	Status      string        `json:"status"`
+	Transaction *Transaction  `json:"transaction,omitempty"`
+	Error       *ErrorDetails `json:"error,omitempty"`
+}
+
+// A batch is created in one database transaction, so either every item is
+// created, or none is and Error tells why
+type BatchResponse struct {
+	Committed bool              `json:"committed"`
+	Results   []BatchItemResult `json:"results"`
+	Error     *ErrorDetails     `json:"error,omitempty"`
+}
+
+// The fields to set on every transaction matched by a bulk update; fields
+// that are nil are left alone
+type TransactionPatch struct {
+	Category    *string `json:"category,omitempty"`
+	SubCategory *string `json:"sub_category,omitempty"`
+	Notes       *string `json:"notes,omitempty"`
+	AccountId   *int    `json:"account_id,omitempty"`
+}
+
+func (patch *TransactionPatch) Validate() error {
+	var verr ValidationError
+	if patch.Category == nil && patch.SubCategory == nil &&
+		patch.Notes == nil && patch.AccountId == nil {
+		verr.Add("body", "at least one field must be set")
+	}
+	if patch.Category != nil && *patch.Category == "" {
+		verr.Add("category", "must not be empty")
+	}
+	if patch.SubCategory != nil && *patch.SubCategory == "" {
+		verr.Add("sub_category", "must not be empty")
+	}
+	return verr.OrNil()
+}
+
+type BulkUpdateResponse struct {
+	Updated int `json:"updated"`
+}
+
 func GetSqlCreateTransactions() string {
 	return `create table transactions (
 		id             serial,
@@ -59,9 +158,16 @@ func GetSqlCreateTransactions() string {
 		amount         bigint,
 		notes          text,
 		association_id text,
+		search_vector  tsvector,
+		version        int not null default 1,
 		primary key(id),
 		constraint fk_account
 			foreign key(account_id)
 				references accounts(id)
 	);`
 }
+
+func GetSqlCreateTransactionsSearchIndex() string {
+	return `create index transactions_search_idx on transactions
+		using gin(search_vector);`
+}
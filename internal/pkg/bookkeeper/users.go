@@ -0,0 +1,126 @@
+package bookkeeper
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type User struct {
+	Id           int    `json:"id"`
+	Name         string `json:"name"`
+	PasswordHash string `json:"-"`
+}
+
+func (user *User) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = string(hash)
+	return nil
+}
+
+func (user *User) CheckPassword(password string) bool {
+	err := bcrypt.CompareHashAndPassword(
+		[]byte(user.PasswordHash), []byte(password))
+	return err == nil
+}
+
+const (
+	TokenScopeRead      = "read"
+	TokenScopeReadWrite = "read-write"
+)
+
+var VALID_TOKEN_SCOPES = []string{TokenScopeRead, TokenScopeReadWrite}
+
+// Only the SHA-256 hash of a token is stored; the token itself is shown once,
+// when it is created
+type ApiToken struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"user_id"`
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	TokenHash string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+func (token *ApiToken) Validate() error {
+	var verr ValidationError
+	if token.Name == "" {
+		verr.Add("name", "is required")
+	}
+	if !stringInList(token.Scope, VALID_TOKEN_SCOPES) {
+		verr.Add("scope", fmt.Sprintf("must be one of %s",
+			strings.Join(VALID_TOKEN_SCOPES, ", ")))
+	}
+	return verr.OrNil()
+}
+
+// AllowsWrite tells if the token may be used for requests that change data
+func (token *ApiToken) AllowsWrite() bool {
+	return token.Scope == TokenScopeReadWrite
+}
+
+const apiTokenPrefix = "bkp_"
+
+// NewSecret generates a random bearer token, and fills its hash in
+func (token *ApiToken) NewSecret() (secret string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	secret = apiTokenPrefix + hex.EncodeToString(b)
+	token.TokenHash = HashApiToken(secret)
+	return
+}
+
+func HashApiToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// The payload of a login request, which exchanges a password for a new token
+type LoginRequest struct {
+	Name      string `json:"name"`
+	Password  string `json:"password"`
+	TokenName string `json:"token_name"`
+	Scope     string `json:"scope"`
+}
+
+// The response to a request that creates a token
+type NewApiTokenResponse struct {
+	Token    string   `json:"token"`
+	ApiToken ApiToken `json:"api_token"`
+}
+
+func GetSqlCreateUsers() string {
+	return `create table users (
+		id            serial,
+		name          text unique not null,
+		password_hash text not null,
+		primary key(id)
+	);`
+}
+
+func GetSqlCreateApiTokens() string {
+	return `create table api_tokens (
+		id         serial,
+		user_id    int not null,
+		name       text,
+		scope      text,
+		token_hash text unique not null,
+		created_at timestamp not null default now(),
+		revoked_at timestamp,
+		primary key(id),
+		constraint fk_user
+			foreign key(user_id)
+				references users(id)
+	);`
+}
@@ -0,0 +1,110 @@
+package bookkeeper
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// A subscription that has bkpsrv POST the events of the change feed to a URL.
+// EventTypes filters the events; all of them are sent if it is empty. Every
+// delivery is signed with Secret, which is only shown when the webhook is
+// created. LastEventId is the last event the webhook is done with.
+type Webhook struct {
+	Id          int       `json:"id"`
+	Url         string    `json:"url"`
+	EventTypes  []string  `json:"event_types"`
+	Secret      string    `json:"secret,omitempty"`
+	LastEventId int64     `json:"last_event_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (webhook *Webhook) Validate() error {
+	var verr ValidationError
+	u, err := url.Parse(webhook.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		verr.Add("url", "must be an absolute http or https URL")
+	}
+	for _, t := range webhook.EventTypes {
+		if !IsValidEventType(t) {
+			verr.Add("event_types", fmt.Sprintf("has an unknown event type %s", t))
+		}
+	}
+	return verr.OrNil()
+}
+
+// Wants tells if the webhook subscribes to the type of events
+func (webhook *Webhook) Wants(eventType string) bool {
+	return len(webhook.EventTypes) == 0 ||
+		stringInList(eventType, webhook.EventTypes)
+}
+
+const webhookSecretPrefix = "whsec_"
+
+// NewSecret fills in a random secret, for a webhook created without one
+func (webhook *Webhook) NewSecret() error {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	webhook.Secret = webhookSecretPrefix + hex.EncodeToString(b)
+	return nil
+}
+
+// Sign returns the value of the signature header of a delivery of body, so
+// that the receiver can check that it comes from bkpsrv
+func (webhook *Webhook) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// One attempt to deliver an event to a webhook. StatusCode is 0 if no
+// response was received, in which case Error tells why.
+type WebhookDelivery struct {
+	Id         int64     `json:"id"`
+	WebhookId  int       `json:"webhook_id"`
+	EventId    int64     `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Succeeded tells if the receiver accepted the event
+func (delivery *WebhookDelivery) Succeeded() bool {
+	return delivery.StatusCode >= 200 && delivery.StatusCode < 300
+}
+
+func GetSqlCreateWebhooks() string {
+	return `create table webhooks (
+		id            serial,
+		url           text not null,
+		event_types   text[] not null default '{}',
+		secret        text not null,
+		last_event_id bigint not null default 0,
+		created_at    timestamp not null default now(),
+		primary key(id)
+	);`
+}
+
+func GetSqlCreateWebhookDeliveries() string {
+	return `create table webhook_deliveries (
+		id          bigserial,
+		webhook_id  int not null references webhooks(id) on delete cascade,
+		event_id    bigint not null,
+		event_type  text not null,
+		attempt     int not null,
+		status_code int not null,
+		error       text not null default '',
+		duration_ms bigint not null,
+		created_at  timestamp not null default now(),
+		primary key(id)
+	);`
+}
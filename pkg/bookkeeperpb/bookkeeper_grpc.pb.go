@@ -0,0 +1,612 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package bookkeeperpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// BookkeeperClient is the client API for Bookkeeper service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BookkeeperClient interface {
+	// accounts
+	ListAccounts(ctx context.Context, in *ListAccountsRequest, opts ...grpc.CallOption) (*ListAccountsResponse, error)
+	GetAccount(ctx context.Context, in *GetAccountRequest, opts ...grpc.CallOption) (*Account, error)
+	CreateAccount(ctx context.Context, in *CreateAccountRequest, opts ...grpc.CallOption) (*Account, error)
+	UpdateAccount(ctx context.Context, in *UpdateAccountRequest, opts ...grpc.CallOption) (*Account, error)
+	DeleteAccount(ctx context.Context, in *DeleteAccountRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// transactions
+	GetTransaction(ctx context.Context, in *GetTransactionRequest, opts ...grpc.CallOption) (*Transaction, error)
+	// Streams every transaction that matches, latest first unless the query
+	// orders them otherwise, however many there are
+	ListTransactions(ctx context.Context, in *ListTransactionsRequest, opts ...grpc.CallOption) (Bookkeeper_ListTransactionsClient, error)
+	CreateTransaction(ctx context.Context, in *CreateTransactionRequest, opts ...grpc.CallOption) (*Transaction, error)
+	UpdateTransaction(ctx context.Context, in *UpdateTransactionRequest, opts ...grpc.CallOption) (*Transaction, error)
+	DeleteTransaction(ctx context.Context, in *DeleteTransactionRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// journal
+	// Validates a journal entry and creates all of its transactions, or none
+	PostJournalEntry(ctx context.Context, in *PostJournalEntryRequest, opts ...grpc.CallOption) (*PostJournalEntryResponse, error)
+	// reporting
+	GetAccountBalances(ctx context.Context, in *GetAccountBalancesRequest, opts ...grpc.CallOption) (*GetAccountBalancesResponse, error)
+	GetBalanceSheets(ctx context.Context, in *GetBalanceSheetsRequest, opts ...grpc.CallOption) (*GetBalanceSheetsResponse, error)
+	GetIncomeStatements(ctx context.Context, in *GetIncomeStatementsRequest, opts ...grpc.CallOption) (*GetIncomeStatementsResponse, error)
+}
+
+type bookkeeperClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBookkeeperClient(cc grpc.ClientConnInterface) BookkeeperClient {
+	return &bookkeeperClient{cc}
+}
+
+func (c *bookkeeperClient) ListAccounts(ctx context.Context, in *ListAccountsRequest, opts ...grpc.CallOption) (*ListAccountsResponse, error) {
+	out := new(ListAccountsResponse)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/ListAccounts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) GetAccount(ctx context.Context, in *GetAccountRequest, opts ...grpc.CallOption) (*Account, error) {
+	out := new(Account)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/GetAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) CreateAccount(ctx context.Context, in *CreateAccountRequest, opts ...grpc.CallOption) (*Account, error) {
+	out := new(Account)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/CreateAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) UpdateAccount(ctx context.Context, in *UpdateAccountRequest, opts ...grpc.CallOption) (*Account, error) {
+	out := new(Account)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/UpdateAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) DeleteAccount(ctx context.Context, in *DeleteAccountRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/DeleteAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) GetTransaction(ctx context.Context, in *GetTransactionRequest, opts ...grpc.CallOption) (*Transaction, error) {
+	out := new(Transaction)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/GetTransaction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) ListTransactions(ctx context.Context, in *ListTransactionsRequest, opts ...grpc.CallOption) (Bookkeeper_ListTransactionsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Bookkeeper_ServiceDesc.Streams[0], "/bookkeeper.v1.Bookkeeper/ListTransactions", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bookkeeperListTransactionsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Bookkeeper_ListTransactionsClient interface {
+	Recv() (*Transaction, error)
+	grpc.ClientStream
+}
+
+type bookkeeperListTransactionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *bookkeeperListTransactionsClient) Recv() (*Transaction, error) {
+	m := new(Transaction)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *bookkeeperClient) CreateTransaction(ctx context.Context, in *CreateTransactionRequest, opts ...grpc.CallOption) (*Transaction, error) {
+	out := new(Transaction)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/CreateTransaction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) UpdateTransaction(ctx context.Context, in *UpdateTransactionRequest, opts ...grpc.CallOption) (*Transaction, error) {
+	out := new(Transaction)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/UpdateTransaction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) DeleteTransaction(ctx context.Context, in *DeleteTransactionRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/DeleteTransaction", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) PostJournalEntry(ctx context.Context, in *PostJournalEntryRequest, opts ...grpc.CallOption) (*PostJournalEntryResponse, error) {
+	out := new(PostJournalEntryResponse)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/PostJournalEntry", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) GetAccountBalances(ctx context.Context, in *GetAccountBalancesRequest, opts ...grpc.CallOption) (*GetAccountBalancesResponse, error) {
+	out := new(GetAccountBalancesResponse)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/GetAccountBalances", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) GetBalanceSheets(ctx context.Context, in *GetBalanceSheetsRequest, opts ...grpc.CallOption) (*GetBalanceSheetsResponse, error) {
+	out := new(GetBalanceSheetsResponse)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/GetBalanceSheets", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookkeeperClient) GetIncomeStatements(ctx context.Context, in *GetIncomeStatementsRequest, opts ...grpc.CallOption) (*GetIncomeStatementsResponse, error) {
+	out := new(GetIncomeStatementsResponse)
+	err := c.cc.Invoke(ctx, "/bookkeeper.v1.Bookkeeper/GetIncomeStatements", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BookkeeperServer is the server API for Bookkeeper service.
+// All implementations must embed UnimplementedBookkeeperServer
+// for forward compatibility
+type BookkeeperServer interface {
+	// accounts
+	ListAccounts(context.Context, *ListAccountsRequest) (*ListAccountsResponse, error)
+	GetAccount(context.Context, *GetAccountRequest) (*Account, error)
+	CreateAccount(context.Context, *CreateAccountRequest) (*Account, error)
+	UpdateAccount(context.Context, *UpdateAccountRequest) (*Account, error)
+	DeleteAccount(context.Context, *DeleteAccountRequest) (*emptypb.Empty, error)
+	// transactions
+	GetTransaction(context.Context, *GetTransactionRequest) (*Transaction, error)
+	// Streams every transaction that matches, latest first unless the query
+	// orders them otherwise, however many there are
+	ListTransactions(*ListTransactionsRequest, Bookkeeper_ListTransactionsServer) error
+	CreateTransaction(context.Context, *CreateTransactionRequest) (*Transaction, error)
+	UpdateTransaction(context.Context, *UpdateTransactionRequest) (*Transaction, error)
+	DeleteTransaction(context.Context, *DeleteTransactionRequest) (*emptypb.Empty, error)
+	// journal
+	// Validates a journal entry and creates all of its transactions, or none
+	PostJournalEntry(context.Context, *PostJournalEntryRequest) (*PostJournalEntryResponse, error)
+	// reporting
+	GetAccountBalances(context.Context, *GetAccountBalancesRequest) (*GetAccountBalancesResponse, error)
+	GetBalanceSheets(context.Context, *GetBalanceSheetsRequest) (*GetBalanceSheetsResponse, error)
+	GetIncomeStatements(context.Context, *GetIncomeStatementsRequest) (*GetIncomeStatementsResponse, error)
+	mustEmbedUnimplementedBookkeeperServer()
+}
+
+// UnimplementedBookkeeperServer must be embedded to have forward compatible implementations.
+type UnimplementedBookkeeperServer struct {
+}
+
+func (UnimplementedBookkeeperServer) ListAccounts(context.Context, *ListAccountsRequest) (*ListAccountsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAccounts not implemented")
+}
+func (UnimplementedBookkeeperServer) GetAccount(context.Context, *GetAccountRequest) (*Account, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAccount not implemented")
+}
+func (UnimplementedBookkeeperServer) CreateAccount(context.Context, *CreateAccountRequest) (*Account, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAccount not implemented")
+}
+func (UnimplementedBookkeeperServer) UpdateAccount(context.Context, *UpdateAccountRequest) (*Account, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateAccount not implemented")
+}
+func (UnimplementedBookkeeperServer) DeleteAccount(context.Context, *DeleteAccountRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteAccount not implemented")
+}
+func (UnimplementedBookkeeperServer) GetTransaction(context.Context, *GetTransactionRequest) (*Transaction, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTransaction not implemented")
+}
+func (UnimplementedBookkeeperServer) ListTransactions(*ListTransactionsRequest, Bookkeeper_ListTransactionsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListTransactions not implemented")
+}
+func (UnimplementedBookkeeperServer) CreateTransaction(context.Context, *CreateTransactionRequest) (*Transaction, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateTransaction not implemented")
+}
+func (UnimplementedBookkeeperServer) UpdateTransaction(context.Context, *UpdateTransactionRequest) (*Transaction, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateTransaction not implemented")
+}
+func (UnimplementedBookkeeperServer) DeleteTransaction(context.Context, *DeleteTransactionRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteTransaction not implemented")
+}
+func (UnimplementedBookkeeperServer) PostJournalEntry(context.Context, *PostJournalEntryRequest) (*PostJournalEntryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PostJournalEntry not implemented")
+}
+func (UnimplementedBookkeeperServer) GetAccountBalances(context.Context, *GetAccountBalancesRequest) (*GetAccountBalancesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAccountBalances not implemented")
+}
+func (UnimplementedBookkeeperServer) GetBalanceSheets(context.Context, *GetBalanceSheetsRequest) (*GetBalanceSheetsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBalanceSheets not implemented")
+}
+func (UnimplementedBookkeeperServer) GetIncomeStatements(context.Context, *GetIncomeStatementsRequest) (*GetIncomeStatementsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetIncomeStatements not implemented")
+}
+func (UnimplementedBookkeeperServer) mustEmbedUnimplementedBookkeeperServer() {}
+
+// UnsafeBookkeeperServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BookkeeperServer will
+// result in compilation errors.
+type UnsafeBookkeeperServer interface {
+	mustEmbedUnimplementedBookkeeperServer()
+}
+
+func RegisterBookkeeperServer(s grpc.ServiceRegistrar, srv BookkeeperServer) {
+	s.RegisterService(&Bookkeeper_ServiceDesc, srv)
+}
+
+func _Bookkeeper_ListAccounts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAccountsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).ListAccounts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/ListAccounts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).ListAccounts(ctx, req.(*ListAccountsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_GetAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).GetAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/GetAccount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).GetAccount(ctx, req.(*GetAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_CreateAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).CreateAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/CreateAccount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).CreateAccount(ctx, req.(*CreateAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_UpdateAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).UpdateAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/UpdateAccount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).UpdateAccount(ctx, req.(*UpdateAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_DeleteAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).DeleteAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/DeleteAccount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).DeleteAccount(ctx, req.(*DeleteAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_GetTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).GetTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/GetTransaction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).GetTransaction(ctx, req.(*GetTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_ListTransactions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListTransactionsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BookkeeperServer).ListTransactions(m, &bookkeeperListTransactionsServer{stream})
+}
+
+type Bookkeeper_ListTransactionsServer interface {
+	Send(*Transaction) error
+	grpc.ServerStream
+}
+
+type bookkeeperListTransactionsServer struct {
+	grpc.ServerStream
+}
+
+func (x *bookkeeperListTransactionsServer) Send(m *Transaction) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Bookkeeper_CreateTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).CreateTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/CreateTransaction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).CreateTransaction(ctx, req.(*CreateTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_UpdateTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).UpdateTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/UpdateTransaction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).UpdateTransaction(ctx, req.(*UpdateTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_DeleteTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).DeleteTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/DeleteTransaction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).DeleteTransaction(ctx, req.(*DeleteTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_PostJournalEntry_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PostJournalEntryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).PostJournalEntry(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/PostJournalEntry",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).PostJournalEntry(ctx, req.(*PostJournalEntryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_GetAccountBalances_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAccountBalancesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).GetAccountBalances(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/GetAccountBalances",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).GetAccountBalances(ctx, req.(*GetAccountBalancesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_GetBalanceSheets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBalanceSheetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).GetBalanceSheets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/GetBalanceSheets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).GetBalanceSheets(ctx, req.(*GetBalanceSheetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bookkeeper_GetIncomeStatements_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetIncomeStatementsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookkeeperServer).GetIncomeStatements(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/bookkeeper.v1.Bookkeeper/GetIncomeStatements",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookkeeperServer).GetIncomeStatements(ctx, req.(*GetIncomeStatementsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Bookkeeper_ServiceDesc is the grpc.ServiceDesc for Bookkeeper service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Bookkeeper_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "bookkeeper.v1.Bookkeeper",
+	HandlerType: (*BookkeeperServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListAccounts",
+			Handler:    _Bookkeeper_ListAccounts_Handler,
+		},
+		{
+			MethodName: "GetAccount",
+			Handler:    _Bookkeeper_GetAccount_Handler,
+		},
+		{
+			MethodName: "CreateAccount",
+			Handler:    _Bookkeeper_CreateAccount_Handler,
+		},
+		{
+			MethodName: "UpdateAccount",
+			Handler:    _Bookkeeper_UpdateAccount_Handler,
+		},
+		{
+			MethodName: "DeleteAccount",
+			Handler:    _Bookkeeper_DeleteAccount_Handler,
+		},
+		{
+			MethodName: "GetTransaction",
+			Handler:    _Bookkeeper_GetTransaction_Handler,
+		},
+		{
+			MethodName: "CreateTransaction",
+			Handler:    _Bookkeeper_CreateTransaction_Handler,
+		},
+		{
+			MethodName: "UpdateTransaction",
+			Handler:    _Bookkeeper_UpdateTransaction_Handler,
+		},
+		{
+			MethodName: "DeleteTransaction",
+			Handler:    _Bookkeeper_DeleteTransaction_Handler,
+		},
+		{
+			MethodName: "PostJournalEntry",
+			Handler:    _Bookkeeper_PostJournalEntry_Handler,
+		},
+		{
+			MethodName: "GetAccountBalances",
+			Handler:    _Bookkeeper_GetAccountBalances_Handler,
+		},
+		{
+			MethodName: "GetBalanceSheets",
+			Handler:    _Bookkeeper_GetBalanceSheets_Handler,
+		},
+		{
+			MethodName: "GetIncomeStatements",
+			Handler:    _Bookkeeper_GetIncomeStatements_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListTransactions",
+			Handler:       _Bookkeeper_ListTransactions_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "bookkeeper.proto",
+}
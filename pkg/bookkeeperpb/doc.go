@@ -0,0 +1,5 @@
+// Package bookkeeperpb holds the gRPC service of bkpsrv and the generated Go
+// client stubs, for tools that want a typed API. See bookkeeper.proto.
+package bookkeeperpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative bookkeeper.proto